@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gwd/session-scheduler/event"
+	"github.com/gwd/session-scheduler/keyvalue"
+	"github.com/gwd/session-scheduler/sessions"
+)
+
+const (
+	KeyBackupInterval = "ServeBackupInterval"
+	KeyBackupKeep     = "ServeBackupKeep"
+	KeyBackupDir      = "ServeBackupDir"
+)
+
+const (
+	dataDir           = "data"
+	defaultBackupDir  = "data/backup"
+	defaultBackupKeep = 7
+	backupTimeFormat  = "20060102-150405"
+)
+
+// backupDatabase is one of the databases which make up a snapshot.
+// Within a snapshot directory, each is stored under its path relative
+// to the data directory (see backupName).
+type backupDatabase struct {
+	filename string
+	backup   func(filename string) error
+	// check makes sure a backup can be restored, returning its
+	// schema version (0 if the database doesn't have one)
+	check func(filename string) (int, error)
+}
+
+// backupDatabases returns the databases which make up a snapshot:
+// those of all the events, the server configuration and the session
+// store.
+func backupDatabases() ([]backupDatabase, error) {
+	filenames, err := eventFilenames()
+	if err != nil {
+		return nil, err
+	}
+
+	var dbs []backupDatabase
+	for _, filename := range filenames {
+		filename := filename
+		backup := func(to string) error {
+			for _, ev := range Events() {
+				if ev.Filename() == filename {
+					return ev.Backup(to)
+				}
+			}
+			return fmt.Errorf("%s isn't open", filename)
+		}
+		dbs = append(dbs, backupDatabase{filename, backup, event.CheckBackup})
+	}
+	return append(dbs,
+		backupDatabase{serverConfigFilename,
+			func(filename string) error { return kvs.Backup(filename) },
+			func(filename string) (int, error) { return 0, keyvalue.CheckBackup(filename) }},
+		backupDatabase{sessionsFilename, sessions.Backup, sessions.CheckBackup}), nil
+}
+
+// backupName returns where the database in filename is kept within a
+// snapshot directory: e.g., event.sqlite, or events/<name>.sqlite.
+func backupName(filename string) string {
+	if rel, err := filepath.Rel(dataDir, filename); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return filepath.Base(filename)
+}
+
+// Snapshot is a backup of all the databases taken at one time.
+type Snapshot struct {
+	Name string // Name of the directory within the backup directory
+	Path string
+	Time time.Time
+}
+
+// Only one snapshot is taken at a time
+var backupLock sync.Mutex
+
+func getBackupDir() string {
+	return kvsGetDef(KeyBackupDir, defaultBackupDir)
+}
+
+// getBackupInterval returns how often snapshots should be taken; 0
+// means never.
+func getBackupInterval() time.Duration {
+	durationString, err := kvs.Get(KeyBackupInterval)
+	var duration time.Duration
+	if err == nil {
+		duration, err = time.ParseDuration(durationString)
+	}
+
+	if err != nil {
+		// Default once a day
+		return time.Hour * 24
+	}
+	return duration
+}
+
+// getBackupKeep returns the number of snapshots to keep; 0 means all
+// of them.
+func getBackupKeep() int {
+	keep, err := strconv.Atoi(kvsGetDef(KeyBackupKeep, ""))
+	if err != nil {
+		return defaultBackupKeep
+	}
+	return keep
+}
+
+// BackupList returns the snapshots in the backup directory, newest
+// first.
+func BackupList() ([]Snapshot, error) {
+	dir := getBackupDir()
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var snapshots []Snapshot
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		t, err := time.ParseInLocation(backupTimeFormat, entry.Name(), time.Local)
+		if err != nil {
+			// Not one of ours
+			continue
+		}
+		snapshots = append(snapshots, Snapshot{
+			Name: entry.Name(),
+			Path: filepath.Join(dir, entry.Name()),
+			Time: t})
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.After(snapshots[j].Time)
+	})
+	return snapshots, nil
+}
+
+// Backup takes a snapshot of all the databases in a new directory
+// under the backup directory, then deletes the oldest snapshots
+// beyond the number to keep.  If a snapshot was already taken within
+// the same second, that one is returned instead.
+func Backup() (*Snapshot, error) {
+	backupLock.Lock()
+	defer backupLock.Unlock()
+
+	now := time.Now()
+	s := &Snapshot{Name: now.Format(backupTimeFormat), Time: now}
+	s.Path = filepath.Join(getBackupDir(), s.Name)
+
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.Mkdir(s.Path, 0700); os.IsExist(err) {
+		// Someone else took one just now
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	dbs, err := backupDatabases()
+	if err != nil {
+		os.RemoveAll(s.Path)
+		return nil, err
+	}
+	for _, db := range dbs {
+		to := filepath.Join(s.Path, backupName(db.filename))
+		if err := os.MkdirAll(filepath.Dir(to), 0700); err != nil {
+			os.RemoveAll(s.Path)
+			return nil, err
+		}
+		if err := db.backup(to); err != nil {
+			os.RemoveAll(s.Path)
+			return nil, err
+		}
+	}
+	log.Printf("Backed up databases to %s", s.Path)
+
+	keep := getBackupKeep()
+	if keep <= 0 {
+		return s, nil
+	}
+	snapshots, err := BackupList()
+	if err != nil {
+		return s, err
+	}
+	for i := keep; i < len(snapshots); i++ {
+		log.Printf("Removing old backup %s", snapshots[i].Path)
+		if err := os.RemoveAll(snapshots[i].Path); err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+// backupLoop takes a snapshot whenever the newest one is older than
+// the backup interval.
+func backupLoop() {
+	for {
+		interval := getBackupInterval()
+		if interval <= 0 {
+			time.Sleep(time.Hour)
+			continue
+		}
+
+		snapshots, err := BackupList()
+		if err != nil {
+			log.Printf("Error listing backups: %v", err)
+			time.Sleep(interval)
+			continue
+		}
+
+		if len(snapshots) > 0 {
+			if wait := time.Until(snapshots[0].Time.Add(interval)); wait > 0 {
+				time.Sleep(wait)
+				continue
+			}
+		}
+
+		if _, err := Backup(); err != nil {
+			log.Printf("Error backing up: %v", err)
+			time.Sleep(interval)
+		}
+	}
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"bufio"
+	"fmt"
 	"log"
+	"os"
+	"strings"
 
 	"encoding/json"
 	"github.com/hjson/hjson-go"
@@ -31,12 +35,12 @@ var header = `// Location is the normal timezone location; e.g., 'Europe/Berlin'
 
 var format = "2006 Jan 2 15:04"
 
-func EditTimetable() {
+func EditTimetable(ev *Event) {
 	// Get timetable.  If it's not empty, marshal it to json (perhaps
 	// with a comment at the top?).  Otherwise, use the starter schedule
-	tt, err := event.GetTimetable("", nil)
+	tt, err := ev.GetTimetable("", nil)
 	if err != nil {
-		log.Fatalf("Getting timetable: %v")
+		log.Fatalf("Getting timetable: %v", err)
 	}
 
 	ett := TimetableEdit{Location: DefaultLocation}
@@ -124,8 +128,32 @@ func EditTimetable() {
 		}
 	}
 
-	err = event.TimetableSet(&tt)
+	err = ev.TimetableSet("", &tt, event.ImpactAbort)
+	if impact := event.GetScheduleImpact(err); impact != nil {
+		ImpactSetTimeDisplay(impact, slotTimeFormat)
+		fmt.Printf("This would unschedule:\n")
+		for _, si := range impact {
+			locked := ""
+			if si.IsLocked {
+				locked = " [locked]"
+			}
+			fmt.Printf("  %s%s: %s (%s)\n", si.TimeDisplay, locked, si.Title, si.LocationName)
+		}
+		if !askYesNo("Unschedule them and save the timetable?") {
+			log.Fatalf("Timetable not changed")
+		}
+		err = ev.TimetableSet("", &tt, event.ImpactUnschedule)
+	}
 	if err != nil {
 		log.Fatalf("Error setting timetable: %v", err)
 	}
 }
+
+// askYesNo asks a question on the terminal, returning true if the
+// answer is yes.
+func askYesNo(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
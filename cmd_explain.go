@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+func utilityPercent(utility, max int) string {
+	if max == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d%%", utility*100/max)
+}
+
+// Explain prints a report explaining the score of the current
+// schedule: how much each discussion contributes to each slot, which
+// sessions each user can't attend because of clashes, and the total
+// utility of the schedule versus the theoretical maximum.
+func Explain(ev *Event) {
+	ex, err := ev.ScheduleExplain(slotTimeFormat, &DefaultLocationTZ)
+	if err != nil {
+		log.Fatalf("Explaining schedule: %v", err)
+	}
+
+	fmt.Printf("Total utility: %d / %d (%s)\n\n",
+		ex.TotalUtility, ex.MaxUtility, utilityPercent(ex.TotalUtility, ex.MaxUtility))
+
+	fmt.Printf("Slots:\n")
+	for _, slot := range ex.Slots {
+		locked := ""
+		if slot.IsLocked {
+			locked = " [locked]"
+		}
+		fmt.Printf("  %s%s: score %d\n", slot.TimeDisplay, locked, slot.Score)
+		for _, ed := range slot.Discussions {
+			fmt.Printf("    %+5d  %s (%s; max %d)\n",
+				ed.Contribution, ed.Title, ed.LocationName, ed.MaxInterest)
+		}
+	}
+
+	if len(ex.Unplaced) > 0 {
+		fmt.Printf("\nUnscheduled discussions:\n")
+		for _, ed := range ex.Unplaced {
+			fmt.Printf("  %s (max %d)\n", ed.Title, ed.MaxInterest)
+		}
+	}
+
+	fmt.Printf("\nUsers:\n")
+	for _, eu := range ex.Users {
+		fmt.Printf("  %s: utility %d / %d (%s)\n", eu.Username,
+			eu.Utility, eu.MaxUtility, utilityPercent(eu.Utility, eu.MaxUtility))
+		for _, c := range eu.Conflicts {
+			fmt.Printf("    Clash at %s:\n", c.TimeDisplay)
+			for _, ei := range c.Discussions {
+				mark := " "
+				if ei.DiscussionID == c.Attending {
+					mark = "*"
+				}
+				fmt.Printf("     %s %3d %s\n", mark, ei.Interest, ei.Title)
+			}
+		}
+		for _, ei := range eu.Unplaced {
+			fmt.Printf("    Unscheduled: %3d %s\n", ei.Interest, ei.Title)
+		}
+	}
+}
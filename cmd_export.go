@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/hjson/hjson-go"
+
+	"github.com/gwd/session-scheduler/event"
+)
+
+var exportHeader = `// session-scheduler event export.  Times are RFC 3339; users'
+// Location is a timezone location, e.g. 'Europe/Berlin'.  Load with
+// 'session-scheduler import'.
+`
+
+// hjsonUnmarshal reads hjson (or json) from data into v.
+func hjsonUnmarshal(data []byte, v interface{}) error {
+	// As in EditTimetable, hjson has trouble unmarshalling into
+	// structs, so go through encoding/json.
+	ints := map[string]interface{}{}
+	if err := hjson.Unmarshal(data, &ints); err != nil {
+		return err
+	}
+	intb, err := json.Marshal(ints)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(intb, v)
+}
+
+// Export writes ev to a file (or standard output) as hjson, or
+// json with -json.
+func Export(ev *Event, args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	output := fs.String("o", "-", "File to write to (- for standard output)")
+	asJSON := fs.Bool("json", false, "Write plain JSON rather than hjson")
+	timetableOnly := fs.Bool("timetable-only", false, "Only export the timetable and locations")
+	passwords := fs.Bool("passwords", false, "Include users' password hashes")
+	fs.Parse(args)
+
+	ex, err := ev.ExportEvent(event.ExportOptions{
+		TimetableOnly: *timetableOnly,
+		Passwords:     *passwords})
+	if err != nil {
+		log.Fatalf("Exporting event: %v", err)
+	}
+
+	var outb []byte
+	if *asJSON {
+		outb, err = json.MarshalIndent(ex, "", "  ")
+		outb = append(outb, '\n')
+	} else {
+		outb, err = hjson.MarshalWithOptions(ex, hjson.EncoderOptions{BracesSameLine: true, Eol: "\n", IndentBy: "  "})
+		outb = append(append([]byte(exportHeader), outb...), '\n')
+	}
+	if err != nil {
+		log.Fatalf("Marshalling export: %v", err)
+	}
+
+	if *output == "-" {
+		_, err = os.Stdout.Write(outb)
+	} else {
+		err = ioutil.WriteFile(*output, outb, 0600)
+	}
+	if err != nil {
+		log.Fatalf("Writing export: %v", err)
+	}
+}
+
+// Import reads an export made by Export (of any version this program
+// understands) into the event, which must be empty.  With
+// -timetable-only, only the timetable and locations are imported.
+func Import(ev *Event, args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	timetableOnly := fs.Bool("timetable-only", false, "Only import the timetable and locations")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatalf("Usage: import [-timetable-only] <file>")
+	}
+
+	var inb []byte
+	var err error
+	if fs.Arg(0) == "-" {
+		inb, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		inb, err = ioutil.ReadFile(fs.Arg(0))
+	}
+	if err != nil {
+		log.Fatalf("Reading export: %v", err)
+	}
+
+	var ex event.Export
+	if err := hjsonUnmarshal(inb, &ex); err != nil {
+		log.Fatalf("Parsing export: %v", err)
+	}
+
+	res, err := ev.ImportEvent("", &ex, event.ImportOptions{TimetableOnly: *timetableOnly})
+	if err != nil {
+		log.Fatalf("Importing event: %v", err)
+	}
+	fmt.Printf("Imported %d days (%d slots), %d locations", res.Days, res.Slots, res.Locations)
+	if !*timetableOnly {
+		fmt.Printf(", %d users, %d sessions, %d schedule entries",
+			res.Users, res.Discussions, res.Schedule)
+	}
+	fmt.Printf("\n")
+}
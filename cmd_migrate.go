@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/gwd/session-scheduler/event"
+	"github.com/gwd/session-scheduler/sessions"
+)
+
+func printMigration(name string, from, to int, steps []string, backup string, dryRun bool) {
+	switch {
+	case from == 0:
+		fmt.Printf("%s: doesn't exist yet; will be created at version %d\n", name, to)
+		return
+	case len(steps) == 0:
+		fmt.Printf("%s: up to date (version %d)\n", name, from)
+		return
+	case dryRun:
+		fmt.Printf("%s: would upgrade from version %d to %d:\n", name, from, to)
+	default:
+		fmt.Printf("%s: upgraded from version %d to %d:\n", name, from, to)
+	}
+	for _, step := range steps {
+		fmt.Printf("  %s\n", step)
+	}
+	if backup != "" {
+		fmt.Printf("  Backup in %s\n", backup)
+	}
+}
+
+// Migrate upgrades the event databases and session store to the
+// current schema versions, backing them up first.  (This also happens
+// automatically on startup.)  With -dry-run, it only reports what
+// would be done, after checking that the upgrade would succeed.
+func Migrate(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Show what would be upgraded, without changing anything")
+	fs.Parse(args)
+
+	filenames, err := eventFilenames()
+	if err != nil {
+		log.Fatalf("Getting events: %v", err)
+	}
+	for _, filename := range filenames {
+		res, err := event.Migrate(filename, *dryRun)
+		if err != nil {
+			log.Fatalf("Migrating event database %s: %v", filename, err)
+		}
+		var steps []string
+		for _, s := range res.Steps {
+			steps = append(steps, fmt.Sprintf("%d: %s", s.Version, s.Description))
+		}
+		printMigration(filename, res.FromVersion, res.ToVersion, steps, res.Backup, *dryRun)
+	}
+
+	sres, err := sessions.Migrate(sessionsFilename, *dryRun)
+	if err != nil {
+		log.Fatalf("Migrating session store: %v", err)
+	}
+	var steps []string
+	for _, s := range sres.Steps {
+		steps = append(steps, fmt.Sprintf("%d: %s", s.Version, s.Description))
+	}
+	printMigration(sessionsFilename, sres.FromVersion, sres.ToVersion, steps, sres.Backup, *dryRun)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gofrs/flock"
+)
+
+// restoreTarget returns the database a backup file is a copy of,
+// judging by its name: snapshot files have the same name as the live
+// file, and backups made before an upgrade start with it.  If more
+// than one database's name fits (e.g. event.sqlite and
+// events/event-2021.sqlite), the longest match wins, and then the one
+// in a directory of the same name.
+func restoreTarget(dbs []backupDatabase, path string) *backupDatabase {
+	name := filepath.Base(path)
+	dir := filepath.Base(filepath.Dir(path))
+	var best *backupDatabase
+	bestLen, bestDir := 0, false
+	for i := range dbs {
+		db := &dbs[i]
+		base := filepath.Base(db.filename)
+		prefix := strings.TrimSuffix(base, filepath.Ext(base))
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		sameDir := filepath.Base(filepath.Dir(db.filename)) == dir
+		if len(prefix) > bestLen || (len(prefix) == bestLen && sameDir && !bestDir) {
+			best, bestLen, bestDir = db, len(prefix), sameDir
+		}
+	}
+	return best
+}
+
+func copyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// restoreFile replaces the database in to with a copy of from.  The
+// database being replaced is moved aside (along with its journal
+// files), rather than deleted; its new name is returned, or "" if
+// there was no database.
+func restoreFile(from, to string) (string, error) {
+	tmp := to + ".restoring"
+	if err := copyFile(from, tmp); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	saved := ""
+	if _, err := os.Stat(to); err == nil {
+		saved = fmt.Sprintf("%s.pre-restore-%s", to, time.Now().Format(backupTimeFormat))
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			err := os.Rename(to+suffix, saved+suffix)
+			if err != nil && !os.IsNotExist(err) {
+				return "", err
+			}
+		}
+	}
+
+	return saved, os.Rename(tmp, to)
+}
+
+// Restore replaces databases with copies from a backup.  The argument
+// is either a snapshot directory, in which case every database in it
+// is restored, or a single database file, such as a snapshot file or
+// a backup made before an upgrade.  All the files are checked before
+// anything is changed.  The server must not be running.
+func Restore(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatalf("Usage: restore <snapshot directory or database file>")
+	}
+	src := fs.Arg(0)
+
+	fi, err := os.Stat(src)
+	if err != nil {
+		log.Fatalf("Restoring: %v", err)
+	}
+
+	dbs, err := backupDatabases()
+	if err != nil {
+		log.Fatalf("Restoring: %v", err)
+	}
+
+	type restoreItem struct {
+		from string
+		db   *backupDatabase
+	}
+	var items []restoreItem
+
+	if fi.IsDir() {
+		for i := range dbs {
+			db := &dbs[i]
+			from := filepath.Join(src, backupName(db.filename))
+			if _, err := os.Stat(from); os.IsNotExist(err) {
+				log.Printf("%s not in %s; leaving it alone", backupName(db.filename), src)
+				continue
+			}
+			items = append(items, restoreItem{from, db})
+		}
+		if len(items) == 0 {
+			log.Fatalf("No databases found in %s", src)
+		}
+	} else {
+		db := restoreTarget(dbs, src)
+		if db == nil {
+			log.Fatalf("Can't tell which database %s is a backup of", src)
+		}
+		items = append(items, restoreItem{src, db})
+	}
+
+	for _, item := range items {
+		version, err := item.db.check(item.from)
+		if err != nil {
+			log.Fatalf("Checking %s: %v", item.from, err)
+		}
+		if version > 0 {
+			fmt.Printf("%s: schema version %d, OK\n", item.from, version)
+		} else {
+			fmt.Printf("%s: OK\n", item.from)
+		}
+	}
+
+	// Databases can't be swapped out from under a running server
+	lock := flock.New(lockfilename)
+	locked, err := lock.TryLock()
+	if err != nil {
+		log.Fatalf("Error locking file %s: %v", lockfilename, err)
+	}
+	if !locked {
+		log.Fatalf("File %s locked; stop the server before restoring", lockfilename)
+	}
+	defer lock.Unlock()
+
+	kvs.Close()
+
+	for _, item := range items {
+		saved, err := restoreFile(item.from, item.db.filename)
+		if err != nil {
+			log.Fatalf("Restoring %s from %s: %v", item.db.filename, item.from, err)
+		}
+		fmt.Printf("Restored %s from %s\n", item.db.filename, item.from)
+		if saved != "" {
+			fmt.Printf("  Previous database saved as %s\n", saved)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"strings"
+)
+
+// Above this many (old words x new words), don't bother working out
+// a proper diff; just show the old text deleted and the new inserted.
+const maxDiffCells = 1000000
+
+// DiffWords returns an HTML rendering of the changes needed to get
+// from old to new, word by word: removed words in <del>, added words
+// in <ins>.  Whitespace is normalized.
+func DiffWords(old, new string) template.HTML {
+	a := strings.Fields(old)
+	b := strings.Fields(new)
+
+	var out strings.Builder
+	var op byte
+	var run []string
+
+	// Coalesce consecutive words with the same operation
+	flush := func() {
+		if len(run) == 0 {
+			return
+		}
+		if out.Len() > 0 {
+			out.WriteByte(' ')
+		}
+		text := html.EscapeString(strings.Join(run, " "))
+		switch op {
+		case '-':
+			out.WriteString("<del>" + text + "</del>")
+		case '+':
+			out.WriteString("<ins>" + text + "</ins>")
+		default:
+			out.WriteString(text)
+		}
+		run = run[:0]
+	}
+	emit := func(o byte, word string) {
+		if o != op {
+			flush()
+			op = o
+		}
+		run = append(run, word)
+	}
+
+	if len(a)*len(b) > maxDiffCells {
+		for _, w := range a {
+			emit('-', w)
+		}
+		for _, w := range b {
+			emit('+', w)
+		}
+		flush()
+		return template.HTML(out.String())
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of
+	// a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			emit('=', a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			emit('-', a[i])
+			i++
+		default:
+			emit('+', b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		emit('-', a[i])
+	}
+	for ; j < len(b); j++ {
+		emit('+', b[j])
+	}
+	flush()
+
+	return template.HTML(out.String())
+}
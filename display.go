@@ -20,23 +20,41 @@ type UserDisplay struct {
 	Username        string
 	IsAdmin         bool
 	IsVerified      bool // Has entered the verification code
+	Role            event.Role
 	MayEdit         bool
+	MaySetRole      bool
 	DefaultLocation string
 	Profile         UserProfile
 	Description     template.HTML // Sanitised description, suitable for displaying
 	List            []*DiscussionDisplay
+
+	// Only filled in for those who may edit the user
+	EmailVerified       bool
+	MaySendVerification bool
+
+	// Only filled in for administrators: the invitation code the
+	// user redeemed, if any
+	Invite *event.Invite
+
+	// Only filled in for the user themselves: the secret token for
+	// their personal calendar feed, and their API tokens.
+	IsSelf        bool
+	CalendarToken string
+	APITokens     []event.APIToken
 }
 
-func UserGetDisplay(u *event.User, cur *event.User, long bool) (ud *UserDisplay) {
+func UserGetDisplay(ev *Event, u *event.User, cur *event.User, long bool) (ud *UserDisplay) {
 	ud = &UserDisplay{
 		UserID:     u.UserID,
 		Username:   u.Username,
 		IsVerified: u.IsVerified,
+		Role:       u.Role,
 	}
 	// Only show profile information to registered users
 	if cur != nil {
 		ud.MayEdit = cur.MayEditUser(u)
 		ud.IsAdmin = cur.IsAdmin
+		ud.MaySetRole = cur.IsAdmin && !u.IsAdminAccount()
 		// Only display profile information to people who are logged in
 		ud.Profile.RealName = u.RealName
 		ud.Profile.Email = u.Email
@@ -44,10 +62,40 @@ func UserGetDisplay(u *event.User, cur *event.User, long bool) (ud *UserDisplay)
 		ud.Profile.Description = u.Description
 		ud.DefaultLocation = u.Location.String()
 		ud.Description = ProcessText(u.Description)
+		if ud.MayEdit {
+			verified, err := ev.UserEmailIsVerified(u)
+			if err != nil {
+				log.Printf("Checking email verification for user %s: %v", u.Username, err)
+			}
+			ud.EmailVerified = verified
+			ud.MaySendVerification = !verified && u.Email != "" && mail != nil
+		}
+		if cur.IsAdmin {
+			inv, err := ev.UserGetInvite(u.UserID)
+			if err != nil {
+				log.Printf("Getting invite for user %s: %v", u.Username, err)
+			}
+			ud.Invite = inv
+		}
+	}
+	if long && cur != nil && cur.UserID == u.UserID {
+		ud.IsSelf = true
+		if kvs.GetBoolDef(ev.Key(FlagScheduleActive)) {
+			token, err := ev.UserGetCalendarToken(u.UserID)
+			if err != nil {
+				log.Printf("Getting calendar token for user %s: %v", u.Username, err)
+			}
+			ud.CalendarToken = token
+		}
+		tokens, err := ev.UserGetAPITokens(u.UserID)
+		if err != nil {
+			log.Printf("Getting API tokens for user %s: %v", u.Username, err)
+		}
+		ud.APITokens = tokens
 	}
 	// But show discussions to everyone.  (This is already available
 	// from the 'sessions' list.)
-	ud.List = DiscussionGetListUser(u, cur)
+	ud.List = DiscussionGetListUser(ev, u, cur)
 	return
 }
 
@@ -64,6 +112,100 @@ type DiscussionDisplay struct {
 	Interest        int
 
 	AllUsers []event.User
+
+	// Why a moderator rejected the discussion (or changes to it);
+	// only filled in for those who may edit it.
+	Rejection *event.Rejection
+
+	// Users who may be chosen as co-facilitators; only filled in
+	// for the owner and admins.
+	FacilitatorChoices []FacilitatorChoice
+
+	// Locations the discussion may be pinned to, and where it's
+	// pinned; only filled in for admins.
+	Locations  []event.Location
+	PinDisplay string
+}
+
+// IsPinnedSlot returns true if the discussion is pinned to slotid.
+func (dd *DiscussionDisplay) IsPinnedSlot(slotid event.SlotID) bool {
+	return dd.Pin != nil && dd.Pin.SlotID == slotid
+}
+
+// IsPinnedLocation returns true if the discussion is pinned to lid.
+func (dd *DiscussionDisplay) IsPinnedLocation(lid event.LocationID) bool {
+	return dd.Pin != nil && dd.Pin.LocationID == lid
+}
+
+// PinSetDisplay fills in what admins need to see and change where dd
+// is pinned.  dd.PossibleSlots must already have their times
+// displayed.
+func PinSetDisplay(ev *Event, dd *DiscussionDisplay) {
+	var err error
+	dd.Locations, err = ev.LocationGetAll()
+	if err != nil {
+		// Report error but continue
+		log.Printf("INTERNAL ERROR: Getting all locations: %v", err)
+	}
+
+	if dd.Pin == nil {
+		return
+	}
+	for i := range dd.PossibleSlots {
+		if dd.PossibleSlots[i].SlotID == dd.Pin.SlotID {
+			dd.PinDisplay = dd.PossibleSlots[i].TimeDisplay
+		}
+	}
+	for i := range dd.Locations {
+		if dd.Locations[i].LocationID == dd.Pin.LocationID {
+			dd.PinDisplay += ", " + dd.Locations[i].LocationName
+		}
+	}
+}
+
+type FacilitatorChoice struct {
+	UserID   event.UserID
+	Username string
+	RealName string
+	Checked  bool
+}
+
+// MayEditFacilitators returns true if cur may change the
+// co-facilitators of d.  Co-facilitators themselves can edit the
+// discussion, but only the owner (or an admin) can change who is
+// facilitating it.
+func MayEditFacilitators(cur *event.User, d *event.Discussion) bool {
+	return cur != nil && (cur.IsAdmin || cur.UserID == d.Owner)
+}
+
+// FacilitatorChoicesGet returns all users other than the admin and
+// the owner of d, marking those who are currently co-facilitators.
+func FacilitatorChoicesGet(ev *Event, d *event.Discussion) (choices []FacilitatorChoice) {
+	users, err := ev.UserGetAll()
+	if err != nil {
+		// Report error but continue
+		log.Printf("INTERNAL ERROR: Getting all users: %v", err)
+		return nil
+	}
+
+	checked := map[event.UserID]bool{}
+	for _, uid := range d.Facilitators {
+		checked[uid] = true
+	}
+
+	for i := range users {
+		u := &users[i]
+		if u.Username == event.AdminUsername || u.UserID == d.Owner {
+			continue
+		}
+		choices = append(choices, FacilitatorChoice{
+			UserID:   u.UserID,
+			Username: u.Username,
+			RealName: u.RealName,
+			Checked:  checked[u.UserID],
+		})
+	}
+	return
 }
 
 func SlotsSetTimeDisplay(slots []event.DisplaySlot, fmt string) {
@@ -74,11 +216,19 @@ func SlotsSetTimeDisplay(slots []event.DisplaySlot, fmt string) {
 
 const slotTimeFormat = "Mon 3:04 PM 2 Jan -0700"
 
+// ImpactSetTimeDisplay formats the slot times of discussions a
+// change would unschedule, in DefaultLocationTZ.
+func ImpactSetTimeDisplay(impact []event.ScheduleImpact, fmt string) {
+	for i := range impact {
+		impact[i].TimeDisplay = impact[i].SlotTime.In(DefaultLocationTZ.Location).Format(fmt)
+	}
+}
+
 // DiscussionGetDisplayRetry returns a DiscussionDisplay suitable for
 // passing back into a new discussion template after a validation
 // error.  We only need Title and DescriptionRaw for normal users.
 // Admins additionally need AllUsers and DiscussionFull.PossibleSlots.
-func DiscussionGetDisplayRetry(df *event.DiscussionFull, cur *event.User) *DiscussionDisplay {
+func DiscussionGetDisplayRetry(ev *Event, df *event.DiscussionFull, cur *event.User) *DiscussionDisplay {
 	dd := &DiscussionDisplay{
 		DiscussionFull: *df,
 		DescriptionRaw: df.Description,
@@ -87,46 +237,56 @@ func DiscussionGetDisplayRetry(df *event.DiscussionFull, cur *event.User) *Discu
 	if cur != nil && cur.IsAdmin {
 		dd.IsAdmin = true
 		var err error
-		dd.AllUsers, err = event.UserGetAll()
+		dd.AllUsers, err = ev.UserGetAll()
 		if err != nil {
 			// Report error but continue
 			log.Printf("INTERNAL ERROR: Getting all users: %v", err)
 		}
 
 		SlotsSetTimeDisplay(dd.PossibleSlots, slotTimeFormat)
+		PinSetDisplay(ev, dd)
 	} else {
 		dd.PossibleSlots = nil
 	}
 
+	if df.DiscussionID != "" && MayEditFacilitators(cur, &df.Discussion) {
+		dd.FacilitatorChoices = FacilitatorChoicesGet(ev, &df.Discussion)
+	}
+
 	return dd
 }
 
-func DiscussionGetDisplay(d *event.DiscussionFull, cur *event.User) *DiscussionDisplay {
-	showMain := true
-
+// DiscussionVisibleText returns the title and description of d which
+// cur is allowed to see, and false if cur isn't allowed to see d at
+// all.
+func DiscussionVisibleText(d *event.DiscussionFull, cur *event.User) (title, description string, ok bool) {
 	// Only display a discussion if:
 	// 1. It's pulbic, or...
-	// 2. The current user is admin, or the discussion owner
+	// 2. The current user is a moderator, or may edit the discussion
 	if !d.IsPublic &&
-		(cur == nil || (!cur.IsAdmin && cur.UserID != d.Owner)) {
+		(cur == nil || !(cur.MayModerate() || cur.MayEditDiscussion(&d.Discussion))) {
 		if d.ApprovedTitle == "" {
-			return nil
-		} else {
-			showMain = false
+			return "", "", false
 		}
+		return d.ApprovedTitle, d.ApprovedDescription, true
+	}
+
+	return d.Title, d.Description, true
+}
+
+func DiscussionGetDisplay(ev *Event, d *event.DiscussionFull, cur *event.User) *DiscussionDisplay {
+	title, description, ok := DiscussionVisibleText(d, cur)
+	if !ok {
+		return nil
 	}
 
 	dd := &DiscussionDisplay{
 		DiscussionFull: *d,
 	}
 
-	if showMain {
-		dd.TitleDisplay = d.Title
-		dd.DescriptionRaw = d.Description
-	} else {
-		dd.Title = d.ApprovedTitle
-		dd.DescriptionRaw = d.ApprovedDescription
-	}
+	dd.Title = title
+	dd.TitleDisplay = title
+	dd.DescriptionRaw = description
 
 	dd.DescriptionHTML = ProcessText(dd.DescriptionRaw)
 
@@ -145,19 +305,27 @@ func DiscussionGetDisplay(d *event.DiscussionFull, cur *event.User) *DiscussionD
 	if cur != nil {
 		if cur.Username != event.AdminUsername {
 			dd.IsUser = true
-			dd.Interest, _ = cur.GetInterest(&d.Discussion)
+			dd.Interest, _ = ev.UserGetInterest(cur, &d.Discussion)
 		}
 		dd.MayEdit = cur.MayEditDiscussion(&d.Discussion)
+		if dd.MayEdit {
+			var err error
+			dd.Rejection, err = ev.DiscussionGetRejection(d.DiscussionID)
+			if err != nil {
+				log.Printf("Getting rejection for discussion %s: %v", d.DiscussionID, err)
+			}
+		}
 		if cur.IsAdmin {
 			dd.IsAdmin = true
 			var err error
-			dd.AllUsers, err = event.UserGetAll()
+			dd.AllUsers, err = ev.UserGetAll()
 			if err != nil {
 				// Report error but continue
 				log.Printf("INTERNAL ERROR: Getting all users: %v", err)
 			}
 
 			SlotsSetTimeDisplay(dd.PossibleSlots, slotTimeFormat)
+			PinSetDisplay(ev, dd)
 		} else {
 			dd.PossibleSlots = nil
 		}
@@ -166,9 +334,9 @@ func DiscussionGetDisplay(d *event.DiscussionFull, cur *event.User) *DiscussionD
 	return dd
 }
 
-func DiscussionGetListUser(u *event.User, cur *event.User) (list []*DiscussionDisplay) {
-	event.DiscussionIterateUser(u.UserID, func(d *event.DiscussionFull) error {
-		dd := DiscussionGetDisplay(d, cur)
+func DiscussionGetListUser(ev *Event, u *event.User, cur *event.User) (list []*DiscussionDisplay) {
+	ev.DiscussionIterateUser(u.UserID, func(d *event.DiscussionFull) error {
+		dd := DiscussionGetDisplay(ev, d, cur)
 		if dd != nil {
 			list = append(list, dd)
 		}
@@ -178,9 +346,9 @@ func DiscussionGetListUser(u *event.User, cur *event.User) (list []*DiscussionDi
 	return
 }
 
-func DiscussionGetList(cur *event.User) (list []*DiscussionDisplay) {
-	err := event.DiscussionIterate(func(d *event.DiscussionFull) error {
-		dd := DiscussionGetDisplay(d, cur)
+func DiscussionGetList(ev *Event, cur *event.User) (list []*DiscussionDisplay) {
+	err := ev.DiscussionIterate(func(d *event.DiscussionFull) error {
+		dd := DiscussionGetDisplay(ev, d, cur)
 		if dd != nil {
 			list = append(list, dd)
 		}
@@ -194,10 +362,23 @@ func DiscussionGetList(cur *event.User) (list []*DiscussionDisplay) {
 	return
 }
 
-func UserGetUsersDisplay(cur *event.User) (users []*UserDisplay) {
-	event.UserIterate(func(u *event.User) error {
+// userGetReassignChoices lists the users who could be given the
+// discussions of user when it's deleted.  The admin user is offered
+// separately.
+func userGetReassignChoices(ev *Event, user *event.User) (users []event.User) {
+	ev.UserIterate(func(u *event.User) error {
+		if u.Username != event.AdminUsername && u.UserID != user.UserID {
+			users = append(users, *u)
+		}
+		return nil
+	})
+	return
+}
+
+func UserGetUsersDisplay(ev *Event, cur *event.User) (users []*UserDisplay) {
+	ev.UserIterate(func(u *event.User) error {
 		if u.Username != event.AdminUsername {
-			users = append(users, UserGetDisplay(u, cur, false))
+			users = append(users, UserGetDisplay(ev, u, cur, false))
 		}
 		return nil
 	})
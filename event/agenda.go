@@ -0,0 +1,140 @@
+package event
+
+import (
+	"github.com/jmoiron/sqlx"
+)
+
+type AgendaDiscussion struct {
+	DiscussionID  DiscussionID
+	Title         string
+	Interest      int
+	IsFacilitator bool
+	LocationName  string
+	LocationURL   string
+}
+
+type AgendaSlot struct {
+	Time        Time
+	TimeDisplay string
+	IsBreak     bool
+
+	// The discussion the user should go to, if any, and the
+	// discussions they're interested in which clash with it.
+	Attending *AgendaDiscussion
+	Missed    []AgendaDiscussion
+}
+
+type AgendaDay struct {
+	DayName string
+	Slots   []AgendaSlot
+}
+
+// Agenda is a single user's view of the schedule.  Unscheduled lists
+// discussions the user is interested in which haven't been scheduled.
+type Agenda struct {
+	Days        []AgendaDay
+	Unscheduled []AgendaDiscussion
+}
+
+// GetAgenda gets the personal agenda for userid: for each slot, the
+// scheduled discussion they're most interested in, and any other
+// discussions they're interested in which they'll miss because of
+// it.  Discussions the user is facilitating always take priority.
+// tfmt and tzl are as for GetTimetable().
+func (store *EventStore) GetAgenda(userid UserID, tfmt string, tzl *TZLocation) (*Agenda, error) {
+	var agenda *Agenda
+	err := store.txLoop(func(eq sqlx.Ext) error {
+		agenda = &Agenda{}
+
+		err := sqlx.Select(eq, &agenda.Days,
+			`select dayname from event_days order by dayid asc`)
+		if err != nil {
+			return errOrRetry("Getting day list", err)
+		}
+
+		for i := range agenda.Days {
+			ad := &agenda.Days[i]
+			dayID := i + 1
+
+			err = sqlx.Select(eq, &ad.Slots,
+				`select slottime as time, isbreak
+                     from event_slots
+                     where dayid=?
+                     order by slotidx asc`, dayID)
+			if err != nil {
+				return errOrRetry("Getting slots for one day", err)
+			}
+
+			if tfmt != "" {
+				for j := range ad.Slots {
+					t := ad.Slots[j].Time.Time
+					if tzl != nil && tzl.Location != nil {
+						t = t.In(tzl.Location)
+					}
+					ad.Slots[j].TimeDisplay = t.Format(tfmt)
+				}
+			}
+		}
+
+		// Most important discussions first within each slot
+		var scheduled []struct {
+			AgendaDiscussion
+			DayID   int
+			SlotIDX int
+		}
+		err = sqlx.Select(eq, &scheduled, `
+            select dayid, slotidx, discussionid, title, interest,
+                   (owner = :uid or discussionid in
+                       (select discussionid
+                            from event_discussions_facilitators
+                            where userid = :uid)) as isfacilitator,
+                   locationname, locationurl
+                from event_interest
+                    natural join event_discussions
+                    natural join event_schedule
+                    natural join event_slots
+                    natural join event_locations
+                where userid = :uid and interest > 0
+                order by dayid, slotidx, isfacilitator desc, interest desc, title`,
+			userid)
+		if err != nil {
+			return errOrRetry("Getting scheduled discussions for user", err)
+		}
+
+		for i := range scheduled {
+			sd := &scheduled[i]
+			if sd.DayID < 1 || sd.DayID > len(agenda.Days) ||
+				sd.SlotIDX < 1 || sd.SlotIDX > len(agenda.Days[sd.DayID-1].Slots) {
+				continue
+			}
+			as := &agenda.Days[sd.DayID-1].Slots[sd.SlotIDX-1]
+			if as.Attending == nil {
+				as.Attending = &sd.AgendaDiscussion
+			} else {
+				as.Missed = append(as.Missed, sd.AgendaDiscussion)
+			}
+		}
+
+		err = sqlx.Select(eq, &agenda.Unscheduled, `
+            select discussionid, title, interest,
+                   (owner = :uid or discussionid in
+                       (select discussionid
+                            from event_discussions_facilitators
+                            where userid = :uid)) as isfacilitator
+                from event_interest
+                    natural join event_discussions
+                where userid = :uid and interest > 0 and deleted = 0
+                    and discussionid not in (select discussionid from event_schedule)
+                order by interest desc, title`,
+			userid)
+		if err != nil {
+			return errOrRetry("Getting unscheduled discussions for user", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return agenda, nil
+}
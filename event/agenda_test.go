@@ -0,0 +1,111 @@
+package event
+
+import (
+	"testing"
+)
+
+func testUnitAgenda(t *testing.T) (exit bool) {
+	// Any "early" exit is a failure
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+
+	m := &mirrorData{}
+
+	t.Logf("Setting up users, discussion, timetable")
+	// More discussions than slots * locations, so some are unplaced
+	if testScheduleSearchSetup(t, m, 20, 2) {
+		return
+	}
+
+	if err := event.MakeSchedule(SearchOptions{}); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+
+	for i := range m.users {
+		user := &m.users[i]
+
+		agenda, err := event.GetAgenda(user.UserID, "3:04pm Jan 2", nil)
+		if err != nil {
+			t.Errorf("GetAgenda: %v", err)
+			return
+		}
+
+		// Every discussion the user is interested in should show up
+		// exactly once.
+		seen := map[DiscussionID]bool{}
+		check := func(ad *AgendaDiscussion) bool {
+			if seen[ad.DiscussionID] {
+				t.Errorf("User %s: Discussion %v appears twice", user.Username, ad.DiscussionID)
+				return true
+			}
+			seen[ad.DiscussionID] = true
+			interest, err := event.UserGetInterest(user, &Discussion{DiscussionID: ad.DiscussionID})
+			if err != nil || interest != ad.Interest || interest == 0 {
+				t.Errorf("User %s: Discussion %v interest %d, wanted %d (err %v)",
+					user.Username, ad.DiscussionID, ad.Interest, interest, err)
+				return true
+			}
+			return false
+		}
+
+		for _, day := range agenda.Days {
+			for _, slot := range day.Slots {
+				if slot.TimeDisplay == "" {
+					t.Errorf("Slot missing TimeDisplay")
+					return
+				}
+				if slot.Attending == nil {
+					if len(slot.Missed) > 0 {
+						t.Errorf("User %s: Missed discussions but not attending any", user.Username)
+						return
+					}
+					continue
+				}
+				if check(slot.Attending) {
+					return
+				}
+				for j := range slot.Missed {
+					missed := &slot.Missed[j]
+					if check(missed) {
+						return
+					}
+					if !slot.Attending.IsFacilitator &&
+						(missed.IsFacilitator || missed.Interest > slot.Attending.Interest) {
+						t.Errorf("User %s: Attending %v (interest %d) rather than %v (interest %d)",
+							user.Username, slot.Attending.DiscussionID, slot.Attending.Interest,
+							missed.DiscussionID, missed.Interest)
+						return
+					}
+				}
+			}
+		}
+		for j := range agenda.Unscheduled {
+			if check(&agenda.Unscheduled[j]) {
+				return
+			}
+		}
+
+		var count int
+		err = event.Get(&count, `
+            select count(*) from event_interest
+                where userid = ? and interest > 0`, user.UserID)
+		if err != nil {
+			t.Errorf("Getting interest count: %v", err)
+			return
+		}
+		if count != len(seen) {
+			t.Errorf("User %s: Interested in %d discussions, but agenda has %d",
+				user.Username, count, len(seen))
+			return
+		}
+	}
+
+	tc.cleanup()
+
+	return false
+}
@@ -0,0 +1,149 @@
+package event
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/gwd/session-scheduler/id"
+)
+
+const (
+	apiTokenIDLength = 16
+	apiTokenLength   = 32
+
+	// Prefix for the secret part of API tokens, so that they're
+	// easy to recognise (e.g., by secret scanners)
+	apiTokenPrefix = "sst"
+)
+
+type APITokenID string
+
+func (tid *APITokenID) generate() {
+	*tid = APITokenID(id.GenerateID("tok", apiTokenIDLength))
+}
+
+// APIToken is a personal access token, which a user can use to
+// access the API without logging in.  Only a hash of the token itself
+// is stored; the token is shown to the user once, when it's created.
+// Read-only tokens may only be used for requests which don't change
+// anything.
+type APIToken struct {
+	TokenID     APITokenID
+	UserID      UserID
+	Name        string
+	HashedToken string `json:"-"`
+	ReadOnly    bool
+	Created     Time
+}
+
+// secretHash hashes a secret token for storage.  Tokens are long and
+// random, so a fast hash is sufficient; and unlike bcrypt, it lets us
+// look tokens up directly.
+func secretHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewAPIToken creates a new token for userid, returning the token
+// itself (which must be shown to the user, as it can't be retrieved
+// later) along with its record.
+func (store *EventStore) NewAPIToken(userid UserID, name string, readOnly bool) (string, *APIToken, error) {
+	if name == "" || AllWhitespace(name) {
+		return "", nil, errAPITokenNoName
+	}
+
+	token := id.GenerateID(apiTokenPrefix, apiTokenLength)
+	at := &APIToken{
+		UserID:      userid,
+		Name:        name,
+		HashedToken: secretHash(token),
+		ReadOnly:    readOnly,
+		Created:     Time{time.Now()},
+	}
+	at.TokenID.generate()
+
+	err := store.txLoop(func(eq sqlx.Ext) error {
+		_, err := sqlx.NamedExec(eq, `
+            insert into event_api_tokens
+                values(:tokenid, :userid, :name, :hashedtoken, :readonly, :created)`,
+			at)
+		if isErrorForeignKey(err) {
+			return ErrUserNotFound
+		} else if err != nil {
+			return errOrRetry("Inserting API token", err)
+		}
+		return auditTx(eq, userid, AuditAPITokenCreate, string(at.TokenID), nil, at)
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, at, nil
+}
+
+// UserGetAPITokens returns userid's tokens, oldest first.
+func (store *EventStore) UserGetAPITokens(userid UserID) ([]APIToken, error) {
+	var tokens []APIToken
+	err := store.txLoop(func(eq sqlx.Ext) error {
+		tokens = nil
+		err := sqlx.Select(eq, &tokens, `
+            select * from event_api_tokens
+                where userid = ?
+                order by created, tokenid`, userid)
+		if err != nil {
+			return errOrRetry("Getting API tokens", err)
+		}
+		return nil
+	})
+	return tokens, err
+}
+
+// DeleteAPIToken revokes one of userid's tokens.
+func (store *EventStore) DeleteAPIToken(userid UserID, tid APITokenID) error {
+	return store.txLoop(func(eq sqlx.Ext) error {
+		var at APIToken
+		err := sqlx.Get(eq, &at, `
+            select * from event_api_tokens
+                where userid = ? and tokenid = ?`, userid, tid)
+		if err == sql.ErrNoRows {
+			return ErrAPITokenNotFound
+		} else if err != nil {
+			return errOrRetry("Getting API token", err)
+		}
+
+		_, err = eq.Exec(`
+            delete from event_api_tokens
+                where userid = ? and tokenid = ?`, userid, tid)
+		if err != nil {
+			return errOrRetry("Deleting API token", err)
+		}
+		return auditTx(eq, userid, AuditAPITokenDelete, string(tid), &at, nil)
+	})
+}
+
+// UserFindByAPIToken returns the user to whom token belongs, along
+// with the token's record; or nil if there's no such token.
+func (store *EventStore) UserFindByAPIToken(token string) (*User, *APIToken, error) {
+	var user User
+	var at APIToken
+	hashed := secretHash(token)
+	err := store.txLoop(func(eq sqlx.Ext) error {
+		err := sqlx.Get(eq, &at, `
+            select * from event_api_tokens where hashedtoken = ?`, hashed)
+		if err != nil {
+			return err
+		}
+		return userGetTx(eq, at.UserID, &user)
+	})
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil, nil
+	case err != nil:
+		return nil, nil, err
+	}
+	return &user, &at, nil
+}
@@ -0,0 +1,132 @@
+package event
+
+import (
+	"strings"
+	"testing"
+)
+
+func testUnitAPITokens(t *testing.T) (exit bool) {
+	// Any "early" exit is a failure
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+
+	m := &mirrorData{}
+
+	if testNewUsers(t, m, 2) {
+		return
+	}
+
+	user := &m.users[0]
+	other := &m.users[1]
+
+	t.Logf("Creating tokens")
+	if _, _, err := event.NewAPIToken(user.UserID, " ", false); err != errAPITokenNoName {
+		t.Errorf("Expected errAPITokenNoName, got %v", err)
+		return
+	}
+
+	if _, _, err := event.NewAPIToken(UserID("invalid"), "test", false); err != ErrUserNotFound {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+		return
+	}
+
+	rwToken, rw, err := event.NewAPIToken(user.UserID, "read-write", false)
+	if err != nil {
+		t.Errorf("NewAPIToken: %v", err)
+		return
+	}
+	if !strings.HasPrefix(rwToken, apiTokenPrefix+"_") || rw.HashedToken == rwToken {
+		t.Errorf("Unexpected token %q (hash %q)", rwToken, rw.HashedToken)
+		return
+	}
+
+	roToken, ro, err := event.NewAPIToken(user.UserID, "read-only", true)
+	if err != nil {
+		t.Errorf("NewAPIToken: %v", err)
+		return
+	}
+
+	otherToken, _, err := event.NewAPIToken(other.UserID, "other", false)
+	if err != nil {
+		t.Errorf("NewAPIToken: %v", err)
+		return
+	}
+
+	tokens, err := event.UserGetAPITokens(user.UserID)
+	if err != nil || len(tokens) != 2 {
+		t.Errorf("UserGetAPITokens: expected 2 tokens, got %v (err %v)", tokens, err)
+		return
+	}
+	for i := range tokens {
+		if tokens[i].HashedToken == rwToken || tokens[i].HashedToken == roToken {
+			t.Errorf("Token stored unhashed")
+			return
+		}
+	}
+
+	t.Logf("Looking up tokens")
+	for _, c := range []struct {
+		token    string
+		userid   UserID
+		readOnly bool
+	}{
+		{rwToken, user.UserID, false},
+		{roToken, user.UserID, true},
+		{otherToken, other.UserID, false},
+	} {
+		found, at, err := event.UserFindByAPIToken(c.token)
+		if err != nil || found == nil || at == nil {
+			t.Errorf("UserFindByAPIToken: got %v %v, err %v", found, at, err)
+			return
+		}
+		if found.UserID != c.userid || at.ReadOnly != c.readOnly {
+			t.Errorf("UserFindByAPIToken: got user %v readonly %v, wanted %v %v",
+				found.UserID, at.ReadOnly, c.userid, c.readOnly)
+			return
+		}
+	}
+
+	if found, at, err := event.UserFindByAPIToken("sst_invalid"); err != nil || found != nil || at != nil {
+		t.Errorf("UserFindByAPIToken with invalid token: got %v %v, err %v", found, at, err)
+		return
+	}
+
+	t.Logf("Revoking tokens")
+	if err := event.DeleteAPIToken(other.UserID, ro.TokenID); err != ErrAPITokenNotFound {
+		t.Errorf("Deleting another user's token: expected ErrAPITokenNotFound, got %v", err)
+		return
+	}
+
+	if err := event.DeleteAPIToken(user.UserID, ro.TokenID); err != nil {
+		t.Errorf("DeleteAPIToken: %v", err)
+		return
+	}
+
+	if found, _, err := event.UserFindByAPIToken(roToken); err != nil || found != nil {
+		t.Errorf("Revoked token still valid: got %v, err %v", found, err)
+		return
+	}
+
+	if found, _, err := event.UserFindByAPIToken(rwToken); err != nil || found == nil {
+		t.Errorf("Other token no longer valid: got %v, err %v", found, err)
+		return
+	}
+
+	if err := event.DeleteUser("", other.UserID, ""); err != nil {
+		t.Errorf("Deleting user: %v", err)
+		return
+	}
+
+	if found, _, err := event.UserFindByAPIToken(otherToken); err != nil || found != nil {
+		t.Errorf("Token for deleted user still valid: got %v, err %v", found, err)
+		return
+	}
+
+	tc.cleanup()
+
+	return false
+}
@@ -0,0 +1,209 @@
+package event
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// AuditAction says what kind of change an audit entry records.
+type AuditAction string
+
+const (
+	AuditUserCreate        = AuditAction("user.create")
+	AuditUserUpdate        = AuditAction("user.update")
+	AuditUserSetVerified   = AuditAction("user.setverified")
+	AuditUserSetRole       = AuditAction("user.setrole")
+	AuditUserResetPassword = AuditAction("user.resetpassword")
+	AuditUserVerifyEmail   = AuditAction("user.verifyemail")
+	AuditUserResetCalendar = AuditAction("user.resetcalendar")
+	AuditUserDelete        = AuditAction("user.delete")
+	AuditUserRestore       = AuditAction("user.restore")
+	AuditUserPurge         = AuditAction("user.purge")
+
+	AuditInterestSet = AuditAction("interest.set")
+
+	AuditDiscussionCreate           = AuditAction("discussion.create")
+	AuditDiscussionUpdate           = AuditAction("discussion.update")
+	AuditDiscussionSetPublic        = AuditAction("discussion.setpublic")
+	AuditDiscussionReject           = AuditAction("discussion.reject")
+	AuditDiscussionSetPossibleSlots = AuditAction("discussion.setpossibleslots")
+	AuditDiscussionSetFacilitators  = AuditAction("discussion.setfacilitators")
+	AuditDiscussionSetLength        = AuditAction("discussion.setlength")
+	AuditDiscussionSetPin           = AuditAction("discussion.setpin")
+	AuditDiscussionDelete           = AuditAction("discussion.delete")
+	AuditDiscussionRestore          = AuditAction("discussion.restore")
+	AuditDiscussionPurge            = AuditAction("discussion.purge")
+
+	AuditLocationCreate = AuditAction("location.create")
+	AuditLocationUpdate = AuditAction("location.update")
+	AuditLocationDelete = AuditAction("location.delete")
+
+	AuditTimetableSetLocked = AuditAction("timetable.setlocked")
+	AuditTimetableSet       = AuditAction("timetable.set")
+	AuditDayCreate          = AuditAction("day.create")
+	AuditDayUpdate          = AuditAction("day.update")
+	AuditDayDelete          = AuditAction("day.delete")
+
+	AuditScheduleRun = AuditAction("schedule.run")
+
+	AuditEventImport = AuditAction("event.import")
+
+	AuditInviteCreate = AuditAction("invite.create")
+	AuditInviteRevoke = AuditAction("invite.revoke")
+	AuditInviteRedeem = AuditAction("invite.redeem")
+
+	AuditAPITokenCreate = AuditAction("apitoken.create")
+	AuditAPITokenDelete = AuditAction("apitoken.delete")
+)
+
+// AuditActions lists all actions, for filtering.
+var AuditActions = []AuditAction{
+	AuditUserCreate, AuditUserUpdate, AuditUserSetVerified,
+	AuditUserSetRole, AuditUserResetPassword, AuditUserVerifyEmail,
+	AuditUserResetCalendar, AuditUserDelete, AuditUserRestore, AuditUserPurge,
+	AuditInterestSet,
+	AuditDiscussionCreate, AuditDiscussionUpdate, AuditDiscussionSetPublic,
+	AuditDiscussionReject, AuditDiscussionSetPossibleSlots,
+	AuditDiscussionSetFacilitators, AuditDiscussionSetLength, AuditDiscussionSetPin,
+	AuditDiscussionDelete,
+	AuditDiscussionRestore, AuditDiscussionPurge,
+	AuditLocationCreate, AuditLocationUpdate, AuditLocationDelete,
+	AuditTimetableSetLocked, AuditTimetableSet,
+	AuditDayCreate, AuditDayUpdate, AuditDayDelete,
+	AuditScheduleRun, AuditEventImport,
+	AuditInviteCreate, AuditInviteRevoke, AuditInviteRedeem,
+	AuditAPITokenCreate, AuditAPITokenDelete,
+}
+
+// AuditEntry records a single change.  Before and After are JSON
+// renderings of the target (or the relevant parts of it); Before is
+// empty for things which have just been created, and After for
+// things which have just been deleted.
+type AuditEntry struct {
+	AuditID   int64
+	Time      int64 // Unix time
+	Actor     UserID
+	ActorName string
+	Action    AuditAction
+	Target    string
+	Before    string
+	After     string
+}
+
+// When returns the time at which the change was made.
+func (ae *AuditEntry) When() time.Time {
+	return time.Unix(ae.Time, 0)
+}
+
+// auditTx records a change made by actor to target as part of the
+// transaction eq.  actor may be empty for changes not made on behalf
+// of any user (e.g., from the command line).  before and after are
+// rendered as JSON; pass nil if there's nothing to record.
+func auditTx(eq sqlx.Ext, actor UserID, action AuditAction, target string, before, after interface{}) error {
+	var actorName string
+	if actor != "" {
+		err := sqlx.Get(eq, &actorName,
+			`select username from event_users where userid = ?`, actor)
+		if err != nil && err != sql.ErrNoRows {
+			return errOrRetry("Getting audit actor", err)
+		}
+	}
+
+	beforeJSON, err := auditJSON(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := auditJSON(after)
+	if err != nil {
+		return err
+	}
+
+	_, err = eq.Exec(`
+        insert into event_audit(time, actor, actorname, action, target, before, after)
+            values(?, ?, ?, ?, ?, ?, ?)`,
+		time.Now().Unix(), actor, actorName, action, target, beforeJSON, afterJSON)
+	if err != nil {
+		return errOrRetry("Inserting audit entry", err)
+	}
+	return nil
+}
+
+func auditJSON(v interface{}) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// AuditFilter selects audit entries.  Empty fields match everything.
+// Actor matches either the user ID or the username; Text matches
+// anywhere in the before or after JSON.
+type AuditFilter struct {
+	Actor  string
+	Action AuditAction
+	Target string
+	Text   string
+	Since  time.Time
+	Until  time.Time
+
+	Limit  int // Default (and maximum) auditMaxLimit
+	Offset int
+}
+
+const auditMaxLimit = 500
+
+// AuditGet returns the entries matching f, newest first.
+func (store *EventStore) AuditGet(f *AuditFilter) ([]AuditEntry, error) {
+	q := `select * from event_audit where true`
+	var args []interface{}
+
+	if f.Actor != "" {
+		q += ` and (actor = ? or actorname = ?)`
+		args = append(args, f.Actor, f.Actor)
+	}
+	if f.Action != "" {
+		q += ` and action = ?`
+		args = append(args, f.Action)
+	}
+	if f.Target != "" {
+		q += ` and target = ?`
+		args = append(args, f.Target)
+	}
+	if f.Text != "" {
+		q += ` and (instr(before, ?) > 0 or instr(after, ?) > 0)`
+		args = append(args, f.Text, f.Text)
+	}
+	if !f.Since.IsZero() {
+		q += ` and time >= ?`
+		args = append(args, f.Since.Unix())
+	}
+	if !f.Until.IsZero() {
+		q += ` and time < ?`
+		args = append(args, f.Until.Unix())
+	}
+
+	limit := f.Limit
+	if limit <= 0 || limit > auditMaxLimit {
+		limit = auditMaxLimit
+	}
+	q += ` order by auditid desc limit ? offset ?`
+	args = append(args, limit, f.Offset)
+
+	var entries []AuditEntry
+	err := store.txLoop(func(eq sqlx.Ext) error {
+		entries = nil
+		err := sqlx.Select(eq, &entries, q, args...)
+		if err != nil {
+			return errOrRetry("Getting audit entries", err)
+		}
+		return nil
+	})
+	return entries, err
+}
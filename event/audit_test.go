@@ -0,0 +1,127 @@
+package event
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func testUnitAudit(t *testing.T) (exit bool) {
+	// Any "early" exit is a failure
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+
+	m := &mirrorData{}
+
+	if testNewUsers(t, m, 2) {
+		return
+	}
+	owner, other := &m.users[0], &m.users[1]
+
+	t.Logf("Creating and deleting a discussion")
+	disc := Discussion{Owner: owner.UserID, Title: "Audited", Description: "Who deleted this?"}
+	if err := event.NewDiscussion(&disc); err != nil {
+		t.Errorf("NewDiscussion: %v", err)
+		return
+	}
+	if err := event.UserSetInterest(other, &disc, 42); err != nil {
+		t.Errorf("SetInterest: %v", err)
+		return
+	}
+	if err := event.DeleteDiscussion(other.UserID, disc.DiscussionID); err != nil {
+		t.Errorf("DeleteDiscussion: %v", err)
+		return
+	}
+
+	entries, err := event.AuditGet(&AuditFilter{Target: string(disc.DiscussionID)})
+	if err != nil {
+		t.Errorf("AuditGet: %v", err)
+		return
+	}
+	wantActions := []AuditAction{AuditDiscussionDelete, AuditInterestSet, AuditDiscussionCreate}
+	if len(entries) != len(wantActions) {
+		t.Errorf("Expected %d entries for discussion, got %v", len(wantActions), entries)
+		return
+	}
+	for i := range entries {
+		if entries[i].Action != wantActions[i] {
+			t.Errorf("Entry %d: expected action %v, got %v", i, wantActions[i], entries[i].Action)
+			return
+		}
+	}
+
+	del := &entries[0]
+	if del.Actor != other.UserID || del.ActorName != other.Username {
+		t.Errorf("Delete recorded as by %v (%s), expected %v (%s)",
+			del.Actor, del.ActorName, other.UserID, other.Username)
+		return
+	}
+	if !strings.Contains(del.Before, "Who deleted this?") || del.After != "" {
+		t.Errorf("Unexpected before/after for delete: %q / %q", del.Before, del.After)
+		return
+	}
+	if entries[2].Before != "" || entries[2].Actor != owner.UserID {
+		t.Errorf("Unexpected create entry %v", entries[2])
+		return
+	}
+
+	t.Logf("Filtering")
+	entries, err = event.AuditGet(&AuditFilter{Actor: other.Username, Action: AuditInterestSet})
+	if err != nil || len(entries) != 1 || !strings.Contains(entries[0].After, "42") {
+		t.Errorf("AuditGet by username and action: got %v, err %v", entries, err)
+		return
+	}
+	entries, err = event.AuditGet(&AuditFilter{Text: "Who deleted"})
+	if err != nil || len(entries) != 2 {
+		t.Errorf("AuditGet by text: got %v, err %v", entries, err)
+		return
+	}
+	entries, err = event.AuditGet(&AuditFilter{Since: time.Now().Add(time.Hour)})
+	if err != nil || len(entries) != 0 {
+		t.Errorf("AuditGet in the future: got %v, err %v", entries, err)
+		return
+	}
+	entries, err = event.AuditGet(&AuditFilter{Limit: 1})
+	if err != nil || len(entries) != 1 || entries[0].Action != AuditDiscussionDelete {
+		t.Errorf("AuditGet with limit: got %v, err %v", entries, err)
+		return
+	}
+
+	t.Logf("Checking secrets aren't recorded")
+	// NB the admin user is created along with the database
+	entries, err = event.AuditGet(&AuditFilter{Action: AuditUserCreate})
+	if err != nil || len(entries) != len(m.users)+1 {
+		t.Errorf("AuditGet user creation: got %v, err %v", entries, err)
+		return
+	}
+	for i := range entries {
+		if strings.Contains(entries[i].After, "HashedPassword") {
+			t.Errorf("Password hash recorded in audit log: %s", entries[i].After)
+			return
+		}
+	}
+
+	t.Logf("Deleting a user")
+	if err := event.DeleteUser(owner.UserID, owner.UserID, ""); err != nil {
+		t.Errorf("DeleteUser: %v", err)
+		return
+	}
+	entries, err = event.AuditGet(&AuditFilter{Action: AuditUserDelete})
+	if err != nil || len(entries) != 1 {
+		t.Errorf("AuditGet user deletion: got %v, err %v", entries, err)
+		return
+	}
+	// Users deleting themselves should still be identifiable
+	if entries[0].ActorName != owner.Username || entries[0].Target != string(owner.UserID) {
+		t.Errorf("Unexpected user deletion entry %v", entries[0])
+		return
+	}
+
+	tc.cleanup()
+
+	return false
+}
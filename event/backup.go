@@ -0,0 +1,63 @@
+package event
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Backup writes a consistent snapshot of the event database to
+// filename, which must not exist yet.  It's safe to call while the
+// database is in use.
+func (store *EventStore) Backup(filename string) error {
+	_, err := store.Exec(`vacuum into ?`, filename)
+	if err != nil {
+		return fmt.Errorf("Backing up event database to %s: %v", filename, err)
+	}
+	return nil
+}
+
+// CheckBackup makes sure filename is an intact event database which
+// this program can use, either directly or after upgrading it, and
+// returns its schema version.
+func CheckBackup(filename string) (int, error) {
+	if _, err := os.Stat(filename); err != nil {
+		return 0, err
+	}
+
+	db, err := sqlx.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro", filename))
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	if err := checkIntegrity(db); err != nil {
+		return 0, err
+	}
+
+	var version int
+	err = db.Get(&version, "pragma user_version")
+	if err != nil {
+		return 0, fmt.Errorf("Getting schema version: %v", err)
+	}
+	if version == 0 {
+		return 0, fmt.Errorf("%s isn't an event database", filename)
+	}
+	if _, err := pendingMigrations(version); err != nil {
+		return version, err
+	}
+	return version, nil
+}
+
+func checkIntegrity(q sqlx.Queryer) error {
+	var result string
+	err := sqlx.Get(q, &result, "pragma integrity_check")
+	if err != nil {
+		return fmt.Errorf("Checking integrity: %v", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("Integrity check failed: %s", result)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package event
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func testUnitBackup(t *testing.T) (exit bool) {
+	// Any "early" exit is a failure
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+
+	m := &mirrorData{}
+
+	if testNewUsers(t, m, 2) {
+		return
+	}
+
+	bfname := tc.tmpdir + "/backup.sqlite"
+
+	t.Logf("Backing up")
+	if err := event.Backup(bfname); err != nil {
+		t.Errorf("Backup: %v", err)
+		return
+	}
+	if err := event.Backup(bfname); err == nil {
+		t.Errorf("Backing up over an existing file succeeded")
+		return
+	}
+	if version, err := CheckBackup(bfname); err != nil || version != codeSchemaVersion {
+		t.Errorf("CheckBackup: got version %d, err %v", version, err)
+		return
+	}
+	if _, err := CheckBackup(tc.tmpdir + "/nonexistent"); err == nil {
+		t.Errorf("CheckBackup of non-existent file succeeded")
+		return
+	}
+
+	// The backup has the same data
+	db, err := sqlx.Open("sqlite3", dbDSN(bfname))
+	if err != nil {
+		t.Errorf("Opening backup: %v", err)
+		return
+	}
+	defer db.Close()
+	var username string
+	err = db.Get(&username, `select username from event_users where userid = ?`,
+		m.users[0].UserID)
+	if err != nil || username != m.users[0].Username {
+		t.Errorf("Finding user in backup: got %s, err %v", username, err)
+		return
+	}
+
+	t.Logf("Checking unusable backups")
+	for _, version := range []int{0, 1, codeSchemaVersion + 1} {
+		if _, err := db.Exec(fmt.Sprintf("pragma user_version=%d", version)); err != nil {
+			t.Errorf("Setting user version: %v", err)
+			return
+		}
+		if _, err := CheckBackup(bfname); err == nil {
+			t.Errorf("CheckBackup of version %d succeeded", version)
+			return
+		}
+	}
+
+	tc.cleanup()
+
+	return false
+}
@@ -0,0 +1,179 @@
+package event
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/gwd/session-scheduler/id"
+)
+
+const calendarTokenLength = 24
+
+// Length of a slot if it can't be inferred from the following slot
+const defaultSlotLength = time.Hour
+
+// CalendarEntry is a single scheduled discussion, suitable for
+// exporting to a calendar.  Title and Description are those visible
+// to the public (i.e., the approved ones if the discussion has
+// changes awaiting moderation).
+type CalendarEntry struct {
+	DiscussionID DiscussionID
+	Title        string
+	Description  string
+	Start        Time
+	End          Time
+	LocationName string
+	LocationURL  string
+}
+
+// GetCalendar returns all scheduled discussions, in time order.
+//
+// Each discussion ends when the next slot on the same day starts; if
+// it's the last slot of the day, it's assumed to be as long as the
+// slot before it.  Discussions longer than one slot have a single
+// entry, ending with their last slot.
+func (store *EventStore) GetCalendar() ([]CalendarEntry, error) {
+	var entries []CalendarEntry
+	err := store.txLoop(func(eq sqlx.Ext) error {
+		var slots []struct {
+			SlotID   SlotID
+			DayID    DayID
+			SlotTime Time
+		}
+		err := sqlx.Select(eq, &slots, `
+            select slotid, dayid, slottime
+                from event_slots
+                order by dayid, slotidx`)
+		if err != nil {
+			return errOrRetry("Getting slots", err)
+		}
+
+		ends := map[SlotID]Time{}
+		for i := range slots {
+			s := &slots[i]
+			switch {
+			case i+1 < len(slots) && slots[i+1].DayID == s.DayID:
+				ends[s.SlotID] = slots[i+1].SlotTime
+			case i > 0 && slots[i-1].DayID == s.DayID:
+				ends[s.SlotID] = Time{s.SlotTime.Add(s.SlotTime.Sub(slots[i-1].SlotTime.Time))}
+			default:
+				ends[s.SlotID] = Time{s.SlotTime.Add(defaultSlotLength)}
+			}
+		}
+
+		var rows []struct {
+			CalendarEntry
+			SlotID SlotID
+		}
+		err = sqlx.Select(eq, &rows, `
+            select discussionid,
+                   case when ispublic then title else approvedtitle end as title,
+                   case when ispublic then description else approveddescription end as description,
+                   slotid,
+                   slottime as start,
+                   locationname,
+                   locationurl
+                from event_schedule
+                    natural join event_discussions
+                    natural join event_slots
+                    natural join event_locations
+                where ispublic or approvedtitle != ''
+                order by dayid, slotidx, locationid`)
+		if err != nil {
+			return errOrRetry("Getting scheduled discussions", err)
+		}
+
+		entries = nil
+		index := map[DiscussionID]int{}
+		for i := range rows {
+			if j, ok := index[rows[i].DiscussionID]; ok {
+				entries[j].End = ends[rows[i].SlotID]
+				continue
+			}
+			index[rows[i].DiscussionID] = len(entries)
+			entries = append(entries, rows[i].CalendarEntry)
+			entries[len(entries)-1].End = ends[rows[i].SlotID]
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func calendarTokenGenerate() string {
+	return id.GenerateRawID(calendarTokenLength)
+}
+
+// UserGetCalendarToken returns the secret token used in the URL of
+// userid's personal calendar feed, creating one if necessary.
+func (store *EventStore) UserGetCalendarToken(userid UserID) (string, error) {
+	var token string
+	err := store.txLoop(func(eq sqlx.Ext) error {
+		err := sqlx.Get(eq, &token,
+			`select token from event_calendar_tokens where userid = ?`,
+			userid)
+		if err == nil {
+			return nil
+		} else if err != sql.ErrNoRows {
+			return errOrRetry("Getting calendar token", err)
+		}
+
+		token = calendarTokenGenerate()
+		_, err = eq.Exec(`
+            insert into event_calendar_tokens(userid, token) values(?, ?)`,
+			userid, token)
+		if isErrorForeignKey(err) {
+			return ErrUserNotFound
+		} else if err != nil {
+			return errOrRetry("Creating calendar token", err)
+		}
+		return nil
+	})
+	return token, err
+}
+
+// UserResetCalendarToken replaces userid's calendar token, so that
+// any existing subscriptions to their feed stop working.
+func (store *EventStore) UserResetCalendarToken(userid UserID) (string, error) {
+	token := calendarTokenGenerate()
+	err := store.txLoop(func(eq sqlx.Ext) error {
+		_, err := eq.Exec(`
+            insert into event_calendar_tokens(userid, token) values(?, ?)
+                on conflict(userid) do update set token = excluded.token`,
+			userid, token)
+		if isErrorForeignKey(err) {
+			return ErrUserNotFound
+		} else if err != nil {
+			return errOrRetry("Resetting calendar token", err)
+		}
+		// Don't record the token itself
+		return auditTx(eq, userid, AuditUserResetCalendar, string(userid), nil, nil)
+	})
+	return token, err
+}
+
+// UserFindByCalendarToken returns the user with the given calendar
+// token, or nil if there is none.
+func (store *EventStore) UserFindByCalendarToken(token string) (*User, error) {
+	var user User
+	for {
+		err := sqlx.Get(store, &user, `
+            select event_users.*
+                from event_users natural join event_calendar_tokens
+                where token = ? and deleted = 0`, token)
+		switch {
+		case shouldRetry(err):
+			continue
+		case err == sql.ErrNoRows:
+			return nil, nil
+		case err != nil:
+			return nil, err
+		}
+		return &user, nil
+	}
+}
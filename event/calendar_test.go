@@ -0,0 +1,119 @@
+package event
+
+import (
+	"testing"
+)
+
+func testUnitCalendar(t *testing.T) (exit bool) {
+	// Any "early" exit is a failure
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+
+	m := &mirrorData{}
+
+	t.Logf("Setting up users, discussion, timetable")
+	if testScheduleSearchSetup(t, m, 20, 2) {
+		return
+	}
+
+	if err := event.MakeSchedule(SearchOptions{}); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+
+	t.Logf("Checking calendar entries")
+	entries, err := event.GetCalendar()
+	if err != nil {
+		t.Errorf("GetCalendar: %v", err)
+		return
+	}
+
+	var count int
+	if err := event.Get(&count, `select count(*) from event_schedule`); err != nil {
+		t.Errorf("Counting scheduled discussions: %v", err)
+		return
+	}
+	if count == 0 || count != len(entries) {
+		t.Errorf("Expected %d calendar entries, got %d", count, len(entries))
+		return
+	}
+
+	for i := range entries {
+		e := &entries[i]
+		if e.Title == "" || e.LocationName == "" {
+			t.Errorf("Entry %d missing title or location: %v", i, *e)
+			return
+		}
+		if !e.End.After(e.Start.Time) {
+			t.Errorf("Entry %d ends (%v) before it starts (%v)", i, e.End, e.Start)
+			return
+		}
+		if i > 0 && e.Start.Before(entries[i-1].Start.Time) {
+			t.Errorf("Entry %d out of order", i)
+			return
+		}
+	}
+
+	t.Logf("Checking calendar tokens")
+	user := &m.users[0]
+
+	token, err := event.UserGetCalendarToken(user.UserID)
+	if err != nil || len(token) != calendarTokenLength {
+		t.Errorf("UserGetCalendarToken: token %q, err %v", token, err)
+		return
+	}
+
+	if again, err := event.UserGetCalendarToken(user.UserID); err != nil || again != token {
+		t.Errorf("UserGetCalendarToken changed token %q to %q (err %v)", token, again, err)
+		return
+	}
+
+	if found, err := event.UserFindByCalendarToken(token); err != nil || found == nil || found.UserID != user.UserID {
+		t.Errorf("UserFindByCalendarToken: got %v, err %v", found, err)
+		return
+	}
+
+	newToken, err := event.UserResetCalendarToken(user.UserID)
+	if err != nil || newToken == token {
+		t.Errorf("UserResetCalendarToken: token %q (old %q), err %v", newToken, token, err)
+		return
+	}
+
+	if found, err := event.UserFindByCalendarToken(token); err != nil || found != nil {
+		t.Errorf("Old token still valid: got %v, err %v", found, err)
+		return
+	}
+
+	if found, err := event.UserFindByCalendarToken(newToken); err != nil || found == nil || found.UserID != user.UserID {
+		t.Errorf("UserFindByCalendarToken after reset: got %v, err %v", found, err)
+		return
+	}
+
+	if _, err := event.UserGetCalendarToken(UserID("invalid")); err != ErrUserNotFound {
+		t.Errorf("UserGetCalendarToken for invalid user: expected ErrUserNotFound, got %v", err)
+		return
+	}
+
+	if _, err := event.UserResetCalendarToken(UserID("invalid")); err != ErrUserNotFound {
+		t.Errorf("UserResetCalendarToken for invalid user: expected ErrUserNotFound, got %v", err)
+		return
+	}
+
+	if err := event.DeleteUser("", user.UserID, ""); err != nil {
+		t.Errorf("Deleting user: %v", err)
+		return
+	}
+
+	if found, err := event.UserFindByCalendarToken(newToken); err != nil || found != nil {
+		t.Errorf("Token for deleted user still valid: got %v, err %v", found, err)
+		return
+	}
+
+	tc.cleanup()
+
+	return false
+}
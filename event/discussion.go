@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 
@@ -55,21 +56,38 @@ type Discussion struct {
 
 	// PossibleSlots []bool
 
+	// Co-facilitators, in addition to the owner.  Not stored in
+	// event_discussions; filled in by DiscussionFindByIdFull().
+	Facilitators []UserID `db:"-"`
+
 	// Is this discussion publicly visible?
 	// If true, 'Title' and 'Description' should be shown to everyone.
 	// If false:
 	//   admin and owner should see 'Title' and 'Description'
 	//   Everyone else should either see 'Approved*', or nothing at all (if nothing has been approved)
 	IsPublic bool
+
+	// How many consecutive slots the discussion takes up.  The
+	// owner asks for a Length; the scheduler uses ApprovedLength,
+	// which only an admin can change.
+	Length         int
+	ApprovedLength int
+
+	// Unix time at which the discussion was moved to the trash, or 0
+	// if it hasn't been.  As with users, discussions in the trash are
+	// treated as though they don't exist.
+	Deleted int64
 }
 
 type DiscussionFull struct {
 	Discussion
-	OwnerInfo     User
-	Location      Location
-	Time          Time
-	IsFinal       bool
-	PossibleSlots []DisplaySlot
+	OwnerInfo       User
+	FacilitatorInfo []User
+	Location        Location
+	Time            Time
+	IsFinal         bool
+	PossibleSlots   []DisplaySlot
+	Pin             *Pin // nil if not pinned
 }
 
 func (d *Discussion) GetURL() string {
@@ -79,6 +97,9 @@ func (d *Discussion) GetURL() string {
 // FIXME
 const maxDiscussionsPerUser = 12
 
+// MaxDiscussionLength is the most slots a discussion can take up.
+const MaxDiscussionLength = 4
+
 func checkDiscussionParams(disc *Discussion) error {
 	if disc.Title == "" || AllWhitespace(disc.Title) {
 		log.Printf("%s New/Update discussion failed: no title",
@@ -91,6 +112,14 @@ func checkDiscussionParams(disc *Discussion) error {
 			disc.Owner)
 		return errNoDesc
 	}
+
+	// Callers which don't know about lengths get a single slot
+	if disc.Length == 0 {
+		disc.Length = 1
+	}
+	if disc.Length < 1 || disc.Length > MaxDiscussionLength {
+		return errDiscussionLength
+	}
 	return nil
 }
 
@@ -99,7 +128,12 @@ func checkDiscussionParams(disc *Discussion) error {
 // - Title can't be empty
 // - Description can't be empty
 // - Title unique (enforced by SQL)
-func NewDiscussion(disc *Discussion) error {
+// - Length must be in range
+// - Facilitators (co-facilitators) must exist
+//
+// Discussions start off with an approved length of one slot,
+// whatever length the owner asks for.
+func (store *EventStore) NewDiscussion(disc *Discussion) error {
 	owner := disc.Owner
 
 	log.Printf("%s New discussion post: '%s'",
@@ -109,10 +143,10 @@ func NewDiscussion(disc *Discussion) error {
 		return err
 	}
 
-	return txLoop(func(eq sqlx.Ext) error {
+	return store.txLoop(func(eq sqlx.Ext) error {
 		count := 0
 		err := sqlx.Get(eq, &count,
-			`select count(*) from event_discussions where owner=?`,
+			`select count(*) from event_discussions where owner=? and deleted = 0`,
 			disc.Owner)
 		if err != nil {
 			return errOrRetry("Getting discussion count for user", err)
@@ -133,6 +167,7 @@ func NewDiscussion(disc *Discussion) error {
 		}
 
 		disc.DiscussionID.generate()
+		disc.ApprovedLength = 1
 
 		// New discussions are non-public by default unless owner is verified
 		err = sqlx.Get(eq, &disc.IsPublic,
@@ -150,31 +185,57 @@ func NewDiscussion(disc *Discussion) error {
 			disc.ApprovedDescription = ""
 		}
 		_, err = eq.Exec(
-			`insert into event_discussions values (?, ?, ?, ?, ?, ?, ?)`,
+			`insert into event_discussions(
+                discussionid, owner, title, description,
+                approvedtitle, approveddescription,
+                ispublic, length, approvedlength)
+                values (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 			disc.DiscussionID, disc.Owner, disc.Title, disc.Description,
 			disc.ApprovedTitle, disc.ApprovedDescription,
-			disc.IsPublic)
+			disc.IsPublic, disc.Length, disc.ApprovedLength)
+		if isErrorConstraintUnique(err) {
+			// NB this includes the titles of discussions in the trash
+			return errTitleExists
+		} else if err != nil {
+			return errOrRetry("Inserting discussion", err)
+		}
+
+		// Owners are assumed to want to attend their own session
+		err = setInterestTx(eq, disc.Owner, disc.DiscussionID, InterestMax)
 		if err != nil {
 			return err
 		}
 
-		// Owners are assumed to want to attend their own session
-		return setInterestTx(eq, disc.Owner, disc.DiscussionID, InterestMax)
+		if len(disc.Facilitators) > 0 {
+			_, disc.Facilitators, err = setFacilitatorsTx(eq, disc.DiscussionID,
+				disc.Owner, disc.Facilitators)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = auditTx(eq, disc.Owner, AuditDiscussionCreate, string(disc.DiscussionID), nil, disc)
+		if err != nil {
+			return err
+		}
+
+		return schedMarkModifiedTx(eq)
 	})
 }
 
-// GetMaxScore returns the maximum possible score a discussion could
+// DiscussionGetMaxScore returns the maximum possible score a discussion could
 // have if everyone attended; that is, the sum of all the interests
 // expressed.
-func (d *Discussion) GetMaxScore() (int, error) {
+func (store *EventStore) DiscussionGetMaxScore(d *Discussion) (int, error) {
 	var maxscore int
 	// Theoretically the owner should always have non-zero interest,
 	// so sum(interest) should never be NULL; but better to be robust.
 	for {
-		err := event.Get(&maxscore, `
+		err := store.Get(&maxscore, `
             select IFNULL(sum(interest), 0)
                 from event_interest
-                where discussionid = ?`,
+                where discussionid = ?
+                    and userid in (select userid from event_users where deleted = 0)`,
 			d.DiscussionID)
 		switch {
 		case shouldRetry(err):
@@ -199,7 +260,7 @@ func (d *Discussion) Slot() (IsFinal bool, Time string) {
 	return false, ""
 }
 
-// Updates discussion's Title, Description, and Owner.
+// Updates discussion's Title, Description, Owner and (requested) Length.
 //
 // If the owner (or the new owner, if that's being changed) is verifed, then
 // IsPublic will be set to 'true', and ApprovedTitle and ApprovedDescription will be set from
@@ -207,14 +268,24 @@ func (d *Discussion) Slot() (IsFinal bool, Time string) {
 //
 // If the owner (or new owner) is not verified, then IsPublic will be
 // set to false, and only Title and Description will be modified.
-func DiscussionUpdate(disc *Discussion) error {
+//
+// actor is the user making the change.
+func (store *EventStore) DiscussionUpdate(actor UserID, disc *Discussion) error {
 	log.Printf("Update discussion post: '%s'", disc.Title)
 
 	if err := checkDiscussionParams(disc); err != nil {
 		return err
 	}
 
-	return txLoop(func(eq sqlx.Ext) error {
+	return store.txLoop(func(eq sqlx.Ext) error {
+		var before Discussion
+		err := discussionGetTx(eq, disc.DiscussionID, &before)
+		if err == sql.ErrNoRows {
+			return ErrDiscussionNotFound
+		} else if err != nil {
+			return errOrRetry("Getting discussion", err)
+		}
+
 		var curOwner UserID
 		var ownerIsVerified bool
 		row := eq.QueryRowx(
@@ -222,7 +293,7 @@ func DiscussionUpdate(disc *Discussion) error {
                  from event_discussions
                    join event_users on owner = userid
                  where discussionid = ?`, disc.DiscussionID)
-		err := row.Scan(&curOwner, &ownerIsVerified)
+		err = row.Scan(&curOwner, &ownerIsVerified)
 		if err != nil {
 			return errOrRetry("Getting info for owner of discussion", err)
 		}
@@ -232,17 +303,9 @@ func DiscussionUpdate(disc *Discussion) error {
 		// latitude.
 
 		if disc.Owner != curOwner {
-			err := setInterestTx(eq, disc.Owner, disc.DiscussionID, InterestMax)
-			if err != nil {
-				return errOrRetry("Setting interest for new owner", err)
-			}
-
-			// If we're changing owner, we need to see whether the new owner is verified
-			err = sqlx.Get(eq, &ownerIsVerified,
-				`select isverified from event_users where userid = ?`,
-				disc.Owner)
+			ownerIsVerified, err = discussionNewOwnerTx(eq, disc.DiscussionID, disc.Owner)
 			if err != nil {
-				return errOrRetry("Getting IsVerified for new owner", err)
+				return err
 			}
 		}
 
@@ -254,8 +317,9 @@ func DiscussionUpdate(disc *Discussion) error {
                  owner = ?,
                  title = ?,
                  description = ?,
-                 ispublic = ?`
-		args := []interface{}{disc.Owner, disc.Title, disc.Description, disc.IsPublic}
+                 ispublic = ?,
+                 length = ?`
+		args := []interface{}{disc.Owner, disc.Title, disc.Description, disc.IsPublic, disc.Length}
 
 		if disc.IsPublic {
 			disc.ApprovedTitle = disc.Title
@@ -271,10 +335,63 @@ func DiscussionUpdate(disc *Discussion) error {
 		args = append(args, disc.DiscussionID)
 
 		_, err = eq.Exec(q, args...)
-		return err
+		if isErrorConstraintUnique(err) {
+			// NB this includes the titles of discussions in the trash
+			return errTitleExists
+		} else if err != nil {
+			return errOrRetry("Updating discussion", err)
+		}
+
+		// The owner has had a chance to address any rejection
+		if err := clearRejectionTx(eq, disc.DiscussionID); err != nil {
+			return err
+		}
+
+		after := *disc
+		after.ApprovedLength = before.ApprovedLength
+		if !after.IsPublic {
+			after.ApprovedTitle = before.ApprovedTitle
+			after.ApprovedDescription = before.ApprovedDescription
+		}
+		after.Facilitators = nil
+		err = auditTx(eq, actor, AuditDiscussionUpdate, string(disc.DiscussionID), &before, &after)
+		if err != nil {
+			return err
+		}
+
+		return schedMarkModifiedTx(eq)
 	})
 }
 
+// discussionNewOwnerTx does what giving discussionid to newOwner
+// involves, other than changing the owner itself: the new owner is
+// made interested in it, and stops being a co-facilitator.  It returns
+// whether the new owner is verified, which decides whether the
+// discussion is public.
+func discussionNewOwnerTx(eq sqlx.Ext, discussionid DiscussionID, newOwner UserID) (bool, error) {
+	err := setInterestTx(eq, newOwner, discussionid, InterestMax)
+	if err != nil {
+		return false, errOrRetry("Setting interest for new owner", err)
+	}
+
+	// The new owner doesn't need to be a co-facilitator as well
+	_, err = eq.Exec(`
+        delete from event_discussions_facilitators
+            where discussionid = ? and userid = ?`,
+		discussionid, newOwner)
+	if err != nil {
+		return false, errOrRetry("Removing new owner from co-facilitators", err)
+	}
+
+	var isVerified bool
+	err = sqlx.Get(eq, &isVerified,
+		`select isverified from event_users where userid = ?`, newOwner)
+	if err != nil {
+		return false, errOrRetry("Getting IsVerified for new owner", err)
+	}
+	return isVerified, nil
+}
+
 // No entries at all means all entries are OK
 func possibleSlotsDBToDisplay(pslots []DisplaySlot) {
 	anyTrue := false
@@ -288,7 +405,9 @@ func possibleSlotsDBToDisplay(pslots []DisplaySlot) {
 	}
 }
 
-func DiscussionSetPossibleSlots(discussionid DiscussionID, pslots []SlotID) error {
+// DiscussionSetPossibleSlots restricts discussionid to the slots in
+// pslots.  actor is the user making the change.
+func (store *EventStore) DiscussionSetPossibleSlots(actor UserID, discussionid DiscussionID, pslots []SlotID) error {
 	checked := []struct {
 		DiscussionID DiscussionID
 		SlotID       SlotID
@@ -305,7 +424,7 @@ func DiscussionSetPossibleSlots(discussionid DiscussionID, pslots []SlotID) erro
 		})
 	}
 
-	err := txLoop(func(eq sqlx.Ext) error {
+	err := store.txLoop(func(eq sqlx.Ext) error {
 		var nslots int
 		err := sqlx.Get(eq, &nslots, `
             select count(*) from event_slots where isbreak = false`)
@@ -313,6 +432,15 @@ func DiscussionSetPossibleSlots(discussionid DiscussionID, pslots []SlotID) erro
 			return errOrRetry("Getting number of slots", err)
 		}
 
+		var before []SlotID
+		err = sqlx.Select(eq, &before, `
+            select slotid from event_discussions_possible_slots
+                where discussionid = ?
+                order by slotid`, discussionid)
+		if err != nil {
+			return errOrRetry("Getting current slot restrictions", err)
+		}
+
 		// Always drop all restrictions
 		_, err = eq.Exec(`
             delete from event_discussions_possible_slots
@@ -324,19 +452,186 @@ func DiscussionSetPossibleSlots(discussionid DiscussionID, pslots []SlotID) erro
 		// Only need to add any back if there are negative values
 		if len(checked) < nslots {
 			_, err = sqlx.NamedExec(eq, `
-                insert into event_discussions_possible_slots
+                insert into event_discussions_possible_slots(discussionid, slotid)
                     values(:discussionid, :slotid)`, checked)
 			if err != nil {
 				return errOrRetry("Adding new slots", err)
 			}
 		}
 
-		return nil
+		// An empty list means all slots are possible
+		after := pslots
+		if len(checked) >= nslots {
+			after = nil
+		}
+		err = auditTx(eq, actor, AuditDiscussionSetPossibleSlots, string(discussionid),
+			struct{ PossibleSlots []SlotID }{before},
+			struct{ PossibleSlots []SlotID }{after})
+		if err != nil {
+			return err
+		}
+
+		return schedMarkModifiedTx(eq)
 	})
 
 	return err
 }
 
+// DiscussionSetFacilitators replaces the list of co-facilitators for
+// a discussion.  The owner is always a facilitator, and so is ignored
+// if present in the list.  Newly added co-facilitators are assumed to
+// want to attend, and so get their interest set to InterestMax.
+// actor is the user making the change.
+func (store *EventStore) DiscussionSetFacilitators(actor UserID, did DiscussionID, facilitators []UserID) error {
+	return store.txLoop(func(eq sqlx.Ext) error {
+		var owner UserID
+		err := sqlx.Get(eq, &owner,
+			`select owner from event_discussions where discussionid = ? and deleted = 0`,
+			did)
+		if err == sql.ErrNoRows {
+			return ErrDiscussionNotFound
+		} else if err != nil {
+			return errOrRetry("Getting discussion owner", err)
+		}
+
+		current, after, err := setFacilitatorsTx(eq, did, owner, facilitators)
+		if err != nil {
+			return err
+		}
+
+		err = auditTx(eq, actor, AuditDiscussionSetFacilitators, string(did),
+			struct{ Facilitators []UserID }{current},
+			struct{ Facilitators []UserID }{after})
+		if err != nil {
+			return err
+		}
+
+		return schedMarkModifiedTx(eq)
+	})
+}
+
+// setFacilitatorsTx replaces the co-facilitators of did, which is
+// owned by owner, with facilitators, as DiscussionSetFacilitators
+// describes.  It returns the co-facilitators before and after.
+func setFacilitatorsTx(eq sqlx.Ext, did DiscussionID, owner UserID, facilitators []UserID) (current, after []UserID, err error) {
+	err = sqlx.Select(eq, &current, `
+        select userid from event_discussions_facilitators
+            where discussionid = ?`, did)
+	if err != nil {
+		return nil, nil, errOrRetry("Getting current co-facilitators", err)
+	}
+	wasFacilitator := map[UserID]bool{}
+	for _, uid := range current {
+		wasFacilitator[uid] = true
+	}
+
+	_, err = eq.Exec(`
+        delete from event_discussions_facilitators
+            where discussionid = ?`, did)
+	if err != nil {
+		return nil, nil, errOrRetry("Dropping co-facilitators", err)
+	}
+
+	added := map[UserID]bool{}
+	for _, uid := range facilitators {
+		if uid == owner || added[uid] {
+			continue
+		}
+		added[uid] = true
+		after = append(after, uid)
+
+		_, err = eq.Exec(`
+            insert into event_discussions_facilitators(discussionid, userid)
+                values(?, ?)`, did, uid)
+		if isErrorForeignKey(err) {
+			return nil, nil, ErrUserNotFound
+		} else if err != nil {
+			return nil, nil, errOrRetry("Adding co-facilitator", err)
+		}
+
+		if !wasFacilitator[uid] {
+			err = setInterestTx(eq, uid, did, InterestMax)
+			if err != nil {
+				return nil, nil, errOrRetry("Setting interest for co-facilitator", err)
+			}
+		}
+	}
+	return current, after, nil
+}
+
+// DiscussionSetApprovedLength sets how many slots the scheduler will
+// give discussionid; normally to the Length its owner asked for.  If
+// the length changes, the discussion is unscheduled, unless it's in a
+// locked slot, in which case errScheduleLocked is returned; a pinned
+// discussion must still fit where it's pinned.  actor is the admin
+// making the change.
+func (store *EventStore) DiscussionSetApprovedLength(actor UserID, discussionid DiscussionID, length int) error {
+	if length < 1 || length > MaxDiscussionLength {
+		return errDiscussionLength
+	}
+
+	return store.txLoop(func(eq sqlx.Ext) error {
+		var before Discussion
+		err := discussionGetTx(eq, discussionid, &before)
+		if err == sql.ErrNoRows {
+			return ErrDiscussionNotFound
+		} else if err != nil {
+			return errOrRetry("Getting discussion", err)
+		}
+
+		if before.ApprovedLength == length {
+			return nil
+		}
+
+		var locked int
+		err = sqlx.Get(eq, &locked, `
+            select count(*)
+                from event_schedule natural join event_slots
+                where discussionid = ? and islocked = true`, discussionid)
+		if err != nil {
+			return errOrRetry("Checking for locked slots", err)
+		}
+		if locked > 0 {
+			return errScheduleLocked
+		}
+
+		// A pinned discussion must still fit where it's pinned
+		pin, err := pinGetTx(eq, discussionid)
+		if err != nil {
+			return err
+		}
+		if pin != nil {
+			after := before
+			after.ApprovedLength = length
+			if err := pinCheckTx(eq, &after, pin); err != nil {
+				return err
+			}
+		}
+
+		_, err = eq.Exec(`
+            update event_discussions set approvedlength = ?
+                where discussionid = ?`, length, discussionid)
+		if err != nil {
+			return errOrRetry("Setting approved length", err)
+		}
+
+		_, err = eq.Exec(`
+            delete from event_schedule where discussionid = ?`, discussionid)
+		if err != nil {
+			return errOrRetry("Unscheduling discussion", err)
+		}
+
+		err = auditTx(eq, actor, AuditDiscussionSetLength, string(discussionid),
+			struct{ ApprovedLength int }{before.ApprovedLength},
+			struct{ ApprovedLength int }{length})
+		if err != nil {
+			return err
+		}
+
+		return schedMarkModifiedTx(eq)
+	})
+}
+
 // Sets the given discussion ID to public or private.
 //
 // If public is true, it copies the title and description into the
@@ -346,7 +641,9 @@ func DiscussionSetPossibleSlots(discussionid DiscussionID, pslots []SlotID) erro
 // If public is false, it hides the discussion entirely, by both
 // setting 'IsPublic' to false, but also clearing the approved title
 // and description.
-func DiscussionSetPublic(discussionid DiscussionID, public bool) error {
+//
+// actor is the user making the change.
+func (store *EventStore) DiscussionSetPublic(actor UserID, discussionid DiscussionID, public bool) error {
 	var query, errlogfmt string
 	if public {
 		query = `
@@ -367,11 +664,19 @@ func DiscussionSetPublic(discussionid DiscussionID, public bool) error {
 		errlogfmt = "Setting event discussion %v non-public: %v"
 	}
 
-	for {
-		res, err := event.Exec(query, discussionid)
+	return store.txLoop(func(eq sqlx.Ext) error {
+		var before Discussion
+		err := discussionGetTx(eq, discussionid, &before)
+		if err == sql.ErrNoRows {
+			return ErrDiscussionNotFound
+		} else if err != nil {
+			return errOrRetry("Getting discussion", err)
+		}
+
+		res, err := eq.Exec(query, discussionid)
 		switch {
 		case shouldRetry(err):
-			continue
+			return err
 		case err != nil:
 			log.Printf(errlogfmt, discussionid, err)
 			return ErrInternal
@@ -388,9 +693,25 @@ func DiscussionSetPublic(discussionid DiscussionID, public bool) error {
 			log.Printf("ERROR Expected to change 1 row, changed %d", rcount)
 			return ErrInternal
 		}
-		return nil
 
-	}
+		// Approving a discussion supersedes any earlier rejection
+		if public {
+			if err := clearRejectionTx(eq, discussionid); err != nil {
+				return err
+			}
+		}
+
+		var after Discussion
+		if err := discussionGetTx(eq, discussionid, &after); err != nil {
+			return errOrRetry("Getting discussion", err)
+		}
+		err = auditTx(eq, actor, AuditDiscussionSetPublic, string(discussionid), &before, &after)
+		if err != nil {
+			return err
+		}
+
+		return schedMarkModifiedTx(eq)
+	})
 }
 
 func deleteDiscussionCommon(eq sqlx.Ext, where string, arg interface{}) (int64, error) {
@@ -401,18 +722,36 @@ func deleteDiscussionCommon(eq sqlx.Ext, where string, arg interface{}) (int64,
 		return 0, errOrRetry("Deleting discussion from event_interest", err)
 	}
 
+	_, err = eq.Exec(`
+           delete from event_discussions_facilitators where `+where, arg)
+	if err != nil {
+		return 0, errOrRetry("Deleting discussion from event_discussions_facilitators", err)
+	}
+
 	_, err = eq.Exec(`
            delete from event_discussions_possible_slots where `+where, arg)
 	if err != nil {
 		return 0, errOrRetry("Deleting discussion from event_discussions_possible_slots", err)
 	}
 
+	_, err = eq.Exec(`
+           delete from event_discussion_rejections where `+where, arg)
+	if err != nil {
+		return 0, errOrRetry("Deleting discussion from event_discussion_rejections", err)
+	}
+
 	_, err = eq.Exec(`
            delete from event_schedule where `+where, arg)
 	if err != nil {
 		return 0, errOrRetry("Deleting discussion from event_schedule", err)
 	}
 
+	_, err = eq.Exec(`
+           delete from event_pins where `+where, arg)
+	if err != nil {
+		return 0, errOrRetry("Deleting discussion from event_pins", err)
+	}
+
 	res, err := eq.Exec(`
             delete from event_discussions
                 where `+where, arg)
@@ -428,25 +767,60 @@ func deleteDiscussionCommon(eq sqlx.Ext, where string, arg interface{}) (int64,
 	return rcount, nil
 }
 
-func DeleteDiscussion(did DiscussionID) error {
+// DeleteDiscussion moves did to the trash; it can be restored with
+// DiscussionRestore until it's purged by TrashPurge.  actor is the
+// user doing the deleting.
+func (store *EventStore) DeleteDiscussion(actor UserID, did DiscussionID) error {
 	log.Printf("Deleting discussion %s", did)
 
-	return txLoop(func(eq sqlx.Ext) error {
-		rcount, err := deleteDiscussionCommon(eq, "discussionid = ?", did)
+	return store.txLoop(func(eq sqlx.Ext) error {
+		var before Discussion
+		err := discussionGetTx(eq, did, &before)
+		if err == sql.ErrNoRows {
+			return ErrDiscussionNotFound
+		} else if err != nil {
+			return errOrRetry("Getting discussion", err)
+		}
 
-		if err == nil {
-			switch {
-			case rcount == 0:
-				return ErrDiscussionNotFound
-			case rcount > 1:
-				log.Printf("ERROR Expected to change 1 row, changed %d", rcount)
-				return ErrInternal
-			}
+		_, err = eq.Exec(`
+            delete from event_schedule where discussionid = ?`, did)
+		if err != nil {
+			return errOrRetry("Unscheduling discussion", err)
 		}
 
-		return err
+		res, err := eq.Exec(`
+            update event_discussions set deleted = ?
+                where discussionid = ? and deleted = 0`,
+			time.Now().Unix(), did)
+		if err != nil {
+			return errOrRetry("Deleting discussion", err)
+		}
+		rcount, err := res.RowsAffected()
+		if shouldRetry(err) {
+			return err
+		} else if err != nil {
+			log.Printf("ERROR Getting number of affected rows: %v; continuing", err)
+		}
+		switch {
+		case rcount == 0:
+			return ErrDiscussionNotFound
+		case rcount > 1:
+			log.Printf("ERROR Expected to change 1 row, changed %d", rcount)
+			return ErrInternal
+		}
+
+		err = auditTx(eq, actor, AuditDiscussionDelete, string(did), &before, nil)
+		if err != nil {
+			return err
+		}
+
+		return schedMarkModifiedTx(eq)
 	})
+}
 
+func discussionGetTx(q sqlx.Queryer, did DiscussionID, d *Discussion) error {
+	return sqlx.Get(q, d,
+		`select * from event_discussions where discussionid = ? and deleted = 0`, did)
 }
 
 func MakePossibleSlots(len int) []bool {
@@ -476,9 +850,9 @@ func discussionGetPossibleSlotsTx(q sqlx.Queryer, did DiscussionID, psp *[]Displ
 	return err
 }
 
-func DiscussionGetPossibleSlots(did DiscussionID) ([]DisplaySlot, error) {
+func (store *EventStore) DiscussionGetPossibleSlots(did DiscussionID) ([]DisplaySlot, error) {
 	var ds []DisplaySlot
-	err := txLoop(func(eq sqlx.Ext) error {
+	err := store.txLoop(func(eq sqlx.Ext) error {
 		err := discussionGetPossibleSlotsTx(eq, did, &ds)
 		if err != nil {
 			return errOrRetry("Getting possible slots for discussion", err)
@@ -489,12 +863,12 @@ func DiscussionGetPossibleSlots(did DiscussionID) ([]DisplaySlot, error) {
 	return ds, err
 }
 
-func discussionFindByIdFullTx(q sqlx.Queryer, did DiscussionID) (*DiscussionFull, error) {
+func (store *EventStore) discussionFindByIdFullTx(q sqlx.Queryer, did DiscussionID) (*DiscussionFull, error) {
 	var disc *DiscussionFull
-	err := txLoop(func(eq sqlx.Ext) error {
+	err := store.txLoop(func(eq sqlx.Ext) error {
 		disc = &DiscussionFull{}
 		err := sqlx.Get(eq, disc,
-			`select * from event_discussions where discussionid = ?`,
+			`select * from event_discussions where discussionid = ? and deleted = 0`,
 			did)
 		if err == sql.ErrNoRows {
 			disc = nil
@@ -510,6 +884,20 @@ func discussionFindByIdFullTx(q sqlx.Queryer, did DiscussionID) (*DiscussionFull
 			return errOrRetry("Getting discussion owner info", err)
 		}
 
+		err = sqlx.Select(eq, &disc.FacilitatorInfo, `
+            select event_users.*
+                from event_discussions_facilitators
+                    natural join event_users
+                where discussionid = ? and deleted = 0
+                order by username`, disc.DiscussionID)
+		if err != nil {
+			return errOrRetry("Getting discussion co-facilitator info", err)
+		}
+		disc.Facilitators = nil
+		for i := range disc.FacilitatorInfo {
+			disc.Facilitators = append(disc.Facilitators, disc.FacilitatorInfo[i].UserID)
+		}
+
 		// Get the schedule info
 		row := eq.QueryRowx(`
             select locationid,
@@ -522,7 +910,8 @@ func discussionFindByIdFullTx(q sqlx.Queryer, did DiscussionID) (*DiscussionFull
                 from event_locations
                     natural join event_schedule
                     natural join event_slots
-                where discussionid=?`, disc.DiscussionID)
+                where discussionid=?
+                order by dayid, slotidx`, disc.DiscussionID)
 		err = row.Scan(&disc.Location.LocationID,
 			&disc.Location.LocationName,
 			&disc.Location.LocationURL,
@@ -539,17 +928,22 @@ func discussionFindByIdFullTx(q sqlx.Queryer, did DiscussionID) (*DiscussionFull
 			return errOrRetry("Getting possible slots for discussion", err)
 		}
 
+		disc.Pin, err = pinGetTx(eq, disc.DiscussionID)
+		if err != nil {
+			return err
+		}
+
 		return nil
 	})
 	return disc, err
 }
 
-func DiscussionFindByIdFull(discussionid DiscussionID) (*DiscussionFull, error) {
-	return discussionFindByIdFullTx(event.DB, discussionid)
+func (store *EventStore) DiscussionFindByIdFull(discussionid DiscussionID) (*DiscussionFull, error) {
+	return store.discussionFindByIdFullTx(store.DB, discussionid)
 }
 
-func discussionIterateQuery(query string, args []interface{}, f func(*DiscussionFull) error) error {
-	return txLoop(func(eq sqlx.Ext) error {
+func (store *EventStore) discussionIterateQuery(query string, args []interface{}, f func(*DiscussionFull) error) error {
+	return store.txLoop(func(eq sqlx.Ext) error {
 		// First, get a list of all the appropriate discussion IDs
 		dids := []DiscussionID{}
 		err := sqlx.Select(eq, &dids, query, args...)
@@ -559,7 +953,7 @@ func discussionIterateQuery(query string, args []interface{}, f func(*Discussion
 
 		for _, did := range dids {
 			// For each discussion, load up "full" discussion information...
-			disc, err := discussionFindByIdFullTx(eq, did)
+			disc, err := store.discussionFindByIdFullTx(eq, did)
 			if err != nil {
 				return err
 			}
@@ -575,15 +969,27 @@ func discussionIterateQuery(query string, args []interface{}, f func(*Discussion
 	})
 }
 
-func DiscussionIterate(f func(*DiscussionFull) error) error {
-	return discussionIterateQuery(`select discussionid from event_discussions order by discussionid`, nil, f)
+func (store *EventStore) DiscussionIterate(f func(*DiscussionFull) error) error {
+	return store.discussionIterateQuery(`
+        select discussionid from event_discussions
+            where deleted = 0
+            order by discussionid`, nil, f)
 }
 
+// DiscussionIterateUser iterates over all discussions which userid
+// either owns or co-facilitates.
+//
 // FIXME: This will simply do nothing if the userid doesn't exist.  It
 // would be nice for the caller to distinguish between "User does not
 // exist" and "User has no discussions".
-func DiscussionIterateUser(userid UserID, f func(*DiscussionFull) error) (err error) {
-	return discussionIterateQuery(
-		`select discussionid from event_discussions where owner=? order by discussionid`,
-		[]interface{}{userid}, f)
+func (store *EventStore) DiscussionIterateUser(userid UserID, f func(*DiscussionFull) error) (err error) {
+	return store.discussionIterateQuery(
+		`select discussionid from event_discussions
+             where (owner=?
+                    or discussionid in (select discussionid
+                                            from event_discussions_facilitators
+                                            where userid=?))
+                 and deleted = 0
+             order by discussionid`,
+		[]interface{}{userid, userid}, f)
 }
@@ -15,7 +15,7 @@ func testNewDiscussion(t *testing.T, owner UserID) (Discussion, bool) {
 	}
 
 	if owner == "" {
-		user, err := UserFindRandom()
+		user, err := event.UserFindRandom()
 		if err != nil {
 			t.Logf("Getting a random user: %v", err)
 			return disc, true
@@ -28,7 +28,7 @@ func testNewDiscussion(t *testing.T, owner UserID) (Discussion, bool) {
 	//t.Logf("Creating test discussion %v", disc)
 
 	failures := 0
-	for err := NewDiscussion(&disc); err != nil; err = NewDiscussion(&disc) {
+	for err := event.NewDiscussion(&disc); err != nil; err = event.NewDiscussion(&disc) {
 		failures++
 		if failures > 10 {
 			t.Logf("%d failures exceeded tolerance.  Most recent failure: %v", failures, err)
@@ -45,7 +45,7 @@ func testNewDiscussion(t *testing.T, owner UserID) (Discussion, bool) {
 				// Try a new user if this one has too many.  If all users
 				// have too many, we'll eventually hit the max failures
 				// above.
-				user, err2 := UserFindRandom()
+				user, err2 := event.UserFindRandom()
 				if err2 != nil {
 					t.Logf("Getting a random user: %v", err2)
 					return disc, true
@@ -127,7 +127,7 @@ func testDeleteDiscussionsAndUsers(t *testing.T, m *mirrorData) (exit bool) {
 		{
 			i := 0
 			stopErr := fmt.Errorf("Done")
-			err := DiscussionIterateUser(uid, func(d *DiscussionFull) error {
+			err := event.DiscussionIterateUser(uid, func(d *DiscussionFull) error {
 				if d.Owner != uid {
 					return fmt.Errorf("Got user %v, expecting %v!", d.Owner, uid)
 				}
@@ -150,14 +150,14 @@ func testDeleteDiscussionsAndUsers(t *testing.T, m *mirrorData) (exit bool) {
 
 		if did != "" {
 			// If we have a discussion, delete it
-			err := DeleteDiscussion(did)
+			err := event.DeleteDiscussion("", did)
 			if err != nil {
 				t.Errorf("Deleting discussion(%v): %v", did, err)
 				return
 			}
 
 			// Try finding the discussion
-			gotdisc, err := DiscussionFindByIdFull(did)
+			gotdisc, err := event.DiscussionFindByIdFull(did)
 			if err != nil {
 				t.Errorf("Finding deleted discussion: %v", err)
 				return
@@ -168,7 +168,7 @@ func testDeleteDiscussionsAndUsers(t *testing.T, m *mirrorData) (exit bool) {
 			}
 
 			// Try deleting it again
-			err = DeleteDiscussion(did)
+			err = event.DeleteDiscussion("", did)
 			if err == nil {
 				t.Errorf("DeleteDiscussion a second time succeeded!")
 				return
@@ -177,13 +177,13 @@ func testDeleteDiscussionsAndUsers(t *testing.T, m *mirrorData) (exit bool) {
 
 		// Now, delete the user
 		{
-			err := DeleteUser(uid)
+			err := event.DeleteUser("", uid, "")
 			if err != nil {
 				t.Errorf("DeleteUser(%v): %v", uid, err)
 				return
 			}
 
-			err = DiscussionIterateUser(uid, func(d *DiscussionFull) error {
+			err = event.DiscussionIterateUser(uid, func(d *DiscussionFull) error {
 				return fmt.Errorf("Shouldn't be called!")
 			})
 			if err != nil {
@@ -194,7 +194,7 @@ func testDeleteDiscussionsAndUsers(t *testing.T, m *mirrorData) (exit bool) {
 	}
 
 	for didx := range m.discussions {
-		gotdisc, err := DiscussionFindByIdFull(m.discussions[didx].DiscussionID)
+		gotdisc, err := event.DiscussionFindByIdFull(m.discussions[didx].DiscussionID)
 		if err != nil {
 			t.Errorf("DiscussionFindById for (allegedly)-deleted discussion: %v", err)
 			return
@@ -206,7 +206,7 @@ func testDeleteDiscussionsAndUsers(t *testing.T, m *mirrorData) (exit bool) {
 	}
 
 	{
-		err := DiscussionIterate(func(d *DiscussionFull) error {
+		err := event.DiscussionIterate(func(d *DiscussionFull) error {
 			return fmt.Errorf("Shouldn't be called!")
 		})
 		if err != nil {
@@ -236,25 +236,25 @@ func testUnitDiscussion(t *testing.T) (exit bool) {
 	// Try making an invalid discussion
 	t.Logf("Trying to make invalid discussions")
 	{
-		err := NewDiscussion(&Discussion{Title: "", Description: "foo", Owner: m.users[0].UserID})
+		err := event.NewDiscussion(&Discussion{Title: "", Description: "foo", Owner: m.users[0].UserID})
 		if err == nil {
 			t.Errorf("Created discussion with empty title")
 			return
 		}
 
-		err = NewDiscussion(&Discussion{Title: "    ", Description: "foo", Owner: m.users[0].UserID})
+		err = event.NewDiscussion(&Discussion{Title: "    ", Description: "foo", Owner: m.users[0].UserID})
 		if err == nil {
 			t.Errorf("Created discussion with whitespace title")
 			return
 		}
 
-		err = NewDiscussion(&Discussion{Title: "foo", Description: "", Owner: m.users[0].UserID})
+		err = event.NewDiscussion(&Discussion{Title: "foo", Description: "", Owner: m.users[0].UserID})
 		if err == nil {
 			t.Errorf("Created discussion with empty description")
 			return
 		}
 
-		err = NewDiscussion(&Discussion{Title: "foo", Description: "    ", Owner: m.users[0].UserID})
+		err = event.NewDiscussion(&Discussion{Title: "foo", Description: "    ", Owner: m.users[0].UserID})
 		if err == nil {
 			t.Errorf("Created discussion with whitespace description")
 			return
@@ -262,7 +262,7 @@ func testUnitDiscussion(t *testing.T) (exit bool) {
 
 		disc := Discussion{Title: "foo", Description: "bar"}
 		disc.Owner.generate()
-		err = NewDiscussion(&disc)
+		err = event.NewDiscussion(&disc)
 		if err == nil {
 			t.Errorf("Created discussion with invalid owner")
 			return
@@ -282,7 +282,7 @@ func testUnitDiscussion(t *testing.T) (exit bool) {
 				return
 			}
 		}
-		err := NewDiscussion(&Discussion{Title: "foo", Description: "bar", Owner: m.users[0].UserID})
+		err := event.NewDiscussion(&Discussion{Title: "foo", Description: "bar", Owner: m.users[0].UserID})
 		if err == nil {
 			t.Errorf("Created too many m.discussions for one user")
 			return
@@ -294,7 +294,7 @@ func testUnitDiscussion(t *testing.T) (exit bool) {
 	{
 		discussions := make([]Discussion, maxDiscussionsPerUser+1)
 
-		admin, err := UserFindByUsername(AdminUsername)
+		admin, err := event.UserFindByUsername(AdminUsername)
 		if err != nil {
 			t.Errorf("Getting admin user: %v", err)
 			return
@@ -307,14 +307,14 @@ func testUnitDiscussion(t *testing.T) (exit bool) {
 			}
 		}
 		discussions[maxDiscussionsPerUser] = Discussion{Title: "foo", Description: "bar", Owner: admin.UserID}
-		err = NewDiscussion(&discussions[maxDiscussionsPerUser])
+		err = event.NewDiscussion(&discussions[maxDiscussionsPerUser])
 		if err != nil {
 			t.Errorf("Error creating surplus discussions w/ admin permissions: %v", err)
 			return
 		}
 		// Delete all these discussions
 		for i := range discussions {
-			err := DeleteDiscussion(discussions[i].DiscussionID)
+			err := event.DeleteDiscussion("", discussions[i].DiscussionID)
 			if err != nil {
 				t.Errorf("Deleting temporary admin discussion: %v", err)
 				return
@@ -370,14 +370,14 @@ func testUnitDiscussion(t *testing.T) (exit bool) {
 
 		// Try creating a new discussionw ith the same title
 		discCopy := m.discussions[i]
-		err := NewDiscussion(&discCopy)
+		err := event.NewDiscussion(&discCopy)
 		if err == nil {
 			t.Errorf("Created discussion with duplicate title")
 			return
 		}
 
 		// Look for that discussion by did
-		gotdisc, err := DiscussionFindByIdFull(m.discussions[i].DiscussionID)
+		gotdisc, err := event.DiscussionFindByIdFull(m.discussions[i].DiscussionID)
 		if err != nil {
 			t.Errorf("Finding the discussion we just created by ID: %v", err)
 			return
@@ -401,7 +401,7 @@ func testUnitDiscussion(t *testing.T) (exit bool) {
 		// Try to find a non-existent ID.  Should return nil for both.
 		var fakedid DiscussionID
 		fakedid.generate()
-		gotdisc, err := DiscussionFindByIdFull(fakedid)
+		gotdisc, err := event.DiscussionFindByIdFull(fakedid)
 		if err != nil {
 			t.Errorf("Unexpected error finding non-existent discussion: %v", err)
 			return
@@ -429,13 +429,13 @@ func testUnitDiscussion(t *testing.T) (exit bool) {
 		copy := m.discussions[i]
 		copy.Title = fake.Title()
 		copy.Description = fake.Paragraphs()
-		err := DiscussionUpdate(&copy)
+		err := event.DiscussionUpdate("", &copy)
 		if err != nil {
 			t.Errorf("Updating discussion: %v", err)
 			return
 		}
 
-		gotdisc, err := DiscussionFindByIdFull(m.discussions[i].DiscussionID)
+		gotdisc, err := event.DiscussionFindByIdFull(m.discussions[i].DiscussionID)
 		if err != nil {
 			t.Errorf("Unexpected error finding just-updated discussion: %v", err)
 			return
@@ -458,13 +458,13 @@ func testUnitDiscussion(t *testing.T) (exit bool) {
 		//
 		// Invert SetPublic
 		//
-		err = DiscussionSetPublic(m.discussions[i].DiscussionID, !m.discussions[i].IsPublic)
+		err = event.DiscussionSetPublic("", m.discussions[i].DiscussionID, !m.discussions[i].IsPublic)
 		if err != nil {
 			t.Errorf("Fliping SetPublic: %v", err)
 			return
 		}
 
-		gotdisc, err = DiscussionFindByIdFull(m.discussions[i].DiscussionID)
+		gotdisc, err = event.DiscussionFindByIdFull(m.discussions[i].DiscussionID)
 		if err != nil {
 			t.Errorf("Unexpected error finding just-updated discussion: %v", err)
 			return
@@ -494,13 +494,13 @@ func testUnitDiscussion(t *testing.T) (exit bool) {
 		copy = m.discussions[i]
 		copy.Title = fake.Title()
 		copy.Description = fake.Paragraphs()
-		err = DiscussionUpdate(&copy)
+		err = event.DiscussionUpdate("", &copy)
 		if err != nil {
 			t.Errorf("Updating discussion: %v", err)
 			return
 		}
 
-		gotdisc, err = DiscussionFindByIdFull(m.discussions[i].DiscussionID)
+		gotdisc, err = event.DiscussionFindByIdFull(m.discussions[i].DiscussionID)
 		if err != nil {
 			t.Errorf("Unexpected error finding just-updated discussion: %v", err)
 			return
@@ -524,19 +524,19 @@ func testUnitDiscussion(t *testing.T) (exit bool) {
 		// Change owner
 		//
 		copy = m.discussions[i]
-		owner, err = UserFindRandom()
+		owner, err = event.UserFindRandom()
 		if err != nil {
 			t.Errorf("Finding a random user: %v", err)
 			return
 		}
 		copy.Owner = owner.UserID
-		err = DiscussionUpdate(&copy)
+		err = event.DiscussionUpdate("", &copy)
 		if err != nil {
 			t.Errorf("Updating discussion: %v", err)
 			return
 		}
 
-		gotdisc, err = DiscussionFindByIdFull(m.discussions[i].DiscussionID)
+		gotdisc, err = event.DiscussionFindByIdFull(m.discussions[i].DiscussionID)
 		if err != nil {
 			t.Errorf("Unexpected error finding just-updated discussion: %v", err)
 			return
@@ -562,7 +562,7 @@ func testUnitDiscussion(t *testing.T) (exit bool) {
 		for _, newTitle := range []string{"", "   "} {
 			copy = m.discussions[i]
 			copy.Title = newTitle
-			err = DiscussionUpdate(&copy)
+			err = event.DiscussionUpdate("", &copy)
 			if err == nil {
 				t.Errorf("Updating discussion with empty title (%s) succeeded!", newTitle)
 				return
@@ -572,14 +572,14 @@ func testUnitDiscussion(t *testing.T) (exit bool) {
 		for _, newDesc := range []string{"", "   "} {
 			copy = m.discussions[i]
 			copy.Description = newDesc
-			err = DiscussionUpdate(&copy)
+			err = event.DiscussionUpdate("", &copy)
 			if err == nil {
 				t.Errorf("Updating discussion with empty description (%s) succeeded!", newDesc)
 				return
 			}
 		}
 
-		gotdisc, err = DiscussionFindByIdFull(m.discussions[i].DiscussionID)
+		gotdisc, err = event.DiscussionFindByIdFull(m.discussions[i].DiscussionID)
 		if err != nil {
 			t.Errorf("Unexpected error finding just-updated discussion: %v", err)
 			return
@@ -598,13 +598,13 @@ func testUnitDiscussion(t *testing.T) (exit bool) {
 		copy.ApprovedTitle = fake.Title()
 		copy.ApprovedDescription = fake.Paragraphs()
 		copy.IsPublic = true
-		err = DiscussionUpdate(&copy)
+		err = event.DiscussionUpdate("", &copy)
 		if err != nil {
 			t.Errorf("Updating discussion: %v", err)
 			return
 		}
 
-		gotdisc, err = DiscussionFindByIdFull(m.discussions[i].DiscussionID)
+		gotdisc, err = event.DiscussionFindByIdFull(m.discussions[i].DiscussionID)
 		if err != nil {
 			t.Errorf("Unexpected error finding just-updated discussion: %v", err)
 			return
@@ -625,7 +625,7 @@ func testUnitDiscussion(t *testing.T) (exit bool) {
 	t.Logf("Testing DiscussionIterate")
 	{
 		i := 0
-		err := DiscussionIterate(func(d *DiscussionFull) error {
+		err := event.DiscussionIterate(func(d *DiscussionFull) error {
 			if !compareDiscussions(&m.discussions[i], &d.Discussion, t) {
 				return fmt.Errorf("DiscussionIterate mismatch")
 			}
@@ -644,7 +644,7 @@ func testUnitDiscussion(t *testing.T) (exit bool) {
 	t.Logf("Testing DiscussionIterate error reporting")
 	{
 		i := 0
-		err := DiscussionIterate(func(d *DiscussionFull) error {
+		err := event.DiscussionIterate(func(d *DiscussionFull) error {
 			if !compareDiscussions(&m.discussions[i], &d.Discussion, t) {
 				return fmt.Errorf("DiscussionIterate mismatch")
 			}
@@ -671,7 +671,7 @@ func testUnitDiscussion(t *testing.T) (exit bool) {
 		for uidx := range m.users {
 			uid := m.users[uidx].UserID
 			i := 0
-			err := DiscussionIterateUser(uid, func(d *DiscussionFull) error {
+			err := event.DiscussionIterateUser(uid, func(d *DiscussionFull) error {
 				if d.Owner != uid {
 					return fmt.Errorf("Got user %v, expecting %v!", d.Owner, uid)
 				}
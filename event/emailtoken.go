@@ -0,0 +1,224 @@
+package event
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/gwd/session-scheduler/id"
+)
+
+// EmailTokenPurpose says what a token sent by email may be used for.
+type EmailTokenPurpose string
+
+const (
+	EmailTokenReset  = EmailTokenPurpose("reset")
+	EmailTokenVerify = EmailTokenPurpose("verify")
+)
+
+const (
+	emailTokenLength = 32
+
+	PasswordResetLifetime = time.Hour
+	EmailVerifyLifetime   = 7 * 24 * time.Hour
+)
+
+// NewEmailToken creates a token for userid to be sent to their
+// current email address, which is returned along with the token.
+// Only the most recent token for each purpose is valid.  Tokens are
+// tied to the address they were sent to: if the user's address
+// changes, the token stops working.
+func (store *EventStore) NewEmailToken(userid UserID, purpose EmailTokenPurpose, lifetime time.Duration) (token, email string, err error) {
+	token = id.GenerateRawID(emailTokenLength)
+	expiry := time.Now().Add(lifetime).Unix()
+
+	err = store.txLoop(func(eq sqlx.Ext) error {
+		err := sqlx.Get(eq, &email,
+			`select email from event_users where userid = ? and deleted = 0`, userid)
+		if err == sql.ErrNoRows {
+			return ErrUserNotFound
+		} else if err != nil {
+			return errOrRetry("Getting user email", err)
+		}
+		if email == "" {
+			return errNoEmail
+		}
+
+		// Clean out expired tokens while we're here
+		_, err = eq.Exec(`
+            delete from event_email_tokens
+                where (userid = ? and purpose = ?) or expiry < ?`,
+			userid, purpose, time.Now().Unix())
+		if err != nil {
+			return errOrRetry("Deleting old email tokens", err)
+		}
+
+		_, err = eq.Exec(`
+            insert into event_email_tokens(hashedtoken, userid, purpose, email, expiry)
+                values(?, ?, ?, ?, ?)`,
+			secretHash(token), userid, purpose, email, expiry)
+		if err != nil {
+			return errOrRetry("Inserting email token", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return token, email, nil
+}
+
+// emailTokenUseTx checks that token is valid for purpose, and if so,
+// deletes it and returns the user it belongs to.
+func emailTokenUseTx(eq sqlx.Ext, token string, purpose EmailTokenPurpose) (*User, error) {
+	var et struct {
+		UserID UserID
+		Email  string
+		Expiry int64
+	}
+	err := sqlx.Get(eq, &et, `
+        select userid, email, expiry
+            from event_email_tokens
+            where hashedtoken = ? and purpose = ?`,
+		secretHash(token), purpose)
+	if err == sql.ErrNoRows {
+		return nil, ErrEmailTokenInvalid
+	} else if err != nil {
+		return nil, errOrRetry("Getting email token", err)
+	}
+
+	var user User
+	err = userGetTx(eq, et.UserID, &user)
+	if err == sql.ErrNoRows {
+		// The user is in the trash
+		return nil, ErrEmailTokenInvalid
+	} else if err != nil {
+		return nil, errOrRetry("Getting user for email token", err)
+	}
+
+	if et.Expiry < time.Now().Unix() || user.Email != et.Email {
+		return nil, ErrEmailTokenInvalid
+	}
+
+	_, err = eq.Exec(`
+        delete from event_email_tokens where userid = ? and purpose = ?`,
+		et.UserID, purpose)
+	if err != nil {
+		return nil, errOrRetry("Deleting email token", err)
+	}
+
+	// Either way, the user has proven they can read mail sent to
+	// this address.
+	_, err = eq.Exec(`
+        insert into event_email_verified(userid, email) values(?, ?)
+            on conflict(userid) do update set email = excluded.email`,
+		user.UserID, user.Email)
+	if err != nil {
+		return nil, errOrRetry("Marking email verified", err)
+	}
+
+	return &user, nil
+}
+
+// UserResetPassword sets a new password for the user to whom a
+// password reset token was sent.
+func (store *EventStore) UserResetPassword(token, newPassword string) (*User, error) {
+	if len(newPassword) < passwordLength {
+		return nil, errPasswordTooShort
+	}
+
+	hashedPassword, err := passwordHash(newPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	var user *User
+	err = store.txLoop(func(eq sqlx.Ext) error {
+		var err error
+		user, err = emailTokenUseTx(eq, token, EmailTokenReset)
+		if err != nil {
+			return err
+		}
+
+		_, err = eq.Exec(`
+            update event_users set hashedpassword = ? where userid = ?`,
+			hashedPassword, user.UserID)
+		if err != nil {
+			return errOrRetry("Setting new password", err)
+		}
+		user.HashedPassword = hashedPassword
+		return auditTx(eq, user.UserID, AuditUserResetPassword, string(user.UserID), nil, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// UserVerifyEmail marks the address to which a verification token
+// was sent as verified.
+func (store *EventStore) UserVerifyEmail(token string) (*User, error) {
+	var user *User
+	err := store.txLoop(func(eq sqlx.Ext) error {
+		var err error
+		user, err = emailTokenUseTx(eq, token, EmailTokenVerify)
+		if err != nil {
+			return err
+		}
+		return auditTx(eq, user.UserID, AuditUserVerifyEmail, string(user.UserID),
+			nil, struct{ Email string }{user.Email})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// UserEmailIsVerified returns true if the user has proven they own
+// their current email address.
+func (store *EventStore) UserEmailIsVerified(u *User) (bool, error) {
+	if u.Email == "" {
+		return false, nil
+	}
+
+	var count int
+	for {
+		err := store.Get(&count, `
+            select count(*) from event_email_verified
+                where userid = ? and email = ?`, u.UserID, u.Email)
+		switch {
+		case shouldRetry(err):
+			continue
+		case err != nil:
+			return false, err
+		}
+		return count > 0, nil
+	}
+}
+
+// UserFindByEmail returns a user with the given email address
+// (ignoring case), or nil if there is none.
+func (store *EventStore) UserFindByEmail(email string) (*User, error) {
+	if email == "" {
+		return nil, nil
+	}
+
+	var user User
+	for {
+		err := store.Get(&user, `
+            select * from event_users
+                where email = ? collate nocase and deleted = 0
+                order by userid
+                limit 1`, email)
+		switch {
+		case shouldRetry(err):
+			continue
+		case err == sql.ErrNoRows:
+			return nil, nil
+		case err != nil:
+			return nil, err
+		}
+		return &user, nil
+	}
+}
@@ -0,0 +1,181 @@
+package event
+
+import (
+	"strings"
+	"testing"
+)
+
+func testUnitEmailTokens(t *testing.T) (exit bool) {
+	// Any "early" exit is a failure
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+
+	m := &mirrorData{}
+
+	if testNewUsers(t, m, 2) {
+		return
+	}
+
+	user := &m.users[0]
+	other := &m.users[1]
+
+	t.Logf("Finding users by email")
+	if found, err := event.UserFindByEmail(strings.ToUpper(user.Email)); err != nil || found == nil || found.UserID != user.UserID {
+		t.Errorf("UserFindByEmail: got %v, err %v", found, err)
+		return
+	}
+	if found, err := event.UserFindByEmail("nobody@example.org"); err != nil || found != nil {
+		t.Errorf("UserFindByEmail for unknown address: got %v, err %v", found, err)
+		return
+	}
+
+	t.Logf("Resetting passwords")
+	if _, _, err := event.NewEmailToken(UserID("invalid"), EmailTokenReset, PasswordResetLifetime); err != ErrUserNotFound {
+		t.Errorf("NewEmailToken for invalid user: expected ErrUserNotFound, got %v", err)
+		return
+	}
+
+	oldToken, _, err := event.NewEmailToken(user.UserID, EmailTokenReset, PasswordResetLifetime)
+	if err != nil {
+		t.Errorf("NewEmailToken: %v", err)
+		return
+	}
+
+	token, email, err := event.NewEmailToken(user.UserID, EmailTokenReset, PasswordResetLifetime)
+	if err != nil || email != user.Email {
+		t.Errorf("NewEmailToken: email %q (wanted %q), err %v", email, user.Email, err)
+		return
+	}
+
+	const newPassword = "newpassword"
+
+	if _, err := event.UserResetPassword(oldToken, newPassword); err != ErrEmailTokenInvalid {
+		t.Errorf("Superseded token: expected ErrEmailTokenInvalid, got %v", err)
+		return
+	}
+
+	if _, err := event.UserResetPassword(token, "x"); err != errPasswordTooShort {
+		t.Errorf("Short password: expected errPasswordTooShort, got %v", err)
+		return
+	}
+
+	// Reset tokens can't be used for verification, and vice versa
+	if _, err := event.UserVerifyEmail(token); err != ErrEmailTokenInvalid {
+		t.Errorf("Reset token used to verify: expected ErrEmailTokenInvalid, got %v", err)
+		return
+	}
+
+	if verified, err := event.UserEmailIsVerified(user); err != nil || verified {
+		t.Errorf("Email verified prematurely (err %v)", err)
+		return
+	}
+
+	reset, err := event.UserResetPassword(token, newPassword)
+	if err != nil || reset == nil || reset.UserID != user.UserID {
+		t.Errorf("UserResetPassword: got %v, err %v", reset, err)
+		return
+	}
+
+	if u, _ := event.UserFind(user.UserID); u == nil || !u.CheckPassword(newPassword) || u.CheckPassword(TestPassword) {
+		t.Errorf("Password not changed")
+		return
+	}
+
+	// Resetting the password proves the user owns the address
+	if verified, err := event.UserEmailIsVerified(user); err != nil || !verified {
+		t.Errorf("Email not verified after password reset (err %v)", err)
+		return
+	}
+
+	if _, err := event.UserResetPassword(token, "anotherpassword"); err != ErrEmailTokenInvalid {
+		t.Errorf("Reused token: expected ErrEmailTokenInvalid, got %v", err)
+		return
+	}
+
+	if _, _, err := event.NewEmailToken(other.UserID, EmailTokenReset, -PasswordResetLifetime); err != nil {
+		t.Errorf("NewEmailToken: %v", err)
+		return
+	}
+	expired, _, err := event.NewEmailToken(other.UserID, EmailTokenVerify, -EmailVerifyLifetime)
+	if err != nil {
+		t.Errorf("NewEmailToken: %v", err)
+		return
+	}
+	if _, err := event.UserVerifyEmail(expired); err != ErrEmailTokenInvalid {
+		t.Errorf("Expired token: expected ErrEmailTokenInvalid, got %v", err)
+		return
+	}
+
+	t.Logf("Verifying email addresses")
+	token, _, err = event.NewEmailToken(other.UserID, EmailTokenVerify, EmailVerifyLifetime)
+	if err != nil {
+		t.Errorf("NewEmailToken: %v", err)
+		return
+	}
+
+	// Changing the address invalidates tokens sent to the old one
+	otherNext := *other
+	otherNext.Email = "changed@example.org"
+	if err := event.UserUpdate(&otherNext, other, "", ""); err != nil {
+		t.Errorf("UserUpdate: %v", err)
+		return
+	}
+
+	if _, err := event.UserVerifyEmail(token); err != ErrEmailTokenInvalid {
+		t.Errorf("Token for old address: expected ErrEmailTokenInvalid, got %v", err)
+		return
+	}
+
+	token, email, err = event.NewEmailToken(other.UserID, EmailTokenVerify, EmailVerifyLifetime)
+	if err != nil || email != otherNext.Email {
+		t.Errorf("NewEmailToken: email %q (wanted %q), err %v", email, otherNext.Email, err)
+		return
+	}
+
+	if verified, err := event.UserVerifyEmail(token); err != nil || verified == nil || verified.UserID != other.UserID {
+		t.Errorf("UserVerifyEmail: got %v, err %v", verified, err)
+		return
+	}
+
+	if verified, err := event.UserEmailIsVerified(&otherNext); err != nil || !verified {
+		t.Errorf("Email not verified (err %v)", err)
+		return
+	}
+
+	// ...and changing it again makes it unverified
+	otherNext2 := otherNext
+	otherNext2.Email = "changed-again@example.org"
+	if err := event.UserUpdate(&otherNext2, &otherNext, "", ""); err != nil {
+		t.Errorf("UserUpdate: %v", err)
+		return
+	}
+	if verified, err := event.UserEmailIsVerified(&otherNext2); err != nil || verified {
+		t.Errorf("Changed email still verified (err %v)", err)
+		return
+	}
+
+	t.Logf("Checking users without email")
+	otherNext3 := otherNext2
+	otherNext3.Email = ""
+	if err := event.UserUpdate(&otherNext3, &otherNext2, "", ""); err != nil {
+		t.Errorf("UserUpdate: %v", err)
+		return
+	}
+	if _, _, err := event.NewEmailToken(other.UserID, EmailTokenReset, PasswordResetLifetime); err != errNoEmail {
+		t.Errorf("NewEmailToken without email: expected errNoEmail, got %v", err)
+		return
+	}
+
+	if err := event.DeleteUser("", user.UserID, ""); err != nil {
+		t.Errorf("Deleting user: %v", err)
+		return
+	}
+
+	tc.cleanup()
+
+	return false
+}
@@ -9,32 +9,58 @@ import (
 type ValidationError error
 
 var (
-	ErrInternal                 = ValidationError(errors.New("Internal server error"))
-	errNoUsername               = ValidationError(errors.New("You must supply a username"))
-	errUsernameIsEmail          = ValidationError(errors.New("Username cannot be an email address"))
-	errNoEmail                  = ValidationError(errors.New("You must supply an email"))
-	errNoPassword               = ValidationError(errors.New("You must supply a password"))
-	errPasswordTooShort         = ValidationError(errors.New("Your password is too short"))
-	errPasswordIncorrect        = ValidationError(errors.New("Password did not match"))
-	errUsernameExists           = ValidationError(errors.New("That username is taken"))
-	errEmailExists              = ValidationError(errors.New("That email address has an account"))
-	ErrCredentialsIncorrect     = ValidationError(errors.New("We couldn’t find a user with the supplied username and password combination"))
-	errNoTitle                  = ValidationError(errors.New("You must provide a title"))
-	errTitleExists              = ValidationError(errors.New("That title exists"))
-	errNoDesc                   = ValidationError(errors.New("You must provide a description"))
-	errInvalidInterest          = ValidationError(errors.New("Interest value out of range"))
-	errTooManyDiscussions       = ValidationError(errors.New("You have too many discussions"))
-	errAllSlotsLocked           = ValidationError(errors.New("All slots are locked"))
-	errInProgress               = ValidationError(errors.New("Schedule already in progress"))
-	errModeratedDiscussions     = ValidationError(errors.New("Moderated discussions present: Please unmoderate or delete"))
-	ErrUserNotFound             = errors.New("UserID not found")
-	ErrDiscussionNotFound       = errors.New("DiscussionID not found")
-	ErrLocationNotFound         = errors.New("LocationID not found")
-	ErrDayNotFound              = errors.New("DayID not found")
-	ErrUserOrDiscussionNotFound = errors.New("UserID or DiscussionID not found")
-	errLocationNoName           = ValidationError(errors.New("Location must have a name"))
-	errLocationInvalidCapacity  = ValidationError(errors.New("Invalid capacity"))
-	errDayNoName                = ValidationError(errors.New("Day must have a name"))
+	ErrInternal                   = ValidationError(errors.New("Internal server error"))
+	errNoUsername                 = ValidationError(errors.New("You must supply a username"))
+	errUsernameIsEmail            = ValidationError(errors.New("Username cannot be an email address"))
+	errNoEmail                    = ValidationError(errors.New("You must supply an email"))
+	errNoPassword                 = ValidationError(errors.New("You must supply a password"))
+	errPasswordTooShort           = ValidationError(errors.New("Your password is too short"))
+	errPasswordIncorrect          = ValidationError(errors.New("Password did not match"))
+	errUsernameExists             = ValidationError(errors.New("That username is taken"))
+	errEmailExists                = ValidationError(errors.New("That email address has an account"))
+	ErrCredentialsIncorrect       = ValidationError(errors.New("We couldn’t find a user with the supplied username and password combination"))
+	errNoTitle                    = ValidationError(errors.New("You must provide a title"))
+	errTitleExists                = ValidationError(errors.New("That title exists"))
+	errNoDesc                     = ValidationError(errors.New("You must provide a description"))
+	errInvalidInterest            = ValidationError(errors.New("Interest value out of range"))
+	errTooManyDiscussions         = ValidationError(errors.New("You have too many discussions"))
+	errDiscussionLength           = ValidationError(errors.New("Invalid session length"))
+	errAllSlotsLocked             = ValidationError(errors.New("All slots are locked"))
+	errInProgress                 = ValidationError(errors.New("Schedule already in progress"))
+	errModeratedDiscussions       = ValidationError(errors.New("Moderated discussions present: Please unmoderate or delete"))
+	ErrUserNotFound               = errors.New("UserID not found")
+	ErrDiscussionNotFound         = errors.New("DiscussionID not found")
+	ErrLocationNotFound           = errors.New("LocationID not found")
+	ErrDayNotFound                = errors.New("DayID not found")
+	ErrAPITokenNotFound           = errors.New("API token not found")
+	ErrUserOrDiscussionNotFound   = errors.New("UserID or DiscussionID not found")
+	errLocationNoName             = ValidationError(errors.New("Location must have a name"))
+	errLocationInvalidCapacity    = ValidationError(errors.New("Invalid capacity"))
+	errDayNoName                  = ValidationError(errors.New("Day must have a name"))
+	errAPITokenNoName             = ValidationError(errors.New("API token must have a name"))
+	ErrEmailTokenInvalid          = ValidationError(errors.New("This link is invalid or has expired"))
+	ErrInviteInvalid              = ValidationError(errors.New("Invalid verification code"))
+	errInviteExpired              = ValidationError(errors.New("Verification code has expired"))
+	errInviteUsedUp               = ValidationError(errors.New("Verification code has already been used"))
+	errInviteAlreadyRedeemed      = ValidationError(errors.New("You have already redeemed a verification code"))
+	errInviteCount                = ValidationError(errors.New("Number of codes must be between 1 and 1000"))
+	errInviteMaxUses              = ValidationError(errors.New("Number of uses must be at least 1"))
+	errRejectNoReason             = ValidationError(errors.New("You must give a reason for rejecting"))
+	errRejectPublic               = ValidationError(errors.New("Discussion isn't awaiting moderation"))
+	errRoleInvalid                = ValidationError(errors.New("Invalid role"))
+	errRoleAdminAccount           = ValidationError(errors.New("The admin account's role can't be changed"))
+	ErrInviteNotFound             = errors.New("Invitation code not found")
+	errReassignUserNotFound       = ValidationError(errors.New("User to reassign sessions to not found"))
+	errReassignSameUser           = ValidationError(errors.New("Can't reassign sessions to the user being deleted"))
+	errReassignTooManyDiscussions = ValidationError(errors.New("That user would have too many discussions"))
+	errRestoreOwnerDeleted        = ValidationError(errors.New("The discussion's owner is in the trash: Please restore them first"))
+	errSlotNotFound               = ValidationError(errors.New("Slot not found"))
+	errSlotLocked                 = ValidationError(errors.New("Locked slots can't be deleted or made into breaks"))
+	errScheduleLocked             = ValidationError(errors.New("Sessions in locked slots can't be unscheduled: Please unlock the slots first"))
+	errImportNotEmpty             = ValidationError(errors.New("Can only import into an empty event"))
+	errPinSlot                    = ValidationError(errors.New("Sessions can't be pinned to breaks or locked slots, or run into them"))
+	errPinClash                   = ValidationError(errors.New("Another session is pinned to that location at the same time"))
+	errPinFull                    = ValidationError(errors.New("More sessions would be pinned to that time than there are locations"))
 )
 
 func IsValidationError(err error) bool {
@@ -2,6 +2,7 @@ package event
 
 import (
 	"log"
+	"sync"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -10,19 +11,25 @@ import (
 	"github.com/gwd/session-scheduler/id"
 )
 
+// EventStore is the database for a single event.  A server may have
+// several open at once.
 type EventStore struct {
-	filename string
 	*sqlx.DB
-	defaultLocation *time.Location
+	filename string
+	// Background scheduler runs, so that Close() can wait for them
+	schedJobs sync.WaitGroup
 }
 
 type EventOptions struct {
 	AdminPwd        string
 	DefaultLocation string
-	dbFilename      string
+	// Filename defaults to DbFilename
+	Filename string
 }
 
-var event EventStore
+// defaultLocation is shared by all events, since it comes from the
+// server configuration.
+var defaultLocation *time.Location
 
 const (
 	DbFilename    = "data/event.sqlite"
@@ -34,8 +41,8 @@ const (
 //  - Generate one.  Either way, print the password.
 //
 // If there is no admin user, but opt.AdminPwd is non-zero, set the password.
-func handleAdminPwd(adminPwd string) {
-	admin, err := UserFindByUsername(AdminUsername)
+func (store *EventStore) handleAdminPwd(adminPwd string) {
+	admin, err := store.UserFindByUsername(AdminUsername)
 
 	if err != nil {
 		log.Fatalf("handleAdminPwd: Error finding admin user: %v", err)
@@ -47,7 +54,7 @@ func handleAdminPwd(adminPwd string) {
 				log.Fatalf("Cannot set admin password: No such user")
 			}
 			log.Printf("Resetting admin password")
-			err = admin.setPassword(adminPwd)
+			err = store.userSetPassword(admin, adminPwd)
 			if err != nil {
 				log.Fatalf("resetting admin password: %v", err)
 			}
@@ -60,7 +67,7 @@ func handleAdminPwd(adminPwd string) {
 		adminPwd = id.GenerateRawID(12)
 	}
 
-	_, err = NewUser(adminPwd, &User{Username: AdminUsername,
+	_, err = store.NewUser(adminPwd, &User{Username: AdminUsername,
 		IsAdmin:    true,
 		IsVerified: true,
 		RealName:   "Xen Schedule Administrator"})
@@ -70,40 +77,47 @@ func handleAdminPwd(adminPwd string) {
 	log.Printf("Administrator account: admin %s", adminPwd)
 }
 
-func (store *EventStore) Load(opt EventOptions) error {
+// Load opens (creating it if necessary) the event database named in
+// opt.
+func Load(opt EventOptions) (*EventStore, error) {
 	if opt.DefaultLocation == "" {
 		log.Fatalf("No default location!")
 	}
+	if opt.Filename == "" {
+		opt.Filename = DbFilename
+	}
 
 	var err error
 
-	event.defaultLocation, err = time.LoadLocation(opt.DefaultLocation)
+	defaultLocation, err = time.LoadLocation(opt.DefaultLocation)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	event.DB, err = openDb(opt.dbFilename)
+	store := &EventStore{filename: opt.Filename}
+	store.DB, err = openDb(opt.Filename)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	handleAdminPwd(opt.AdminPwd)
+	store.handleAdminPwd(opt.AdminPwd)
 
-	// FIXME: Clean up any stale 'running' data
-
-	return nil
+	if err := store.schedCleanup(); err != nil {
+		store.DB.Close()
+		return nil, err
+	}
+	return store, nil
 }
 
-func Load(opt EventOptions) error {
-	if opt.dbFilename == "" {
-		opt.dbFilename = DbFilename
-	}
-	return event.Load(opt)
+// Filename returns the name of the database file.
+func (store *EventStore) Filename() string {
+	return store.filename
 }
 
-func Close() {
-	if event.DB != nil {
-		event.DB.Close()
+func (store *EventStore) Close() {
+	// Wait for any background scheduler runs to finish
+	store.schedJobs.Wait()
+	if store.DB != nil {
+		store.DB.Close()
 	}
-	event = EventStore{}
 }
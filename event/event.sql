@@ -8,7 +8,9 @@ CREATE TABLE event_users(
     email           text,
     company         text,
     description     text,
-    location        text not null /* Parsable by time.LoadLocation() */);
+    location        text not null, /* Parsable by time.LoadLocation() */
+    role            text not null default 'attendee', /* See Roles in role.go */
+    deleted         integer not null default 0); /* Unix time moved to the trash; 0 if not deleted */
 
 CREATE TABLE event_interest(
     userid text not null,
@@ -26,9 +28,20 @@ CREATE TABLE event_discussions(
     approvedtitle       text,
     approveddescription text,
     ispublic            boolean not null,
+    deleted             integer not null default 0, /* As for event_users */
+    length              integer not null default 1, /* In slots, as requested by the owner */
+    approvedlength      integer not null default 1, /* In slots, as approved by an admin */
     foreign key(owner) references event_users(userid),
     unique(title));
 
+/* Co-facilitators of a discussion, in addition to the owner */
+CREATE TABLE event_discussions_facilitators(
+    discussionid text not null,
+    userid       text not null,
+    foreign key(discussionid) references event_discussions(discussionid),
+    foreign key(userid) references event_users(userid),
+    unique(discussionid, userid));
+
 /* If a discussion has no possible slots, that means there are no restrictions. */
 CREATE TABLE event_discussions_possible_slots(
     discussionid text not null,
@@ -62,6 +75,7 @@ CREATE TABLE event_slots(
     foreign  key(dayid) references event_days(dayid),
     unique(dayid, slotidx));
 
+/* A discussion longer than one slot has an entry for each slot it takes up */
 CREATE TABLE event_schedule(
     discussionid text not null,
     slotid       text not null,
@@ -71,3 +85,89 @@ CREATE TABLE event_schedule(
     foreign key(locationid) references event_locations(locationid),
     unique(slotid, locationid));
 
+/* Discussions an admin has put in a particular slot (and optionally
+ * location); the scheduler schedules everything else around them */
+CREATE TABLE event_pins(
+    discussionid text primary key,
+    slotid       text not null, /* First slot, for discussions longer than one */
+    locationid   integer,       /* NULL to let the scheduler choose */
+    foreign key(discussionid) references event_discussions(discussionid),
+    foreign key(slotid) references event_slots(slotid),
+    foreign key(locationid) references event_locations(locationid));
+
+/* Exactly one row */
+CREATE TABLE event_scheduler(
+    isrunning  boolean not null,
+    ismodified boolean not null,
+    laststart  integer not null, /* in Unix time; 0 if never */
+    lastfinish integer not null, /* in Unix time; 0 if never */
+    lastresult text not null);
+
+/* Secret tokens for per-user calendar feeds */
+CREATE TABLE event_calendar_tokens(
+    userid text primary key,
+    token  text not null unique,
+    foreign key(userid) references event_users(userid));
+
+/* Personal access tokens for the JSON API */
+CREATE TABLE event_api_tokens(
+    tokenid     text primary key,
+    userid      text not null,
+    name        text not null,
+    hashedtoken text not null unique, /* hex-encoded SHA-256 */
+    readonly    boolean not null,
+    created     string not null,      /* Output of time.MarshalText() */
+    foreign key(userid) references event_users(userid));
+
+/* Secret tokens sent by email, for password resets and verifying email addresses */
+CREATE TABLE event_email_tokens(
+    hashedtoken text primary key, /* hex-encoded SHA-256 */
+    userid      text not null,
+    purpose     text not null,
+    email       text not null,    /* Address the token was sent to */
+    expiry      integer not null, /* in Unix time */
+    foreign key(userid) references event_users(userid));
+
+/* Email addresses which users have proven they own */
+CREATE TABLE event_email_verified(
+    userid text primary key,
+    email  text not null,
+    foreign key(userid) references event_users(userid));
+
+/* Invitation codes, which verify the users who redeem them */
+CREATE TABLE event_invites(
+    code    text primary key,
+    note    text not null,
+    maxuses integer not null,
+    uses    integer not null,
+    expiry  integer not null, /* in Unix time; 0 if never */
+    created text not null);   /* Output of time.MarshalText() */
+
+/* Which invitation code each user redeemed */
+CREATE TABLE event_invite_redemptions(
+    userid text primary key,
+    code   text not null,
+    foreign key(userid) references event_users(userid),
+    foreign key(code) references event_invites(code));
+
+/* Why a moderator most recently rejected a discussion (or changes to
+ * it); cleared when the owner edits it again, or it's approved */
+CREATE TABLE event_discussion_rejections(
+    discussionid text primary key,
+    reason       text not null,
+    rejected     text not null, /* Output of time.MarshalText() */
+    foreign key(discussionid) references event_discussions(discussionid));
+
+/* Record of every change, and who made it.  No foreign keys: entries
+ * must outlive the users and objects they refer to. */
+CREATE TABLE event_audit(
+    auditid   integer primary key,
+    time      integer not null, /* in Unix time */
+    actor     text not null,    /* "" if not done on behalf of a user */
+    actorname text not null,    /* Username of actor at the time */
+    action    text not null,
+    target    text not null,
+    before    text not null,    /* JSON; "" if target didn't exist */
+    after     text not null);   /* JSON; "" if target doesn't exist */
+
+CREATE INDEX event_audit_target on event_audit(target);
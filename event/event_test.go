@@ -41,6 +41,88 @@ func TestVersion(t *testing.T) {
 		return
 	}
 
+	// Make it look like a version 2 database and check that it's upgraded
+	for _, table := range []string{"event_scheduler", "event_discussions_facilitators", "event_calendar_tokens", "event_api_tokens",
+		"event_email_tokens", "event_email_verified", "event_invite_redemptions", "event_invites",
+		"event_discussion_rejections", "event_audit", "event_pins"} {
+		_, err = db.Exec(`drop table ` + table)
+		if err != nil {
+			t.Errorf("Dropping %s: %v", table, err)
+			return
+		}
+	}
+	_, err = db.Exec(`alter table event_users drop column role`)
+	if err != nil {
+		t.Errorf("Dropping role column: %v", err)
+		return
+	}
+	for _, table := range []string{"event_users", "event_discussions"} {
+		_, err = db.Exec(`alter table ` + table + ` drop column deleted`)
+		if err != nil {
+			t.Errorf("Dropping deleted column from %s: %v", table, err)
+			return
+		}
+	}
+	for _, column := range []string{"length", "approvedlength"} {
+		_, err = db.Exec(`alter table event_discussions drop column ` + column)
+		if err != nil {
+			t.Errorf("Dropping %s column from event_discussions: %v", column, err)
+			return
+		}
+	}
+	_, err = db.Exec(`
+        insert into event_users(userid, hashedpassword, username, isadmin, isverified, location)
+            values('usr_admin', '', 'admin', true, true, 'UTC')`)
+	if err != nil {
+		t.Errorf("Inserting admin user: %v", err)
+		return
+	}
+	_, err = db.Exec("pragma user_version=2")
+	if err != nil {
+		t.Errorf("Setting user version: %v", err)
+		return
+	}
+
+	db.Close()
+
+	db, err = openDb(sfname)
+	if err != nil {
+		t.Errorf("Opening version 2 database: %v", err)
+		return
+	}
+
+	var rows int
+	err = db.Get(&rows, `select count(*) from event_scheduler`)
+	if err != nil || rows != 1 {
+		t.Errorf("Upgraded database event_scheduler: %d rows, err %v", rows, err)
+		return
+	}
+
+	for _, table := range []string{"event_discussions_facilitators", "event_calendar_tokens", "event_api_tokens",
+		"event_email_tokens", "event_email_verified", "event_invite_redemptions", "event_invites",
+		"event_discussion_rejections", "event_audit"} {
+		err = db.Get(&rows, `select count(*) from `+table)
+		if err != nil || rows != 0 {
+			t.Errorf("Upgraded database %s: %d rows, err %v", table, rows, err)
+			return
+		}
+	}
+
+	var role Role
+	err = db.Get(&role, `select role from event_users where userid = 'usr_admin'`)
+	if err != nil || role != RoleAdmin {
+		t.Errorf("Upgraded database admin role %q (err %v), wanted %q", role, err, RoleAdmin)
+		return
+	}
+
+	var version int
+	err = db.Get(&version, "pragma user_version")
+	if err != nil || version != codeSchemaVersion {
+		t.Errorf("Upgraded database version %d (err %v), wanted %d",
+			version, err, codeSchemaVersion)
+		return
+	}
+
 	// Manually break the schema version
 	_, err = db.Exec(fmt.Sprintf("pragma user_version=%d", codeSchemaVersion+1))
 	if err != nil {
@@ -66,6 +148,9 @@ type testContext struct {
 
 var TestDefaultLocation = "Europe/Berlin"
 
+// The event store opened by dataInit
+var event *EventStore
+
 func dataInit(t *testing.T) *testContext {
 	tc := &testContext{}
 	var err error
@@ -82,14 +167,14 @@ func dataInit(t *testing.T) *testContext {
 	// Remove the file first, just in case
 	os.Remove(tc.dbfname)
 
-	evopt := EventOptions{dbFilename: tc.dbfname, DefaultLocation: TestDefaultLocation}
+	evopt := EventOptions{Filename: tc.dbfname, DefaultLocation: TestDefaultLocation}
 	if err != nil {
 		t.Errorf("Getting default test location %s: %v", TestDefaultLocation, err)
 		return nil
 	}
 
 	// Test simple open / close
-	err = Load(evopt)
+	event, err = Load(evopt)
 	if err != nil {
 		t.Errorf("Opening stores: %v", err)
 		return nil
@@ -100,7 +185,8 @@ func dataInit(t *testing.T) *testContext {
 
 func (tc testContext) cleanup() {
 	os.RemoveAll(tc.tmpdir)
-	Close()
+	event.Close()
+	event = nil
 }
 
 func TestEvent(t *testing.T) {
@@ -114,6 +200,34 @@ func TestEvent(t *testing.T) {
 		return
 	}
 
+	if testScheduleSearch(t) {
+		return
+	}
+
+	if testScheduleOwnerConflict(t) {
+		return
+	}
+
+	if testScheduleFacilitatorConflict(t) {
+		return
+	}
+
+	if testSchedulePlacement(t) {
+		return
+	}
+
+	if testScheduleExplain(t) {
+		return
+	}
+
+	if testUnitAgenda(t) {
+		return
+	}
+
+	if testSchedState(t) {
+		return
+	}
+
 	if testUnitTimetable(t) {
 		return
 	}
@@ -142,4 +256,71 @@ func TestEvent(t *testing.T) {
 		return
 	}
 
+	if testUnitFacilitators(t) {
+		return
+	}
+
+	if testUnitCalendar(t) {
+		return
+	}
+
+	if testUnitAPITokens(t) {
+		return
+	}
+
+	if testUnitEmailTokens(t) {
+		return
+	}
+
+	if testUnitInvites(t) {
+		return
+	}
+
+	if testUnitRoles(t) {
+		return
+	}
+
+	if testUnitModeration(t) {
+		return
+	}
+
+	if testUnitAudit(t) {
+		return
+	}
+
+	if testUnitTrash(t) {
+		return
+	}
+
+	if testUnitDeleteReassign(t) {
+		return
+	}
+
+	if testUnitBackup(t) {
+		return
+	}
+
+	if testUnitExport(t) {
+		return
+	}
+
+	if testUnitTimetableEdit(t) {
+		return
+	}
+
+	if testUnitScheduleImpact(t) {
+		return
+	}
+
+	if testUnitUserCopy(t) {
+		return
+	}
+
+	if testUnitDiscussionLength(t) {
+		return
+	}
+
+	if testUnitPin(t) {
+		return
+	}
 }
@@ -4,13 +4,14 @@ import (
 	//"database/sql"
 	"fmt"
 	"log"
+	"math/rand"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/mattn/go-sqlite3"
 )
 
-const codeSchemaVersion = 2
+const codeSchemaVersion = 14
 
 func isSqliteErrorCode(err error, queries ...error) bool {
 	if err == nil {
@@ -57,21 +58,32 @@ func errOrRetry(comment string, err error) error {
 }
 
 const (
-	minTxRetries = 5
-	maxTxTime    = time.Second
+	minTxRetries  = 5
+	maxTxTime     = time.Second
+	txBackoffUnit = 10 * time.Microsecond
 )
 
-func txLoop(txFunc func(eq sqlx.Ext) error) error {
+// txBackoff sleeps a short, random, increasing amount of time before
+// a transaction is retried, so that retrying transactions don't spin
+// and starve the transaction holding the lock.
+func txBackoff(count int) {
+	if count > 1 {
+		time.Sleep(time.Duration(rand.Int63n(int64(count) * int64(txBackoffUnit))))
+	}
+}
+
+func (store *EventStore) txLoop(txFunc func(eq sqlx.Ext) error) error {
 	start := time.Now()
 	count := 0
 	for {
 		count++
+		txBackoff(count)
 		if count > minTxRetries && time.Now().Sub(start) > maxTxTime {
 			return fmt.Errorf("Internal error: Transaction taking too long (reps %v time %v)",
 				count, time.Now().Sub(start))
 		}
 
-		tx, err := event.Beginx()
+		tx, err := store.Beginx()
 		if shouldRetry(err) {
 			continue
 		} else if err != nil {
@@ -104,47 +116,55 @@ func txLoop(txFunc func(eq sqlx.Ext) error) error {
 	}
 }
 
-func openDb(filename string) (*sqlx.DB, error) {
-	db, err := sqlx.Open("sqlite3", fmt.Sprintf("file:%s?cache=shared&_foreign_keys=on&_journal_mode=wal", filename))
-	if err != nil {
-		return nil, err
-	}
+func dbDSN(filename string) string {
+	return fmt.Sprintf("file:%s?cache=shared&_foreign_keys=on&_journal_mode=wal", filename)
+}
 
-	// Check for existence of tables
-	tx, err := db.Beginx()
+func openDb(filename string) (*sqlx.DB, error) {
+	db, err := sqlx.Open("sqlite3", dbDSN(filename))
 	if err != nil {
 		return nil, err
 	}
-	defer tx.Rollback()
 
 	var dbSchemaVersion int
-	err = tx.Get(&dbSchemaVersion, "pragma user_version")
+	err = db.Get(&dbSchemaVersion, "pragma user_version")
 	if err != nil {
+		db.Close()
 		return nil, fmt.Errorf("Getting schema version: %v", err)
 	}
 
-	commit := false
+	if dbSchemaVersion == 0 {
+		tx, err := db.Beginx()
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		defer tx.Rollback()
 
-	switch dbSchemaVersion {
-	case 0:
 		err = initDb(tx)
 		if err != nil {
+			db.Close()
 			return nil, fmt.Errorf("Initializing database: %v", err)
 		}
-		commit = true
-	case codeSchemaVersion:
-		break
-	default:
-		return nil, fmt.Errorf("Wrong schema version (code %d, db %d)",
-			codeSchemaVersion, dbSchemaVersion)
-	}
 
-	// No need to commit in common case
-	if commit {
 		err = tx.Commit()
 		if err != nil {
-			return nil, fmt.Errorf("Committing database init/upgrade: %v", err)
+			db.Close()
+			return nil, fmt.Errorf("Committing database init: %v", err)
 		}
+
+		return db, nil
+	}
+
+	// Older databases are upgraded automatically
+	res, err := migrateDb(db, filename, dbSchemaVersion, false)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if res.Backup != "" {
+		log.Printf("Upgraded event database from version %d to %d; backup in %s",
+			res.FromVersion, res.ToVersion, res.Backup)
 	}
 
 	return db, nil
@@ -167,7 +187,9 @@ CREATE TABLE event_users(
     email           text,
     company         text,
     description     text,
-    location        text not null /* Parsable by time.LoadLocation() */)`)
+    location        text not null, /* Parsable by time.LoadLocation() */
+    role            text not null default 'attendee',
+    deleted         integer not null default 0)`)
 	if err != nil {
 		return errOrRetry("Creating table event_users", err)
 	}
@@ -181,6 +203,9 @@ CREATE TABLE event_discussions(
     approvedtitle       text,
     approveddescription text,
     ispublic            boolean not null,
+    deleted             integer not null default 0,
+    length              integer not null default 1,
+    approvedlength      integer not null default 1,
     foreign key(owner) references event_users(userid),
     unique(title))`)
 	if err != nil {
@@ -256,5 +281,270 @@ CREATE TABLE event_schedule(
 		return errOrRetry("Creating table event_schedule", err)
 	}
 
+	err = createSchedulerTable(ext)
+	if err != nil {
+		return err
+	}
+
+	err = createFacilitatorsTable(ext)
+	if err != nil {
+		return err
+	}
+
+	err = createCalendarTokensTable(ext)
+	if err != nil {
+		return err
+	}
+
+	err = createAPITokensTable(ext)
+	if err != nil {
+		return err
+	}
+
+	err = createEmailTables(ext)
+	if err != nil {
+		return err
+	}
+
+	err = createInviteTables(ext)
+	if err != nil {
+		return err
+	}
+
+	err = createRejectionsTable(ext)
+	if err != nil {
+		return err
+	}
+
+	err = createAuditTable(ext)
+	if err != nil {
+		return err
+	}
+
+	return createPinsTable(ext)
+}
+
+func createSchedulerTable(ext sqlx.Ext) error {
+	_, err := ext.Exec(`
+CREATE TABLE event_scheduler(
+    isrunning  boolean not null,
+    ismodified boolean not null,
+    laststart  integer not null, /* in Unix time; 0 if never */
+    lastfinish integer not null, /* in Unix time; 0 if never */
+    lastresult text not null)`)
+	if err != nil {
+		return errOrRetry("Creating table event_scheduler", err)
+	}
+
+	_, err = ext.Exec(`
+insert into event_scheduler values(false, true, 0, 0, '')`)
+	if err != nil {
+		return errOrRetry("Initializing event_scheduler", err)
+	}
+
 	return nil
 }
+
+func createFacilitatorsTable(ext sqlx.Ext) error {
+	_, err := ext.Exec(`
+CREATE TABLE event_discussions_facilitators(
+    discussionid text not null,
+    userid       text not null,
+    foreign key(discussionid) references event_discussions(discussionid),
+    foreign key(userid) references event_users(userid),
+    unique(discussionid, userid))`)
+	if err != nil {
+		return errOrRetry("Creating table event_discussions_facilitators", err)
+	}
+	return nil
+}
+
+func createCalendarTokensTable(ext sqlx.Ext) error {
+	_, err := ext.Exec(`
+CREATE TABLE event_calendar_tokens(
+    userid text primary key,
+    token  text not null unique,
+    foreign key(userid) references event_users(userid))`)
+	if err != nil {
+		return errOrRetry("Creating table event_calendar_tokens", err)
+	}
+	return nil
+}
+
+func createAPITokensTable(ext sqlx.Ext) error {
+	_, err := ext.Exec(`
+CREATE TABLE event_api_tokens(
+    tokenid     text primary key,
+    userid      text not null,
+    name        text not null,
+    hashedtoken text not null unique, /* hex-encoded SHA-256 */
+    readonly    boolean not null,
+    created     string not null,      /* Output of time.MarshalText() */
+    foreign key(userid) references event_users(userid))`)
+	if err != nil {
+		return errOrRetry("Creating table event_api_tokens", err)
+	}
+	return nil
+}
+
+func createEmailTables(ext sqlx.Ext) error {
+	_, err := ext.Exec(`
+CREATE TABLE event_email_tokens(
+    hashedtoken text primary key, /* hex-encoded SHA-256 */
+    userid      text not null,
+    purpose     text not null,
+    email       text not null,    /* Address the token was sent to */
+    expiry      integer not null, /* in Unix time */
+    foreign key(userid) references event_users(userid))`)
+	if err != nil {
+		return errOrRetry("Creating table event_email_tokens", err)
+	}
+
+	_, err = ext.Exec(`
+CREATE TABLE event_email_verified(
+    userid text primary key,
+    email  text not null,
+    foreign key(userid) references event_users(userid))`)
+	if err != nil {
+		return errOrRetry("Creating table event_email_verified", err)
+	}
+	return nil
+}
+
+func createInviteTables(ext sqlx.Ext) error {
+	_, err := ext.Exec(`
+CREATE TABLE event_invites(
+    code    text primary key,
+    note    text not null,
+    maxuses integer not null,
+    uses    integer not null,
+    expiry  integer not null, /* in Unix time; 0 if never */
+    created text not null)    /* Output of time.MarshalText() */`)
+	if err != nil {
+		return errOrRetry("Creating table event_invites", err)
+	}
+
+	_, err = ext.Exec(`
+CREATE TABLE event_invite_redemptions(
+    userid text primary key,
+    code   text not null,
+    foreign key(userid) references event_users(userid),
+    foreign key(code) references event_invites(code))`)
+	if err != nil {
+		return errOrRetry("Creating table event_invite_redemptions", err)
+	}
+	return nil
+}
+
+// Roles are part of event_users in new databases; older ones need
+// the column adding, with existing admins given the admin role.
+func addUserRoles(ext sqlx.Ext) error {
+	_, err := ext.Exec(`
+ALTER TABLE event_users ADD COLUMN role text not null default 'attendee'`)
+	if err != nil {
+		return errOrRetry("Adding role to event_users", err)
+	}
+
+	_, err = ext.Exec(`update event_users set role = 'admin' where isadmin`)
+	if err != nil {
+		return errOrRetry("Setting role for admins", err)
+	}
+	return nil
+}
+
+func createRejectionsTable(ext sqlx.Ext) error {
+	_, err := ext.Exec(`
+CREATE TABLE event_discussion_rejections(
+    discussionid text primary key,
+    reason       text not null,
+    rejected     text not null, /* Output of time.MarshalText() */
+    foreign key(discussionid) references event_discussions(discussionid))`)
+	if err != nil {
+		return errOrRetry("Creating table event_discussion_rejections", err)
+	}
+	return nil
+}
+
+// No foreign keys: entries must outlive the users and objects they
+// refer to.
+func createAuditTable(ext sqlx.Ext) error {
+	_, err := ext.Exec(`
+CREATE TABLE event_audit(
+    auditid   integer primary key,
+    time      integer not null, /* in Unix time */
+    actor     text not null,    /* "" if not done on behalf of a user */
+    actorname text not null,    /* Username of actor at the time */
+    action    text not null,
+    target    text not null,
+    before    text not null,    /* JSON; "" if target didn't exist */
+    after     text not null)    /* JSON; "" if target doesn't exist */`)
+	if err != nil {
+		return errOrRetry("Creating table event_audit", err)
+	}
+
+	_, err = ext.Exec(`CREATE INDEX event_audit_target on event_audit(target)`)
+	if err != nil {
+		return errOrRetry("Creating index event_audit_target", err)
+	}
+	return nil
+}
+
+// Deleted users and discussions are kept in the trash until purged.
+func addDeletedColumns(ext sqlx.Ext) error {
+	for _, table := range []string{"event_users", "event_discussions"} {
+		_, err := ext.Exec(`
+ALTER TABLE ` + table + ` ADD COLUMN deleted integer not null default 0`)
+		if err != nil {
+			return errOrRetry("Adding deleted to "+table, err)
+		}
+	}
+	return nil
+}
+
+// Discussions can take up more than one slot.
+func addDiscussionLengths(ext sqlx.Ext) error {
+	for _, column := range []string{"length", "approvedlength"} {
+		_, err := ext.Exec(`
+ALTER TABLE event_discussions ADD COLUMN ` + column + ` integer not null default 1`)
+		if err != nil {
+			return errOrRetry("Adding "+column+" to event_discussions", err)
+		}
+	}
+	return nil
+}
+
+// Pins are kept apart from event_schedule, which the scheduler
+// replaces wholesale.
+func createPinsTable(ext sqlx.Ext) error {
+	_, err := ext.Exec(`
+CREATE TABLE event_pins(
+    discussionid text primary key,
+    slotid       text not null, /* First slot, for discussions longer than one */
+    locationid   integer,       /* NULL to let the scheduler choose */
+    foreign key(discussionid) references event_discussions(discussionid),
+    foreign key(slotid) references event_slots(slotid),
+    foreign key(locationid) references event_locations(locationid))`)
+	if err != nil {
+		return errOrRetry("Creating table event_pins", err)
+	}
+	return nil
+}
+
+// dbMigrations lists the steps to upgrade an older database to
+// codeSchemaVersion, in order.  New steps must be added at the end,
+// with the next version number, and the schema created by initDb
+// updated to match.
+var dbMigrations = []dbMigration{
+	{MigrationStep{3, "Add scheduler state"}, createSchedulerTable},
+	{MigrationStep{4, "Add discussion co-facilitators"}, createFacilitatorsTable},
+	{MigrationStep{5, "Add calendar feed tokens"}, createCalendarTokensTable},
+	{MigrationStep{6, "Add API tokens"}, createAPITokensTable},
+	{MigrationStep{7, "Add email tokens and verified addresses"}, createEmailTables},
+	{MigrationStep{8, "Add invitation codes"}, createInviteTables},
+	{MigrationStep{9, "Add user roles"}, addUserRoles},
+	{MigrationStep{10, "Add discussion rejections"}, createRejectionsTable},
+	{MigrationStep{11, "Add audit log"}, createAuditTable},
+	{MigrationStep{12, "Add trash for users and discussions"}, addDeletedColumns},
+	{MigrationStep{13, "Add discussion lengths"}, addDiscussionLengths},
+	{MigrationStep{14, "Add discussion pins"}, createPinsTable},
+}
@@ -0,0 +1,277 @@
+package event
+
+import (
+	"sort"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ExplainDiscussion describes a single scheduled discussion.
+//
+// Contribution is how much the discussion adds to the score of its
+// slot (as computed by scoreSlotDelta() against the other
+// discussions in the slot); that is, how much utility would be lost
+// if it were removed.  MaxInterest is the sum of all interest
+// expressed in the discussion.
+type ExplainDiscussion struct {
+	DiscussionID DiscussionID
+	Title        string
+	LocationName string
+	Contribution int
+	MaxInterest  int
+}
+
+type ExplainSlot struct {
+	SlotID      SlotID
+	Time        Time
+	TimeDisplay string
+	IsLocked    bool
+	Score       int
+	Discussions []ExplainDiscussion
+}
+
+// ExplainConflict is a slot containing more than one discussion a
+// user is interested in.  Attending is the discussion they're
+// assumed to attend (the one they're most interested in).
+type ExplainConflict struct {
+	SlotID      SlotID
+	TimeDisplay string
+	Attending   DiscussionID
+	Discussions []ExplainInterest
+}
+
+type ExplainInterest struct {
+	DiscussionID DiscussionID
+	Title        string
+	Interest     int
+}
+
+// ExplainUser describes how the schedule looks for one user: the
+// utility they get from the schedule, versus the sum of their
+// interest in all discussions.
+type ExplainUser struct {
+	UserID     UserID
+	Username   string
+	Utility    int
+	MaxUtility int
+	Conflicts  []ExplainConflict
+	Unplaced   []ExplainInterest
+}
+
+// ScheduleExplanation explains the current schedule.  TotalUtility
+// is the score of the schedule; MaxUtility is what the score would be
+// if everyone could attend everything they're interested in (the
+// sum of MaxInterest for all discussions, counted once for each slot
+// they take up).  A discussion longer than one slot is listed in
+// each of its slots.
+type ScheduleExplanation struct {
+	Slots        []ExplainSlot
+	Users        []ExplainUser
+	Unplaced     []ExplainDiscussion
+	TotalUtility int
+	MaxUtility   int
+}
+
+// ScheduleExplain breaks down the score of the current schedule by
+// slot, discussion, and user.  If tfmt is non-empty, TimeDisplay will
+// be formatted with it, converted to tzl if non-nil.
+func (store *EventStore) ScheduleExplain(tfmt string, tzl *TZLocation) (*ScheduleExplanation, error) {
+	var ex *ScheduleExplanation
+	err := store.txLoop(func(eq sqlx.Ext) error {
+		ex = &ScheduleExplanation{}
+
+		err := sqlx.Select(eq, &ex.Slots, `
+            select slotid, slottime as time, islocked
+                from event_slots
+                where isbreak = false
+                order by dayid, slotidx`)
+		if err != nil {
+			return errOrRetry("Getting slots", err)
+		}
+
+		var discs []struct {
+			DiscussionID DiscussionID
+			Title        string
+			Length       int
+			SlotID       *SlotID
+			LocationName *string
+		}
+		err = sqlx.Select(eq, &discs, `
+            select discussionid, title, approvedlength as length, slotid, locationname
+                from event_discussions
+                    natural left join event_schedule
+                    natural left join event_locations
+                where deleted = 0
+                order by discussionid`)
+		if err != nil {
+			return errOrRetry("Getting discussions", err)
+		}
+
+		var interest []struct {
+			UserID       UserID
+			DiscussionID DiscussionID
+			Interest     int
+		}
+		err = sqlx.Select(eq, &interest, `
+            select userid, discussionid, interest
+                from event_interest
+                where interest > 0
+                    and userid in (select userid from event_users where deleted = 0)
+                    and discussionid in (select discussionid from event_discussions where deleted = 0)`)
+		if err != nil {
+			return errOrRetry("Getting interest", err)
+		}
+
+		var users []User
+		if err = userGetAllTx(eq, &users); err != nil {
+			return errOrRetry("Getting users", err)
+		}
+
+		// Build searchDiscussions so that we can use the same
+		// scoring functions as the scheduler.
+		sds := map[DiscussionID]*searchDiscussion{}
+		titles := map[DiscussionID]string{}
+		for i := range discs {
+			if sds[discs[i].DiscussionID] == nil {
+				sds[discs[i].DiscussionID] = &searchDiscussion{
+					DiscussionID: discs[i].DiscussionID,
+					Length:       discs[i].Length,
+				}
+			}
+			titles[discs[i].DiscussionID] = discs[i].Title
+		}
+		userInterest := map[UserID]map[DiscussionID]int{}
+		for _, ui := range interest {
+			sd := sds[ui.DiscussionID]
+			sd.UserInterest = append(sd.UserInterest, struct {
+				UserID   UserID
+				Interest int
+			}{ui.UserID, ui.Interest})
+			sd.MaxInterest += ui.Interest
+			ex.MaxUtility += ui.Interest * sd.length()
+			if userInterest[ui.UserID] == nil {
+				userInterest[ui.UserID] = map[DiscussionID]int{}
+			}
+			userInterest[ui.UserID][ui.DiscussionID] = ui.Interest
+		}
+
+		slotIndex := map[SlotID]int{}
+		for i := range ex.Slots {
+			slot := &ex.Slots[i]
+			slotIndex[slot.SlotID] = i
+			if tfmt != "" {
+				t := slot.Time.Time
+				if tzl != nil && tzl.Location != nil {
+					t = t.In(tzl.Location)
+				}
+				slot.TimeDisplay = t.Format(tfmt)
+			}
+		}
+
+		slotDiscs := make([][]*searchDiscussion, len(ex.Slots))
+		for i := range discs {
+			d := &discs[i]
+			ed := ExplainDiscussion{
+				DiscussionID: d.DiscussionID,
+				Title:        d.Title,
+				MaxInterest:  sds[d.DiscussionID].MaxInterest,
+			}
+			sidx, ok := -1, false
+			if d.SlotID != nil {
+				sidx, ok = slotIndex[*d.SlotID]
+			}
+			if !ok {
+				ex.Unplaced = append(ex.Unplaced, ed)
+				continue
+			}
+			if d.LocationName != nil {
+				ed.LocationName = *d.LocationName
+			}
+			ex.Slots[sidx].Discussions = append(ex.Slots[sidx].Discussions, ed)
+			slotDiscs[sidx] = append(slotDiscs[sidx], sds[d.DiscussionID])
+		}
+
+		// Per-slot, per-discussion contributions
+		for i := range ex.Slots {
+			slot := &ex.Slots[i]
+			slot.Score = scoreSlot(slotDiscs[i])
+			ex.TotalUtility += slot.Score
+			for j := range slotDiscs[i] {
+				others := make([]*searchDiscussion, 0, len(slotDiscs[i])-1)
+				others = append(others, slotDiscs[i][:j]...)
+				others = append(others, slotDiscs[i][j+1:]...)
+				slot.Discussions[j].Contribution = scoreSlotDelta(others, slotDiscs[i][j])
+			}
+			sort.SliceStable(slot.Discussions, func(a, b int) bool {
+				return slot.Discussions[a].Contribution > slot.Discussions[b].Contribution
+			})
+		}
+
+		// Per-user utility and conflicts
+		for i := range users {
+			u := &users[i]
+			uinterest := userInterest[u.UserID]
+			if len(uinterest) == 0 {
+				continue
+			}
+
+			eu := ExplainUser{UserID: u.UserID, Username: u.Username}
+			for did, interest := range uinterest {
+				eu.MaxUtility += interest * sds[did].length()
+			}
+
+			for j := range ex.Slots {
+				var interested []ExplainInterest
+				best := ExplainInterest{}
+				for _, sd := range slotDiscs[j] {
+					interest := uinterest[sd.DiscussionID]
+					if interest == 0 {
+						continue
+					}
+					ei := ExplainInterest{
+						DiscussionID: sd.DiscussionID,
+						Title:        titles[sd.DiscussionID],
+						Interest:     interest,
+					}
+					interested = append(interested, ei)
+					if interest > best.Interest {
+						best = ei
+					}
+				}
+				eu.Utility += best.Interest
+				if len(interested) > 1 {
+					eu.Conflicts = append(eu.Conflicts, ExplainConflict{
+						SlotID:      ex.Slots[j].SlotID,
+						TimeDisplay: ex.Slots[j].TimeDisplay,
+						Attending:   best.DiscussionID,
+						Discussions: interested,
+					})
+				}
+			}
+
+			for _, ed := range ex.Unplaced {
+				if interest := uinterest[ed.DiscussionID]; interest > 0 {
+					eu.Unplaced = append(eu.Unplaced, ExplainInterest{
+						DiscussionID: ed.DiscussionID,
+						Title:        ed.Title,
+						Interest:     interest,
+					})
+				}
+			}
+
+			ex.Users = append(ex.Users, eu)
+		}
+
+		// Users losing the most utility first
+		sort.SliceStable(ex.Users, func(a, b int) bool {
+			ua, ub := &ex.Users[a], &ex.Users[b]
+			return ua.MaxUtility-ua.Utility > ub.MaxUtility-ub.Utility
+		})
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ex, nil
+}
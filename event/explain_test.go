@@ -0,0 +1,116 @@
+package event
+
+import (
+	"testing"
+	"time"
+)
+
+func testScheduleExplain(t *testing.T) (exit bool) {
+	// Any "early" exit is a failure
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+
+	m := &mirrorData{}
+
+	t.Logf("Setting up users, discussion, timetable")
+	// More discussions than slots * locations, so some are unplaced
+	if testScheduleSearchSetup(t, m, 20, 3) {
+		return
+	}
+
+	if err := event.MakeSchedule(SearchOptions{}); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+
+	ex, err := event.ScheduleExplain(time.RFC3339, nil)
+	if err != nil {
+		t.Errorf("ScheduleExplain: %v", err)
+		return
+	}
+
+	// Every discussion should be either in exactly one slot or unplaced
+	seen := map[DiscussionID]int{}
+	slotTotal := 0
+	for _, slot := range ex.Slots {
+		if slot.TimeDisplay == "" {
+			t.Errorf("Slot %v has no TimeDisplay", slot.SlotID)
+			return
+		}
+		slotTotal += slot.Score
+		for _, ed := range slot.Discussions {
+			seen[ed.DiscussionID]++
+			if ed.Contribution < 0 || ed.Contribution > slot.Score ||
+				ed.Contribution > ed.MaxInterest {
+				t.Errorf("Slot %v discussion %v: Unexpected contribution %d (slot score %d, max %d)",
+					slot.SlotID, ed.DiscussionID, ed.Contribution, slot.Score, ed.MaxInterest)
+				return
+			}
+		}
+	}
+	for _, ed := range ex.Unplaced {
+		seen[ed.DiscussionID]++
+	}
+	if len(ex.Unplaced) == 0 {
+		t.Errorf("Expected some unplaced discussions")
+		return
+	}
+	for _, disc := range m.discussions {
+		if seen[disc.DiscussionID] != 1 {
+			t.Errorf("Discussion %v appears %d times", disc.DiscussionID, seen[disc.DiscussionID])
+			return
+		}
+	}
+
+	if slotTotal != ex.TotalUtility {
+		t.Errorf("Slot scores add up to %d, but total utility %d", slotTotal, ex.TotalUtility)
+		return
+	}
+
+	var maxUtility int
+	if err = event.Get(&maxUtility, `select sum(interest) from event_interest`); err != nil {
+		t.Errorf("Getting total interest: %v", err)
+		return
+	}
+	if ex.MaxUtility != maxUtility || ex.TotalUtility > ex.MaxUtility {
+		t.Errorf("Max utility %d (wanted %d), total %d",
+			ex.MaxUtility, maxUtility, ex.TotalUtility)
+		return
+	}
+
+	// Per-user utilities should add up to the same total
+	userTotal := 0
+	for _, eu := range ex.Users {
+		userTotal += eu.Utility
+		for _, c := range eu.Conflicts {
+			if len(c.Discussions) < 2 {
+				t.Errorf("User %s: Conflict with only %d discussions", eu.Username, len(c.Discussions))
+				return
+			}
+			for _, ei := range c.Discussions {
+				if ei.DiscussionID == c.Attending {
+					continue
+				}
+				for _, ea := range c.Discussions {
+					if ea.DiscussionID == c.Attending && ea.Interest < ei.Interest {
+						t.Errorf("User %s attending %v (interest %d) rather than %v (interest %d)",
+							eu.Username, ea.DiscussionID, ea.Interest, ei.DiscussionID, ei.Interest)
+						return
+					}
+				}
+			}
+		}
+	}
+	if userTotal != ex.TotalUtility {
+		t.Errorf("User utilities add up to %d, but total utility %d", userTotal, ex.TotalUtility)
+		return
+	}
+
+	tc.cleanup()
+
+	return false
+}
@@ -0,0 +1,551 @@
+package event
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ExportFormatVersion is the version of the Export format.  It must
+// be increased whenever the format changes in a way that older
+// versions of Import couldn't read.
+const ExportFormatVersion = 1
+
+// Export is a portable copy of an event: its timetable and locations,
+// and (unless it's only a timetable) its users, discussions, interest
+// and schedule.  Things in the trash aren't included.
+//
+// IDs are kept as they are, so that an event can be moved to another
+// server unchanged.
+type Export struct {
+	FormatVersion int
+	Days          []ExportDay
+	Locations     []Location
+	Users         []ExportUser         `json:",omitempty"`
+	Discussions   []ExportDiscussion   `json:",omitempty"`
+	Schedule      []ExportScheduleSlot `json:",omitempty"`
+}
+
+// ExportDay is a day of the timetable.  Days, and slots within them,
+// are in order.
+type ExportDay struct {
+	DayName string
+	Slots   []ExportSlot
+}
+
+type ExportSlot struct {
+	SlotID   SlotID
+	Time     Time
+	IsBreak  bool `json:",omitempty"`
+	IsLocked bool `json:",omitempty"`
+}
+
+type ExportUser struct {
+	UserID UserID
+	// Only exported on request; users imported without one must
+	// reset their password before they can log in.
+	HashedPassword string `json:",omitempty"`
+	Username       string
+	IsAdmin        bool `json:",omitempty"`
+	IsVerified     bool `json:",omitempty"`
+	Role           Role
+	Location       string // Name of the time zone, e.g. Europe/Berlin
+	RealName       string `json:",omitempty"`
+	Email          string `json:",omitempty"`
+	EmailVerified  bool   `json:",omitempty"`
+	Company        string `json:",omitempty"`
+	Description    string `json:",omitempty"`
+}
+
+type ExportDiscussion struct {
+	DiscussionID        DiscussionID
+	Owner               UserID
+	Title               string
+	Description         string         `json:",omitempty"`
+	ApprovedTitle       string         `json:",omitempty"`
+	ApprovedDescription string         `json:",omitempty"`
+	IsPublic            bool           `json:",omitempty"`
+	Length              int            `json:",omitempty"` // Missing means 1
+	ApprovedLength      int            `json:",omitempty"` // Missing means 1
+	Facilitators        []UserID       `json:",omitempty"`
+	PossibleSlots       []SlotID       `json:",omitempty"` // Empty if any slot will do
+	Pin                 *Pin           `json:",omitempty"`
+	Interest            map[UserID]int `json:",omitempty"`
+}
+
+// ExportScheduleSlot is a discussion's place in the schedule; a
+// discussion longer than one slot has one for each slot.
+type ExportScheduleSlot struct {
+	DiscussionID DiscussionID
+	SlotID       SlotID
+	LocationID   LocationID
+}
+
+// ExportOptions says what to include in an export.
+type ExportOptions struct {
+	TimetableOnly bool // Only days, slots and locations
+	Passwords     bool // Include users' password hashes
+}
+
+func exportTimetableTx(eq sqlx.Ext, ex *Export) error {
+	var days []struct {
+		DayID   DayID
+		DayName string
+	}
+	err := sqlx.Select(eq, &days, `select dayid, dayname from event_days order by dayid`)
+	if err != nil {
+		return errOrRetry("Getting days", err)
+	}
+	ex.Days = make([]ExportDay, len(days))
+	for i := range days {
+		ex.Days[i].DayName = days[i].DayName
+		err = sqlx.Select(eq, &ex.Days[i].Slots, `
+            select slotid, slottime as time, isbreak, islocked
+                from event_slots
+                where dayid = ?
+                order by slotidx`, days[i].DayID)
+		if err != nil {
+			return errOrRetry("Getting slots", err)
+		}
+	}
+
+	err = sqlx.Select(eq, &ex.Locations, `select * from event_locations order by locationid`)
+	if err != nil {
+		return errOrRetry("Getting locations", err)
+	}
+	return nil
+}
+
+func exportUsersTx(eq sqlx.Ext, ex *Export, passwords bool) error {
+	var users []User
+	err := sqlx.Select(eq, &users, `
+        select * from event_users where deleted = 0 order by username`)
+	if err != nil {
+		return errOrRetry("Getting users", err)
+	}
+
+	var verified []UserID
+	err = sqlx.Select(eq, &verified, `
+        select userid from event_email_verified
+            join event_users using(userid)
+            where event_email_verified.email = event_users.email`)
+	if err != nil {
+		return errOrRetry("Getting verified emails", err)
+	}
+	isVerified := map[UserID]bool{}
+	for _, uid := range verified {
+		isVerified[uid] = true
+	}
+
+	ex.Users = make([]ExportUser, len(users))
+	for i := range users {
+		u := &users[i]
+		eu := ExportUser{
+			UserID:        u.UserID,
+			Username:      u.Username,
+			IsAdmin:       u.IsAdmin,
+			IsVerified:    u.IsVerified,
+			Role:          u.Role,
+			Location:      u.Location.String(),
+			RealName:      u.RealName,
+			Email:         u.Email,
+			EmailVerified: isVerified[u.UserID],
+			Company:       u.Company,
+			Description:   u.Description,
+		}
+		if passwords {
+			eu.HashedPassword = u.HashedPassword
+		}
+		ex.Users[i] = eu
+	}
+	return nil
+}
+
+func exportDiscussionsTx(eq sqlx.Ext, ex *Export) error {
+	var discussions []Discussion
+	err := sqlx.Select(eq, &discussions, `
+        select * from event_discussions where deleted = 0 order by title`)
+	if err != nil {
+		return errOrRetry("Getting discussions", err)
+	}
+
+	ex.Discussions = make([]ExportDiscussion, len(discussions))
+	for i := range discussions {
+		d := &discussions[i]
+		ed := &ex.Discussions[i]
+		*ed = ExportDiscussion{
+			DiscussionID:        d.DiscussionID,
+			Owner:               d.Owner,
+			Title:               d.Title,
+			Description:         d.Description,
+			ApprovedTitle:       d.ApprovedTitle,
+			ApprovedDescription: d.ApprovedDescription,
+			IsPublic:            d.IsPublic,
+			Length:              d.Length,
+			ApprovedLength:      d.ApprovedLength,
+		}
+
+		err = sqlx.Select(eq, &ed.Facilitators, `
+            select userid from event_discussions_facilitators
+                    join event_users using(userid)
+                where discussionid = ? and deleted = 0
+                order by userid`, d.DiscussionID)
+		if err != nil {
+			return errOrRetry("Getting facilitators", err)
+		}
+
+		err = sqlx.Select(eq, &ed.PossibleSlots, `
+            select slotid from event_discussions_possible_slots
+                    natural join event_slots
+                where discussionid = ?
+                order by dayid, slotidx`, d.DiscussionID)
+		if err != nil {
+			return errOrRetry("Getting possible slots", err)
+		}
+
+		if ed.Pin, err = pinGetTx(eq, d.DiscussionID); err != nil {
+			return err
+		}
+
+		var interest []struct {
+			UserID   UserID
+			Interest int
+		}
+		err = sqlx.Select(eq, &interest, `
+            select userid, interest from event_interest
+                    join event_users using(userid)
+                where discussionid = ? and deleted = 0`, d.DiscussionID)
+		if err != nil {
+			return errOrRetry("Getting interest", err)
+		}
+		if len(interest) > 0 {
+			ed.Interest = make(map[UserID]int, len(interest))
+			for _, in := range interest {
+				ed.Interest[in.UserID] = in.Interest
+			}
+		}
+	}
+
+	err = sqlx.Select(eq, &ex.Schedule, `
+        select discussionid, slotid, locationid
+            from event_schedule
+                natural join event_slots
+            order by dayid, slotidx, locationid`)
+	if err != nil {
+		return errOrRetry("Getting schedule", err)
+	}
+	return nil
+}
+
+// ExportEvent returns a copy of the event, for ImportEvent.
+func (store *EventStore) ExportEvent(opt ExportOptions) (*Export, error) {
+	var ex *Export
+	err := store.txLoop(func(eq sqlx.Ext) error {
+		ex = &Export{FormatVersion: ExportFormatVersion}
+		if err := exportTimetableTx(eq, ex); err != nil {
+			return err
+		}
+		if opt.TimetableOnly {
+			return nil
+		}
+		if err := exportUsersTx(eq, ex, opt.Passwords); err != nil {
+			return err
+		}
+		return exportDiscussionsTx(eq, ex)
+	})
+	return ex, err
+}
+
+// ImportOptions says what to take from an export.
+type ImportOptions struct {
+	TimetableOnly bool // Only days, slots and locations
+}
+
+// ImportResult counts what was imported.
+type ImportResult struct {
+	Days, Slots, Locations       int
+	Users, Discussions, Schedule int
+}
+
+// eventIsEmptyTx checks that there's nothing in the event which an
+// import could clash with.  Only the timetable and locations need be
+// empty for a timetable-only import; otherwise there mustn't be any
+// users other than the admin account, nor any discussions (even in the
+// trash).
+func eventIsEmptyTx(eq sqlx.Ext, timetableOnly bool) error {
+	queries := []string{
+		`select count(*) from event_days`,
+		`select count(*) from event_locations`,
+	}
+	if !timetableOnly {
+		queries = append(queries,
+			`select count(*) from event_users where username != '`+AdminUsername+`'`,
+			`select count(*) from event_discussions`)
+	}
+	for _, q := range queries {
+		var count int
+		if err := sqlx.Get(eq, &count, q); err != nil {
+			return errOrRetry("Checking event is empty", err)
+		}
+		if count > 0 {
+			return errImportNotEmpty
+		}
+	}
+	return nil
+}
+
+// importTimetableTx adds the exported days, slots and locations.
+// Without a schedule, locked slots would only get in the way, so
+// they're only kept if unlock isn't set.
+func importTimetableTx(eq sqlx.Ext, ex *Export, unlock bool, res *ImportResult) error {
+	for i := range ex.Days {
+		ed := &ex.Days[i]
+		d := Day{DayID: DayID(i + 1), DayName: ed.DayName}
+		if err := checkDayParams(&d); err != nil {
+			return err
+		}
+		if err := dayAddTx(eq, &d); err != nil {
+			return err
+		}
+		for j := range ed.Slots {
+			es := &ed.Slots[j]
+			_, err := eq.Exec(`
+                insert into event_slots(slotid, slotidx, dayid, slottime, isbreak, islocked)
+                    values(?, ?, ?, ?, ?, ?)`,
+				es.SlotID, j+1, d.DayID, es.Time, es.IsBreak, es.IsLocked && !unlock)
+			if err != nil {
+				return errOrRetry(fmt.Sprintf("Importing slot %s", es.SlotID), err)
+			}
+			res.Slots++
+		}
+		res.Days++
+	}
+
+	for i := range ex.Locations {
+		l := &ex.Locations[i]
+		if l.LocationID != LocationID(i+1) {
+			return fmt.Errorf("Location %s has ID %d; expected %d", l.LocationName, l.LocationID, i+1)
+		}
+		if err := checkLocationParams(l); err != nil {
+			return err
+		}
+		_, err := eq.Exec(`
+            insert into event_locations(locationid, locationname, locationurl, isplace, capacity)
+                values (?, ?, ?, ?, ?)`,
+			l.LocationID, l.LocationName, l.LocationURL, l.IsPlace, l.Capacity)
+		if err != nil {
+			return errOrRetry("Importing location", err)
+		}
+		res.Locations++
+	}
+	return nil
+}
+
+// importUsersTx adds the exported users.  The admin account already
+// exists, and keeps its password; anything referring to the exported
+// admin account is changed to refer to it, using userMap.
+func importUsersTx(eq sqlx.Ext, ex *Export, userMap map[UserID]UserID, res *ImportResult) error {
+	for i := range ex.Users {
+		eu := &ex.Users[i]
+		if eu.Username == AdminUsername {
+			var adminID UserID
+			err := sqlx.Get(eq, &adminID, `
+                select userid from event_users where username = ?`, AdminUsername)
+			if err != nil {
+				return errOrRetry("Getting admin account", err)
+			}
+			userMap[eu.UserID] = adminID
+			continue
+		}
+
+		if eu.Username == "" || AllWhitespace(eu.Username) {
+			return errNoUsername
+		}
+		if !eu.Role.Valid() {
+			return fmt.Errorf("User %s has invalid role %q", eu.Username, eu.Role)
+		}
+		loc, err := LoadLocation(eu.Location)
+		if err != nil {
+			return fmt.Errorf("User %s: %v", eu.Username, err)
+		}
+
+		_, err = eq.Exec(`
+            insert into event_users(
+                userid,
+                hashedpassword,
+                username,
+                isadmin, isverified,
+                realname, email, company, description,
+                location, role) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			eu.UserID,
+			eu.HashedPassword,
+			eu.Username,
+			eu.IsAdmin, eu.IsVerified,
+			eu.RealName, eu.Email, eu.Company, eu.Description,
+			loc, eu.Role)
+		if err != nil {
+			return errOrRetry(fmt.Sprintf("Importing user %s", eu.Username), err)
+		}
+
+		if eu.EmailVerified && eu.Email != "" {
+			_, err = eq.Exec(`
+                insert into event_email_verified(userid, email) values(?, ?)`,
+				eu.UserID, eu.Email)
+			if err != nil {
+				return errOrRetry("Importing verified email", err)
+			}
+		}
+
+		userMap[eu.UserID] = eu.UserID
+		res.Users++
+	}
+	return nil
+}
+
+func importDiscussionsTx(eq sqlx.Ext, ex *Export, userMap map[UserID]UserID, res *ImportResult) error {
+	mapUser := func(uid UserID) (UserID, error) {
+		if newid, ok := userMap[uid]; ok {
+			return newid, nil
+		}
+		return "", fmt.Errorf("Unknown user %s", uid)
+	}
+
+	for i := range ex.Discussions {
+		ed := &ex.Discussions[i]
+		owner, err := mapUser(ed.Owner)
+		if err != nil {
+			return fmt.Errorf("Discussion %s: %v", ed.Title, err)
+		}
+		if ed.Title == "" {
+			return errNoTitle
+		}
+		length, approvedLength := ed.Length, ed.ApprovedLength
+		if length == 0 {
+			length = 1
+		}
+		if approvedLength == 0 {
+			approvedLength = 1
+		}
+		if length < 1 || length > MaxDiscussionLength ||
+			approvedLength < 1 || approvedLength > MaxDiscussionLength {
+			return fmt.Errorf("Discussion %s: %v", ed.Title, errDiscussionLength)
+		}
+
+		_, err = eq.Exec(
+			`insert into event_discussions(
+                discussionid, owner, title, description,
+                approvedtitle, approveddescription,
+                ispublic, length, approvedlength)
+                values (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			ed.DiscussionID, owner, ed.Title, ed.Description,
+			ed.ApprovedTitle, ed.ApprovedDescription,
+			ed.IsPublic, length, approvedLength)
+		if err != nil {
+			return errOrRetry(fmt.Sprintf("Importing discussion %s", ed.Title), err)
+		}
+
+		for _, uid := range ed.Facilitators {
+			if uid, err = mapUser(uid); err != nil {
+				return fmt.Errorf("Discussion %s: %v", ed.Title, err)
+			}
+			_, err = eq.Exec(`
+                insert into event_discussions_facilitators(discussionid, userid)
+                    values(?, ?)`, ed.DiscussionID, uid)
+			if err != nil {
+				return errOrRetry("Importing co-facilitator", err)
+			}
+		}
+
+		for _, slotid := range ed.PossibleSlots {
+			_, err = eq.Exec(`
+                insert into event_discussions_possible_slots(discussionid, slotid)
+                    values(?, ?)`, ed.DiscussionID, slotid)
+			if err != nil {
+				return errOrRetry(fmt.Sprintf("Importing possible slot %s", slotid), err)
+			}
+		}
+
+		if ed.Pin != nil {
+			_, err = eq.Exec(`
+                insert into event_pins(discussionid, slotid, locationid)
+                    values(?, ?, nullif(?, 0))`,
+				ed.DiscussionID, ed.Pin.SlotID, ed.Pin.LocationID)
+			if err != nil {
+				return errOrRetry("Importing pin", err)
+			}
+		}
+
+		for uid, interest := range ed.Interest {
+			if uid, err = mapUser(uid); err != nil {
+				return fmt.Errorf("Discussion %s: %v", ed.Title, err)
+			}
+			if interest > InterestMax || interest < 0 {
+				return errInvalidInterest
+			}
+			if err = setInterestTx(eq, uid, ed.DiscussionID, interest); err != nil {
+				return errOrRetry("Importing interest", err)
+			}
+		}
+
+		res.Discussions++
+	}
+
+	for _, es := range ex.Schedule {
+		_, err := eq.Exec(`
+            insert into event_schedule(discussionid, slotid, locationid)
+                values(?, ?, ?)`, es.DiscussionID, es.SlotID, es.LocationID)
+		if err != nil {
+			return errOrRetry("Importing schedule", err)
+		}
+		res.Schedule++
+	}
+	return nil
+}
+
+// ImportEvent adds everything in ex to the event, which must be empty
+// (see eventIsEmptyTx).  Either all of it is imported, or none of it.
+// actor is the user doing the import.
+func (store *EventStore) ImportEvent(actor UserID, ex *Export, opt ImportOptions) (*ImportResult, error) {
+	if ex.FormatVersion < 1 || ex.FormatVersion > ExportFormatVersion {
+		return nil, fmt.Errorf("Can't import format version %d (this program supports up to %d)",
+			ex.FormatVersion, ExportFormatVersion)
+	}
+
+	var res *ImportResult
+	err := store.txLoop(func(eq sqlx.Ext) error {
+		res = &ImportResult{}
+
+		if err := eventIsEmptyTx(eq, opt.TimetableOnly); err != nil {
+			return err
+		}
+
+		if err := importTimetableTx(eq, ex, opt.TimetableOnly, res); err != nil {
+			return err
+		}
+
+		if !opt.TimetableOnly {
+			userMap := map[UserID]UserID{}
+			if err := importUsersTx(eq, ex, userMap, res); err != nil {
+				return err
+			}
+			if err := importDiscussionsTx(eq, ex, userMap, res); err != nil {
+				return err
+			}
+		}
+
+		err := auditTx(eq, actor, AuditEventImport, "", nil, res)
+		if err != nil {
+			return err
+		}
+
+		return schedMarkModifiedTx(eq)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Imported %d days, %d slots, %d locations, %d users, %d discussions",
+		res.Days, res.Slots, res.Locations, res.Users, res.Discussions)
+	return res, nil
+}
@@ -0,0 +1,260 @@
+package event
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testUnitExport(t *testing.T) (exit bool) {
+	// Any "early" exit is a failure
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+
+	//
+	// SETUP: Users, discussions, a timetable, locations and a schedule
+	//
+	m := &mirrorData{}
+	if testNewUsers(t, m, 4) {
+		return
+	}
+
+	var discussions []Discussion
+	for i := range m.users {
+		disc, subexit := testNewDiscussion(t, m.users[i].UserID)
+		if subexit {
+			return
+		}
+		discussions = append(discussions, disc)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, subexit := testNewLocation(t); subexit {
+			return
+		}
+	}
+
+	tt := Timetable{
+		Days: []TimetableDay{
+			{DayName: "Monday", Slots: []TimetableSlot{
+				{Time: Date(2020, 7, 6, 14, 30, 0, 0, time.UTC)},
+				{Time: Date(2020, 7, 6, 15, 15, 0, 0, time.UTC), IsBreak: true},
+				{Time: Date(2020, 7, 6, 16, 00, 0, 0, time.UTC)},
+			}},
+			{DayName: "Tuesday", Slots: []TimetableSlot{
+				{Time: Date(2020, 7, 7, 14, 30, 0, 0, time.UTC)},
+				{Time: Date(2020, 7, 7, 15, 15, 0, 0, time.UTC)},
+			}},
+		},
+	}
+	if err := event.TimetableSet("", &tt, ImpactAbort); err != nil {
+		t.Errorf("TimetableSet: %v", err)
+		return
+	}
+
+	if err := event.DiscussionSetFacilitators("", discussions[0].DiscussionID,
+		[]UserID{m.users[1].UserID}); err != nil {
+		t.Errorf("DiscussionSetFacilitators: %v", err)
+		return
+	}
+	gotdisc, err := event.DiscussionFindByIdFull(discussions[1].DiscussionID)
+	if err != nil {
+		t.Errorf("Finding discussion: %v", err)
+		return
+	}
+	if err := event.DiscussionSetPossibleSlots("", discussions[1].DiscussionID,
+		CheckedToSlotList(gotdisc.PossibleSlots)[2:]); err != nil {
+		t.Errorf("DiscussionSetPossibleSlots: %v", err)
+		return
+	}
+	if err := event.UserSetInterest(&m.users[2], &discussions[0], 42); err != nil {
+		t.Errorf("SetInterest: %v", err)
+		return
+	}
+	for i := range discussions {
+		if err := event.DiscussionSetPublic("", discussions[i].DiscussionID, true); err != nil {
+			t.Errorf("DiscussionSetPublic: %v", err)
+			return
+		}
+	}
+
+	if err := event.MakeSchedule(SearchOptions{}); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+	locked := event.TimetableGetLockedSlots()
+	if err := event.TimetableSetLockedSlots("", []SlotID{locked[0].SlotID}); err != nil {
+		t.Errorf("TimetableSetLockedSlots: %v", err)
+		return
+	}
+
+	// Things in the trash aren't exported; including users in the
+	// trash who are co-facilitating discussions which aren't
+	if err := event.DiscussionSetFacilitators("", discussions[1].DiscussionID,
+		[]UserID{m.users[3].UserID}); err != nil {
+		t.Errorf("DiscussionSetFacilitators: %v", err)
+		return
+	}
+	if err := event.DeleteUser("", m.users[3].UserID, ""); err != nil {
+		t.Errorf("DeleteUser: %v", err)
+		return
+	}
+
+	//
+	// TESTING
+	//
+	t.Logf("Exporting")
+	ex, err := event.ExportEvent(ExportOptions{Passwords: true})
+	if err != nil {
+		t.Errorf("ExportEvent: %v", err)
+		return
+	}
+	// Three users plus admin
+	if len(ex.Days) != 2 || len(ex.Days[0].Slots) != 3 || len(ex.Locations) != 2 ||
+		len(ex.Users) != 4 || len(ex.Discussions) != 3 || len(ex.Schedule) == 0 {
+		t.Errorf("Unexpected export: %d days, %d locations, %d users, %d discussions, %d scheduled",
+			len(ex.Days), len(ex.Locations), len(ex.Users), len(ex.Discussions), len(ex.Schedule))
+		return
+	}
+
+	for _, ed := range ex.Discussions {
+		for _, uid := range ed.Facilitators {
+			if uid == m.users[3].UserID {
+				t.Errorf("Deleted user exported as a facilitator of %s", ed.DiscussionID)
+				return
+			}
+		}
+	}
+
+	exb, err := json.Marshal(ex)
+	if err != nil {
+		t.Errorf("Marshalling export: %v", err)
+		return
+	}
+
+	tc.cleanup()
+
+	// Normalize what's expected to differ between servers: the admin
+	// account's ID and password.
+	normalize := func(ex *Export) (string, bool) {
+		var adminID UserID
+		for i := range ex.Users {
+			if ex.Users[i].Username == AdminUsername {
+				adminID = ex.Users[i].UserID
+				ex.Users[i].HashedPassword = ""
+			}
+		}
+		b, err := json.Marshal(ex)
+		if err != nil {
+			t.Errorf("Marshalling export: %v", err)
+			return "", true
+		}
+		return strings.ReplaceAll(string(b), string(adminID), "admin"), false
+	}
+
+	t.Logf("Importing everything")
+	tc = dataInit(t)
+	if tc == nil {
+		return
+	}
+
+	var imp Export
+	if err := json.Unmarshal(exb, &imp); err != nil {
+		t.Errorf("Unmarshalling export: %v", err)
+		return
+	}
+	res, err := event.ImportEvent("", &imp, ImportOptions{})
+	if err != nil {
+		t.Errorf("ImportEvent: %v", err)
+		return
+	}
+	if res.Users != 3 || res.Discussions != 3 || res.Slots != 5 {
+		t.Errorf("Unexpected import result %v", res)
+		return
+	}
+
+	ex2, err := event.ExportEvent(ExportOptions{Passwords: true})
+	if err != nil {
+		t.Errorf("ExportEvent after import: %v", err)
+		return
+	}
+	want, subexit := normalize(ex)
+	if subexit {
+		return
+	}
+	got, subexit := normalize(ex2)
+	if subexit {
+		return
+	}
+	if got != want {
+		t.Errorf("Re-exported event differs:\n want %s\n got  %s", want, got)
+		return
+	}
+
+	// Users can still log in
+	if u, err := event.UserFindByUsername(m.users[0].Username); err != nil || u == nil ||
+		!u.CheckPassword(TestPassword) {
+		t.Errorf("Imported user can't log in: %v, err %v", u, err)
+		return
+	}
+
+	if _, err := event.ImportEvent("", &imp, ImportOptions{}); err != errImportNotEmpty {
+		t.Errorf("Importing twice: expected errImportNotEmpty, got %v", err)
+		return
+	}
+
+	tc.cleanup()
+
+	t.Logf("Importing the timetable only")
+	tc = dataInit(t)
+	if tc == nil {
+		return
+	}
+
+	// Users don't get in the way
+	if testNewUsers(t, m, 2) {
+		return
+	}
+
+	if _, err := event.ImportEvent("", &Export{FormatVersion: ExportFormatVersion + 1},
+		ImportOptions{TimetableOnly: true}); err == nil {
+		t.Errorf("Importing newer format succeeded")
+		return
+	}
+
+	res, err = event.ImportEvent("", ex, ImportOptions{TimetableOnly: true})
+	if err != nil {
+		t.Errorf("ImportEvent timetable only: %v", err)
+		return
+	}
+	if res.Days != 2 || res.Locations != 2 || res.Users != 0 || res.Discussions != 0 {
+		t.Errorf("Unexpected timetable import result %v", res)
+		return
+	}
+	ex3, err := event.ExportEvent(ExportOptions{TimetableOnly: true})
+	if err != nil {
+		t.Errorf("ExportEvent timetable only: %v", err)
+		return
+	}
+	if len(ex3.Days) != 2 || len(ex3.Locations) != 2 || ex3.Users != nil {
+		t.Errorf("Unexpected timetable export %v", ex3)
+		return
+	}
+	for _, d := range ex3.Days {
+		for _, s := range d.Slots {
+			if s.IsLocked {
+				t.Errorf("Slot %s still locked after timetable import", s.SlotID)
+				return
+			}
+		}
+	}
+
+	tc.cleanup()
+
+	return false
+}
@@ -0,0 +1,249 @@
+package event
+
+import (
+	"testing"
+	"time"
+)
+
+func testUnitFacilitators(t *testing.T) (exit bool) {
+	// Any "early" exit is a failure
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+
+	m := &mirrorData{}
+	if testNewUsers(t, m, 4) {
+		return
+	}
+	owner, cofac, other := &m.users[0], &m.users[1], &m.users[2]
+
+	disc, subexit := testNewDiscussion(t, owner.UserID)
+	if subexit {
+		return
+	}
+
+	t.Logf("Setting co-facilitators")
+	// The owner and duplicates should be silently ignored
+	err := event.DiscussionSetFacilitators("", disc.DiscussionID,
+		[]UserID{cofac.UserID, owner.UserID, cofac.UserID})
+	if err != nil {
+		t.Errorf("DiscussionSetFacilitators: %v", err)
+		return
+	}
+
+	df, err := event.DiscussionFindByIdFull(disc.DiscussionID)
+	if err != nil {
+		t.Errorf("DiscussionFindByIdFull: %v", err)
+		return
+	}
+	if len(df.Facilitators) != 1 || df.Facilitators[0] != cofac.UserID ||
+		len(df.FacilitatorInfo) != 1 || df.FacilitatorInfo[0].Username != cofac.Username {
+		t.Errorf("Unexpected co-facilitators %v (info %v)", df.Facilitators, df.FacilitatorInfo)
+		return
+	}
+
+	if !cofac.MayEditDiscussion(&df.Discussion) || !owner.MayEditDiscussion(&df.Discussion) {
+		t.Errorf("Facilitators unexpectedly can't edit discussion")
+		return
+	}
+	if other.MayEditDiscussion(&df.Discussion) {
+		t.Errorf("Non-facilitator unexpectedly can edit discussion")
+		return
+	}
+
+	// New co-facilitators should be assumed to want to attend
+	interest, err := event.UserGetInterest(cofac, &disc)
+	if err != nil || interest != InterestMax {
+		t.Errorf("Co-facilitator interest: wanted %d, got %d (err %v)",
+			InterestMax, interest, err)
+		return
+	}
+
+	// ...but shouldn't have their interest reset if the list is re-set
+	if err = event.UserSetInterest(cofac, &disc, 50); err != nil {
+		t.Errorf("Setting co-facilitator interest: %v", err)
+		return
+	}
+	err = event.DiscussionSetFacilitators("", disc.DiscussionID, []UserID{cofac.UserID, other.UserID})
+	if err != nil {
+		t.Errorf("DiscussionSetFacilitators: %v", err)
+		return
+	}
+	if interest, _ = event.UserGetInterest(cofac, &disc); interest != 50 {
+		t.Errorf("Co-facilitator interest reset to %d", interest)
+		return
+	}
+
+	// Co-facilitated discussions show up in the user's list
+	found := false
+	err = event.DiscussionIterateUser(other.UserID, func(d *DiscussionFull) error {
+		if d.DiscussionID == disc.DiscussionID {
+			found = true
+		}
+		return nil
+	})
+	if err != nil || !found {
+		t.Errorf("DiscussionIterateUser didn't find co-facilitated discussion (err %v)", err)
+		return
+	}
+
+	t.Logf("Testing invalid values")
+	err = event.DiscussionSetFacilitators("", disc.DiscussionID, []UserID{"bogus"})
+	if err != ErrUserNotFound {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+		return
+	}
+	err = event.DiscussionSetFacilitators("", "bogus", []UserID{cofac.UserID})
+	if err != ErrDiscussionNotFound {
+		t.Errorf("Expected ErrDiscussionNotFound, got %v", err)
+		return
+	}
+
+	// The failed call shouldn't have changed anything
+	if df, _ = event.DiscussionFindByIdFull(disc.DiscussionID); len(df.Facilitators) != 2 {
+		t.Errorf("Failed DiscussionSetFacilitators changed co-facilitators to %v",
+			df.Facilitators)
+		return
+	}
+
+	t.Logf("Changing owner to a co-facilitator")
+	df.Owner = other.UserID
+	if err = event.DiscussionUpdate("", &df.Discussion); err != nil {
+		t.Errorf("DiscussionUpdate: %v", err)
+		return
+	}
+	if df, _ = event.DiscussionFindByIdFull(disc.DiscussionID); len(df.Facilitators) != 1 ||
+		df.Facilitators[0] != cofac.UserID {
+		t.Errorf("New owner still a co-facilitator: %v", df.Facilitators)
+		return
+	}
+
+	t.Logf("Creating a discussion with co-facilitators")
+	withFacs := Discussion{Owner: m.users[3].UserID, Title: "Co-facilitated",
+		Description: "From the start", Facilitators: []UserID{cofac.UserID, "bogus"}}
+	if err = event.NewDiscussion(&withFacs); err != ErrUserNotFound {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+		return
+	}
+	// The failed call shouldn't have created the discussion, so the
+	// title is still free
+	withFacs.Facilitators = []UserID{cofac.UserID, m.users[3].UserID}
+	if err = event.NewDiscussion(&withFacs); err != nil {
+		t.Errorf("NewDiscussion: %v", err)
+		return
+	}
+	if df, err := event.DiscussionFindByIdFull(withFacs.DiscussionID); err != nil || df == nil ||
+		len(df.Facilitators) != 1 || df.Facilitators[0] != cofac.UserID {
+		t.Errorf("Unexpected co-facilitators of new discussion: %v (err %v)", df, err)
+		return
+	}
+	if interest, _ = event.UserGetInterest(cofac, &withFacs); interest != InterestMax {
+		t.Errorf("Co-facilitator of new discussion has interest %d", interest)
+		return
+	}
+
+	t.Logf("Deleting co-facilitator")
+	if err = event.DeleteUser("", cofac.UserID, ""); err != nil {
+		t.Errorf("Deleting co-facilitator: %v", err)
+		return
+	}
+	if df, _ = event.DiscussionFindByIdFull(disc.DiscussionID); len(df.Facilitators) != 0 {
+		t.Errorf("Deleted user still a co-facilitator: %v", df.Facilitators)
+		return
+	}
+
+	if err = event.DeleteDiscussion("", disc.DiscussionID); err != nil {
+		t.Errorf("Deleting discussion with co-facilitators: %v", err)
+		return
+	}
+
+	tc.cleanup()
+
+	return false
+}
+
+func testScheduleFacilitatorConflict(t *testing.T) (exit bool) {
+	// Any "early" exit is a failure
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+
+	m := &mirrorData{}
+
+	t.Logf("Setting up users, discussion, timetable")
+	if testScheduleSearchSetup(t, m, 4, 5) {
+		return
+	}
+
+	// Have one user co-facilitate more discussions than there are
+	// slots, each owned by somebody else
+	busy := m.users[0].UserID
+	for i := 0; i < 8; i++ {
+		disc, subexit := testNewDiscussion(t, m.users[1+i%(len(m.users)-1)].UserID)
+		if subexit {
+			return
+		}
+		if err := event.DiscussionSetFacilitators("", disc.DiscussionID, []UserID{busy}); err != nil {
+			t.Errorf("Setting co-facilitators: %v", err)
+			return
+		}
+		if err := event.DiscussionSetPublic("", disc.DiscussionID, true); err != nil {
+			t.Errorf("Setting discussion public: %v", err)
+			return
+		}
+	}
+
+	ss, err := event.makeSnapshot()
+	if err != nil {
+		t.Errorf("Getting snapshot: %v", err)
+		return
+	}
+
+	check := func(desc string, sched *schedule) bool {
+		if err := scheduleValidate(ss, sched); err != nil {
+			t.Errorf("%s: Invalid schedule: %v", desc, err)
+			return true
+		}
+		for i := range sched.Slots {
+			facilitators := map[UserID]bool{}
+			for _, disc := range sched.Slots[i].Discussions {
+				for _, uid := range disc.Facilitators {
+					if facilitators[uid] {
+						t.Errorf("%s: Slot %d has two discussions facilitated by %v",
+							desc, i, uid)
+						return true
+					}
+					facilitators[uid] = true
+				}
+			}
+		}
+		return false
+	}
+
+	heuristic, err := makeScheduleHeuristic(ss)
+	if err != nil {
+		t.Errorf("Making heuristic schedule: %v", err)
+		return
+	}
+	if check("heuristic", heuristic) {
+		return
+	}
+
+	opt := SearchOptions{SearchDuration: 100 * time.Millisecond}
+	if check("random", makeScheduleRandom(ss, heuristic.clone(), &opt)) {
+		return
+	}
+	if check("genetic", makeScheduleGenetic(ss, heuristic.clone(), &opt)) {
+		return
+	}
+
+	tc.cleanup()
+
+	return false
+}
@@ -69,7 +69,7 @@ func testScheduleHeuristic(t *testing.T) (exit bool) {
 	}
 	//totalSlots := 6
 
-	err := TimetableSet(&tt)
+	err := event.TimetableSet("", &tt, ImpactAbort)
 	if err != nil {
 		t.Errorf("ERROR Basic TimetableSet: %v", err)
 		return
@@ -89,7 +89,7 @@ func testScheduleHeuristic(t *testing.T) (exit bool) {
 		didx := rand.Int31n(int32(len(discussions)))
 		did := discussions[didx].DiscussionID
 		interest := int(rand.Int31n(101))
-		err = users[uidx].SetInterest(&discussions[didx], interest)
+		err = event.UserSetInterest(&users[uidx], &discussions[didx], interest)
 		if err != nil {
 			t.Errorf("Setting interest: %v", err)
 			return
@@ -104,17 +104,17 @@ func testScheduleHeuristic(t *testing.T) (exit bool) {
 	// Restrict discussion 0 to Monday
 	{
 		t.Logf("Restricting Discussion[0] (did %v) to Tuesday", discussions[0].DiscussionID)
-		gotdisc, err := DiscussionFindByIdFull(discussions[0].DiscussionID)
+		gotdisc, err := event.DiscussionFindByIdFull(discussions[0].DiscussionID)
 		if err != nil {
 			t.Errorf("Finding discussion 0 by id: %v", err)
 			return
 		}
-		err = DiscussionSetPossibleSlots(discussions[0].DiscussionID, CheckedToSlotList(gotdisc.PossibleSlots)[3:])
+		err = event.DiscussionSetPossibleSlots("", discussions[0].DiscussionID, CheckedToSlotList(gotdisc.PossibleSlots)[3:])
 	}
 
 	// Make all discussions public
 	for i := range discussions {
-		err = DiscussionSetPublic(discussions[i].DiscussionID, true)
+		err = event.DiscussionSetPublic("", discussions[i].DiscussionID, true)
 		if err != nil {
 			t.Errorf("Setting discussion public: %v", err)
 			return
@@ -125,7 +125,7 @@ func testScheduleHeuristic(t *testing.T) (exit bool) {
 	// TESTING
 	//
 
-	err = MakeSchedule(SearchOptions{})
+	err = event.MakeSchedule(SearchOptions{})
 	if err != nil {
 		t.Errorf("Making Schedule: %v", err)
 		return
@@ -0,0 +1,145 @@
+package event
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ImpactAction says what a change to the timetable or locations
+// should do if it would affect discussions which have been scheduled:
+// for instance, deleting the slot or location they're scheduled in.
+type ImpactAction int
+
+const (
+	// ImpactAbort makes the change fail with a *ScheduleImpactError
+	// if anything scheduled would be affected
+	ImpactAbort = ImpactAction(iota)
+	// ImpactUnschedule makes the change go ahead, unscheduling the
+	// affected discussions.  Discussions in locked slots are never
+	// unscheduled; the change fails instead.
+	ImpactUnschedule
+)
+
+// ScheduleImpact is a discussion which would be unscheduled by a
+// change.  For discussions longer than one slot, SlotID and SlotTime
+// are for the first slot.
+type ScheduleImpact struct {
+	DiscussionID DiscussionID
+	Title        string
+	SlotID       SlotID
+	SlotTime     Time
+	TimeDisplay  string
+	IsLocked     bool
+	LocationID   LocationID
+	LocationName string
+}
+
+// ScheduleImpactError is returned (with ImpactAbort) by changes which
+// would unschedule discussions.  Nothing has been changed.
+type ScheduleImpactError struct {
+	Impact []ScheduleImpact
+}
+
+func (e *ScheduleImpactError) Error() string {
+	return fmt.Sprintf("This would unschedule %d sessions", len(e.Impact))
+}
+
+// GetScheduleImpact returns the discussions a change would have
+// unscheduled, if err is a *ScheduleImpactError, and nil otherwise.
+func GetScheduleImpact(err error) []ScheduleImpact {
+	if ie, ok := err.(*ScheduleImpactError); ok {
+		return ie.Impact
+	}
+	return nil
+}
+
+type scheduleEntry struct {
+	DiscussionID DiscussionID
+	SlotID       SlotID
+	LocationID   LocationID
+}
+
+// scheduleImpactTx makes a change to the timetable or locations with
+// change, and then looks for schedule entries which it's removed.
+// change should delete any schedule entries it makes invalid; a
+// discussion longer than one slot which is left with only some of its
+// slots, or whose slots no longer follow on from each other, is then
+// unscheduled altogether.
+//
+// If any schedule entries were removed, then for ImpactAbort, a
+// *ScheduleImpactError listing them is returned, which rolls back the
+// transaction.  For ImpactUnschedule, the change stands (and the
+// schedule is marked modified), unless any of the removed schedule
+// entries were in locked slots, in which case errScheduleLocked is
+// returned.
+func scheduleImpactTx(eq sqlx.Ext, action ImpactAction, change func() error) error {
+	var before []ScheduleImpact
+	err := sqlx.Select(eq, &before, `
+        select discussionid, title, slotid, slottime, islocked, locationid, locationname
+            from event_schedule
+                join event_discussions using(discussionid)
+                join event_slots using(slotid)
+                join event_locations using(locationid)
+            order by slottime, locationid`)
+	if err != nil {
+		return errOrRetry("Getting schedule", err)
+	}
+
+	if err := change(); err != nil {
+		return err
+	}
+
+	_, err = eq.Exec(`
+        delete from event_schedule
+            where discussionid in
+                (select discussionid
+                     from event_schedule
+                         join event_slots using(slotid)
+                         join event_discussions using(discussionid)
+                     group by discussionid
+                     having count(*) != approvedlength
+                         or count(distinct locationid) != 1
+                         or count(distinct dayid) != 1
+                         or max(isbreak)
+                         or max(slotidx) - min(slotidx) != count(*) - 1)`)
+	if err != nil {
+		return errOrRetry("Unscheduling broken-up discussions", err)
+	}
+
+	var after []scheduleEntry
+	err = sqlx.Select(eq, &after,
+		`select discussionid, slotid, locationid from event_schedule`)
+	if err != nil {
+		return errOrRetry("Getting schedule", err)
+	}
+	remaining := map[scheduleEntry]bool{}
+	for _, e := range after {
+		remaining[e] = true
+	}
+
+	var impact []ScheduleImpact
+	locked := false
+	listed := map[DiscussionID]bool{}
+	for _, si := range before {
+		if remaining[scheduleEntry{si.DiscussionID, si.SlotID, si.LocationID}] {
+			continue
+		}
+		locked = locked || si.IsLocked
+		if !listed[si.DiscussionID] {
+			listed[si.DiscussionID] = true
+			impact = append(impact, si)
+		}
+	}
+	if len(impact) == 0 {
+		return nil
+	}
+
+	switch {
+	case action != ImpactUnschedule:
+		return &ScheduleImpactError{Impact: impact}
+	case locked:
+		return errScheduleLocked
+	}
+	return schedMarkModifiedTx(eq)
+}
@@ -0,0 +1,202 @@
+package event
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func testUnitScheduleImpact(t *testing.T) (exit bool) {
+	// Any "early" exit is a failure
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+
+	m := &mirrorData{}
+	if testNewUsers(t, m, 3) {
+		return
+	}
+	for i := range m.users {
+		disc, subexit := testNewDiscussion(t, m.users[i].UserID)
+		if subexit {
+			return
+		}
+		if err := event.DiscussionSetPublic("", disc.DiscussionID, true); err != nil {
+			t.Errorf("DiscussionSetPublic: %v", err)
+			return
+		}
+	}
+
+	var locations []Location
+	for i := 0; i < 2; i++ {
+		loc := Location{LocationName: fmt.Sprintf("Room %d", i+1), IsPlace: true, Capacity: 100}
+		if _, err := event.NewLocation("", &loc); err != nil {
+			t.Errorf("NewLocation: %v", err)
+			return
+		}
+		locations = append(locations, loc)
+	}
+
+	tt := Timetable{
+		Days: []TimetableDay{
+			{DayName: "Monday", Slots: []TimetableSlot{
+				{Time: Date(2020, 7, 6, 14, 30, 0, 0, time.UTC)},
+				{Time: Date(2020, 7, 6, 15, 15, 0, 0, time.UTC)},
+			}},
+			{DayName: "Tuesday", Slots: []TimetableSlot{
+				{Time: Date(2020, 7, 7, 14, 30, 0, 0, time.UTC)},
+				{Time: Date(2020, 7, 7, 15, 15, 0, 0, time.UTC)},
+			}},
+		},
+	}
+	if err := event.TimetableSet("", &tt, ImpactAbort); err != nil {
+		t.Errorf("TimetableSet: %v", err)
+		return
+	}
+
+	if err := event.MakeSchedule(SearchOptions{}); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+
+	getSchedule := func() (entries []scheduleEntry, subexit bool) {
+		err := sqlx.Select(event.DB, &entries,
+			`select discussionid, slotid, locationid from event_schedule`)
+		if err != nil {
+			t.Errorf("Getting schedule: %v", err)
+			return nil, true
+		}
+		return entries, false
+	}
+	schedule, subexit := getSchedule()
+	if subexit {
+		return
+	}
+	if len(schedule) != 3 {
+		t.Errorf("Expected 3 discussions scheduled, got %v", schedule)
+		return
+	}
+
+	// checkImpact checks that err reports the entries for which
+	// match returns true, and that the schedule hasn't changed.
+	checkImpact := func(err error, match func(e scheduleEntry) bool) bool {
+		want := map[scheduleEntry]bool{}
+		for _, e := range schedule {
+			if match(e) {
+				want[e] = true
+			}
+		}
+		impact := GetScheduleImpact(err)
+		if len(want) == 0 {
+			if err != nil {
+				t.Errorf("Expected no impact, got %v", err)
+				return true
+			}
+			return false
+		}
+		if len(impact) != len(want) {
+			t.Errorf("Expected %d affected discussions, got %v (%v)", len(want), impact, err)
+			return true
+		}
+		for _, si := range impact {
+			if !want[scheduleEntry{si.DiscussionID, si.SlotID, si.LocationID}] {
+				t.Errorf("Unexpected impact %v", si)
+				return true
+			}
+		}
+		got, subexit := getSchedule()
+		if subexit {
+			return true
+		}
+		if len(got) != len(schedule) {
+			t.Errorf("Schedule changed by aborted change: %v", got)
+			return true
+		}
+		return false
+	}
+
+	// The location and day with something scheduled in them
+	lid := schedule[0].LocationID
+	var dayid DayID
+	if err := sqlx.Get(event.DB, &dayid, `select dayid from event_slots where slotid = ?`,
+		schedule[0].SlotID); err != nil {
+		t.Errorf("Getting day: %v", err)
+		return
+	}
+
+	t.Logf("Changes which don't affect the schedule")
+	loc := locations[lid-1]
+	loc.LocationName = "Renamed"
+	loc.Capacity = 150
+	if err := event.LocationUpdate("", &loc, ImpactAbort); err != nil {
+		t.Errorf("Renaming location: %v", err)
+		return
+	}
+	if got, subexit := getSchedule(); subexit || len(got) != len(schedule) {
+		t.Errorf("Renaming location changed the schedule: %v", got)
+		return
+	}
+
+	t.Logf("Reducing a location's capacity")
+	loc.Capacity = 10
+	if checkImpact(event.LocationUpdate("", &loc, ImpactAbort),
+		func(e scheduleEntry) bool { return e.LocationID == lid }) {
+		return
+	}
+
+	t.Logf("Deleting a location in a locked slot")
+	if err := event.TimetableSetLockedSlots("", []SlotID{schedule[0].SlotID}); err != nil {
+		t.Errorf("TimetableSetLockedSlots: %v", err)
+		return
+	}
+	if err := event.DeleteLocation("", lid, ImpactUnschedule); err != errScheduleLocked {
+		t.Errorf("Expected errScheduleLocked, got %v", err)
+		return
+	}
+	if err := event.TimetableSetLockedSlots("", nil); err != nil {
+		t.Errorf("TimetableSetLockedSlots: %v", err)
+		return
+	}
+
+	t.Logf("Deleting a day")
+	if checkImpact(event.DeleteDay("", dayid, ImpactAbort), func(e scheduleEntry) bool {
+		var d DayID
+		sqlx.Get(event.DB, &d, `select dayid from event_slots where slotid = ?`, e.SlotID)
+		return d == dayid
+	}) {
+		return
+	}
+
+	t.Logf("Deleting a location")
+	if checkImpact(event.DeleteLocation("", lid, ImpactAbort),
+		func(e scheduleEntry) bool { return e.LocationID == lid }) {
+		return
+	}
+	if err := event.DeleteLocation("", lid, ImpactUnschedule); err != nil {
+		t.Errorf("DeleteLocation: %v", err)
+		return
+	}
+	got, subexit := getSchedule()
+	if subexit {
+		return
+	}
+	for _, e := range got {
+		if e.LocationID == lid {
+			t.Errorf("Discussion still scheduled in deleted location: %v", e)
+			return
+		}
+	}
+	if event.SchedGetState() != SchedStateModified {
+		t.Errorf("Expected schedule to be marked modified")
+		return
+	}
+
+	tc.cleanup()
+
+	return false
+}
@@ -9,7 +9,7 @@ func checkInterest(interestMap [][]int, users []User, discussions []Discussion,
 	eMaxScore := make([]int, len(discussions))
 	for uidx := range users {
 		for didx := range discussions {
-			interest, err := users[uidx].GetInterest(&discussions[didx])
+			interest, err := event.UserGetInterest(&users[uidx], &discussions[didx])
 			if err != nil {
 				t.Errorf("[%d][%d] GetInterest returned %v", uidx, didx, err)
 				return true
@@ -23,7 +23,7 @@ func checkInterest(interestMap [][]int, users []User, discussions []Discussion,
 	}
 
 	for didx := range discussions {
-		maxScore, err := discussions[didx].GetMaxScore()
+		maxScore, err := event.DiscussionGetMaxScore(&discussions[didx])
 		if err != nil {
 			t.Errorf("ERROR GetMaxScore: %v", err)
 			return true
@@ -80,7 +80,7 @@ func testUnitInterest(t *testing.T) (exit bool) {
 		}
 		owner := &users[owneridx] // Wish I had 'const'
 
-		interest, err := owner.GetInterest(&discussions[i])
+		interest, err := event.UserGetInterest(owner, &discussions[i])
 		if err != nil {
 			t.Errorf("ERROR GetInterest: %v", err)
 			return
@@ -102,11 +102,11 @@ func testUnitInterest(t *testing.T) (exit bool) {
 
 	t.Logf("Setting invalid values")
 	// Test setting invalid values
-	if err := users[0].SetInterest(&discussions[0], -1); err != errInvalidInterest {
+	if err := event.UserSetInterest(&users[0], &discussions[0], -1); err != errInvalidInterest {
 		t.Errorf("Unexpected result in setting interest to -1: %v", err)
 		return
 	}
-	if err := users[0].SetInterest(&discussions[0], InterestMax+1); err != errInvalidInterest {
+	if err := event.UserSetInterest(&users[0], &discussions[0], InterestMax+1); err != errInvalidInterest {
 		t.Errorf("Unexpected result in setting interest to InterestMax+1: %v", err)
 		return
 	}
@@ -115,14 +115,14 @@ func testUnitInterest(t *testing.T) (exit bool) {
 	t.Logf("Testing with bogus users and discussions")
 	{
 		user := User{UserID: UserID("NotAUser")}
-		err := user.SetInterest(&discussions[0], 50)
+		err := event.UserSetInterest(&user, &discussions[0], 50)
 		if err == nil {
 			t.Errorf("Unexpectedly succeeded setting interest for an invalid user!")
 			return
 		}
 
 		discussion := &Discussion{DiscussionID: DiscussionID("NotADiscussion")}
-		err = users[0].SetInterest(discussion, 50)
+		err = event.UserSetInterest(&users[0], discussion, 50)
 		if err == nil {
 			t.Errorf("Unexpectedly succeeded setting interest for an invalid discussion!")
 			return
@@ -142,7 +142,7 @@ func testUnitInterest(t *testing.T) (exit bool) {
 
 		interest := rand.Intn(InterestMax-1) + 1
 		t.Logf(" [%d][%d] %d -> %d", uidx, didx, interestMap[uidx][didx], interest)
-		if err := users[uidx].SetInterest(&discussions[didx], interest); err != nil {
+		if err := event.UserSetInterest(&users[uidx], &discussions[didx], interest); err != nil {
 			t.Errorf("Trying to set interest [%d][%d] to %d: %v",
 				uidx, didx, interest, err)
 			return
@@ -168,7 +168,7 @@ func testUnitInterest(t *testing.T) (exit bool) {
 
 		interest := rand.Intn(InterestMax-1) + 1
 		t.Logf(" [%d][%d] %d -> %d", uidx, didx, interestMap[uidx][didx], interest)
-		if err := users[uidx].SetInterest(&discussions[didx], interest); err != nil {
+		if err := event.UserSetInterest(&users[uidx], &discussions[didx], interest); err != nil {
 			t.Errorf("Trying to set interest [%d][%d] to %d: %v",
 				uidx, didx, interest, err)
 			return
@@ -195,7 +195,7 @@ func testUnitInterest(t *testing.T) (exit bool) {
 
 		interest := 0
 		t.Logf(" [%d][%d] %d -> %d", uidx, didx, interestMap[uidx][didx], interest)
-		if err := users[uidx].SetInterest(&discussions[didx], interest); err != nil {
+		if err := event.UserSetInterest(&users[uidx], &discussions[didx], interest); err != nil {
 			t.Errorf("Trying to set interest [%d][%d] to %d: %v",
 				uidx, didx, interest, err)
 			return
@@ -221,7 +221,7 @@ func testUnitInterest(t *testing.T) (exit bool) {
 
 		interest := 0
 		t.Logf(" [%d][%d] %d -> %d", uidx, didx, interestMap[uidx][didx], interest)
-		if err := users[uidx].SetInterest(&discussions[didx], interest); err != nil {
+		if err := event.UserSetInterest(&users[uidx], &discussions[didx], interest); err != nil {
 			t.Errorf("Trying to set interest [%d][%d] to %d: %v",
 				uidx, didx, interest, err)
 			return
@@ -250,7 +250,7 @@ func testUnitInterest(t *testing.T) (exit bool) {
 
 		copy := discussions[didx]
 		copy.Owner = users[uidx].UserID
-		err := DiscussionUpdate(&copy)
+		err := event.DiscussionUpdate("", &copy)
 		if err != nil {
 			t.Errorf("Changing discussion owner: %v", err)
 			return
@@ -272,17 +272,17 @@ func testUnitInterest(t *testing.T) (exit bool) {
 		}
 
 		// First set the interest for user 0 to non-zero
-		users[0].SetInterest(&discussions[didx], InterestMax)
+		event.UserSetInterest(&users[0], &discussions[didx], InterestMax)
 
 		// Then delete the discussion
-		err := DeleteDiscussion(discussions[didx].DiscussionID)
+		err := event.DeleteDiscussion("", discussions[didx].DiscussionID)
 		if err != nil {
 			t.Errorf("Deleting discussion: %v", err)
 			return
 		}
 
 		// Then get the interest and make sure it's zero
-		interest, err := users[0].GetInterest(&discussions[didx])
+		interest, err := event.UserGetInterest(&users[0], &discussions[didx])
 		if err != nil {
 			t.Errorf("ERROR GetInterest: %v", err)
 			return
@@ -310,13 +310,13 @@ func testUnitInterest(t *testing.T) (exit bool) {
 		// First, assign all of user N's discussions to user 0.
 		uidx := len(users) - 1
 		toDelete := []Discussion{}
-		err := DiscussionIterateUser(users[uidx].UserID, func(df *DiscussionFull) error {
+		err := event.DiscussionIterateUser(users[uidx].UserID, func(df *DiscussionFull) error {
 			toDelete = append(toDelete, df.Discussion)
 			return nil
 		})
 		for i := range toDelete {
 			toDelete[i].Owner = users[0].UserID
-			err = DiscussionUpdate(&toDelete[i])
+			err = event.DiscussionUpdate("", &toDelete[i])
 			if err != nil {
 				t.Errorf("Changing discussion owner: %v", err)
 				return
@@ -329,21 +329,21 @@ func testUnitInterest(t *testing.T) (exit bool) {
 		interestMap = nil
 
 		// Set user N's interest in at least one discussion to non-zero
-		err = users[uidx].SetInterest(&discussions[0], InterestMax)
+		err = event.UserSetInterest(&users[uidx], &discussions[0], InterestMax)
 		if err != nil {
 			t.Errorf("Setting interest: %v", err)
 			return
 		}
 
 		// Delete the user
-		err = DeleteUser(users[uidx].UserID)
+		err = event.DeleteUser("", users[uidx].UserID, "")
 		if err != nil {
 			t.Errorf("Deleting user: %v", err)
 			return
 		}
 
 		// Check to see that the interest is zero
-		interest, err := users[uidx].GetInterest(&discussions[0])
+		interest, err := event.UserGetInterest(&users[uidx], &discussions[0])
 		if err != nil {
 			t.Errorf("ERROR: GetInterest %v", err)
 			return
@@ -0,0 +1,279 @@
+package event
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/gwd/session-scheduler/id"
+)
+
+const (
+	inviteCodeLength = 10
+
+	maxInviteBatch = 1000
+)
+
+// Invite is an invitation code.  Redeeming one verifies the user who
+// redeems it; each code may be redeemed at most MaxUses times, and
+// not at all after Expiry (if set).
+type Invite struct {
+	Code    string
+	Note    string
+	MaxUses int
+	Uses    int
+	Expiry  int64 // Unix time; 0 if the code never expires
+	Created Time
+}
+
+// HasExpiry returns true if the code has an expiry time.
+func (inv *Invite) HasExpiry() bool {
+	return inv.Expiry != 0
+}
+
+// ExpiryTime returns the time after which the code can't be used.
+func (inv *Invite) ExpiryTime() time.Time {
+	return time.Unix(inv.Expiry, 0)
+}
+
+// IsExpired returns true if the code has passed its expiry time.
+func (inv *Invite) IsExpired() bool {
+	return inv.HasExpiry() && inv.Expiry < time.Now().Unix()
+}
+
+// IsUsable returns true if the code can still be redeemed.
+func (inv *Invite) IsUsable() bool {
+	return !inv.IsExpired() && inv.Uses < inv.MaxUses
+}
+
+func (inv *Invite) check() error {
+	switch {
+	case inv.IsExpired():
+		return errInviteExpired
+	case inv.Uses >= inv.MaxUses:
+		return errInviteUsedUp
+	}
+	return nil
+}
+
+// InviteRedemption records which code a user redeemed.
+type InviteRedemption struct {
+	UserID   UserID
+	Username string
+	Code     string
+	Note     string
+}
+
+// NewInviteBatch creates count new codes, each of which may be
+// redeemed maxUses times.  If expiry is the zero time, the codes
+// never expire.  actor is the user creating them.
+func (store *EventStore) NewInviteBatch(actor UserID, count, maxUses int, expiry time.Time, note string) ([]Invite, error) {
+	if count < 1 || count > maxInviteBatch {
+		return nil, errInviteCount
+	}
+	if maxUses < 1 {
+		return nil, errInviteMaxUses
+	}
+
+	var unixExpiry int64
+	if !expiry.IsZero() {
+		unixExpiry = expiry.Unix()
+	}
+
+	created := Time{time.Now()}
+
+	var invites []Invite
+	err := store.txLoop(func(eq sqlx.Ext) error {
+		invites = nil
+		for i := 0; i < count; i++ {
+			inv := Invite{
+				Code:    id.GenerateRawID(inviteCodeLength),
+				Note:    note,
+				MaxUses: maxUses,
+				Expiry:  unixExpiry,
+				Created: created,
+			}
+			_, err := sqlx.NamedExec(eq, `
+                insert into event_invites(code, note, maxuses, uses, expiry, created)
+                    values(:code, :note, :maxuses, :uses, :expiry, :created)`,
+				&inv)
+			if err != nil {
+				return errOrRetry("Inserting invite", err)
+			}
+			if err := auditTx(eq, actor, AuditInviteCreate, inv.Code, nil, &inv); err != nil {
+				return err
+			}
+			invites = append(invites, inv)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return invites, nil
+}
+
+// InviteGetAll returns all codes, newest first.
+func (store *EventStore) InviteGetAll() ([]Invite, error) {
+	var invites []Invite
+	err := store.txLoop(func(eq sqlx.Ext) error {
+		invites = nil
+		err := sqlx.Select(eq, &invites, `
+            select * from event_invites
+                order by created desc, note, code`)
+		if err != nil {
+			return errOrRetry("Getting invites", err)
+		}
+		return nil
+	})
+	return invites, err
+}
+
+func inviteGetTx(q sqlx.Queryer, code string, inv *Invite) error {
+	err := sqlx.Get(q, inv, `select * from event_invites where code = ?`,
+		strings.TrimSpace(code))
+	if err == sql.ErrNoRows {
+		return ErrInviteInvalid
+	}
+	return err
+}
+
+// InviteCheck returns nil if code exists and can be redeemed, or a
+// validation error describing why it can't.
+func (store *EventStore) InviteCheck(code string) error {
+	var inv Invite
+	for {
+		err := inviteGetTx(store, code, &inv)
+		switch {
+		case shouldRetry(err):
+			continue
+		case err != nil:
+			return err
+		}
+		return inv.check()
+	}
+}
+
+// InviteRedeem uses up one redemption of code and marks userid as
+// verified.  Each user may only redeem one code.
+func (store *EventStore) InviteRedeem(userid UserID, code string) error {
+	return store.txLoop(func(eq sqlx.Ext) error {
+		return inviteRedeemTx(eq, userid, code)
+	})
+}
+
+func inviteRedeemTx(eq sqlx.Ext, userid UserID, code string) error {
+	var inv Invite
+	err := inviteGetTx(eq, code, &inv)
+	if err == ErrInviteInvalid {
+		return err
+	} else if err != nil {
+		return errOrRetry("Getting invite", err)
+	}
+
+	if err := inv.check(); err != nil {
+		return err
+	}
+
+	var redeemed int
+	err = sqlx.Get(eq, &redeemed, `
+        select count(*) from event_invite_redemptions where userid = ?`,
+		userid)
+	if err != nil {
+		return errOrRetry("Checking invite redemptions", err)
+	}
+	if redeemed > 0 {
+		return errInviteAlreadyRedeemed
+	}
+
+	_, err = eq.Exec(`
+        insert into event_invite_redemptions(userid, code) values(?, ?)`,
+		userid, inv.Code)
+	if isErrorForeignKey(err) {
+		return ErrUserNotFound
+	} else if err != nil {
+		return errOrRetry("Inserting invite redemption", err)
+	}
+
+	_, err = eq.Exec(`
+        update event_invites set uses = uses + 1 where code = ?`, inv.Code)
+	if err != nil {
+		return errOrRetry("Updating invite uses", err)
+	}
+
+	_, err = eq.Exec(`
+        update event_users set isverified = true where userid = ?`, userid)
+	if err != nil {
+		return errOrRetry("Setting user verified", err)
+	}
+	return auditTx(eq, userid, AuditInviteRedeem, inv.Code, nil,
+		&InviteRedemption{UserID: userid, Code: inv.Code, Note: inv.Note})
+}
+
+// RevokeInvite prevents code from being redeemed any more.  Users who
+// have already redeemed it remain verified.
+func (store *EventStore) RevokeInvite(actor UserID, code string) error {
+	return store.txLoop(func(eq sqlx.Ext) error {
+		var before Invite
+		err := sqlx.Get(eq, &before, `select * from event_invites where code = ?`, code)
+		if err == sql.ErrNoRows {
+			return ErrInviteNotFound
+		} else if err != nil {
+			return errOrRetry("Getting invite", err)
+		}
+
+		_, err = eq.Exec(`
+            update event_invites set maxuses = uses where code = ?`, code)
+		if err != nil {
+			return errOrRetry("Revoking invite", err)
+		}
+
+		after := before
+		after.MaxUses = after.Uses
+		return auditTx(eq, actor, AuditInviteRevoke, code, &before, &after)
+	})
+}
+
+// InviteRedemptions returns which code each user redeemed, ordered
+// by username.
+func (store *EventStore) InviteRedemptions() ([]InviteRedemption, error) {
+	var redemptions []InviteRedemption
+	err := store.txLoop(func(eq sqlx.Ext) error {
+		redemptions = nil
+		err := sqlx.Select(eq, &redemptions, `
+            select userid, username, code, note
+                from event_invite_redemptions
+                    natural join event_users
+                    natural join event_invites
+                where deleted = 0
+                order by username`)
+		if err != nil {
+			return errOrRetry("Getting invite redemptions", err)
+		}
+		return nil
+	})
+	return redemptions, err
+}
+
+// UserGetInvite returns the code userid redeemed, or nil if they
+// haven't redeemed one.
+func (store *EventStore) UserGetInvite(userid UserID) (*Invite, error) {
+	var inv Invite
+	for {
+		err := store.Get(&inv, `
+            select event_invites.*
+                from event_invite_redemptions natural join event_invites
+                where userid = ?`, userid)
+		switch {
+		case shouldRetry(err):
+			continue
+		case err == sql.ErrNoRows:
+			return nil, nil
+		case err != nil:
+			return nil, err
+		}
+		return &inv, nil
+	}
+}
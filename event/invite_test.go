@@ -0,0 +1,180 @@
+package event
+
+import (
+	"testing"
+	"time"
+)
+
+func testUnitInvites(t *testing.T) (exit bool) {
+	// Any "early" exit is a failure
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+
+	m := &mirrorData{}
+
+	if testNewUsers(t, m, 4) {
+		return
+	}
+
+	t.Logf("Creating invites")
+	if _, err := event.NewInviteBatch("", 0, 1, time.Time{}, "none"); err != errInviteCount {
+		t.Errorf("Empty batch: expected errInviteCount, got %v", err)
+		return
+	}
+	if _, err := event.NewInviteBatch("", 1, 0, time.Time{}, "unusable"); err != errInviteMaxUses {
+		t.Errorf("Zero uses: expected errInviteMaxUses, got %v", err)
+		return
+	}
+
+	speakers, err := event.NewInviteBatch("", 3, 1, time.Time{}, "speakers")
+	if err != nil || len(speakers) != 3 {
+		t.Errorf("NewInviteBatch: got %d invites, err %v", len(speakers), err)
+		return
+	}
+	sponsor, err := event.NewInviteBatch("", 1, 2, time.Now().Add(time.Hour), "sponsor")
+	if err != nil {
+		t.Errorf("NewInviteBatch: %v", err)
+		return
+	}
+	expired, err := event.NewInviteBatch("", 1, 5, time.Now().Add(-time.Hour), "expired")
+	if err != nil {
+		t.Errorf("NewInviteBatch: %v", err)
+		return
+	}
+
+	all, err := event.InviteGetAll()
+	if err != nil || len(all) != 5 {
+		t.Errorf("InviteGetAll: got %d invites, err %v", len(all), err)
+		return
+	}
+
+	t.Logf("Checking invites")
+	if err := event.InviteCheck("nosuchcode"); err != ErrInviteInvalid {
+		t.Errorf("Invalid code: expected ErrInviteInvalid, got %v", err)
+		return
+	}
+	if err := event.InviteCheck(expired[0].Code); err != errInviteExpired {
+		t.Errorf("Expired code: expected errInviteExpired, got %v", err)
+		return
+	}
+	if err := event.InviteCheck(" " + speakers[0].Code + " "); err != nil {
+		t.Errorf("Valid code: %v", err)
+		return
+	}
+
+	t.Logf("Redeeming invites")
+	if err := event.InviteRedeem(m.users[0].UserID, expired[0].Code); err != errInviteExpired {
+		t.Errorf("Redeeming expired code: expected errInviteExpired, got %v", err)
+		return
+	}
+	if err := event.InviteRedeem(UserID("invalid"), speakers[0].Code); err != ErrUserNotFound {
+		t.Errorf("Redeeming for invalid user: expected ErrUserNotFound, got %v", err)
+		return
+	}
+
+	if err := event.InviteRedeem(m.users[0].UserID, speakers[0].Code); err != nil {
+		t.Errorf("InviteRedeem: %v", err)
+		return
+	}
+	if u, _ := event.UserFind(m.users[0].UserID); u == nil || !u.IsVerified {
+		t.Errorf("User not verified after redeeming invite")
+		return
+	}
+
+	// Single-use codes can only be used once...
+	if err := event.InviteRedeem(m.users[1].UserID, speakers[0].Code); err != errInviteUsedUp {
+		t.Errorf("Reusing single-use code: expected errInviteUsedUp, got %v", err)
+		return
+	}
+	// ...and each user can only redeem one code
+	if err := event.InviteRedeem(m.users[0].UserID, speakers[1].Code); err != errInviteAlreadyRedeemed {
+		t.Errorf("Redeeming second code: expected errInviteAlreadyRedeemed, got %v", err)
+		return
+	}
+
+	for i := 1; i <= 2; i++ {
+		if err := event.InviteRedeem(m.users[i].UserID, sponsor[0].Code); err != nil {
+			t.Errorf("Redeeming multi-use code: %v", err)
+			return
+		}
+	}
+	if err := event.InviteRedeem(m.users[3].UserID, sponsor[0].Code); err != errInviteUsedUp {
+		t.Errorf("Overusing multi-use code: expected errInviteUsedUp, got %v", err)
+		return
+	}
+
+	t.Logf("Revoking invites")
+	if err := event.RevokeInvite("", "nosuchcode"); err != ErrInviteNotFound {
+		t.Errorf("Revoking invalid code: expected ErrInviteNotFound, got %v", err)
+		return
+	}
+	if err := event.RevokeInvite("", speakers[2].Code); err != nil {
+		t.Errorf("RevokeInvite: %v", err)
+		return
+	}
+	if err := event.InviteCheck(speakers[2].Code); err != errInviteUsedUp {
+		t.Errorf("Revoked code: expected errInviteUsedUp, got %v", err)
+		return
+	}
+
+	t.Logf("Checking redemptions")
+	redemptions, err := event.InviteRedemptions()
+	if err != nil || len(redemptions) != 3 {
+		t.Errorf("InviteRedemptions: got %v, err %v", redemptions, err)
+		return
+	}
+	for _, r := range redemptions {
+		want := sponsor[0]
+		if r.UserID == m.users[0].UserID {
+			want = speakers[0]
+		}
+		if r.Code != want.Code || r.Note != want.Note {
+			t.Errorf("User %s redeemed %s (%s), expected %s (%s)",
+				r.Username, r.Code, r.Note, want.Code, want.Note)
+			return
+		}
+	}
+
+	if inv, err := event.UserGetInvite(m.users[0].UserID); err != nil || inv == nil || inv.Code != speakers[0].Code {
+		t.Errorf("UserGetInvite: got %v, err %v", inv, err)
+		return
+	}
+	if inv, err := event.UserGetInvite(m.users[3].UserID); err != nil || inv != nil {
+		t.Errorf("UserGetInvite for user without invite: got %v, err %v", inv, err)
+		return
+	}
+
+	t.Logf("Creating users with invites")
+	uid, err := event.NewUserWithInvite(TestPassword, &User{Username: "invited1"}, speakers[1].Code)
+	if err != nil {
+		t.Errorf("NewUserWithInvite: %v", err)
+		return
+	}
+	if u, err := event.UserFind(uid); err != nil || u == nil || !u.IsVerified {
+		t.Errorf("Expected invited user to be verified, got %v, err %v", u, err)
+		return
+	}
+	for _, code := range []string{speakers[1].Code, "nosuchcode"} {
+		if _, err := event.NewUserWithInvite(TestPassword, &User{Username: "invited2"}, code); err != ErrInviteInvalid {
+			t.Errorf("Expected ErrInviteInvalid, got %v", err)
+			return
+		}
+		if u, err := event.UserFindByUsername("invited2"); err != nil || u != nil {
+			t.Errorf("Expected user not to be created, got %v, err %v", u, err)
+			return
+		}
+	}
+
+	if err := event.DeleteUser("", m.users[0].UserID, ""); err != nil {
+		t.Errorf("Deleting user: %v", err)
+		return
+	}
+
+	tc.cleanup()
+
+	return false
+}
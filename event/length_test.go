@@ -0,0 +1,207 @@
+package event
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func testUnitDiscussionLength(t *testing.T) (exit bool) {
+	// Any "early" exit is a failure
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+
+	m := &mirrorData{}
+	if testNewUsers(t, m, 3) {
+		return
+	}
+	for i := range m.users {
+		disc, subexit := testNewDiscussion(t, m.users[i].UserID)
+		if subexit {
+			return
+		}
+		if disc.Length != 1 || disc.ApprovedLength != 1 {
+			t.Errorf("Expected new discussion to have length 1, got %d (approved %d)",
+				disc.Length, disc.ApprovedLength)
+			return
+		}
+		if err := event.DiscussionSetPublic("", disc.DiscussionID, true); err != nil {
+			t.Errorf("DiscussionSetPublic: %v", err)
+			return
+		}
+		m.discussions = append(m.discussions, disc)
+	}
+
+	for i := 0; i < 2; i++ {
+		loc := Location{LocationName: fmt.Sprintf("Room %d", i+1), IsPlace: true, Capacity: 100}
+		if _, err := event.NewLocation("", &loc); err != nil {
+			t.Errorf("NewLocation: %v", err)
+			return
+		}
+	}
+
+	tt := Timetable{
+		Days: []TimetableDay{
+			{DayName: "Monday", Slots: []TimetableSlot{
+				{Time: Date(2020, 7, 6, 14, 30, 0, 0, time.UTC)},
+				{Time: Date(2020, 7, 6, 15, 15, 0, 0, time.UTC)},
+				{Time: Date(2020, 7, 6, 16, 0, 0, 0, time.UTC)},
+			}},
+		},
+	}
+	if err := event.TimetableSet("", &tt, ImpactAbort); err != nil {
+		t.Errorf("TimetableSet: %v", err)
+		return
+	}
+
+	t.Logf("Requesting invalid lengths")
+	long := &m.discussions[0]
+	long.Length = MaxDiscussionLength + 1
+	if err := event.DiscussionUpdate(long.Owner, long); err != errDiscussionLength {
+		t.Errorf("Expected errDiscussionLength, got %v", err)
+		return
+	}
+	if err := event.DiscussionSetApprovedLength("", long.DiscussionID, 0); err != errDiscussionLength {
+		t.Errorf("Expected errDiscussionLength, got %v", err)
+		return
+	}
+
+	t.Logf("Requesting and approving a longer discussion")
+	long.Length = 2
+	if err := event.DiscussionUpdate(long.Owner, long); err != nil {
+		t.Errorf("DiscussionUpdate: %v", err)
+		return
+	}
+	requests, err := event.DiscussionGetLengthRequests()
+	if err != nil {
+		t.Errorf("DiscussionGetLengthRequests: %v", err)
+		return
+	}
+	if len(requests) != 1 || requests[0].DiscussionID != long.DiscussionID ||
+		requests[0].Length != 2 || requests[0].ApprovedLength != 1 {
+		t.Errorf("Unexpected length requests %v", requests)
+		return
+	}
+	if err := event.DiscussionSetApprovedLength("", long.DiscussionID, 2); err != nil {
+		t.Errorf("DiscussionSetApprovedLength: %v", err)
+		return
+	}
+	if requests, err = event.DiscussionGetLengthRequests(); err != nil || len(requests) != 0 {
+		t.Errorf("Expected no length requests after approval, got %v (%v)", requests, err)
+		return
+	}
+
+	if err := event.MakeSchedule(SearchOptions{}); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+
+	t.Logf("Checking the long discussion takes up consecutive slots")
+	var slots []struct {
+		SlotID     SlotID
+		SlotIDX    int
+		LocationID LocationID
+	}
+	err = sqlx.Select(event.DB, &slots, `
+        select slotid, slotidx, locationid
+            from event_schedule natural join event_slots
+            where discussionid = ?
+            order by slotidx`, long.DiscussionID)
+	if err != nil {
+		t.Errorf("Getting schedule: %v", err)
+		return
+	}
+	if len(slots) != 2 || slots[1].SlotIDX != slots[0].SlotIDX+1 ||
+		slots[1].LocationID != slots[0].LocationID {
+		t.Errorf("Expected two consecutive slots in one location, got %v", slots)
+		return
+	}
+
+	var count int
+	if err := event.Get(&count, `select count(distinct discussionid) from event_schedule`); err != nil {
+		t.Errorf("Counting scheduled discussions: %v", err)
+		return
+	}
+	if count != len(m.discussions) {
+		t.Errorf("Expected %d discussions scheduled, got %d", len(m.discussions), count)
+		return
+	}
+
+	t.Logf("Checking the calendar has one entry for the long discussion")
+	entries, err := event.GetCalendar()
+	if err != nil {
+		t.Errorf("GetCalendar: %v", err)
+		return
+	}
+	if len(entries) != len(m.discussions) {
+		t.Errorf("Expected %d calendar entries, got %d", len(m.discussions), len(entries))
+		return
+	}
+	for _, e := range entries {
+		if e.DiscussionID != long.DiscussionID {
+			continue
+		}
+		if e.End.Sub(e.Start.Time) != 90*time.Minute {
+			t.Errorf("Expected long discussion to last 90 minutes, got %v - %v", e.Start, e.End)
+			return
+		}
+	}
+
+	t.Logf("Checking the timetable shows the long discussion continuing")
+	tt, err = event.GetTimetable("", nil)
+	if err != nil {
+		t.Errorf("GetTimetable: %v", err)
+		return
+	}
+	continued := 0
+	for _, ts := range tt.Days[0].Slots {
+		for _, td := range ts.Discussions {
+			if td.Continued {
+				if td.DiscussionID != long.DiscussionID || td.Length != 2 {
+					t.Errorf("Unexpected continued discussion %v", td)
+					return
+				}
+				continued++
+			}
+		}
+	}
+	if continued != 1 {
+		t.Errorf("Expected long discussion to be continued in one slot, got %d", continued)
+		return
+	}
+
+	t.Logf("Changing the length of a locked discussion")
+	if err := event.TimetableSetLockedSlots("", []SlotID{slots[0].SlotID}); err != nil {
+		t.Errorf("TimetableSetLockedSlots: %v", err)
+		return
+	}
+	if err := event.DiscussionSetApprovedLength("", long.DiscussionID, 1); err != errScheduleLocked {
+		t.Errorf("Expected errScheduleLocked, got %v", err)
+		return
+	}
+	if err := event.TimetableSetLockedSlots("", nil); err != nil {
+		t.Errorf("TimetableSetLockedSlots: %v", err)
+		return
+	}
+
+	t.Logf("Shortening the long discussion unschedules it")
+	if err := event.DiscussionSetApprovedLength("", long.DiscussionID, 1); err != nil {
+		t.Errorf("DiscussionSetApprovedLength: %v", err)
+		return
+	}
+	if err := event.Get(&count, `select count(*) from event_schedule where discussionid = ?`,
+		long.DiscussionID); err != nil || count != 0 {
+		t.Errorf("Expected shortened discussion to be unscheduled, got %d (%v)", count, err)
+		return
+	}
+
+	tc.cleanup()
+
+	return false
+}
@@ -3,6 +3,7 @@ package event
 import (
 	"database/sql"
 	"log"
+	"strconv"
 
 	"github.com/jmoiron/sqlx"
 )
@@ -11,6 +12,10 @@ import (
 
 type LocationID int
 
+func (lid LocationID) String() string {
+	return strconv.Itoa(int(lid))
+}
+
 type Location struct {
 	LocationID   LocationID
 	LocationName string
@@ -35,14 +40,15 @@ func checkLocationParams(l *Location) error {
 	return nil
 }
 
-//
-func NewLocation(l *Location) (LocationID, error) {
+// NewLocation adds l, filling in its LocationID.  actor is the user
+// adding it.
+func (store *EventStore) NewLocation(actor UserID, l *Location) (LocationID, error) {
 	err := checkLocationParams(l)
 	if err != nil {
 		return l.LocationID, err
 	}
 
-	err = txLoop(func(eq sqlx.Ext) error {
+	err = store.txLoop(func(eq sqlx.Ext) error {
 		// Find the highest locationid.  Returning 0 if no rows means the
 		// next one chosen will be 1, as we intend.
 		var maxlocid int
@@ -64,17 +70,22 @@ func NewLocation(l *Location) (LocationID, error) {
 			return errOrRetry("Inserting location", err)
 		}
 
-		return nil
+		err = auditTx(eq, actor, AuditLocationCreate, l.LocationID.String(), nil, l)
+		if err != nil {
+			return err
+		}
+
+		return schedMarkModifiedTx(eq)
 	})
 
 	return l.LocationID, err
 }
 
 /// LocationFindById
-func LocationFindById(lid LocationID) (*Location, error) {
+func (store *EventStore) LocationFindById(lid LocationID) (*Location, error) {
 	loc := &Location{}
 	for {
-		err := event.Get(loc,
+		err := store.Get(loc,
 			`select * from event_locations where locationid = ?`,
 			lid)
 		switch {
@@ -88,13 +99,38 @@ func LocationFindById(lid LocationID) (*Location, error) {
 	}
 }
 
-// DeleteLocation
-func DeleteLocation(lid LocationID) error {
-	return txLoop(func(eq sqlx.Ext) error {
-		// TODO: Delete (nullify?) the schedule as well
-		res, err := event.Exec(`delete from event_locations where locationid=?`, lid)
+// DeleteLocation deletes a location.  action says what to do if
+// anything is scheduled there.
+func (store *EventStore) DeleteLocation(actor UserID, lid LocationID, action ImpactAction) error {
+	return store.txLoop(func(eq sqlx.Ext) error {
+		var before Location
+		err := sqlx.Get(eq, &before,
+			`select * from event_locations where locationid = ?`, lid)
+		if err == sql.ErrNoRows {
+			return ErrLocationNotFound
+		} else if err != nil {
+			return errOrRetry("Getting location", err)
+		}
+
+		var res sql.Result
+		err = scheduleImpactTx(eq, action, func() error {
+			_, err := eq.Exec(`delete from event_schedule where locationid=?`, lid)
+			if err != nil {
+				return errOrRetry("Deleting schedule entries for location", err)
+			}
+			// Discussions pinned here stay pinned to their slots
+			_, err = eq.Exec(`update event_pins set locationid = null where locationid=?`, lid)
+			if err != nil {
+				return errOrRetry("Unpinning discussions from location", err)
+			}
+			res, err = eq.Exec(`delete from event_locations where locationid=?`, lid)
+			if err != nil {
+				return errOrRetry("Deleting location from event_locations", err)
+			}
+			return nil
+		})
 		if err != nil {
-			return errOrRetry("Deleting location from event_locations", err)
+			return err
 		}
 
 		rcount, err := res.RowsAffected()
@@ -111,35 +147,69 @@ func DeleteLocation(lid LocationID) error {
 			return ErrInternal
 		}
 
-		return nil
+		err = auditTx(eq, actor, AuditLocationDelete, lid.String(), &before, nil)
+		if err != nil {
+			return err
+		}
+
+		return schedMarkModifiedTx(eq)
 	})
 }
 
-// LocationUpdate
-func LocationUpdate(l *Location) error {
+// LocationUpdate sets l.LocationID's fields.  Changing whether a
+// location is a place, or reducing its capacity, unschedules anything
+// scheduled there; action says whether that's OK.
+func (store *EventStore) LocationUpdate(actor UserID, l *Location, action ImpactAction) error {
 	if err := checkLocationParams(l); err != nil {
 		return err
 	}
 
-	err := txLoop(func(eq sqlx.Ext) error {
-		// TODO: Delete (nullify?) the schedule if changing isplace or capacity
-		_, err := eq.Exec(`
-            update event_locations
-                set locationname =?,
-                    locationurl = ?,
-                    isplace = ?,
-                    capacity = ?
-                where locationid = ?`,
-			l.LocationName, l.LocationURL, l.IsPlace, l.Capacity, l.LocationID)
-		return err
+	err := store.txLoop(func(eq sqlx.Ext) error {
+		var before Location
+		err := sqlx.Get(eq, &before,
+			`select * from event_locations where locationid = ?`, l.LocationID)
+		if err == sql.ErrNoRows {
+			return ErrLocationNotFound
+		} else if err != nil {
+			return errOrRetry("Getting location", err)
+		}
+
+		err = scheduleImpactTx(eq, action, func() error {
+			if l.IsPlace != before.IsPlace || (l.IsPlace && l.Capacity < before.Capacity) {
+				_, err := eq.Exec(`delete from event_schedule where locationid=?`, l.LocationID)
+				if err != nil {
+					return errOrRetry("Deleting schedule entries for location", err)
+				}
+			}
+
+			_, err := eq.Exec(`
+                update event_locations
+                    set locationname =?,
+                        locationurl = ?,
+                        isplace = ?,
+                        capacity = ?
+                    where locationid = ?`,
+				l.LocationName, l.LocationURL, l.IsPlace, l.Capacity, l.LocationID)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		err = auditTx(eq, actor, AuditLocationUpdate, l.LocationID.String(), &before, l)
+		if err != nil {
+			return err
+		}
+
+		return schedMarkModifiedTx(eq)
 	})
 
 	return err
 }
 
-func LocationGetAll() (locations []Location, err error) {
+func (store *EventStore) LocationGetAll() (locations []Location, err error) {
 	for {
-		err = event.Select(&locations, `select * from event_locations order by locationid`)
+		err = store.Select(&locations, `select * from event_locations order by locationid`)
 		switch {
 		case shouldRetry(err):
 			continue
@@ -15,7 +15,7 @@ func testNewLocation(t *testing.T) (Location, bool) {
 		Capacity:     rand.Intn(200) + 1,
 	}
 
-	if _, err := NewLocation(&loc); err != nil {
+	if _, err := event.NewLocation("", &loc); err != nil {
 		t.Errorf("ERROR: Creating new location: %v", err)
 		return loc, true
 	}
@@ -58,19 +58,19 @@ func testUnitLocation(t *testing.T) (exit bool) {
 
 	t.Logf("Trying to make invalid locations")
 	{
-		_, err := NewLocation(&Location{LocationName: "", LocationURL: "<URL>", Capacity: 10})
+		_, err := event.NewLocation("", &Location{LocationName: "", LocationURL: "<URL>", Capacity: 10})
 		if err == nil {
 			t.Errorf("ERROR: Created location with empty name!")
 			return
 		}
 
-		_, err = NewLocation(&Location{LocationName: "Blah", LocationURL: "<URL>", Capacity: 0})
+		_, err = event.NewLocation("", &Location{LocationName: "Blah", LocationURL: "<URL>", Capacity: 0})
 		if err == nil {
 			t.Errorf("ERROR: Created location with zero capacity!")
 			return
 		}
 
-		_, err = NewLocation(&Location{LocationName: "Blah", LocationURL: "<URL>", Capacity: -100})
+		_, err = event.NewLocation("", &Location{LocationName: "Blah", LocationURL: "<URL>", Capacity: -100})
 		if err == nil {
 			t.Errorf("ERROR: Created location with negative capacity!")
 			return
@@ -96,7 +96,7 @@ func testUnitLocation(t *testing.T) (exit bool) {
 		}
 
 		// Look for that location by did
-		gotloc, err := LocationFindById(locations[i].LocationID)
+		gotloc, err := event.LocationFindById(locations[i].LocationID)
 		if err != nil {
 			t.Errorf("Finding the location we just created by ID: %v", err)
 			return
@@ -114,7 +114,7 @@ func testUnitLocation(t *testing.T) (exit bool) {
 	t.Logf("Testing Corner cases")
 	{
 		// Try to find a non-existent ID.  Should return nil for both.
-		gotdisc, err := LocationFindById(LocationID(len(locations) + 1))
+		gotdisc, err := event.LocationFindById(LocationID(len(locations) + 1))
 		if err != nil {
 			t.Errorf("Unexpected error finding non-existent location: %v", err)
 			return
@@ -130,13 +130,13 @@ func testUnitLocation(t *testing.T) (exit bool) {
 		copy := locations[i]
 		copy.LocationName = fake.Word()
 		copy.LocationURL = "https://" + fake.DomainName()
-		err := LocationUpdate(&copy)
+		err := event.LocationUpdate("", &copy, ImpactAbort)
 		if err != nil {
 			t.Errorf("Updating location: %v", err)
 			return
 		}
 
-		gotloc, err := LocationFindById(locations[i].LocationID)
+		gotloc, err := event.LocationFindById(locations[i].LocationID)
 		if err != nil {
 			t.Errorf("Finding the location we just created by ID: %v", err)
 			return
@@ -154,7 +154,7 @@ func testUnitLocation(t *testing.T) (exit bool) {
 
 	t.Logf("Testing LocationGetAll")
 	{
-		gotlocs, err := LocationGetAll()
+		gotlocs, err := event.LocationGetAll()
 		if err != nil {
 			t.Errorf("Getting locations: %v", err)
 			return
@@ -175,21 +175,21 @@ func testUnitLocation(t *testing.T) (exit bool) {
 
 	t.Logf("Testing DeleteLocation")
 	for i := range locations {
-		err := DeleteLocation(locations[i].LocationID)
+		err := event.DeleteLocation("", locations[i].LocationID, ImpactAbort)
 		if err != nil {
 			t.Errorf("Deleting location: %v", err)
 			return
 		}
 
 		// Delete it again, should get ErrorLocationNotFound
-		err = DeleteLocation(locations[i].LocationID)
+		err = event.DeleteLocation("", locations[i].LocationID, ImpactAbort)
 		if err != ErrLocationNotFound {
 			t.Errorf("Unexpected err from second delete: %v", err)
 			return
 		}
 
 		// Try to find it.  Should return nil for both.
-		gotdisc, err := LocationFindById(locations[i].LocationID)
+		gotdisc, err := event.LocationFindById(locations[i].LocationID)
 		if err != nil {
 			t.Errorf("Unexpected error finding deleted location: %v", err)
 			return
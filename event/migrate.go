@@ -0,0 +1,147 @@
+package event
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// MigrationStep describes one step in upgrading a database schema.
+type MigrationStep struct {
+	Version     int // Schema version after the step
+	Description string
+}
+
+// MigrationResult describes the upgrade of a database from one schema
+// version to another.
+type MigrationResult struct {
+	FromVersion int
+	ToVersion   int
+	Steps       []MigrationStep
+	Backup      string // Copy of the database made beforehand; "" if none
+}
+
+type dbMigration struct {
+	MigrationStep
+	up func(sqlx.Ext) error
+}
+
+// pendingMigrations returns the steps needed to upgrade a database at
+// schema version from to codeSchemaVersion.
+func pendingMigrations(from int) ([]dbMigration, error) {
+	if from == codeSchemaVersion {
+		return nil, nil
+	}
+	if from > codeSchemaVersion {
+		return nil, fmt.Errorf("Database schema version %d is newer than this program's (%d)",
+			from, codeSchemaVersion)
+	}
+	for i := range dbMigrations {
+		if dbMigrations[i].Version == from+1 {
+			return dbMigrations[i:], nil
+		}
+	}
+	return nil, fmt.Errorf("Can't upgrade database from schema version %d", from)
+}
+
+// backupDb copies db to a file next to filename, named for the schema
+// version and the time, and returns the name of the copy.
+func backupDb(db *sqlx.DB, filename string, version int) (string, error) {
+	backup := fmt.Sprintf("%s.v%d-%s.bak", filename, version,
+		time.Now().Format("20060102-150405"))
+	_, err := db.Exec(`vacuum into ?`, backup)
+	if err != nil {
+		return "", fmt.Errorf("Backing up database to %s: %v", backup, err)
+	}
+	return backup, nil
+}
+
+// migrateDb upgrades db (stored in filename) from schema version from
+// to codeSchemaVersion.  A backup is made first, then all steps are run
+// in a single transaction, so either all of them are applied or none
+// are.  If dryRun is set, the steps are run but the transaction is
+// rolled back, and no backup is made.
+func migrateDb(db *sqlx.DB, filename string, from int, dryRun bool) (*MigrationResult, error) {
+	res := &MigrationResult{FromVersion: from, ToVersion: codeSchemaVersion}
+
+	migrations, err := pendingMigrations(from)
+	if err != nil {
+		return nil, err
+	}
+	if len(migrations) == 0 {
+		return res, nil
+	}
+	for i := range migrations {
+		res.Steps = append(res.Steps, migrations[i].MigrationStep)
+	}
+
+	if !dryRun {
+		res.Backup, err = backupDb(db, filename, from)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for i := range migrations {
+		m := &migrations[i]
+		if !dryRun {
+			log.Printf("Upgrading event database to version %d: %s", m.Version, m.Description)
+		}
+		if err := m.up(tx); err != nil {
+			return nil, fmt.Errorf("Upgrading database to version %d (%s): %v",
+				m.Version, m.Description, err)
+		}
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("pragma user_version=%d", codeSchemaVersion))
+	if err != nil {
+		return nil, fmt.Errorf("Setting user_version: %v", err)
+	}
+
+	if dryRun {
+		return res, nil
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("Committing database upgrade: %v", err)
+	}
+	return res, nil
+}
+
+// Migrate upgrades the event database in filename to the current
+// schema version, as happens automatically when it's loaded.  If
+// dryRun is set, nothing is changed, but the upgrade is tried out to
+// make sure it would succeed.  Databases which don't exist yet are
+// left alone.
+func Migrate(filename string, dryRun bool) (*MigrationResult, error) {
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return &MigrationResult{ToVersion: codeSchemaVersion}, nil
+	}
+
+	db, err := sqlx.Open("sqlite3", dbDSN(filename))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var version int
+	err = db.Get(&version, "pragma user_version")
+	if err != nil {
+		return nil, fmt.Errorf("Getting schema version: %v", err)
+	}
+	if version == 0 {
+		return &MigrationResult{ToVersion: codeSchemaVersion}, nil
+	}
+
+	return migrateDb(db, filename, version, dryRun)
+}
@@ -0,0 +1,151 @@
+package event
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestMigrate(t *testing.T) {
+	for i := range dbMigrations {
+		if i > 0 && dbMigrations[i].Version != dbMigrations[i-1].Version+1 {
+			t.Errorf("Migration %d (%s) has version %d, expected %d", i,
+				dbMigrations[i].Description, dbMigrations[i].Version, dbMigrations[i-1].Version+1)
+			return
+		}
+	}
+	if last := dbMigrations[len(dbMigrations)-1].Version; last != codeSchemaVersion {
+		t.Errorf("Last migration is to version %d, but codeSchemaVersion is %d", last, codeSchemaVersion)
+		return
+	}
+
+	tmpdir, err := ioutil.TempDir("", "event")
+	if err != nil {
+		t.Errorf("Creating temporary directory: %v", err)
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+
+	sfname := tmpdir + "/event.sqlite3"
+
+	if res, err := Migrate(sfname, false); err != nil || len(res.Steps) != 0 {
+		t.Errorf("Migrating non-existent database: got %v, err %v", res, err)
+		return
+	}
+	if _, err := os.Stat(sfname); !os.IsNotExist(err) {
+		t.Errorf("Migrating non-existent database created it")
+		return
+	}
+
+	db, err := openDb(sfname)
+	if err != nil {
+		t.Errorf("Creating database: %v", err)
+		return
+	}
+	db.Close()
+
+	setVersion := func(version int, setup ...string) bool {
+		db, err := sqlx.Open("sqlite3", dbDSN(sfname))
+		if err != nil {
+			t.Errorf("Opening database: %v", err)
+			return true
+		}
+		defer db.Close()
+		for _, q := range setup {
+			if _, err := db.Exec(q); err != nil {
+				t.Errorf("%s: %v", q, err)
+				return true
+			}
+		}
+		if _, err := db.Exec(fmt.Sprintf("pragma user_version=%d", version)); err != nil {
+			t.Errorf("Setting user version: %v", err)
+			return true
+		}
+		return false
+	}
+
+	getVersion := func(filename string) int {
+		db, err := sqlx.Open("sqlite3", dbDSN(filename))
+		if err != nil {
+			t.Errorf("Opening %s: %v", filename, err)
+			return -1
+		}
+		defer db.Close()
+		var version int
+		if err := db.Get(&version, "pragma user_version"); err != nil {
+			t.Errorf("Getting user version of %s: %v", filename, err)
+			return -1
+		}
+		return version
+	}
+
+	// Make it look like a version 10 database
+	if setVersion(10,
+		`drop table event_audit`,
+		`drop table event_pins`,
+		`alter table event_users drop column deleted`,
+		`alter table event_discussions drop column deleted`,
+		`alter table event_discussions drop column length`,
+		`alter table event_discussions drop column approvedlength`) {
+		return
+	}
+
+	t.Logf("Dry run")
+	res, err := Migrate(sfname, true)
+	if err != nil {
+		t.Errorf("Dry run: %v", err)
+		return
+	}
+	if res.FromVersion != 10 || res.ToVersion != codeSchemaVersion || len(res.Steps) != codeSchemaVersion-10 ||
+		res.Steps[0].Version != 11 || res.Backup != "" {
+		t.Errorf("Unexpected dry run result %v", res)
+		return
+	}
+	if v := getVersion(sfname); v != 10 {
+		t.Errorf("Dry run changed schema version to %d", v)
+		return
+	}
+
+	t.Logf("Migrating")
+	res, err = Migrate(sfname, false)
+	if err != nil {
+		t.Errorf("Migrating: %v", err)
+		return
+	}
+	if len(res.Steps) != codeSchemaVersion-10 || res.Backup == "" {
+		t.Errorf("Unexpected migration result %v", res)
+		return
+	}
+	if v := getVersion(sfname); v != codeSchemaVersion {
+		t.Errorf("Migrated database has version %d, expected %d", v, codeSchemaVersion)
+		return
+	}
+	if v := getVersion(res.Backup); v != 10 {
+		t.Errorf("Backup has version %d, expected 10", v)
+		return
+	}
+
+	if res, err := Migrate(sfname, false); err != nil || len(res.Steps) != 0 || res.Backup != "" {
+		t.Errorf("Migrating current database: got %v, err %v", res, err)
+		return
+	}
+
+	t.Logf("Migrating unsupported versions")
+	for _, version := range []int{1, codeSchemaVersion + 1} {
+		if setVersion(version) {
+			return
+		}
+		if _, err := Migrate(sfname, true); err == nil {
+			t.Errorf("Migrating from version %d succeeded", version)
+			return
+		}
+		if db, err := openDb(sfname); err == nil {
+			db.Close()
+			t.Errorf("Opening database with version %d succeeded", version)
+			return
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package event
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Rejection records why a moderator rejected a discussion, or
+// changes to it, so that the owner can fix them.
+type Rejection struct {
+	Reason   string
+	Rejected Time
+}
+
+// PendingDiscussion is a non-public discussion awaiting moderation.
+// If ApprovedTitle is empty, the discussion has never been approved;
+// otherwise Title and Description are edits to an approved
+// discussion.  Rejection is set if a moderator has rejected it and
+// the owner hasn't changed it since.
+type PendingDiscussion struct {
+	Discussion
+	OwnerUsername string
+	Rejection     *Rejection
+}
+
+// IsNew returns true if the discussion has never been approved.
+func (pd *PendingDiscussion) IsNew() bool {
+	return pd.ApprovedTitle == ""
+}
+
+// DiscussionGetPending returns all non-public discussions, oldest
+// first (as far as we can tell; discussions don't record when they
+// were created) with rejected ones last.
+func (store *EventStore) DiscussionGetPending() ([]PendingDiscussion, error) {
+	var pending []PendingDiscussion
+	err := store.txLoop(func(eq sqlx.Ext) error {
+		var rows []struct {
+			Discussion
+			OwnerUsername string
+			Reason        sql.NullString
+			Rejected      *Time
+		}
+		err := sqlx.Select(eq, &rows, `
+            select event_discussions.*,
+                   username as ownerusername,
+                   reason,
+                   rejected
+                from event_discussions
+                    join event_users on owner = userid
+                    natural left join event_discussion_rejections
+                where ispublic = false and event_discussions.deleted = 0
+                order by reason is not null, event_discussions.rowid`)
+		if err != nil {
+			return errOrRetry("Getting pending discussions", err)
+		}
+
+		pending = make([]PendingDiscussion, len(rows))
+		for i := range rows {
+			pending[i].Discussion = rows[i].Discussion
+			pending[i].OwnerUsername = rows[i].OwnerUsername
+			if rows[i].Reason.Valid {
+				pending[i].Rejection = &Rejection{
+					Reason:   rows[i].Reason.String,
+					Rejected: *rows[i].Rejected,
+				}
+			}
+		}
+		return nil
+	})
+	return pending, err
+}
+
+// DiscussionGetLengthRequests returns the discussions whose owners
+// have asked for a different length to the one approved.
+func (store *EventStore) DiscussionGetLengthRequests() ([]PendingDiscussion, error) {
+	var requests []PendingDiscussion
+	err := store.txLoop(func(eq sqlx.Ext) error {
+		err := sqlx.Select(eq, &requests, `
+            select event_discussions.*,
+                   username as ownerusername
+                from event_discussions
+                    join event_users on owner = userid
+                where length != approvedlength and event_discussions.deleted = 0
+                order by event_discussions.rowid`)
+		if err != nil {
+			return errOrRetry("Getting length requests", err)
+		}
+		return nil
+	})
+	return requests, err
+}
+
+// DiscussionGetRejection returns why discussionid was rejected, or
+// nil if it hasn't been (or has been changed since).
+func (store *EventStore) DiscussionGetRejection(discussionid DiscussionID) (*Rejection, error) {
+	var rej Rejection
+	for {
+		err := store.Get(&rej, `
+            select reason, rejected
+                from event_discussion_rejections
+                where discussionid = ?`, discussionid)
+		switch {
+		case shouldRetry(err):
+			continue
+		case err == sql.ErrNoRows:
+			return nil, nil
+		case err != nil:
+			return nil, err
+		}
+		return &rej, nil
+	}
+}
+
+// DiscussionReject rejects a discussion awaiting moderation, giving
+// a reason to show to the owner.  If the discussion has been approved
+// before, the pending changes are discarded and it reverts to the
+// approved version; otherwise it stays non-public until the owner
+// changes it and it's approved.  actor is the moderator rejecting it.
+func (store *EventStore) DiscussionReject(actor UserID, discussionid DiscussionID, reason string) error {
+	if reason == "" || AllWhitespace(reason) {
+		return errRejectNoReason
+	}
+
+	return store.txLoop(func(eq sqlx.Ext) error {
+		var d Discussion
+		err := discussionGetTx(eq, discussionid, &d)
+		if err == sql.ErrNoRows {
+			return ErrDiscussionNotFound
+		} else if err != nil {
+			return errOrRetry("Getting discussion", err)
+		}
+
+		if d.IsPublic {
+			return errRejectPublic
+		}
+
+		if d.ApprovedTitle != "" {
+			_, err = eq.Exec(`
+                update event_discussions
+                    set title = approvedtitle,
+                        description = approveddescription,
+                        ispublic = true
+                    where discussionid = ?`, discussionid)
+			if err != nil {
+				return errOrRetry("Reverting discussion", err)
+			}
+		}
+
+		_, err = eq.Exec(`
+            insert into event_discussion_rejections(discussionid, reason, rejected)
+                values(?, ?, ?)
+                on conflict(discussionid) do update
+                    set reason = excluded.reason, rejected = excluded.rejected`,
+			discussionid, reason, Time{time.Now()})
+		if err != nil {
+			return errOrRetry("Recording rejection", err)
+		}
+
+		var after struct {
+			Discussion
+			Reason string
+		}
+		if err := discussionGetTx(eq, discussionid, &after.Discussion); err != nil {
+			return errOrRetry("Getting discussion", err)
+		}
+		after.Reason = reason
+		err = auditTx(eq, actor, AuditDiscussionReject, string(discussionid), &d, &after)
+		if err != nil {
+			return err
+		}
+
+		return schedMarkModifiedTx(eq)
+	})
+}
+
+func clearRejectionTx(eq sqlx.Ext, discussionid DiscussionID) error {
+	_, err := eq.Exec(`
+        delete from event_discussion_rejections where discussionid = ?`,
+		discussionid)
+	if err != nil {
+		return errOrRetry("Clearing rejection", err)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package event
+
+import (
+	"testing"
+)
+
+func testUnitModeration(t *testing.T) (exit bool) {
+	// Any "early" exit is a failure
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+
+	m := &mirrorData{}
+
+	if testNewUsers(t, m, 2) {
+		return
+	}
+
+	var verified, unverified *User
+	for i := range m.users {
+		if m.users[i].IsVerified {
+			verified = &m.users[i]
+		} else {
+			unverified = &m.users[i]
+		}
+	}
+
+	t.Logf("Creating discussions")
+	public := Discussion{Owner: verified.UserID, Title: "Public", Description: "Approved text"}
+	fresh := Discussion{Owner: unverified.UserID, Title: "Fresh", Description: "Never approved"}
+	for _, d := range []*Discussion{&public, &fresh} {
+		if err := event.NewDiscussion(d); err != nil {
+			t.Errorf("NewDiscussion: %v", err)
+			return
+		}
+	}
+
+	// Edits to the public discussion by an unverified owner need
+	// moderation
+	public.Owner = unverified.UserID
+	public.Description = "Edited text"
+	if err := event.DiscussionUpdate("", &public); err != nil {
+		t.Errorf("DiscussionUpdate: %v", err)
+		return
+	}
+
+	pending, err := event.DiscussionGetPending()
+	if err != nil || len(pending) != 2 {
+		t.Errorf("DiscussionGetPending: got %v, err %v", pending, err)
+		return
+	}
+	for i := range pending {
+		pd := &pending[i]
+		if pd.OwnerUsername != unverified.Username || pd.Rejection != nil {
+			t.Errorf("Unexpected pending discussion %v", pd)
+			return
+		}
+		switch pd.DiscussionID {
+		case public.DiscussionID:
+			if pd.IsNew() || pd.ApprovedDescription != "Approved text" || pd.Description != "Edited text" {
+				t.Errorf("Unexpected pending edit %v", pd)
+				return
+			}
+		case fresh.DiscussionID:
+			if !pd.IsNew() {
+				t.Errorf("Unexpected pending discussion %v", pd)
+				return
+			}
+		}
+	}
+
+	t.Logf("Rejecting discussions")
+	if err := event.DiscussionReject("", public.DiscussionID, " "); err != errRejectNoReason {
+		t.Errorf("Rejecting without reason: expected errRejectNoReason, got %v", err)
+		return
+	}
+	if err := event.DiscussionReject("", DiscussionID("invalid"), "No"); err != ErrDiscussionNotFound {
+		t.Errorf("Rejecting invalid discussion: expected ErrDiscussionNotFound, got %v", err)
+		return
+	}
+
+	const reason = "Please don't"
+	for _, did := range []DiscussionID{public.DiscussionID, fresh.DiscussionID} {
+		if err := event.DiscussionReject("", did, reason); err != nil {
+			t.Errorf("DiscussionReject: %v", err)
+			return
+		}
+		if rej, err := event.DiscussionGetRejection(did); err != nil || rej == nil || rej.Reason != reason {
+			t.Errorf("DiscussionGetRejection: got %v, err %v", rej, err)
+			return
+		}
+	}
+
+	// Rejected edits revert to the approved version
+	df, _ := event.DiscussionFindByIdFull(public.DiscussionID)
+	if df == nil || !df.IsPublic || df.Description != "Approved text" {
+		t.Errorf("Rejected edit not reverted: %v", df)
+		return
+	}
+	if err := event.DiscussionReject("", public.DiscussionID, reason); err != errRejectPublic {
+		t.Errorf("Rejecting public discussion: expected errRejectPublic, got %v", err)
+		return
+	}
+
+	// ...while rejected new discussions stay pending
+	pending, err = event.DiscussionGetPending()
+	if err != nil || len(pending) != 1 || pending[0].DiscussionID != fresh.DiscussionID ||
+		pending[0].Rejection == nil || pending[0].Rejection.Reason != reason {
+		t.Errorf("DiscussionGetPending after rejection: got %v, err %v", pending, err)
+		return
+	}
+
+	t.Logf("Resubmitting and approving")
+	fresh.Description = "Improved"
+	if err := event.DiscussionUpdate("", &fresh); err != nil {
+		t.Errorf("DiscussionUpdate: %v", err)
+		return
+	}
+	if rej, err := event.DiscussionGetRejection(fresh.DiscussionID); err != nil || rej != nil {
+		t.Errorf("Rejection not cleared by edit: got %v, err %v", rej, err)
+		return
+	}
+
+	if err := event.DiscussionReject("", fresh.DiscussionID, reason); err != nil {
+		t.Errorf("DiscussionReject: %v", err)
+		return
+	}
+	if err := event.DiscussionSetPublic("", fresh.DiscussionID, true); err != nil {
+		t.Errorf("DiscussionSetPublic: %v", err)
+		return
+	}
+	if rej, err := event.DiscussionGetRejection(fresh.DiscussionID); err != nil || rej != nil {
+		t.Errorf("Rejection not cleared by approval: got %v, err %v", rej, err)
+		return
+	}
+	if pending, err := event.DiscussionGetPending(); err != nil || len(pending) != 0 {
+		t.Errorf("DiscussionGetPending after approval: got %v, err %v", pending, err)
+		return
+	}
+
+	// The rejection of the edit is still there to show the owner,
+	// and has to be deleted along with the discussion
+	if err := event.DeleteUser("", unverified.UserID, ""); err != nil {
+		t.Errorf("DeleteUser: %v", err)
+		return
+	}
+
+	tc.cleanup()
+
+	return false
+}
@@ -0,0 +1,205 @@
+package event
+
+import (
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Pin puts a discussion in a particular slot (its first slot, if it's
+// longer than one), and optionally a particular location.  The
+// scheduler leaves pinned discussions where they are, and schedules
+// everything else around them.
+type Pin struct {
+	SlotID     SlotID
+	LocationID LocationID `json:",omitempty"` // 0 to let the scheduler choose
+}
+
+// pinGetTx returns discussionid's pin, or nil if it doesn't have one.
+func pinGetTx(q sqlx.Queryer, discussionid DiscussionID) (*Pin, error) {
+	var pin Pin
+	err := sqlx.Get(q, &pin, `
+        select slotid, ifnull(locationid, 0) as locationid
+            from event_pins
+            where discussionid = ?`, discussionid)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, errOrRetry("Getting pin", err)
+	}
+	return &pin, nil
+}
+
+// pinSlotsTx returns the slots a discussion length slots long takes
+// up, if it starts in slotid.  They must all be on the same day, and
+// none of them breaks or locked; otherwise errPinSlot is returned.
+func pinSlotsTx(q sqlx.Queryer, slotid SlotID, length int) ([]SlotID, error) {
+	var first struct {
+		DayID   DayID
+		SlotIDX int
+	}
+	err := sqlx.Get(q, &first, `
+        select dayid, slotidx from event_slots where slotid = ?`, slotid)
+	if err == sql.ErrNoRows {
+		return nil, errSlotNotFound
+	} else if err != nil {
+		return nil, errOrRetry("Getting pinned slot", err)
+	}
+
+	var slots []struct {
+		SlotID   SlotID
+		IsBreak  bool
+		IsLocked bool
+	}
+	err = sqlx.Select(q, &slots, `
+        select slotid, isbreak, islocked
+            from event_slots
+            where dayid = ? and slotidx >= ? and slotidx < ?
+            order by slotidx`, first.DayID, first.SlotIDX, first.SlotIDX+length)
+	if err != nil {
+		return nil, errOrRetry("Getting pinned slots", err)
+	}
+	if len(slots) != length {
+		return nil, errPinSlot
+	}
+
+	slotids := make([]SlotID, len(slots))
+	for i := range slots {
+		if slots[i].IsBreak || slots[i].IsLocked {
+			return nil, errPinSlot
+		}
+		slotids[i] = slots[i].SlotID
+	}
+	return slotids, nil
+}
+
+// DiscussionSetPin pins discussionid to pin, or unpins it if pin is
+// nil.  The pin takes effect the next time the scheduler is run.
+// Discussions can't be pinned while they're in a locked slot, nor
+// pinned to the same location as another discussion at the same time,
+// nor pinned to a time when all the locations are taken by pinned
+// discussions.  actor is the admin making the change.
+func (store *EventStore) DiscussionSetPin(actor UserID, discussionid DiscussionID, pin *Pin) error {
+	return store.txLoop(func(eq sqlx.Ext) error {
+		var disc Discussion
+		err := discussionGetTx(eq, discussionid, &disc)
+		if err == sql.ErrNoRows {
+			return ErrDiscussionNotFound
+		} else if err != nil {
+			return errOrRetry("Getting discussion", err)
+		}
+
+		before, err := pinGetTx(eq, discussionid)
+		if err != nil {
+			return err
+		}
+
+		var locked int
+		err = sqlx.Get(eq, &locked, `
+            select count(*)
+                from event_schedule natural join event_slots
+                where discussionid = ? and islocked = true`, discussionid)
+		if err != nil {
+			return errOrRetry("Checking for locked slots", err)
+		}
+		if locked > 0 {
+			return errScheduleLocked
+		}
+
+		if pin == nil {
+			_, err = eq.Exec(`delete from event_pins where discussionid = ?`, discussionid)
+			if err != nil {
+				return errOrRetry("Unpinning discussion", err)
+			}
+		} else {
+			if err := pinCheckTx(eq, &disc, pin); err != nil {
+				return err
+			}
+
+			_, err = eq.Exec(`
+                insert or replace into event_pins(discussionid, slotid, locationid)
+                    values(?, ?, nullif(?, 0))`,
+				discussionid, pin.SlotID, pin.LocationID)
+			if err != nil {
+				return errOrRetry("Pinning discussion", err)
+			}
+		}
+
+		err = auditTx(eq, actor, AuditDiscussionSetPin, string(discussionid),
+			struct{ Pin *Pin }{before}, struct{ Pin *Pin }{pin})
+		if err != nil {
+			return err
+		}
+
+		return schedMarkModifiedTx(eq)
+	})
+}
+
+// pinCheckTx checks that disc can be pinned to pin, given the other
+// pins.  Pins which no longer fit (e.g., because a slot has become a
+// break) are ignored by the scheduler, and so are here too.
+func pinCheckTx(eq sqlx.Ext, disc *Discussion, pin *Pin) error {
+	slots, err := pinSlotsTx(eq, pin.SlotID, disc.ApprovedLength)
+	if err != nil {
+		return err
+	}
+
+	var nlocations int
+	err = sqlx.Get(eq, &nlocations, `select count(*) from event_locations`)
+	if err != nil {
+		return errOrRetry("Counting locations", err)
+	}
+	if nlocations == 0 {
+		return errPinFull
+	}
+	if pin.LocationID != 0 {
+		var count int
+		err = sqlx.Get(eq, &count, `
+            select count(*) from event_locations where locationid = ?`, pin.LocationID)
+		if err != nil {
+			return errOrRetry("Checking location", err)
+		}
+		if count == 0 {
+			return ErrLocationNotFound
+		}
+	}
+
+	var others []struct {
+		Pin
+		Length int
+	}
+	err = sqlx.Select(eq, &others, `
+        select slotid, ifnull(locationid, 0) as locationid, approvedlength as length
+            from event_pins join event_discussions using(discussionid)
+            where discussionid != ? and deleted = 0`, disc.DiscussionID)
+	if err != nil {
+		return errOrRetry("Getting other pins", err)
+	}
+
+	pinned := map[SlotID]int{}
+	for _, slotid := range slots {
+		pinned[slotid] = 1
+	}
+	for i := range others {
+		other := &others[i]
+		oslots, err := pinSlotsTx(eq, other.SlotID, other.Length)
+		if IsValidationError(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		for _, slotid := range oslots {
+			if _, ok := pinned[slotid]; !ok {
+				continue
+			}
+			if pin.LocationID != 0 && other.LocationID == pin.LocationID {
+				return errPinClash
+			}
+			pinned[slotid]++
+			if pinned[slotid] > nlocations {
+				return errPinFull
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package event
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func testUnitPin(t *testing.T) (exit bool) {
+	// Any "early" exit is a failure
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+
+	m := &mirrorData{}
+	if testNewUsers(t, m, 4) {
+		return
+	}
+	for i := range m.users {
+		disc, subexit := testNewDiscussion(t, m.users[i].UserID)
+		if subexit {
+			return
+		}
+		if err := event.DiscussionSetPublic("", disc.DiscussionID, true); err != nil {
+			t.Errorf("DiscussionSetPublic: %v", err)
+			return
+		}
+		m.discussions = append(m.discussions, disc)
+	}
+
+	var locations []LocationID
+	for i := 0; i < 2; i++ {
+		loc := Location{LocationName: fmt.Sprintf("Room %d", i+1), IsPlace: true, Capacity: 100}
+		lid, err := event.NewLocation("", &loc)
+		if err != nil {
+			t.Errorf("NewLocation: %v", err)
+			return
+		}
+		locations = append(locations, lid)
+	}
+
+	tt := Timetable{
+		Days: []TimetableDay{
+			{DayName: "Monday", Slots: []TimetableSlot{
+				{Time: Date(2020, 7, 6, 14, 30, 0, 0, time.UTC)},
+				{Time: Date(2020, 7, 6, 15, 15, 0, 0, time.UTC)},
+				{Time: Date(2020, 7, 6, 16, 0, 0, 0, time.UTC)},
+			}},
+		},
+	}
+	if err := event.TimetableSet("", &tt, ImpactAbort); err != nil {
+		t.Errorf("TimetableSet: %v", err)
+		return
+	}
+	var slots []SlotID
+	if err := event.Select(&slots, `select slotid from event_slots order by slotidx`); err != nil {
+		t.Errorf("Getting slots: %v", err)
+		return
+	}
+
+	keynote, other := m.discussions[0].DiscussionID, m.discussions[1].DiscussionID
+
+	t.Logf("Pinning discussions")
+	if err := event.DiscussionSetPin("", keynote, &Pin{SlotID: "slot_nonexistent"}); err != errSlotNotFound {
+		t.Errorf("Expected errSlotNotFound, got %v", err)
+		return
+	}
+	if err := event.DiscussionSetPin("", keynote, &Pin{SlotID: slots[1], LocationID: locations[1]}); err != nil {
+		t.Errorf("DiscussionSetPin: %v", err)
+		return
+	}
+	if err := event.DiscussionSetPin("", other, &Pin{SlotID: slots[1], LocationID: locations[1]}); err != errPinClash {
+		t.Errorf("Expected errPinClash, got %v", err)
+		return
+	}
+	if err := event.DiscussionSetPin("", other, &Pin{SlotID: slots[1]}); err != nil {
+		t.Errorf("DiscussionSetPin: %v", err)
+		return
+	}
+	if err := event.DiscussionSetPin("", m.discussions[2].DiscussionID, &Pin{SlotID: slots[1]}); err != errPinFull {
+		t.Errorf("Expected errPinFull, got %v", err)
+		return
+	}
+
+	t.Logf("Pinning a longer discussion where it won't fit")
+	long := m.discussions[3].DiscussionID
+	if err := event.DiscussionSetApprovedLength("", long, 2); err != nil {
+		t.Errorf("DiscussionSetApprovedLength: %v", err)
+		return
+	}
+	if err := event.DiscussionSetPin("", long, &Pin{SlotID: slots[2]}); err != errPinSlot {
+		t.Errorf("Expected errPinSlot, got %v", err)
+		return
+	}
+	if err := event.DiscussionSetPin("", long, &Pin{SlotID: slots[0]}); err != errPinFull {
+		t.Errorf("Expected errPinFull, got %v", err)
+		return
+	}
+	if err := event.DiscussionSetApprovedLength("", long, 1); err != nil {
+		t.Errorf("DiscussionSetApprovedLength: %v", err)
+		return
+	}
+
+	df, err := event.DiscussionFindByIdFull(keynote)
+	if err != nil || df == nil {
+		t.Errorf("DiscussionFindByIdFull: %v", err)
+		return
+	}
+	if df.Pin == nil || *df.Pin != (Pin{SlotID: slots[1], LocationID: locations[1]}) {
+		t.Errorf("Unexpected pin %v", df.Pin)
+		return
+	}
+
+	// checkPinned checks that the pinned discussions are where
+	// they're pinned, and everything else was scheduled around them.
+	checkPinned := func() bool {
+		var schedule []scheduleEntry
+		err := sqlx.Select(event.DB, &schedule,
+			`select discussionid, slotid, locationid from event_schedule`)
+		if err != nil {
+			t.Errorf("Getting schedule: %v", err)
+			return true
+		}
+		if len(schedule) != len(m.discussions) {
+			t.Errorf("Expected everything to be scheduled, got %v", schedule)
+			return true
+		}
+		found := 0
+		for _, e := range schedule {
+			switch e.DiscussionID {
+			case keynote:
+				if e.SlotID != slots[1] || e.LocationID != locations[1] {
+					t.Errorf("Pinned discussion scheduled in %v", e)
+					return true
+				}
+				found++
+			case other:
+				if e.SlotID != slots[1] {
+					t.Errorf("Pinned discussion scheduled in %v", e)
+					return true
+				}
+				found++
+			}
+		}
+		if found != 2 {
+			t.Errorf("Expected both pinned discussions scheduled, got %v", schedule)
+			return true
+		}
+		return false
+	}
+
+	t.Logf("Scheduling around pins")
+	if err := event.MakeSchedule(SearchOptions{Validate: true}); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+	if checkPinned() {
+		return
+	}
+
+	opt := SearchOptions{Algo: SearchGenetic, SearchDuration: 100 * time.Millisecond, Validate: true}
+	if err := event.MakeSchedule(opt); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+	if checkPinned() {
+		return
+	}
+
+	t.Logf("Changing pins of locked discussions")
+	if err := event.TimetableSetLockedSlots("", []SlotID{slots[1]}); err != nil {
+		t.Errorf("TimetableSetLockedSlots: %v", err)
+		return
+	}
+	if err := event.DiscussionSetPin("", keynote, nil); err != errScheduleLocked {
+		t.Errorf("Expected errScheduleLocked, got %v", err)
+		return
+	}
+	if err := event.TimetableSetLockedSlots("", nil); err != nil {
+		t.Errorf("TimetableSetLockedSlots: %v", err)
+		return
+	}
+
+	t.Logf("Deleting a location discussions are pinned to")
+	if err := event.DeleteLocation("", locations[1], ImpactUnschedule); err != nil {
+		t.Errorf("DeleteLocation: %v", err)
+		return
+	}
+	if df, err = event.DiscussionFindByIdFull(keynote); err != nil || df == nil {
+		t.Errorf("DiscussionFindByIdFull: %v", err)
+		return
+	}
+	if df.Pin == nil || *df.Pin != (Pin{SlotID: slots[1]}) {
+		t.Errorf("Expected pin to lose its location, got %v", df.Pin)
+		return
+	}
+
+	t.Logf("Unpinning")
+	if err := event.DiscussionSetPin("", other, nil); err != nil {
+		t.Errorf("DiscussionSetPin: %v", err)
+		return
+	}
+	if df, err = event.DiscussionFindByIdFull(other); err != nil || df == nil || df.Pin != nil {
+		t.Errorf("Expected discussion to be unpinned, got %v (%v)", df, err)
+		return
+	}
+
+	tc.cleanup()
+
+	return false
+}
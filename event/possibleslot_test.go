@@ -95,7 +95,7 @@ func testUnitPossibleSlots(t *testing.T) (exit bool) {
 		}
 	}
 
-	err := TimetableSet(&tt)
+	err := event.TimetableSet("", &tt, ImpactAbort)
 	if err != nil {
 		t.Errorf("ERROR Basic TimetableSet: %v", err)
 		return
@@ -107,7 +107,7 @@ func testUnitPossibleSlots(t *testing.T) (exit bool) {
 
 	// Get slots for non-set discussions, should all be 'true'
 	t.Logf("Checking to see that default is all slots")
-	gotdisc, err := DiscussionFindByIdFull(discussions[0].DiscussionID)
+	gotdisc, err := event.DiscussionFindByIdFull(discussions[0].DiscussionID)
 	if err != nil {
 		t.Errorf("Finding the discussion we just created by ID: %v", err)
 		return
@@ -125,7 +125,7 @@ func testUnitPossibleSlots(t *testing.T) (exit bool) {
 		tmp[i].Checked = false
 	}
 	fmt.Printf(" [0] %v\n", possibleslots[0])
-	err = DiscussionSetPossibleSlots(discussions[0].DiscussionID, CheckedToSlotList(tmp))
+	err = event.DiscussionSetPossibleSlots("", discussions[0].DiscussionID, CheckedToSlotList(tmp))
 	if err != nil {
 		t.Errorf("Setting possible slots: %v", err)
 		return
@@ -136,7 +136,7 @@ func testUnitPossibleSlots(t *testing.T) (exit bool) {
 		possibleslots[1][i] = false
 		tmp[i].Checked = false
 	}
-	err = DiscussionSetPossibleSlots(discussions[1].DiscussionID, CheckedToSlotList(tmp))
+	err = event.DiscussionSetPossibleSlots("", discussions[1].DiscussionID, CheckedToSlotList(tmp))
 	if err != nil {
 		t.Errorf("Setting possible slots: %v", err)
 		return
@@ -144,7 +144,7 @@ func testUnitPossibleSlots(t *testing.T) (exit bool) {
 	fmt.Printf(" [0] %v\n", possibleslots[0])
 
 	for i := range discussions {
-		gotdisc, err = DiscussionFindByIdFull(discussions[i].DiscussionID)
+		gotdisc, err = event.DiscussionFindByIdFull(discussions[i].DiscussionID)
 		if err != nil {
 			t.Errorf("Finding the discussion we just created by ID: %v", err)
 			return
@@ -160,7 +160,7 @@ func testUnitPossibleSlots(t *testing.T) (exit bool) {
 	t.Logf("Adding a slot, making sure we get what we expect")
 	tt.Days[0].Slots = append(tt.Days[0].Slots,
 		TimetableSlot{Time: Date(2020, 7, 6, 17, 15, 0, 0, time.UTC)})
-	err = TimetableSet(&tt)
+	err = event.TimetableSet("", &tt, ImpactAbort)
 	if err != nil {
 		t.Errorf("ERROR Day / slot add failed: %v", err)
 		return
@@ -179,7 +179,7 @@ func testUnitPossibleSlots(t *testing.T) (exit bool) {
 		possibleslots[i] = n
 	}
 	for i := range discussions {
-		gotdisc, err = DiscussionFindByIdFull(discussions[i].DiscussionID)
+		gotdisc, err = event.DiscussionFindByIdFull(discussions[i].DiscussionID)
 		if err != nil {
 			t.Errorf("Finding the discussion we just created by ID: %v", err)
 			return
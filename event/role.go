@@ -0,0 +1,115 @@
+package event
+
+import (
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Role determines what a user may do beyond editing their own
+// profile and discussions.  Each role has all the permissions of the
+// roles before it in Roles.
+type Role string
+
+const (
+	RoleAttendee         = Role("attendee")
+	RoleModerator        = Role("moderator")
+	RoleProgramCommittee = Role("committee")
+	RoleAdmin            = Role("admin")
+)
+
+// Roles lists all roles, from least to most privileged.
+var Roles = []Role{RoleAttendee, RoleModerator, RoleProgramCommittee, RoleAdmin}
+
+func (r Role) rank() int {
+	for i := range Roles {
+		if Roles[i] == r {
+			return i
+		}
+	}
+	return -1
+}
+
+// Valid returns true if r is one of Roles.
+func (r Role) Valid() bool {
+	return r.rank() >= 0
+}
+
+// AtLeast returns true if r has all the permissions of o.
+func (r Role) AtLeast(o Role) bool {
+	return r.Valid() && r.rank() >= o.rank()
+}
+
+// Description returns a human-readable name for r.
+func (r Role) Description() string {
+	switch r {
+	case RoleAttendee:
+		return "Attendee"
+	case RoleModerator:
+		return "Moderator"
+	case RoleProgramCommittee:
+		return "Program committee"
+	case RoleAdmin:
+		return "Administrator"
+	}
+	return string(r)
+}
+
+// MayModerate returns true if u may approve discussions and verify
+// users.
+func (u *User) MayModerate() bool {
+	return u.IsAdmin || u.Role.AtLeast(RoleModerator)
+}
+
+// IsAdminAccount returns true if u is the built-in administrator
+// account (as opposed to a user who has been given the admin role).
+// That account can't express interest in discussions, and its role
+// can't be changed.
+func (u *User) IsAdminAccount() bool {
+	return u.Username == AdminUsername
+}
+
+// CheckRole returns the validation error UserSetRole would return for
+// giving u role, or nil if it can be given it; so that callers making
+// other changes at the same time can check first.
+func (u *User) CheckRole(role Role) error {
+	if !role.Valid() {
+		return errRoleInvalid
+	}
+	if u.IsAdminAccount() && role != RoleAdmin {
+		return errRoleAdminAccount
+	}
+	return nil
+}
+
+// UserSetRole changes userid's role.  IsAdmin is kept in sync: users
+// with the admin role have full administrative rights.  actor is the
+// user making the change.
+func (store *EventStore) UserSetRole(actor UserID, userid UserID, role Role) error {
+	if !role.Valid() {
+		return errRoleInvalid
+	}
+
+	return store.txLoop(func(eq sqlx.Ext) error {
+		var user User
+		err := userGetTx(eq, userid, &user)
+		if err == sql.ErrNoRows {
+			return ErrUserNotFound
+		} else if err != nil {
+			return errOrRetry("Getting user", err)
+		}
+
+		if err := user.CheckRole(role); err != nil {
+			return err
+		}
+
+		_, err = eq.Exec(`
+            update event_users set role = ?, isadmin = ? where userid = ?`,
+			role, role == RoleAdmin, userid)
+		if err != nil {
+			return errOrRetry("Setting user role", err)
+		}
+		return auditTx(eq, actor, AuditUserSetRole, string(userid),
+			struct{ Role Role }{user.Role}, struct{ Role Role }{role})
+	})
+}
@@ -0,0 +1,125 @@
+package event
+
+import (
+	"testing"
+)
+
+func testUnitRoles(t *testing.T) (exit bool) {
+	// Any "early" exit is a failure
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+
+	m := &mirrorData{}
+
+	if testNewUsers(t, m, 3) {
+		return
+	}
+
+	owner := &m.users[0]
+	moderator := &m.users[1]
+	committee := &m.users[2]
+
+	for i := range m.users {
+		if m.users[i].Role != RoleAttendee {
+			t.Errorf("New user has role %q, expected %q", m.users[i].Role, RoleAttendee)
+			return
+		}
+	}
+
+	admin, err := event.UserFindByUsername(AdminUsername)
+	if err != nil || admin == nil || admin.Role != RoleAdmin || !admin.IsAdminAccount() {
+		t.Errorf("Admin account: got %v, err %v", admin, err)
+		return
+	}
+
+	t.Logf("Checking roles")
+	if err := moderator.CheckRole(Role("overlord")); err != errRoleInvalid {
+		t.Errorf("Invalid role: expected errRoleInvalid, got %v", err)
+		return
+	}
+	if err := admin.CheckRole(RoleAttendee); err != errRoleAdminAccount {
+		t.Errorf("Demoting admin account: expected errRoleAdminAccount, got %v", err)
+		return
+	}
+	if err := moderator.CheckRole(RoleModerator); err != nil {
+		t.Errorf("CheckRole: %v", err)
+		return
+	}
+
+	t.Logf("Setting roles")
+	if err := event.UserSetRole("", moderator.UserID, Role("overlord")); err != errRoleInvalid {
+		t.Errorf("Invalid role: expected errRoleInvalid, got %v", err)
+		return
+	}
+	if err := event.UserSetRole("", UserID("invalid"), RoleModerator); err != ErrUserNotFound {
+		t.Errorf("Invalid user: expected ErrUserNotFound, got %v", err)
+		return
+	}
+	if err := event.UserSetRole("", admin.UserID, RoleAttendee); err != errRoleAdminAccount {
+		t.Errorf("Demoting admin account: expected errRoleAdminAccount, got %v", err)
+		return
+	}
+
+	for _, tgt := range []struct {
+		user *User
+		role Role
+	}{{moderator, RoleModerator}, {committee, RoleProgramCommittee}} {
+		if err := event.UserSetRole("", tgt.user.UserID, tgt.role); err != nil {
+			t.Errorf("UserSetRole: %v", err)
+			return
+		}
+		tgt.user.Role = tgt.role
+
+		u, _ := event.UserFind(tgt.user.UserID)
+		if u == nil || !compareUsers(u, tgt.user, t) {
+			t.Errorf("User doesn't match after setting role")
+			return
+		}
+	}
+
+	t.Logf("Checking permissions")
+	disc := Discussion{Owner: owner.UserID, Title: "Roles", Description: "Test"}
+	if err := event.NewDiscussion(&disc); err != nil {
+		t.Errorf("NewDiscussion: %v", err)
+		return
+	}
+
+	if owner.MayModerate() || !moderator.MayModerate() || !committee.MayModerate() || !admin.MayModerate() {
+		t.Errorf("Unexpected MayModerate results")
+		return
+	}
+	if moderator.MayEditDiscussion(&disc) || !committee.MayEditDiscussion(&disc) {
+		t.Errorf("Unexpected MayEditDiscussion results")
+		return
+	}
+	if moderator.MayEditUser(owner) || committee.MayEditUser(owner) {
+		t.Errorf("Non-admins may edit other users")
+		return
+	}
+
+	t.Logf("Promoting to admin")
+	if err := event.UserSetRole("", committee.UserID, RoleAdmin); err != nil {
+		t.Errorf("UserSetRole: %v", err)
+		return
+	}
+	if u, _ := event.UserFind(committee.UserID); u == nil || !u.IsAdmin || u.IsAdminAccount() || !u.MayEditUser(owner) {
+		t.Errorf("Promoted user doesn't have admin rights: %v", u)
+		return
+	}
+	if err := event.UserSetRole("", committee.UserID, RoleAttendee); err != nil {
+		t.Errorf("UserSetRole: %v", err)
+		return
+	}
+	if u, _ := event.UserFind(committee.UserID); u == nil || u.IsAdmin || u.MayModerate() {
+		t.Errorf("Demoted user still has admin rights: %v", u)
+		return
+	}
+
+	tc.cleanup()
+
+	return false
+}
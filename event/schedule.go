@@ -7,15 +7,14 @@ import (
 	"time"
 
 	"github.com/jmoiron/sqlx"
-	//"github.com/hako/durafmt"
 )
 
 type SearchAlgo string
 
 const (
 	SearchHeuristicOnly = SearchAlgo("heuristic")
-	//SearchGenetic       = SearchAlgo("genetic")
-	SearchRandom = SearchAlgo("random")
+	SearchGenetic       = SearchAlgo("genetic")
+	SearchRandom        = SearchAlgo("random")
 )
 
 type SearchOptions struct {
@@ -25,16 +24,15 @@ type SearchOptions struct {
 	DebugLevel     int
 	SearchDuration time.Duration
 	Debug          *log.Logger
-}
 
-var opt SearchOptions
+	// Who asked for the schedule to be made, for the audit log
+	Actor UserID
+}
 
-func SchedLastUpdate() string {
-	lastUpdate := "Never"
-	// if event.ScheduleV2 != nil {
-	// 	lastUpdate = durafmt.ParseShort(time.Since(event.ScheduleV2.Created)).String() + " ago"
-	// }
-	return lastUpdate
+func (opt *SearchOptions) debugf(level int, format string, v ...interface{}) {
+	if opt.Debug != nil && opt.DebugLevel >= level {
+		opt.Debug.Printf(format, v...)
+	}
 }
 
 type SchedState int
@@ -45,15 +43,171 @@ const (
 	SchedStateRunning
 )
 
-func SchedGetState() SchedState {
-	return SchedStateCurrent
+// The state of the scheduler is kept in a single row of
+// event_scheduler:
+//
+// isrunning: A scheduler run is in progress.  Set by schedStart(),
+// cleared by schedFinish() (or at Load() if we crashed mid-run).
+//
+// ismodified: Something which affects the schedule (interest,
+// discussions, slots or locations) has changed since the last
+// successful run started.  Set by schedMarkModified[Tx](), cleared
+// by schedStart(), and set again by schedFinish() if the run failed.
+//
+// laststart, lastfinish: Unix time of the start and end of the last
+// run; 0 if there has never been one.
+//
+// lastresult: The error from the last run, or "" if it succeeded.
+type schedStatus struct {
+	IsRunning  bool
+	IsModified bool
+	LastStart  int64
+	LastFinish int64
+	LastResult string
+}
+
+func (store *EventStore) schedGetStatus() (schedStatus, error) {
+	var status schedStatus
+	err := store.txLoop(func(eq sqlx.Ext) error {
+		err := sqlx.Get(eq, &status, `select * from event_scheduler`)
+		if err != nil {
+			return errOrRetry("Getting scheduler status", err)
+		}
+		return nil
+	})
+	return status, err
+}
+
+func schedMarkModifiedTx(e sqlx.Execer) error {
+	_, err := e.Exec(`update event_scheduler set ismodified = true`)
+	if err != nil {
+		return errOrRetry("Marking schedule modified", err)
+	}
+	return nil
+}
+
+// schedMarkModified is for mutators which don't use txLoop
+func (store *EventStore) schedMarkModified() error {
+	return store.txLoop(func(eq sqlx.Ext) error {
+		return schedMarkModifiedTx(eq)
+	})
+}
+
+// schedStart marks the scheduler as running, returning errInProgress
+// if it's already running.
+func (store *EventStore) schedStart(opt *SearchOptions) error {
+	return store.txLoop(func(eq sqlx.Ext) error {
+		var isRunning bool
+		err := sqlx.Get(eq, &isRunning, `select isrunning from event_scheduler`)
+		if err != nil {
+			return errOrRetry("Getting scheduler running state", err)
+		}
+		if isRunning {
+			return errInProgress
+		}
+		_, err = eq.Exec(`
+            update event_scheduler
+                set isrunning = true,
+                    ismodified = false,
+                    laststart = ?`, time.Now().Unix())
+		if err != nil {
+			return errOrRetry("Setting scheduler running", err)
+		}
+		return auditTx(eq, opt.Actor, AuditScheduleRun, "", nil,
+			struct {
+				Algo           SearchAlgo
+				SearchDuration string
+			}{opt.Algo, opt.SearchDuration.String()})
+	})
+}
+
+// schedFinish records the result of a run started by schedStart.
+func (store *EventStore) schedFinish(result error) {
+	lastResult := ""
+	if result != nil {
+		lastResult = result.Error()
+	}
+	err := store.txLoop(func(eq sqlx.Ext) error {
+		_, err := eq.Exec(`
+            update event_scheduler
+                set isrunning = false,
+                    ismodified = ismodified or ?,
+                    lastfinish = ?,
+                    lastresult = ?`,
+			result != nil, time.Now().Unix(), lastResult)
+		if err != nil {
+			return errOrRetry("Setting scheduler finished", err)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("INTERNAL ERROR: Recording scheduler result: %v", err)
+	}
+}
+
+// schedCleanup clears a stale 'running' flag left behind if we exited
+// while the scheduler was running.
+func (store *EventStore) schedCleanup() error {
+	return store.txLoop(func(eq sqlx.Ext) error {
+		_, err := eq.Exec(`
+            update event_scheduler
+                set isrunning = false,
+                    ismodified = true,
+                    lastresult = 'Interrupted'
+                where isrunning = true`)
+		if err != nil {
+			return errOrRetry("Clearing stale scheduler state", err)
+		}
+		return nil
+	})
+}
+
+func (store *EventStore) SchedLastUpdate() string {
+	lastUpdate := "Never"
+	status, err := store.schedGetStatus()
+	if err != nil {
+		log.Printf("INTERNAL ERROR: %v", err)
+		return lastUpdate
+	}
+	if status.LastFinish != 0 {
+		since := time.Since(time.Unix(status.LastFinish, 0)).Round(time.Second)
+		lastUpdate = since.String() + " ago"
+	}
+	return lastUpdate
+}
+
+// SchedLastResult returns the error from the last scheduler run, or
+// "" if it succeeded (or has never run).
+func (store *EventStore) SchedLastResult() string {
+	status, err := store.schedGetStatus()
+	if err != nil {
+		log.Printf("INTERNAL ERROR: %v", err)
+		return ""
+	}
+	return status.LastResult
+}
+
+func (store *EventStore) SchedGetState() SchedState {
+	status, err := store.schedGetStatus()
+	switch {
+	case err != nil:
+		log.Printf("INTERNAL ERROR: %v", err)
+		return SchedStateModified
+	case status.IsRunning:
+		return SchedStateRunning
+	case status.IsModified:
+		return SchedStateModified
+	default:
+		return SchedStateCurrent
+	}
 }
 
 type scheduleSlot struct {
 	SlotID SlotID
 
 	// These should be sorted in order of expected attendees, high to
-	// low.
+	// low.  A discussion longer than one slot is in each of the
+	// (consecutive) slots it takes up.
 	Discussions []*searchDiscussion
 }
 
@@ -65,6 +219,7 @@ type schedule struct {
 type searchDiscussion struct {
 	DiscussionID  DiscussionID
 	Owner         UserID
+	Facilitators  []UserID // Owner plus any co-facilitators
 	PossibleSlots map[SlotID]bool
 	UserInterest  []struct {
 		UserID   UserID
@@ -72,15 +227,38 @@ type searchDiscussion struct {
 	}
 	MaxInterest int
 
-	// Filled in by placement
+	// Number of consecutive slots the discussion takes up
+	Length int
+
+	// Fixed discussions can't be moved by the scheduler, but take
+	// up their location in the slots they're in; unless they're
+	// pinned to a slot but not a location, in which case placement
+	// chooses one
+	Fixed       bool
+	AnyLocation bool
+
+	// Filled in by placement (SlotID is the first slot)
 	SlotID     SlotID
 	LocationID LocationID
 }
 
+// length returns the number of slots disc takes up.
+func (disc *searchDiscussion) length() int {
+	if disc.Length < 1 {
+		return 1
+	}
+	return disc.Length
+}
+
 type searchStore struct {
 	// All unlocked slots
 	Slots []SlotID
 
+	// For each of Slots, how many slots, starting with it, follow
+	// on from each other on the same day: the longest discussion
+	// which can start there.
+	SlotRuns []int
+
 	// All users
 	Users []User
 
@@ -89,8 +267,15 @@ type searchStore struct {
 	// interest, and fordbidden slots
 	Discussions []searchDiscussion
 
-	// All locations
-	Locations []LocationID
+	// Discussions in locked slots which carry on into unlocked ones,
+	// and pinned discussions; and, for each unlocked slot, which of
+	// those are in it
+	Fixed      []searchDiscussion
+	FixedSlots map[SlotID][]*searchDiscussion
+
+	// All locations; physical places first, largest to smallest,
+	// followed by any non-place locations
+	Locations []Location
 
 	CurrentSchedule *schedule
 }
@@ -100,14 +285,15 @@ type searchStore struct {
 // Should fail if:
 // - Any discussions are non-public
 // - There are no unlocked slots
-func makeSnapshot() (*searchStore, error) {
+func (store *EventStore) makeSnapshot() (*searchStore, error) {
 	var ss *searchStore
-	err := txLoop(func(eq sqlx.Ext) error {
+	err := store.txLoop(func(eq sqlx.Ext) error {
 		// Make sure there are no non-public discussion
 		{
 			var dcount int
 			err := sqlx.Get(eq, &dcount, `
-				select count(*) from event_discussions where ispublic = false`)
+				select count(*) from event_discussions
+                    where ispublic = false and deleted = 0`)
 			if err != nil {
 				return errOrRetry("Getting non-public discussion count", err)
 			}
@@ -119,8 +305,13 @@ func makeSnapshot() (*searchStore, error) {
 		ss = &searchStore{}
 
 		// Get all unlocked slots
-		err := sqlx.Select(eq, &ss.Slots, `
-            select slotid
+		var slots []struct {
+			SlotID  SlotID
+			DayID   DayID
+			SlotIDX int
+		}
+		err := sqlx.Select(eq, &slots, `
+            select slotid, dayid, slotidx
                 from event_slots
                 where isbreak == false and islocked == false
                 order by dayid, slotidx`)
@@ -128,10 +319,23 @@ func makeSnapshot() (*searchStore, error) {
 			return errOrRetry("Getting schedule-able slots", err)
 		}
 
-		if len(ss.Slots) == 0 {
+		if len(slots) == 0 {
 			return fmt.Errorf("No schedulable slots found!")
 		}
 
+		// Runs are worked out backwards: a slot followed directly by
+		// another one extends that one's run.
+		ss.Slots = make([]SlotID, len(slots))
+		ss.SlotRuns = make([]int, len(slots))
+		for i := len(slots) - 1; i >= 0; i-- {
+			ss.Slots[i] = slots[i].SlotID
+			ss.SlotRuns[i] = 1
+			if i+1 < len(slots) && slots[i+1].DayID == slots[i].DayID &&
+				slots[i+1].SlotIDX == slots[i].SlotIDX+1 {
+				ss.SlotRuns[i] += ss.SlotRuns[i+1]
+			}
+		}
+
 		// Get all users
 		err = userGetAllTx(eq, &ss.Users)
 		if err != nil {
@@ -140,23 +344,150 @@ func makeSnapshot() (*searchStore, error) {
 
 		// Get all locations
 		err = sqlx.Select(eq, &ss.Locations,
-			`select locationid from event_locations`)
+			`select * from event_locations
+                 order by isplace desc, capacity desc, locationid`)
 		if err != nil {
 			return errOrRetry("Getting locations", err)
 		}
 
 		// Get Discussions not scheduled to locked slots
 		err = sqlx.Select(eq, &ss.Discussions,
-			`select discussionid, owner
+			`select discussionid, owner, approvedlength as length
                  from event_discussions
-                     natural left join event_schedule
-                     natural left join event_slots
-                 where ifnull(islocked, false) = false
+                 where deleted = 0
+                     and discussionid not in
+                         (select discussionid
+                              from event_schedule natural join event_slots
+                              where islocked = true)
                  order by discussionid`)
 		if err != nil {
 			return errOrRetry("Getting unlocked discussions", err)
 		}
 
+		// Get discussions which are partly in locked slots: they
+		// stay where they are, so the rest of the schedule has to
+		// fit around them.
+		var fixed []struct {
+			searchDiscussion
+			SlotID SlotID
+		}
+		err = sqlx.Select(eq, &fixed,
+			`select discussionid, owner, approvedlength as length, slotid, locationid
+                 from event_schedule
+                     natural join event_slots
+                     join event_discussions using(discussionid)
+                 where islocked = false
+                     and discussionid in
+                         (select discussionid
+                              from event_schedule natural join event_slots
+                              where islocked = true)
+                 order by discussionid, dayid, slotidx`)
+		if err != nil {
+			return errOrRetry("Getting fixed discussions", err)
+		}
+
+		// The slots each of ss.Fixed is in
+		var fixedSlots [][]SlotID
+		for i := range fixed {
+			if i == 0 || fixed[i].DiscussionID != fixed[i-1].DiscussionID {
+				d := fixed[i].searchDiscussion
+				d.Fixed = true
+				ss.Fixed = append(ss.Fixed, d)
+				fixedSlots = append(fixedSlots, nil)
+			}
+			j := len(fixedSlots) - 1
+			fixedSlots[j] = append(fixedSlots[j], fixed[i].SlotID)
+		}
+
+		// Pinned discussions are fixed too, as long as they still fit
+		// where they're pinned: they may not if the timetable or
+		// locations have changed since.
+		slotIndex := map[SlotID]int{}
+		for i, slotid := range ss.Slots {
+			slotIndex[slotid] = i
+		}
+		slotCount := map[SlotID]int{}
+		slotLocations := map[SlotID]map[LocationID]bool{}
+		for i := range ss.Fixed {
+			for _, slotid := range fixedSlots[i] {
+				slotCount[slotid]++
+				if slotLocations[slotid] == nil {
+					slotLocations[slotid] = map[LocationID]bool{}
+				}
+				slotLocations[slotid][ss.Fixed[i].LocationID] = true
+			}
+		}
+
+		var pinned []searchDiscussion
+		err = sqlx.Select(eq, &pinned,
+			`select discussionid, owner, approvedlength as length,
+                    slotid, ifnull(locationid, 0) as locationid
+                 from event_pins
+                     join event_discussions using(discussionid)
+                 where deleted = 0
+                     and discussionid not in
+                         (select discussionid
+                              from event_schedule natural join event_slots
+                              where islocked = true)
+                 order by discussionid`)
+		if err != nil {
+			return errOrRetry("Getting pinned discussions", err)
+		}
+
+		isPinned := map[DiscussionID]bool{}
+	pins:
+		for _, d := range pinned {
+			idx, ok := slotIndex[d.SlotID]
+			if !ok || ss.SlotRuns[idx] < d.length() {
+				log.Printf("WARNING: Discussion %v no longer fits where it's pinned; ignoring pin",
+					d.DiscussionID)
+				continue
+			}
+			slots := ss.Slots[idx : idx+d.length()]
+			for _, slotid := range slots {
+				if slotCount[slotid] >= len(ss.Locations) ||
+					(d.LocationID != 0 && slotLocations[slotid][d.LocationID]) {
+					log.Printf("WARNING: No room for discussion %v where it's pinned; ignoring pin",
+						d.DiscussionID)
+					continue pins
+				}
+			}
+			for _, slotid := range slots {
+				slotCount[slotid]++
+				if d.LocationID != 0 {
+					if slotLocations[slotid] == nil {
+						slotLocations[slotid] = map[LocationID]bool{}
+					}
+					slotLocations[slotid][d.LocationID] = true
+				}
+			}
+
+			d.Fixed = true
+			d.AnyLocation = d.LocationID == 0
+			ss.Fixed = append(ss.Fixed, d)
+			fixedSlots = append(fixedSlots, slots)
+			isPinned[d.DiscussionID] = true
+		}
+
+		ss.FixedSlots = make(map[SlotID][]*searchDiscussion)
+		for i := range ss.Fixed {
+			if err = searchDiscussionGetInterestTx(eq, &ss.Fixed[i]); err != nil {
+				return err
+			}
+			for _, slotid := range fixedSlots[i] {
+				ss.FixedSlots[slotid] = append(ss.FixedSlots[slotid], &ss.Fixed[i])
+			}
+		}
+
+		// The scheduler only needs to place the rest
+		unpinned := ss.Discussions[:0]
+		for _, d := range ss.Discussions {
+			if !isPinned[d.DiscussionID] {
+				unpinned = append(unpinned, d)
+			}
+		}
+		ss.Discussions = unpinned
+
 		for i := range ss.Discussions {
 			d := &ss.Discussions[i]
 
@@ -198,18 +529,8 @@ func makeSnapshot() (*searchStore, error) {
 				d.PossibleSlots[slotid] = true
 			}
 
-			// Get user interest in this discussion
-			err = sqlx.Select(eq, &d.UserInterest,
-				`select userid, interest
-                     from event_interest
-                     where discussionid = ?`, d.DiscussionID)
-			if err != nil {
-				return errOrRetry("Error getting interest for discussion", err)
-			}
-
-			d.MaxInterest = 0
-			for i := range d.UserInterest {
-				d.MaxInterest += d.UserInterest[i].Interest
+			if err = searchDiscussionGetInterestTx(eq, d); err != nil {
+				return err
 			}
 		}
 
@@ -222,10 +543,91 @@ func makeSnapshot() (*searchStore, error) {
 	return ss, nil
 }
 
-// FIXME: This doesn't handle IsPlace == false
+// searchDiscussionGetInterestTx fills in the interest in d, and who
+// needs to be there.
+func searchDiscussionGetInterestTx(eq sqlx.Ext, d *searchDiscussion) error {
+	// Get user interest in this discussion
+	err := sqlx.Select(eq, &d.UserInterest,
+		`select userid, interest
+             from event_interest
+             where discussionid = ?
+                 and userid in (select userid from event_users where deleted = 0)`,
+		d.DiscussionID)
+	if err != nil {
+		return errOrRetry("Error getting interest for discussion", err)
+	}
+
+	d.MaxInterest = 0
+	for i := range d.UserInterest {
+		d.MaxInterest += d.UserInterest[i].Interest
+	}
+
+	// Get everyone who needs to be at this discussion
+	var cofacilitators []UserID
+	err = sqlx.Select(eq, &cofacilitators,
+		`select userid
+             from event_discussions_facilitators
+             where discussionid = ?
+                 and userid in (select userid from event_users where deleted = 0)`,
+		d.DiscussionID)
+	if err != nil {
+		return errOrRetry("Error getting facilitators for discussion", err)
+	}
+	d.Facilitators = append([]UserID{d.Owner}, cofacilitators...)
+	return nil
+}
+
+// slotAttendance returns the projected attendance for each discussion
+// in a slot.  Like GetTimetable(), it assumes each user will go to
+// whichever discussion(s) in the slot they're most interested in.
+func slotAttendance(discussions []*searchDiscussion) map[DiscussionID]int {
+	maxInterest := map[UserID]int{}
+	for _, disc := range discussions {
+		for _, ui := range disc.UserInterest {
+			if ui.Interest > maxInterest[ui.UserID] {
+				maxInterest[ui.UserID] = ui.Interest
+			}
+		}
+	}
+
+	attendance := map[DiscussionID]int{}
+	for _, disc := range discussions {
+		for _, ui := range disc.UserInterest {
+			if ui.Interest > 0 && ui.Interest == maxInterest[ui.UserID] {
+				attendance[disc.DiscussionID]++
+			}
+		}
+	}
+	return attendance
+}
+
+// placeDiscussions assigns a location to each discussion in the
+// current schedule.  Within each slot, the discussion with the most
+// projected attendees gets the largest place, and so on down;
+// non-place locations are only used once all the places are taken.
+// A discussion longer than one slot is placed in its first slot, and
+// stays in the same location for the rest; fixed discussions stay in
+// the locations they already have (or are pinned to, if any).  A
+// warning is logged for any discussion whose projected attendance
+// exceeds the capacity of the place it's assigned.
+//
+// A long discussion can't be placed in a location which a fixed
+// discussion takes up in one of its later slots.  If that leaves it
+// nowhere to go, it's unscheduled, with a warning.
 func placeDiscussions(ss *searchStore) error {
 	s := ss.CurrentSchedule
 
+	for i := range s.Slots {
+		for _, disc := range s.Slots[i].Discussions {
+			if !disc.Fixed {
+				disc.SlotID = ""
+				disc.LocationID = 0
+			} else if disc.AnyLocation {
+				disc.LocationID = 0
+			}
+		}
+	}
+
 	for i := range s.Slots {
 		slot := &s.Slots[i]
 		if len(slot.Discussions) > len(ss.Locations) {
@@ -233,18 +635,71 @@ func placeDiscussions(ss *searchStore) error {
 				len(slot.Discussions), len(ss.Locations))
 		}
 
+		attendance := slotAttendance(slot.Discussions)
+		sort.SliceStable(slot.Discussions, func(a, b int) bool {
+			da, db := slot.Discussions[a], slot.Discussions[b]
+			if attendance[da.DiscussionID] != attendance[db.DiscussionID] {
+				return attendance[da.DiscussionID] > attendance[db.DiscussionID]
+			}
+			return da.DiscussionID < db.DiscussionID
+		})
+
+		// Locations already taken by discussions carrying on from
+		// the previous slot, or fixed ones
+		taken := map[LocationID]bool{}
+		for _, disc := range slot.Discussions {
+			if disc.LocationID != 0 {
+				taken[disc.LocationID] = true
+			}
+		}
+
 		// Set SlotID, LocationID
-		for j := range slot.Discussions {
-			slot.Discussions[j].SlotID = slot.SlotID
-			slot.Discussions[j].LocationID = ss.Locations[j]
+		for _, disc := range append([]*searchDiscussion(nil), slot.Discussions...) {
+			if disc.LocationID != 0 {
+				continue
+			}
+
+			// Locations fixed discussions take up later on
+			reserved := map[LocationID]bool{}
+			for j := i + 1; j < i+disc.length() && j < len(s.Slots); j++ {
+				for _, other := range s.Slots[j].Discussions {
+					if other.Fixed {
+						reserved[other.LocationID] = true
+					}
+				}
+			}
+
+			var loc *Location
+			for j := range ss.Locations {
+				if !taken[ss.Locations[j].LocationID] && !reserved[ss.Locations[j].LocationID] {
+					loc = &ss.Locations[j]
+					break
+				}
+			}
+			if loc == nil {
+				log.Printf("WARNING: No location free for all of discussion %v; unscheduling",
+					disc.DiscussionID)
+				s.unplace(i, disc)
+				s.UnplacedDiscussions = append(s.UnplacedDiscussions, disc)
+				continue
+			}
+
+			taken[loc.LocationID] = true
+			disc.SlotID = slot.SlotID
+			disc.LocationID = loc.LocationID
+			if loc.IsPlace && attendance[disc.DiscussionID] > loc.Capacity {
+				log.Printf("WARNING: Discussion %v projected attendance %d exceeds capacity %d of location %v (%s)",
+					disc.DiscussionID, attendance[disc.DiscussionID],
+					loc.Capacity, loc.LocationID, loc.LocationName)
+			}
 		}
 	}
 
 	return nil
 }
 
-func scheduleSet(s *schedule) error {
-	err := txLoop(func(eq sqlx.Ext) error {
+func (store *EventStore) scheduleSet(s *schedule) error {
+	err := store.txLoop(func(eq sqlx.Ext) error {
 		for i := range s.Slots {
 			ss := &s.Slots[i]
 			// Check to see if this slot is locked
@@ -276,12 +731,16 @@ func scheduleSet(s *schedule) error {
 
 			// Add new schedule entries
 			if len(ss.Discussions) > 0 {
+				entries := make([]scheduleEntry, len(ss.Discussions))
+				for j, disc := range ss.Discussions {
+					entries[j] = scheduleEntry{disc.DiscussionID, ss.SlotID, disc.LocationID}
+				}
 				_, err = sqlx.NamedExec(eq, `
-                insert into event_schedule
+                insert into event_schedule(discussionid, slotid, locationid)
                     values(:discussionid, :slotid, :locationid)`,
-					ss.Discussions)
+					entries)
 				if err != nil {
-					log.Printf("Failed inserting schedule entries %v", ss.Discussions)
+					log.Printf("Failed inserting schedule entries %v", entries)
 					return errOrRetry("Adding new schedule entries", err)
 				}
 			}
@@ -294,7 +753,10 @@ func scheduleSet(s *schedule) error {
 func scheduleMakeEmpty(ss *searchStore) *schedule {
 	sched := &schedule{}
 	for i := range ss.Slots {
-		sched.Slots = append(sched.Slots, scheduleSlot{SlotID: ss.Slots[i]})
+		sched.Slots = append(sched.Slots, scheduleSlot{
+			SlotID:      ss.Slots[i],
+			Discussions: append([]*searchDiscussion(nil), ss.FixedSlots[ss.Slots[i]]...),
+		})
 	}
 	for i := range ss.Discussions {
 		sched.UnplacedDiscussions = append(sched.UnplacedDiscussions, &ss.Discussions[i])
@@ -338,9 +800,15 @@ func makeScheduleHeuristic(ss *searchStore) (*schedule, error) {
 	sched := scheduleMakeEmpty(ss)
 	unplaced := []*searchDiscussion(nil)
 
-	// Sort discussion list by interest, high to low
+	// Sort discussion list by length, long to short, since longer
+	// discussions are harder to fit in; and then by interest, high
+	// to low
 	sort.Slice(sched.UnplacedDiscussions, func(i, j int) bool {
-		return sched.UnplacedDiscussions[i].MaxInterest > sched.UnplacedDiscussions[j].MaxInterest
+		di, dj := sched.UnplacedDiscussions[i], sched.UnplacedDiscussions[j]
+		if di.length() != dj.length() {
+			return di.length() > dj.length()
+		}
+		return di.MaxInterest > dj.MaxInterest
 	})
 
 	// Starting at the top, look for a slot to put it in which will maximize this score
@@ -352,17 +820,17 @@ func makeScheduleHeuristic(ss *searchStore) (*schedule, error) {
 		best := struct{ score, index int }{score: 0, index: -1}
 		for i := range sched.Slots {
 			log.Printf(" Evaluating slot %d", i)
-			if !disc.PossibleSlots[sched.Slots[i].SlotID] {
-				log.Printf("  Slot disallowed, skipping")
-				continue
-			}
-			if len(sched.Slots[i].Discussions) >= len(ss.Locations) {
-				log.Printf("  Slot full, skipping")
+			if !sched.accepts(ss, i, disc) {
+				log.Printf("  Slot disallowed, full, or facilitator busy; skipping")
 				continue
 			}
 
-			// OK, how much will we increase the score by putting this discussion here?
-			score := scoreSlotDelta(sched.Slots[i].Discussions, disc)
+			// OK, how much will we increase the score by putting this
+			// discussion here (and in any following slots it takes up)?
+			score := 0
+			for j := i; j < i+disc.length(); j++ {
+				score += scoreSlotDelta(sched.Slots[j].Discussions, disc)
+			}
 
 			// All things being equal, favor a slot with fewer
 			// discussions.  NB we know this is > 0 because we've
@@ -393,7 +861,7 @@ func makeScheduleHeuristic(ss *searchStore) (*schedule, error) {
 				best.index)
 
 			// Make it so
-			sched.Slots[best.index].Discussions = append(sched.Slots[best.index].Discussions, disc)
+			sched.place(best.index, disc)
 		}
 	}
 
@@ -402,26 +870,76 @@ func makeScheduleHeuristic(ss *searchStore) (*schedule, error) {
 	return sched, nil
 }
 
-func MakeSchedule(opt SearchOptions) error {
-	ss, err := makeSnapshot()
+func (store *EventStore) makeSchedule(ss *searchStore, opt *SearchOptions) error {
+	var err error
+	ss.CurrentSchedule, err = makeScheduleHeuristic(ss)
 	if err != nil {
 		return err
 	}
 
-	ss.CurrentSchedule, err = makeScheduleHeuristic(ss)
-	if err != nil {
-		return err
+	opt.debugf(1, "Heuristic schedule score %d", ss.CurrentSchedule.score())
+
+	switch opt.Algo {
+	case SearchHeuristicOnly, "":
+	case SearchRandom:
+		ss.CurrentSchedule = makeScheduleRandom(ss, ss.CurrentSchedule, opt)
+	case SearchGenetic:
+		ss.CurrentSchedule = makeScheduleGenetic(ss, ss.CurrentSchedule, opt)
+	default:
+		return fmt.Errorf("Unknown search algorithm %s", opt.Algo)
+	}
+
+	if opt.Validate {
+		if err = scheduleValidate(ss, ss.CurrentSchedule); err != nil {
+			return fmt.Errorf("INTERNAL ERROR: Invalid schedule: %v", err)
+		}
 	}
 
+	for _, disc := range ss.CurrentSchedule.UnplacedDiscussions {
+		log.Printf("WARNING: Could not place discussion %v", disc.DiscussionID)
+	}
+
+	ss.CurrentSchedule.sortSlots()
+
 	err = placeDiscussions(ss)
 	if err != nil {
 		return err
 	}
 
-	err = scheduleSet(ss.CurrentSchedule)
+	return store.scheduleSet(ss.CurrentSchedule)
+}
+
+// MakeSchedule runs the scheduler.  Only one run may be in progress
+// at a time; errInProgress is returned if another is running.
+//
+// If opt.Async is set, MakeSchedule returns once the snapshot has
+// been taken, and the search runs in the background; its result can
+// be found with SchedGetState() and SchedLastResult().
+func (store *EventStore) MakeSchedule(opt SearchOptions) error {
+	if err := store.schedStart(&opt); err != nil {
+		return err
+	}
+
+	ss, err := store.makeSnapshot()
 	if err != nil {
+		store.schedFinish(err)
 		return err
 	}
 
-	return nil
+	if opt.Async {
+		store.schedJobs.Add(1)
+		go func() {
+			defer store.schedJobs.Done()
+			err := store.makeSchedule(ss, &opt)
+			if err != nil {
+				log.Printf("Scheduler failed: %v", err)
+			}
+			store.schedFinish(err)
+		}()
+		return nil
+	}
+
+	err = store.makeSchedule(ss, &opt)
+	store.schedFinish(err)
+	return err
 }
@@ -1,6 +1,7 @@
 package event
 
 import (
+	"fmt"
 	"math/rand"
 	"testing"
 	"time"
@@ -65,7 +66,7 @@ func testUnitSchedule(t *testing.T) (exit bool) {
 	}
 	totalSlots := 6
 
-	err := TimetableSet(&tt)
+	err := event.TimetableSet("", &tt, ImpactAbort)
 	if err != nil {
 		t.Errorf("ERROR Basic TimetableSet: %v", err)
 		return
@@ -85,7 +86,7 @@ func testUnitSchedule(t *testing.T) (exit bool) {
 		didx := rand.Int31n(int32(len(m.discussions)))
 		did := m.discussions[didx].DiscussionID
 		interest := int(rand.Int31n(101))
-		err = m.users[uidx].SetInterest(&m.discussions[didx], interest)
+		err = event.UserSetInterest(&m.users[uidx], &m.discussions[didx], interest)
 		if err != nil {
 			t.Errorf("Setting interest: %v", err)
 			return
@@ -100,12 +101,12 @@ func testUnitSchedule(t *testing.T) (exit bool) {
 	// Restrict discussion 0 to Monday
 	{
 		t.Logf("Restricting Discussion[0] (did %v) to Tuesday", m.discussions[0].DiscussionID)
-		gotdisc, err := DiscussionFindByIdFull(m.discussions[0].DiscussionID)
+		gotdisc, err := event.DiscussionFindByIdFull(m.discussions[0].DiscussionID)
 		if err != nil {
 			t.Errorf("Finding discussion 0 by id: %v", err)
 			return
 		}
-		err = DiscussionSetPossibleSlots(m.discussions[0].DiscussionID, CheckedToSlotList(gotdisc.PossibleSlots)[3:])
+		err = event.DiscussionSetPossibleSlots("", m.discussions[0].DiscussionID, CheckedToSlotList(gotdisc.PossibleSlots)[3:])
 	}
 
 	//
@@ -115,12 +116,12 @@ func testUnitSchedule(t *testing.T) (exit bool) {
 	//
 	// Set at least one discussion non-public and make sure it fails
 	//
-	err = DiscussionSetPublic(m.discussions[0].DiscussionID, false)
+	err = event.DiscussionSetPublic("", m.discussions[0].DiscussionID, false)
 	if err != nil {
 		t.Errorf("Setting discussion 0 non-public: %v", err)
 		return
 	}
-	_, err = makeSnapshot()
+	_, err = event.makeSnapshot()
 	if err == nil {
 		t.Errorf("Snapshot unexpectedly succeeded with non-public discussion!")
 		return
@@ -128,7 +129,7 @@ func testUnitSchedule(t *testing.T) (exit bool) {
 
 	// Make all discussions public
 	for i := range m.discussions {
-		err = DiscussionSetPublic(m.discussions[i].DiscussionID, true)
+		err = event.DiscussionSetPublic("", m.discussions[i].DiscussionID, true)
 		if err != nil {
 			t.Errorf("Setting discussion public: %v", err)
 			return
@@ -138,7 +139,7 @@ func testUnitSchedule(t *testing.T) (exit bool) {
 	//
 	// Take a snapshot, make sure it has what we expect
 	//
-	store, err := makeSnapshot()
+	store, err := event.makeSnapshot()
 	if err != nil {
 		t.Errorf("Getting snapshot: %v", err)
 		return
@@ -191,7 +192,7 @@ func testUnitSchedule(t *testing.T) (exit bool) {
 	//
 	var sched schedule
 	// No restrictions on discussion 1, so this should get us all slots
-	gotdisc, err := DiscussionFindByIdFull(m.discussions[1].DiscussionID)
+	gotdisc, err := event.DiscussionFindByIdFull(m.discussions[1].DiscussionID)
 	if err != nil {
 		t.Errorf("Getting discussion 1: %v", err)
 		return
@@ -211,20 +212,20 @@ func testUnitSchedule(t *testing.T) (exit bool) {
 	store.CurrentSchedule = &sched
 	placeDiscussions(store)
 
-	err = scheduleSet(&sched)
+	err = event.scheduleSet(&sched)
 	if err != nil {
 		t.Errorf("Setting schedule: %v", err)
 		return
 	}
 
-	err = TimetableSetLockedSlots(CheckedToSlotList(gotdisc.PossibleSlots[:3]))
+	err = event.TimetableSetLockedSlots("", CheckedToSlotList(gotdisc.PossibleSlots[:3]))
 	if err != nil {
 		t.Errorf("Locking slots: %v", err)
 		return
 	}
 	unlockedSlots := totalSlots - len(gotdisc.PossibleSlots[:3])
 
-	store, err = makeSnapshot()
+	store, err = event.makeSnapshot()
 	if err != nil {
 		t.Errorf("Getting snapshot: %v", err)
 		return
@@ -281,3 +282,264 @@ func testUnitSchedule(t *testing.T) (exit bool) {
 
 	return false
 }
+
+func testSchedState(t *testing.T) (exit bool) {
+	// Any "early" exit is a failure
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+
+	m := &mirrorData{}
+
+	t.Logf("Setting up users, discussion, timetable")
+	if testScheduleSearchSetup(t, m, 8, 3) {
+		return
+	}
+
+	checkState := func(desc string, want SchedState) bool {
+		if got := event.SchedGetState(); got != want {
+			t.Errorf("%s: Wanted state %v, got %v", desc, want, got)
+			return true
+		}
+		return false
+	}
+
+	if event.SchedLastUpdate() != "Never" {
+		t.Errorf("Expected last update Never, got %s", event.SchedLastUpdate())
+		return
+	}
+	if checkState("Never run", SchedStateModified) {
+		return
+	}
+
+	run := func(desc string) bool {
+		if err := event.MakeSchedule(SearchOptions{}); err != nil {
+			t.Errorf("%s: MakeSchedule: %v", desc, err)
+			return true
+		}
+		return checkState(desc, SchedStateCurrent)
+	}
+
+	if run("First run") {
+		return
+	}
+	if event.SchedLastUpdate() == "Never" || event.SchedLastResult() != "" {
+		t.Errorf("Unexpected last update %s result %s", event.SchedLastUpdate(), event.SchedLastResult())
+		return
+	}
+
+	// Each of these should mark the schedule modified
+	mutators := []struct {
+		desc string
+		f    func() error
+	}{
+		{"SetInterest", func() error {
+			return event.UserSetInterest(&m.users[0], &m.discussions[1], 37)
+		}},
+		{"SetInterest(0)", func() error {
+			return event.UserSetInterest(&m.users[0], &m.discussions[1], 0)
+		}},
+		{"DiscussionSetPossibleSlots", func() error {
+			ps, err := event.DiscussionGetPossibleSlots(m.discussions[2].DiscussionID)
+			if err != nil {
+				return err
+			}
+			return event.DiscussionSetPossibleSlots("", m.discussions[2].DiscussionID,
+				CheckedToSlotList(ps)[1:])
+		}},
+		{"TimetableSetLockedSlots", func() error {
+			return event.TimetableSetLockedSlots("", nil)
+		}},
+		{"NewLocation", func() error {
+			_, subexit := testNewLocation(t)
+			if subexit {
+				return fmt.Errorf("Creating location")
+			}
+			return nil
+		}},
+		{"DeleteDiscussion", func() error {
+			return event.DeleteDiscussion("", m.discussions[3].DiscussionID)
+		}},
+	}
+
+	for _, mut := range mutators {
+		if err := mut.f(); err != nil {
+			t.Errorf("%s: %v", mut.desc, err)
+			return
+		}
+		if checkState(mut.desc, SchedStateModified) || run(mut.desc) {
+			return
+		}
+	}
+
+	// Concurrent runs should be rejected
+	if err := event.schedStart(&SearchOptions{}); err != nil {
+		t.Errorf("schedStart: %v", err)
+		return
+	}
+	if checkState("Running", SchedStateRunning) {
+		return
+	}
+	if err := event.MakeSchedule(SearchOptions{}); err != errInProgress {
+		t.Errorf("Concurrent MakeSchedule: wanted errInProgress, got %v", err)
+		return
+	}
+
+	// A stale 'running' state should be cleaned up at load
+	if err := event.schedCleanup(); err != nil {
+		t.Errorf("schedCleanup: %v", err)
+		return
+	}
+	if checkState("After cleanup", SchedStateModified) || run("After cleanup") {
+		return
+	}
+
+	// Async runs
+	err := event.MakeSchedule(SearchOptions{
+		Async:          true,
+		Algo:           SearchRandom,
+		SearchDuration: 200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Errorf("Async MakeSchedule: %v", err)
+		return
+	}
+	if checkState("Async", SchedStateRunning) {
+		return
+	}
+	event.schedJobs.Wait()
+	if checkState("Async finished", SchedStateCurrent) {
+		return
+	}
+
+	// Failed runs should leave the schedule modified, with the error
+	if err = event.DiscussionSetPublic("", m.discussions[0].DiscussionID, false); err != nil {
+		t.Errorf("Setting discussion non-public: %v", err)
+		return
+	}
+	if err = event.MakeSchedule(SearchOptions{}); err == nil {
+		t.Errorf("MakeSchedule with non-public discussion unexpectedly succeeded")
+		return
+	}
+	if checkState("Failed run", SchedStateModified) {
+		return
+	}
+	if event.SchedLastResult() == "" {
+		t.Errorf("Failed run: Expected non-empty last result")
+		return
+	}
+
+	tc.cleanup()
+
+	return false
+}
+
+func testSchedulePlacement(t *testing.T) (exit bool) {
+	// Any "early" exit is a failure
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+
+	m := &mirrorData{}
+
+	t.Logf("Setting up users, discussions, locations, timetable")
+	if testNewUsers(t, m, 6) {
+		return
+	}
+
+	// One discussion per owner, so they can all go in the same slot
+	m.discussions = make([]Discussion, 3)
+	for i := range m.discussions {
+		subexit := false
+		m.discussions[i], subexit = testNewDiscussion(t, m.users[i].UserID)
+		if subexit {
+			return
+		}
+		if err := event.DiscussionSetPublic("", m.discussions[i].DiscussionID, true); err != nil {
+			t.Errorf("Setting discussion public: %v", err)
+			return
+		}
+	}
+
+	// Owners attend their own discussions; the other three users give
+	// projected attendance of 3, 2, and 1 respectively.
+	for _, ui := range []struct{ user, disc int }{{3, 0}, {4, 0}, {5, 1}} {
+		err := event.UserSetInterest(&m.users[ui.user], &m.discussions[ui.disc], InterestMax)
+		if err != nil {
+			t.Errorf("Setting interest: %v", err)
+			return
+		}
+	}
+
+	// Create the non-place and smaller place first, to make sure
+	// they're not just used in order
+	locations := []Location{
+		{LocationName: "Online", LocationURL: "https://example.org", Capacity: 500},
+		{LocationName: "Small", IsPlace: true, Capacity: 1},
+		{LocationName: "Large", IsPlace: true, Capacity: 100},
+	}
+	for i := range locations {
+		if _, err := event.NewLocation("", &locations[i]); err != nil {
+			t.Errorf("Creating location: %v", err)
+			return
+		}
+	}
+
+	tt := Timetable{
+		Days: []TimetableDay{
+			{DayName: "Monday", Slots: []TimetableSlot{
+				{Time: Date(2020, 7, 6, 14, 30, 0, 0, time.UTC)},
+			}},
+		},
+	}
+	if err := event.TimetableSet("", &tt, ImpactAbort); err != nil {
+		t.Errorf("TimetableSet: %v", err)
+		return
+	}
+
+	if err := event.MakeSchedule(SearchOptions{}); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+
+	gottt, err := event.GetTimetable("", nil)
+	if err != nil {
+		t.Errorf("GetTimetable: %v", err)
+		return
+	}
+
+	got := gottt.Days[0].Slots[0].Discussions
+	want := []struct {
+		disc         int
+		attendees    int
+		location     string
+		overCapacity bool
+	}{
+		{0, 3, "Large", false},
+		{1, 2, "Small", true},
+		{2, 1, "Online", false},
+	}
+	if len(got) != len(want) {
+		t.Errorf("Wanted %d discussions in slot, got %d", len(want), len(got))
+		return
+	}
+	for i := range want {
+		if got[i].DiscussionID != m.discussions[want[i].disc].DiscussionID ||
+			got[i].Attendees != want[i].attendees ||
+			got[i].LocationName != want[i].location ||
+			got[i].OverCapacity != want[i].overCapacity {
+			t.Errorf("Discussion %d: wanted %v, got %v", i, want[i], got[i])
+			return
+		}
+	}
+
+	tc.cleanup()
+
+	return false
+}
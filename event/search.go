@@ -0,0 +1,518 @@
+package event
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Local search over schedules.
+//
+// Both the "random" and "genetic" algorithms start from the
+// heuristic schedule and spend opt.SearchDuration trying to improve
+// on it, always keeping the best-scoring schedule found so far.
+// Every candidate is generated by the mutation and crossover
+// operators below, which only ever produce schedules satisfying
+// slotAccepts(); so the result is never worse, and never less valid,
+// than the heuristic result.
+
+const (
+	// Number of consecutive non-improving mutations before the
+	// random search gives up on the current schedule and restarts.
+	searchRestartThreshold = 500
+
+	searchPopulationSize = 16
+	searchMutationsMax   = 3
+	searchTournamentSize = 3
+)
+
+// Score of a single slot: The sum, over all users, of the highest
+// interest that user has in any discussion in that slot.
+func scoreSlot(discussions []*searchDiscussion) int {
+	userMaxInt := map[UserID]int{}
+	for i := range discussions {
+		addDiscussionInterest(userMaxInt, discussions[i])
+	}
+
+	score := 0
+	for _, interest := range userMaxInt {
+		score += interest
+	}
+	return score
+}
+
+func (sched *schedule) score() int {
+	score := 0
+	for i := range sched.Slots {
+		score += scoreSlot(sched.Slots[i].Discussions)
+	}
+	return score
+}
+
+// slotAccepts returns true if disc can be added to a slot with
+// SlotID slotid currently holding discussions.  These are hard
+// constraints, which every search algorithm must respect:
+// - The discussion must be allowed in the slot
+// - There must be a free location
+// - No two discussions in the slot may share a facilitator
+//
+// (A discussion's facilitators are its owner and co-facilitators.  A
+// discussion longer than one slot must be accepted by each of the
+// slots it takes up; see accepts().)
+func slotAccepts(ss *searchStore, slotid SlotID, discussions []*searchDiscussion, disc *searchDiscussion) bool {
+	if !disc.PossibleSlots[slotid] {
+		return false
+	}
+	if len(discussions) >= len(ss.Locations) {
+		return false
+	}
+	for _, other := range discussions {
+		if shareFacilitator(other, disc) {
+			return false
+		}
+	}
+	return true
+}
+
+// accepts returns true if disc can be placed in sched starting at
+// the slot with index start: the slots it would take up must follow
+// on from each other on the same day, and each must accept it.
+func (sched *schedule) accepts(ss *searchStore, start int, disc *searchDiscussion) bool {
+	if ss.SlotRuns[start] < disc.length() {
+		return false
+	}
+	for i := start; i < start+disc.length(); i++ {
+		s := &sched.Slots[i]
+		if !slotAccepts(ss, s.SlotID, s.Discussions, disc) {
+			return false
+		}
+	}
+	return true
+}
+
+// place puts disc in sched, starting at the slot with index start.
+func (sched *schedule) place(start int, disc *searchDiscussion) {
+	for i := start; i < start+disc.length(); i++ {
+		sched.Slots[i].Discussions = append(sched.Slots[i].Discussions, disc)
+	}
+}
+
+// unplace takes disc, which starts at the slot with index start, out
+// of sched.
+func (sched *schedule) unplace(start int, disc *searchDiscussion) {
+	for i := start; i < start+disc.length() && i < len(sched.Slots); i++ {
+		s := &sched.Slots[i]
+		if j := discussionIndex(s.Discussions, disc); j >= 0 {
+			s.Discussions = removeDiscussion(s.Discussions, j)
+		}
+	}
+}
+
+// start returns the index of the first slot of disc, which is in the
+// slot with index i.
+func (sched *schedule) start(i int, disc *searchDiscussion) int {
+	for i > 0 && discussionIndex(sched.Slots[i-1].Discussions, disc) >= 0 {
+		i--
+	}
+	return i
+}
+
+func discussionIndex(list []*searchDiscussion, disc *searchDiscussion) int {
+	for i := range list {
+		if list[i] == disc {
+			return i
+		}
+	}
+	return -1
+}
+
+func shareFacilitator(a, b *searchDiscussion) bool {
+	for _, ua := range a.Facilitators {
+		for _, ub := range b.Facilitators {
+			if ua == ub {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (sched *schedule) clone() *schedule {
+	n := &schedule{
+		Slots:               make([]scheduleSlot, len(sched.Slots)),
+		UnplacedDiscussions: append([]*searchDiscussion(nil), sched.UnplacedDiscussions...),
+	}
+	for i := range sched.Slots {
+		n.Slots[i].SlotID = sched.Slots[i].SlotID
+		n.Slots[i].Discussions = append([]*searchDiscussion(nil), sched.Slots[i].Discussions...)
+	}
+	return n
+}
+
+// Sort discussions within each slot by MaxInterest, high to low, as
+// placeDiscussions expects.
+func (sched *schedule) sortSlots() {
+	for i := range sched.Slots {
+		d := sched.Slots[i].Discussions
+		sort.SliceStable(d, func(i, j int) bool {
+			return d[i].MaxInterest > d[j].MaxInterest
+		})
+	}
+}
+
+func removeDiscussion(list []*searchDiscussion, idx int) []*searchDiscussion {
+	return append(list[:idx:idx], list[idx+1:]...)
+}
+
+// pick returns a random discussion from the slot with index i, and
+// the index of its first slot, or nil if there are none which can be
+// moved.
+func (sched *schedule) pick(i int, rng *rand.Rand) (*searchDiscussion, int) {
+	s := &sched.Slots[i]
+	if len(s.Discussions) == 0 {
+		return nil, 0
+	}
+	disc := s.Discussions[rng.Intn(len(s.Discussions))]
+	if disc.Fixed {
+		return nil, 0
+	}
+	return disc, sched.start(i, disc)
+}
+
+// mutateMove moves a random placed discussion into a different slot.
+func (sched *schedule) mutateMove(ss *searchStore, rng *rand.Rand) bool {
+	disc, from := sched.pick(rng.Intn(len(sched.Slots)), rng)
+	if disc == nil {
+		return false
+	}
+	to := rng.Intn(len(sched.Slots))
+	if to == from {
+		return false
+	}
+
+	sched.unplace(from, disc)
+	if !sched.accepts(ss, to, disc) {
+		sched.place(from, disc)
+		return false
+	}
+	sched.place(to, disc)
+	return true
+}
+
+// mutateSwap exchanges two discussions in different slots.
+func (sched *schedule) mutateSwap(ss *searchStore, rng *rand.Rand) bool {
+	ad, a := sched.pick(rng.Intn(len(sched.Slots)), rng)
+	bd, b := sched.pick(rng.Intn(len(sched.Slots)), rng)
+	if ad == nil || bd == nil || ad == bd || a == b {
+		return false
+	}
+
+	sched.unplace(a, ad)
+	sched.unplace(b, bd)
+	if sched.accepts(ss, a, bd) {
+		sched.place(a, bd)
+		if sched.accepts(ss, b, ad) {
+			sched.place(b, ad)
+			return true
+		}
+		sched.unplace(a, bd)
+	}
+	sched.place(a, ad)
+	sched.place(b, bd)
+	return false
+}
+
+// mutatePlace tries to put a random unplaced discussion into a random
+// slot.
+func (sched *schedule) mutatePlace(ss *searchStore, rng *rand.Rand) bool {
+	if len(sched.UnplacedDiscussions) == 0 {
+		return false
+	}
+	ui := rng.Intn(len(sched.UnplacedDiscussions))
+	disc := sched.UnplacedDiscussions[ui]
+	to := rng.Intn(len(sched.Slots))
+	if !sched.accepts(ss, to, disc) {
+		return false
+	}
+	sched.UnplacedDiscussions = removeDiscussion(sched.UnplacedDiscussions, ui)
+	sched.place(to, disc)
+	return true
+}
+
+// mutate applies a single random mutation to sched.  It returns false
+// if the randomly-chosen mutation wasn't possible, in which case
+// sched is unchanged.
+func (sched *schedule) mutate(ss *searchStore, rng *rand.Rand) bool {
+	if len(sched.Slots) == 0 {
+		return false
+	}
+	switch rng.Intn(3) {
+	case 0:
+		return sched.mutateMove(ss, rng)
+	case 1:
+		return sched.mutateSwap(ss, rng)
+	default:
+		return sched.mutatePlace(ss, rng)
+	}
+}
+
+// scheduleMakeRandom places discussions, in random order, into random
+// slots which will accept them.
+func scheduleMakeRandom(ss *searchStore, rng *rand.Rand) *schedule {
+	sched := scheduleMakeEmpty(ss)
+	discs := sched.UnplacedDiscussions
+	sched.UnplacedDiscussions = nil
+	rng.Shuffle(len(discs), func(i, j int) { discs[i], discs[j] = discs[j], discs[i] })
+
+	for _, disc := range discs {
+		if !sched.placeRandom(ss, rng, disc) {
+			sched.UnplacedDiscussions = append(sched.UnplacedDiscussions, disc)
+		}
+	}
+	return sched
+}
+
+// placeRandom puts disc in a random slot which will accept it,
+// returning false if there is no such slot.
+func (sched *schedule) placeRandom(ss *searchStore, rng *rand.Rand, disc *searchDiscussion) bool {
+	for _, i := range rng.Perm(len(sched.Slots)) {
+		if sched.accepts(ss, i, disc) {
+			sched.place(i, disc)
+			return true
+		}
+	}
+	return false
+}
+
+// makeScheduleRandom performs hill-climbing from the starting
+// schedule, restarting from a random schedule whenever it gets stuck
+// for too long.
+func makeScheduleRandom(ss *searchStore, start *schedule, opt *SearchOptions) *schedule {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	deadline := time.Now().Add(opt.SearchDuration)
+
+	best, bestScore := start, start.score()
+	cur, curScore := start, bestScore
+	stale, restarts, iterations := 0, 0, 0
+
+	for time.Now().Before(deadline) {
+		iterations++
+		if stale > searchRestartThreshold {
+			cur = scheduleMakeRandom(ss, rng)
+			curScore = cur.score()
+			stale = 0
+			restarts++
+			opt.debugf(2, "Random restart %d: score %d", restarts, curScore)
+		}
+
+		next := cur.clone()
+		if !next.mutate(ss, rng) {
+			stale++
+			continue
+		}
+
+		nextScore := next.score()
+		switch {
+		case nextScore > curScore:
+			stale = 0
+		case nextScore == curScore:
+			// Accept sideways moves to get across plateaus
+			stale++
+		default:
+			stale++
+			continue
+		}
+		cur, curScore = next, nextScore
+
+		if curScore > bestScore {
+			best, bestScore = cur, curScore
+			opt.debugf(1, "New best score %d after %d iterations", bestScore, iterations)
+		}
+	}
+
+	opt.debugf(1, "Random search: %d iterations, %d restarts, best score %d",
+		iterations, restarts, bestScore)
+
+	return best
+}
+
+type searchCandidate struct {
+	sched *schedule
+	score int
+}
+
+// assignment maps each discussion to the index of the (first) slot
+// it's in, or -1 if it's unplaced.
+func (sched *schedule) assignment() map[*searchDiscussion]int {
+	a := map[*searchDiscussion]int{}
+	for i := range sched.Slots {
+		for _, disc := range sched.Slots[i].Discussions {
+			if _, ok := a[disc]; !ok {
+				a[disc] = i
+			}
+		}
+	}
+	for _, disc := range sched.UnplacedDiscussions {
+		a[disc] = -1
+	}
+	return a
+}
+
+// crossover makes a child where each discussion tries to go to the
+// slot it was in in one of the two parents (chosen at random),
+// falling back to the other parent's slot, then any slot at all.
+func crossover(ss *searchStore, rng *rand.Rand, a, b *schedule) *schedule {
+	child := scheduleMakeEmpty(ss)
+	discs := child.UnplacedDiscussions
+	child.UnplacedDiscussions = nil
+	rng.Shuffle(len(discs), func(i, j int) { discs[i], discs[j] = discs[j], discs[i] })
+
+	aa, ba := a.assignment(), b.assignment()
+
+	for _, disc := range discs {
+		first, second := aa[disc], ba[disc]
+		if rng.Intn(2) == 0 {
+			first, second = second, first
+		}
+
+		placed := false
+		for _, idx := range []int{first, second} {
+			if idx < 0 {
+				continue
+			}
+			if child.accepts(ss, idx, disc) {
+				child.place(idx, disc)
+				placed = true
+				break
+			}
+		}
+		if !placed && !child.placeRandom(ss, rng, disc) {
+			child.UnplacedDiscussions = append(child.UnplacedDiscussions, disc)
+		}
+	}
+	return child
+}
+
+func tournament(pop []searchCandidate, rng *rand.Rand) *schedule {
+	best := &pop[rng.Intn(len(pop))]
+	for i := 1; i < searchTournamentSize; i++ {
+		c := &pop[rng.Intn(len(pop))]
+		if c.score > best.score {
+			best = c
+		}
+	}
+	return best.sched
+}
+
+// makeScheduleGenetic evolves a population seeded with the starting
+// schedule.  Each generation breeds a new set of children by
+// tournament selection, crossover and mutation; the best of parents
+// and children survive.  Since the population is sorted and the
+// parents compete with the children, the best schedule is never lost.
+func makeScheduleGenetic(ss *searchStore, start *schedule, opt *SearchOptions) *schedule {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	deadline := time.Now().Add(opt.SearchDuration)
+
+	pop := []searchCandidate{{sched: start, score: start.score()}}
+	for len(pop) < searchPopulationSize {
+		var s *schedule
+		if len(pop)%2 == 0 {
+			s = scheduleMakeRandom(ss, rng)
+		} else {
+			s = start.clone()
+			for i := 0; i < searchMutationsMax; i++ {
+				s.mutate(ss, rng)
+			}
+		}
+		pop = append(pop, searchCandidate{sched: s, score: s.score()})
+	}
+
+	generations := 0
+	for time.Now().Before(deadline) {
+		generations++
+		for i := 0; i < searchPopulationSize; i++ {
+			child := crossover(ss, rng, tournament(pop, rng), tournament(pop, rng))
+			for n := rng.Intn(searchMutationsMax + 1); n > 0; n-- {
+				child.mutate(ss, rng)
+			}
+			pop = append(pop, searchCandidate{sched: child, score: child.score()})
+		}
+
+		sort.SliceStable(pop, func(i, j int) bool {
+			return pop[i].score > pop[j].score
+		})
+		pop = pop[:searchPopulationSize]
+
+		opt.debugf(2, "Generation %d: best score %d", generations, pop[0].score)
+	}
+
+	opt.debugf(1, "Genetic search: %d generations, best score %d",
+		generations, pop[0].score)
+
+	return pop[0].sched
+}
+
+// scheduleValidate checks that every discussion in the search store
+// appears exactly once in sched, taking up the right number of
+// consecutive slots, and that every slot accepts all of the
+// discussions placed in it.
+func scheduleValidate(ss *searchStore, sched *schedule) error {
+	seen := map[DiscussionID]bool{}
+	check := func(disc *searchDiscussion) error {
+		if seen[disc.DiscussionID] {
+			return fmt.Errorf("Discussion %v appears more than once", disc.DiscussionID)
+		}
+		seen[disc.DiscussionID] = true
+		return nil
+	}
+
+	for i := range sched.Slots {
+		s := &sched.Slots[i]
+		for j, disc := range s.Discussions {
+			if disc.Fixed {
+				continue
+			}
+			others := append(append([]*searchDiscussion(nil), s.Discussions[:j]...), s.Discussions[j+1:]...)
+			if !slotAccepts(ss, s.SlotID, others, disc) {
+				return fmt.Errorf("Discussion %v not allowed in slot %v",
+					disc.DiscussionID, s.SlotID)
+			}
+
+			// Check the rest of a discussion's slots from its first
+			if sched.start(i, disc) != i {
+				continue
+			}
+			if err := check(disc); err != nil {
+				return err
+			}
+			end := i + disc.length()
+			if ss.SlotRuns[i] < disc.length() {
+				return fmt.Errorf("Discussion %v doesn't fit in slots starting at %v",
+					disc.DiscussionID, s.SlotID)
+			}
+			for k := i + 1; k < end; k++ {
+				if discussionIndex(sched.Slots[k].Discussions, disc) < 0 {
+					return fmt.Errorf("Discussion %v missing from slot %v",
+						disc.DiscussionID, sched.Slots[k].SlotID)
+				}
+			}
+			if end < len(sched.Slots) && discussionIndex(sched.Slots[end].Discussions, disc) >= 0 {
+				return fmt.Errorf("Discussion %v takes up too many slots", disc.DiscussionID)
+			}
+		}
+	}
+
+	for _, disc := range sched.UnplacedDiscussions {
+		if err := check(disc); err != nil {
+			return err
+		}
+	}
+
+	if len(seen) != len(ss.Discussions) {
+		return fmt.Errorf("Schedule has %d discussions, expected %d",
+			len(seen), len(ss.Discussions))
+	}
+
+	return nil
+}
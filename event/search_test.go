@@ -0,0 +1,283 @@
+package event
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// testScheduleSearchSetup makes users, discussions, locations, a
+// timetable, and random interest, with all discussions public.
+func testScheduleSearchSetup(t *testing.T, m *mirrorData, discussionCount, locationCount int) (exit bool) {
+	exit = true
+
+	if testNewUsers(t, m, 10) {
+		return
+	}
+
+	m.discussions = make([]Discussion, discussionCount)
+	for i := range m.discussions {
+		subexit := false
+		uidx := rand.Int31n(int32(len(m.users)))
+		m.discussions[i], subexit = testNewDiscussion(t, m.users[uidx].UserID)
+		if subexit {
+			return
+		}
+	}
+
+	for i := 0; i < locationCount; i++ {
+		if _, subexit := testNewLocation(t); subexit {
+			return
+		}
+	}
+
+	tt := Timetable{
+		Days: []TimetableDay{
+			{DayName: "Monday", Slots: []TimetableSlot{
+				{Time: Date(2020, 7, 6, 14, 30, 0, 0, time.UTC)},
+				{Time: Date(2020, 7, 6, 15, 15, 0, 0, time.UTC)},
+				{Time: Date(2020, 7, 6, 16, 00, 0, 0, time.UTC), IsBreak: true},
+				{Time: Date(2020, 7, 6, 16, 30, 0, 0, time.UTC)},
+			}},
+			{DayName: "Tuesday", Slots: []TimetableSlot{
+				{Time: Date(2020, 7, 7, 14, 30, 0, 0, time.UTC)},
+				{Time: Date(2020, 7, 7, 15, 15, 0, 0, time.UTC)},
+				{Time: Date(2020, 7, 7, 16, 00, 0, 0, time.UTC), IsBreak: true},
+				{Time: Date(2020, 7, 7, 16, 30, 0, 0, time.UTC)},
+			}},
+		},
+	}
+
+	if err := event.TimetableSet("", &tt, ImpactAbort); err != nil {
+		t.Errorf("ERROR Basic TimetableSet: %v", err)
+		return
+	}
+
+	for i := 0; i < (len(m.users)*len(m.discussions))/2; i++ {
+		uidx := rand.Intn(len(m.users))
+		didx := rand.Intn(len(m.discussions))
+		err := event.UserSetInterest(&m.users[uidx], &m.discussions[didx], rand.Intn(InterestMax+1))
+		if err != nil {
+			t.Errorf("Setting interest: %v", err)
+			return
+		}
+	}
+
+	for i := range m.discussions {
+		if err := event.DiscussionSetPublic("", m.discussions[i].DiscussionID, true); err != nil {
+			t.Errorf("Setting discussion public: %v", err)
+			return
+		}
+	}
+
+	return false
+}
+
+func testScheduleSearch(t *testing.T) (exit bool) {
+	// Any "early" exit is a failure
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+
+	m := &mirrorData{}
+
+	t.Logf("Setting up users, discussion, timetable")
+	if testScheduleSearchSetup(t, m, 16, 3) {
+		return
+	}
+
+	ss, err := event.makeSnapshot()
+	if err != nil {
+		t.Errorf("Getting snapshot: %v", err)
+		return
+	}
+
+	heuristic, err := makeScheduleHeuristic(ss)
+	if err != nil {
+		t.Errorf("Making heuristic schedule: %v", err)
+		return
+	}
+	if err = scheduleValidate(ss, heuristic); err != nil {
+		t.Errorf("Heuristic schedule invalid: %v", err)
+		return
+	}
+	hscore := heuristic.score()
+
+	// Random schedules and mutations should always be valid
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 10; i++ {
+		sched := scheduleMakeRandom(ss, rng)
+		for j := 0; j < 100; j++ {
+			sched.mutate(ss, rng)
+		}
+		if err = scheduleValidate(ss, sched); err != nil {
+			t.Errorf("Random mutated schedule invalid: %v", err)
+			return
+		}
+	}
+
+	opt := SearchOptions{SearchDuration: 200 * time.Millisecond}
+	for _, algo := range []struct {
+		name string
+		f    func(*searchStore, *schedule, *SearchOptions) *schedule
+	}{
+		{"random", makeScheduleRandom},
+		{"genetic", makeScheduleGenetic},
+	} {
+		t.Logf("Testing %s search", algo.name)
+		start := heuristic.clone()
+		sched := algo.f(ss, start, &opt)
+		if err = scheduleValidate(ss, sched); err != nil {
+			t.Errorf("%s search schedule invalid: %v", algo.name, err)
+			return
+		}
+		if sched.score() < hscore {
+			t.Errorf("%s search score %d worse than heuristic score %d",
+				algo.name, sched.score(), hscore)
+			return
+		}
+		// The starting schedule must not be modified
+		if start.score() != hscore {
+			t.Errorf("%s search modified starting schedule", algo.name)
+			return
+		}
+	}
+
+	// Full run; there are enough slots and locations for everything,
+	// so all discussions should end up in the schedule.
+	for _, algo := range []SearchAlgo{SearchRandom, SearchGenetic} {
+		err = event.MakeSchedule(SearchOptions{
+			Algo:           algo,
+			Validate:       true,
+			SearchDuration: 100 * time.Millisecond,
+		})
+		if err != nil {
+			t.Errorf("MakeSchedule(%s): %v", algo, err)
+			return
+		}
+
+		var count int
+		if err = event.Get(&count, `select count(*) from event_schedule`); err != nil {
+			t.Errorf("Counting schedule entries: %v", err)
+			return
+		}
+		if count != len(m.discussions) {
+			t.Errorf("MakeSchedule(%s): Wanted %d schedule entries, got %d",
+				algo, len(m.discussions), count)
+			return
+		}
+	}
+
+	if err = event.MakeSchedule(SearchOptions{Algo: "bogus"}); err == nil {
+		t.Errorf("MakeSchedule with unknown algorithm unexpectedly succeeded")
+		return
+	}
+
+	tc.cleanup()
+
+	return false
+}
+
+func testScheduleOwnerConflict(t *testing.T) (exit bool) {
+	// Any "early" exit is a failure
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+
+	m := &mirrorData{}
+
+	// Enough locations that the other discussions can never fill
+	// up a slot
+	t.Logf("Setting up users, discussion, timetable")
+	if testScheduleSearchSetup(t, m, 4, 5) {
+		return
+	}
+
+	// Give one user more discussions than there are slots
+	busy := m.users[0].UserID
+	for i := 0; i < 8; i++ {
+		disc, subexit := testNewDiscussion(t, busy)
+		if subexit {
+			return
+		}
+		if err := event.DiscussionSetPublic("", disc.DiscussionID, true); err != nil {
+			t.Errorf("Setting discussion public: %v", err)
+			return
+		}
+	}
+
+	ss, err := event.makeSnapshot()
+	if err != nil {
+		t.Errorf("Getting snapshot: %v", err)
+		return
+	}
+
+	owned := 0
+	for i := range ss.Discussions {
+		if ss.Discussions[i].Owner == busy {
+			owned++
+		}
+	}
+	wantUnplaced := owned - len(ss.Slots)
+
+	// Every discussion which can't be placed must be in
+	// UnplacedDiscussions (which scheduleValidate checks).  The
+	// heuristic always places a discussion if it can; the searches
+	// may choose not to if it doesn't affect the score.
+	check := func(desc string, sched *schedule, exact bool) bool {
+		if err := scheduleValidate(ss, sched); err != nil {
+			t.Errorf("%s: Invalid schedule: %v", desc, err)
+			return true
+		}
+		for i := range sched.Slots {
+			owners := map[UserID]bool{}
+			for _, disc := range sched.Slots[i].Discussions {
+				if owners[disc.Owner] {
+					t.Errorf("%s: Slot %d has two discussions owned by %v",
+						desc, i, disc.Owner)
+					return true
+				}
+				owners[disc.Owner] = true
+			}
+		}
+		unplaced := 0
+		for _, disc := range sched.UnplacedDiscussions {
+			if disc.Owner == busy {
+				unplaced++
+			}
+		}
+		if unplaced < wantUnplaced || (exact && unplaced != wantUnplaced) {
+			t.Errorf("%s: Wanted %d unplaced discussions, got %d",
+				desc, wantUnplaced, unplaced)
+			return true
+		}
+		return false
+	}
+
+	heuristic, err := makeScheduleHeuristic(ss)
+	if err != nil {
+		t.Errorf("Making heuristic schedule: %v", err)
+		return
+	}
+	if check("heuristic", heuristic, true) {
+		return
+	}
+
+	opt := SearchOptions{SearchDuration: 100 * time.Millisecond}
+	if check("random", makeScheduleRandom(ss, heuristic.clone(), &opt), false) {
+		return
+	}
+	if check("genetic", makeScheduleGenetic(ss, heuristic.clone(), &opt), false) {
+		return
+	}
+
+	tc.cleanup()
+
+	return false
+}
@@ -14,9 +14,9 @@ func init() {
 // - Create four "unique" people at the beginning, with random interests
 // - Afterwards, choose someone randomly to emulate 90% of the time.
 // - When emulating somebody, choose like them 7/8 times
-func TestGenerateInterest() {
+func (store *EventStore) TestGenerateInterest() {
 	handled := []*User{}
-	UserIterate(func(user *User) error {
+	store.UserIterate(func(user *User) error {
 		var model *User
 		// Create 4 random "models" at first; after that, 10% are random
 		if len(handled) > 4 && rand.Intn(10) != 0 {
@@ -26,7 +26,7 @@ func TestGenerateInterest() {
 		} else {
 			log.Printf("User %s will be themselves", user.Username)
 		}
-		DiscussionIterate(func(disc *DiscussionFull) error {
+		store.DiscussionIterate(func(disc *DiscussionFull) error {
 			r := rand.Intn(100)
 			interest := 0
 
@@ -49,7 +49,7 @@ func TestGenerateInterest() {
 
 			log.Printf("Setting uid %s interest in discussion %s to %d",
 				user.Username, disc.Title, interest)
-			if err := user.SetInterest(&disc.Discussion, interest); err != nil {
+			if err := store.UserSetInterest(user, &disc.Discussion, interest); err != nil {
 				log.Fatalf("Setting interest: %v", err)
 			}
 			return nil
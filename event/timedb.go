@@ -25,8 +25,8 @@ func (l *TZLocation) Scan(src interface{}) error {
 		var err error
 		if ts == "" {
 			log.Printf("INTERNAL ERROR: Empty string for location; using default %v",
-				event.defaultLocation)
-			l.Location = event.defaultLocation
+				defaultLocation)
+			l.Location = defaultLocation
 		} else {
 			l.Location, err = time.LoadLocation(ts)
 		}
@@ -44,6 +44,15 @@ func (l TZLocation) Value() (driver.Value, error) {
 	return driver.Value(l.String()), nil
 }
 
+// MarshalText renders the location as its name (e.g.,
+// "Europe/Berlin"), so that it shows up usefully in JSON.
+func (l TZLocation) MarshalText() ([]byte, error) {
+	if l.Location == nil {
+		return []byte{}, nil
+	}
+	return []byte(l.String()), nil
+}
+
 type Time struct {
 	time.Time
 }
@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"sort"
+	"strconv"
 
 	"github.com/jmoiron/sqlx"
 
@@ -17,12 +19,25 @@ type TimetableDiscussion struct {
 	Score        int
 	LocationName string
 	LocationURL  string
+	IsPlace      bool
+	Capacity     int
+
+	// Projected attendance exceeds the capacity of the place
+	OverCapacity bool
+
+	// Number of slots the discussion takes up.  A discussion longer
+	// than one slot is listed in each of them, first in any slot
+	// after the first, where Continued is set.
+	Length    int
+	Continued bool
 }
 
 type TimetableSlot struct {
-	Time        Time // NB: Must duplicate this so that sqlx's StructScan doesn't get confused
+	SlotID      SlotID // "" for slots which don't exist yet; see TimetableSet
+	Time        Time   // NB: Must duplicate this so that sqlx's StructScan doesn't get confused
 	TimeDisplay string
 	IsBreak     bool
+	IsLocked    bool
 
 	// Which room will each discussion be in?
 	// (Separate because placement and scheduling are separate steps)
@@ -52,9 +67,9 @@ type DisplaySlot struct {
 
 // FIXME: Add testing for [GS]etLockedSlots
 
-func TimetableGetLockedSlots() []DisplaySlot {
+func (store *EventStore) TimetableGetLockedSlots() []DisplaySlot {
 	var ds []DisplaySlot
-	err := txLoop(func(eq sqlx.Ext) error {
+	err := store.txLoop(func(eq sqlx.Ext) error {
 		err := sqlx.Select(eq, &ds, `
             select slotid,
                    slottime,
@@ -74,11 +89,21 @@ func TimetableGetLockedSlots() []DisplaySlot {
 	return ds
 }
 
-func TimetableSetLockedSlots(pslots []SlotID) error {
-	return txLoop(func(eq sqlx.Ext) error {
+// TimetableSetLockedSlots locks the slots in pslots, and unlocks all
+// others.  actor is the user making the change.
+func (store *EventStore) TimetableSetLockedSlots(actor UserID, pslots []SlotID) error {
+	return store.txLoop(func(eq sqlx.Ext) error {
+		var before []SlotID
+		err := sqlx.Select(eq, &before, `
+            select slotid from event_slots
+                where islocked = true
+                order by dayid, slotidx`)
+		if err != nil {
+			return errOrRetry("Getting locked slots", err)
+		}
+
 		var q string
 		var args []interface{}
-		var err error
 		if len(pslots) > 0 {
 			q, args, err = sqlx.In(`
             update event_slots
@@ -93,7 +118,15 @@ func TimetableSetLockedSlots(pslots []SlotID) error {
 		if err != nil {
 			return errOrRetry("Updating locked slots", err)
 		}
-		return nil
+
+		err = auditTx(eq, actor, AuditTimetableSetLocked, "",
+			struct{ LockedSlots []SlotID }{before},
+			struct{ LockedSlots []SlotID }{pslots})
+		if err != nil {
+			return err
+		}
+
+		return schedMarkModifiedTx(eq)
 	})
 }
 
@@ -101,8 +134,8 @@ func TimetableSetLockedSlots(pslots []SlotID) error {
 // If tfmt is non-empty, TimetableStot.TimeDisplay will be formatted
 // with the specified time.  If tzl is non-nil, the location will be
 // converted to that location before displaying.
-func GetTimetable(tfmt string, tzl *TZLocation) (tt Timetable, err error) {
-	err = txLoop(func(eq sqlx.Ext) error {
+func (store *EventStore) GetTimetable(tfmt string, tzl *TZLocation) (tt Timetable, err error) {
+	err = store.txLoop(func(eq sqlx.Ext) error {
 		err := sqlx.Select(eq, &tt.Days,
 			`select dayname from event_days order by dayid asc`)
 		if err != nil {
@@ -122,7 +155,7 @@ func GetTimetable(tfmt string, tzl *TZLocation) (tt Timetable, err error) {
 			}
 
 			err = sqlx.Select(eq, &td.Slots,
-				`select slottime as time, isbreak
+				`select slotid, slottime as time, isbreak, islocked
                      from event_slots
                      where dayid=?
                      order by slotidx asc`, dayID)
@@ -133,30 +166,47 @@ func GetTimetable(tfmt string, tzl *TZLocation) (tt Timetable, err error) {
 			for j := range td.Slots {
 				ts := &td.Slots[j]
 				err = sqlx.Select(eq, &ts.Discussions, `
-with intjoin (userid, discussionid, interest, locationname, locationurl) as
-  (select userid, discussionid, interest, locationname, locationurl
+with intjoin (userid, discussionid, interest, locationname, locationurl, isplace, capacity) as
+  (select userid, discussionid, interest, locationname, locationurl, isplace, capacity
        from event_interest
            natural join event_schedule
 	       natural join event_slots
            natural join event_locations
-       where dayid=? and slotidx=?),
-maxint (userid, discussionid, maxint, locationname, locationurl) as
-    (select x.userid, discussionid, maxint, locationname, locationurl
+       where dayid=? and slotidx=?
+           and userid in (select userid from event_users where deleted = 0)),
+maxint (userid, discussionid, maxint, locationname, locationurl, isplace, capacity) as
+    (select x.userid, discussionid, maxint, locationname, locationurl, isplace, capacity
      from intjoin x
         join (select userid, max(interest) as maxint
                     from intjoin
                group by userid) y
 	on x.userid = y.userid and x.interest = y.maxint),
-discint (discussionid, attendees, score, locationname, locationurl) as
-	(select discussionid, count(*) as attendees, sum(maxint) as score, locationname, locationurl
+discint (discussionid, attendees, score, locationname, locationurl, isplace, capacity) as
+	(select discussionid, count(*) as attendees, sum(maxint) as score, locationname, locationurl, isplace, capacity
              from maxint
     	     group by discussionid)
-select discussionid, title, attendees, score, locationname, locationurl
+select discussionid, title, attendees, score, locationname, locationurl, isplace, capacity,
+       approvedlength as length
     from discint natural join event_discussions
     order by attendees desc`, dayID, j+1)
 				if err != nil {
 					return errOrRetry("Getting discussion info for slot", err)
 				}
+
+				for k := range ts.Discussions {
+					disc := &ts.Discussions[k]
+					disc.OverCapacity = disc.IsPlace && disc.Attendees > disc.Capacity
+					if j > 0 {
+						for _, prev := range td.Slots[j-1].Discussions {
+							if prev.DiscussionID == disc.DiscussionID {
+								disc.Continued = true
+							}
+						}
+					}
+				}
+				sort.SliceStable(ts.Discussions, func(a, b int) bool {
+					return ts.Discussions[a].Continued && !ts.Discussions[b].Continued
+				})
 			}
 
 			if tfmt != "" {
@@ -234,13 +284,14 @@ func dayAddTx(eq sqlx.Ext, d *Day) error {
 	return nil
 }
 
-func NewDay(d *Day) (DayID, error) {
+// NewDay adds d, filling in its DayID.  actor is the user adding it.
+func (store *EventStore) NewDay(actor UserID, d *Day) (DayID, error) {
 	err := checkDayParams(d)
 	if err != nil {
 		return d.DayID, err
 	}
 
-	return d.DayID, txLoop(func(eq sqlx.Ext) error {
+	err = store.txLoop(func(eq sqlx.Ext) error {
 		maxdayid, err := getMaxDay(eq)
 		if err != nil {
 			return errOrRetry("Getting  max dayid", err)
@@ -248,15 +299,20 @@ func NewDay(d *Day) (DayID, error) {
 
 		d.DayID = DayID(maxdayid + 1)
 
-		return dayAddTx(eq, d)
+		if err := dayAddTx(eq, d); err != nil {
+			return err
+		}
+
+		return auditTx(eq, actor, AuditDayCreate, strconv.Itoa(int(d.DayID)), nil, d)
 	})
+	return d.DayID, err
 }
 
 /// DayFindById
-func DayFindByID(did DayID) (*Day, error) {
+func (store *EventStore) DayFindByID(did DayID) (*Day, error) {
 	day := &Day{}
 	for {
-		err := event.Get(day,
+		err := store.Get(day,
 			`select * from event_days where dayid = ?`,
 			did)
 		switch {
@@ -286,7 +342,8 @@ func daySlotsDeleteTx(eq sqlx.Ext, did DayID, firstDelIdx int) error {
 	}
 
 	if lockedSlots > 0 {
-		return fmt.Errorf("Cannot delete slot range, %d are locked", lockedSlots)
+		log.Printf("Cannot delete slot range, %d are locked", lockedSlots)
+		return errSlotLocked
 	}
 
 	// Delete schedule entries for slots we're about to delete
@@ -299,6 +356,26 @@ func daySlotsDeleteTx(eq sqlx.Ext, did DayID, firstDelIdx int) error {
 		return errOrRetry("Deleting schedule entries for slot range", err)
 	}
 
+	// ...and discussions' possible slots
+	_, err = eq.Exec(
+		`delete from event_discussions_possible_slots
+             where slotid in
+                 (select slotid from event_slots
+                      where dayid=? and slotidx >= ?)`, did, firstDelIdx)
+	if err != nil {
+		return errOrRetry("Deleting possible slots for slot range", err)
+	}
+
+	// ...and pins
+	_, err = eq.Exec(
+		`delete from event_pins
+             where slotid in
+                 (select slotid from event_slots
+                      where dayid=? and slotidx >= ?)`, did, firstDelIdx)
+	if err != nil {
+		return errOrRetry("Deleting pins for slot range", err)
+	}
+
 	// Delete the slots
 	res, err := eq.Exec(`delete from event_slots where dayid=? and slotidx >= ?`,
 		did, firstDelIdx)
@@ -341,13 +418,57 @@ func deleteDayTx(eq sqlx.Ext, did DayID) error {
 		log.Printf("ERROR Expected to change 1 row, changed %d", rcount)
 		return ErrInternal
 	}
+
+	// Day IDs must stay contiguous, so move the following days down
+	// one, taking their slots with them.
+	maxdayid, err := getMaxDay(eq)
+	if err != nil {
+		return errOrRetry("Getting max dayid", err)
+	}
+	for dayid := int(did) + 1; dayid <= maxdayid; dayid++ {
+		_, err = eq.Exec(`
+            insert into event_days(dayid, dayname)
+                select ?, dayname from event_days where dayid = ?`,
+			dayid-1, dayid)
+		if err != nil {
+			return errOrRetry("Renumbering day", err)
+		}
+		_, err = eq.Exec(`update event_slots set dayid = ? where dayid = ?`,
+			dayid-1, dayid)
+		if err != nil {
+			return errOrRetry("Renumbering day's slots", err)
+		}
+		_, err = eq.Exec(`delete from event_days where dayid = ?`, dayid)
+		if err != nil {
+			return errOrRetry("Renumbering day", err)
+		}
+	}
 	return nil
 }
 
-// DeleteDay
-func DeleteDay(did DayID) error {
-	return txLoop(func(eq sqlx.Ext) error {
-		return deleteDayTx(eq, did)
+// DeleteDay deletes a day and its slots; the following days are
+// renumbered.  action says what to do if anything is scheduled in the
+// day's slots.
+func (store *EventStore) DeleteDay(actor UserID, did DayID, action ImpactAction) error {
+	return store.txLoop(func(eq sqlx.Ext) error {
+		before, err := timetableAuditDaysTx(eq, "where dayid = ?", did)
+		if err != nil {
+			return err
+		}
+
+		err = scheduleImpactTx(eq, action, func() error {
+			return deleteDayTx(eq, did)
+		})
+		if err != nil {
+			return err
+		}
+
+		err = auditTx(eq, actor, AuditDayDelete, strconv.Itoa(int(did)), before, nil)
+		if err != nil {
+			return err
+		}
+
+		return schedMarkModifiedTx(eq)
 	})
 }
 
@@ -361,13 +482,26 @@ func dayUpdateTx(e sqlx.Execer, d *Day) error {
 }
 
 // DayUpdate: Set d.DayID's fields
-func DayUpdate(d *Day) error {
+func (store *EventStore) DayUpdate(actor UserID, d *Day) error {
 	if err := checkDayParams(d); err != nil {
 		return err
 	}
 
-	return txLoop(func(eq sqlx.Ext) error {
-		return dayUpdateTx(eq, d)
+	return store.txLoop(func(eq sqlx.Ext) error {
+		// sqlx can't scan into Day's embedded DayID
+		before := Day{DayID: d.DayID}
+		err := sqlx.Get(eq, &before.DayName, `select dayname from event_days where dayid = ?`, d.DayID)
+		if err == sql.ErrNoRows {
+			return ErrDayNotFound
+		} else if err != nil {
+			return errOrRetry("Getting day", err)
+		}
+
+		if err := dayUpdateTx(eq, d); err != nil {
+			return errOrRetry("Updating day", err)
+		}
+
+		return auditTx(eq, actor, AuditDayUpdate, strconv.Itoa(int(d.DayID)), &before, d)
 	})
 }
 
@@ -384,8 +518,29 @@ func timetableSlotAddTx(eq sqlx.Ext, dayid DayID, slotIdx int, slot *TimetableSl
 }
 
 func timetableSlotUpdateTx(eq sqlx.Ext, dayID DayID, slotidx int, slot *TimetableSlot) error {
-	// FIXME: Also need to delete schedule entries if going isBreak ==
-	// false => true (and return an error if that slot is locked)
+	if slot.IsBreak {
+		var locked int
+		err := sqlx.Get(eq, &locked, `
+            select count(*) from event_slots
+                where dayid=? and slotidx=? and isbreak=false and islocked=true`,
+			dayID, slotidx)
+		if err != nil {
+			return errOrRetry("Checking for locked slot", err)
+		}
+		if locked > 0 {
+			return errSlotLocked
+		}
+
+		_, err = eq.Exec(`
+            delete from event_schedule
+                where slotid in (select slotid from event_slots
+                                     where dayid=? and slotidx=?)`,
+			dayID, slotidx)
+		if err != nil {
+			return errOrRetry("Deleting schedule entries for break", err)
+		}
+	}
+
 	_, err := eq.Exec(`
         update event_slots
             set slottime=?, isbreak=?
@@ -452,7 +607,157 @@ func timetableDayUpdateTx(eq sqlx.Ext, d *Day, slots []TimetableSlot) error {
 	return nil
 }
 
+func checkTimetableDay(d *Day, td *TimetableDay) error {
+	if err := checkDayParams(d); err != nil {
+		return err
+	}
+
+	// Sanity-check: Distance between last slot and first slot must be < 24 hours
+	if len(td.Slots) > 2 {
+		if td.Slots[len(td.Slots)-1].Time.Sub(td.Slots[0].Time.Time).Hours() > 24.0 {
+			return fmt.Errorf("Slots span more than 24 hours!")
+		}
+	}
+	return nil
+}
+
+// deleteSlotTx deletes a single slot, which mustn't be locked, along
+// with anything referring to it.
+func deleteSlotTx(eq sqlx.Ext, slotid SlotID) error {
+	for _, table := range []string{"event_schedule", "event_discussions_possible_slots", "event_pins"} {
+		_, err := eq.Exec(`delete from `+table+` where slotid = ?`, slotid)
+		if err != nil {
+			return errOrRetry("Deleting references to slot", err)
+		}
+	}
+	_, err := eq.Exec(`delete from event_slots where slotid = ?`, slotid)
+	if err != nil {
+		return errOrRetry("Deleting slot", err)
+	}
+	return nil
+}
+
+// timetableSetByIDTx is timetableSetTx for timetables whose existing
+// slots are identified by their SlotIDs.  Slots keep their identity
+// (and so their schedule entries) wherever they're moved; slots
+// missing from tt are deleted, and slots without a SlotID are added.
+func timetableSetByIDTx(eq sqlx.Ext, tt *Timetable) error {
+	var existing []struct {
+		SlotID   SlotID
+		IsLocked bool
+	}
+	err := sqlx.Select(eq, &existing, `select slotid, islocked from event_slots`)
+	if err != nil {
+		return errOrRetry("Getting slots", err)
+	}
+	isLocked := map[SlotID]bool{}
+	for _, s := range existing {
+		isLocked[s.SlotID] = s.IsLocked
+	}
+
+	keep := map[SlotID]bool{}
+	for i := range tt.Days {
+		td := &tt.Days[i]
+		day := Day{DayID: DayID(i + 1), DayName: td.DayName}
+		if err := checkTimetableDay(&day, td); err != nil {
+			return err
+		}
+		for j := range td.Slots {
+			ts := &td.Slots[j]
+			if ts.SlotID == "" {
+				continue
+			}
+			if _, ok := isLocked[ts.SlotID]; !ok || keep[ts.SlotID] {
+				return errSlotNotFound
+			}
+			if ts.IsBreak && isLocked[ts.SlotID] {
+				return errSlotLocked
+			}
+			keep[ts.SlotID] = true
+		}
+	}
+
+	// Delete the slots which have gone
+	for _, s := range existing {
+		if keep[s.SlotID] {
+			continue
+		}
+		if s.IsLocked {
+			return errSlotLocked
+		}
+		if err := deleteSlotTx(eq, s.SlotID); err != nil {
+			return err
+		}
+	}
+
+	// Add or rename days, so that slots can be moved into them
+	curmaxdayid, err := getMaxDay(eq)
+	if err != nil {
+		return err
+	}
+	for i := range tt.Days {
+		day := Day{DayID: DayID(i + 1), DayName: tt.Days[i].DayName}
+		if i < curmaxdayid {
+			err = dayUpdateTx(eq, &day)
+		} else {
+			err = dayAddTx(eq, &day)
+		}
+		if err != nil {
+			return errOrRetry("Updating day", err)
+		}
+	}
+
+	// Move the remaining slots out of the way, so that they can be
+	// renumbered without clashing with each other
+	_, err = eq.Exec(`update event_slots set slotidx = -slotidx`)
+	if err != nil {
+		return errOrRetry("Renumbering slots", err)
+	}
+
+	for i := range tt.Days {
+		dayid := DayID(i + 1)
+		for j := range tt.Days[i].Slots {
+			ts := &tt.Days[i].Slots[j]
+			if ts.SlotID == "" {
+				if err := timetableSlotAddTx(eq, dayid, j+1, ts); err != nil {
+					return err
+				}
+				continue
+			}
+			_, err = eq.Exec(`
+                update event_slots
+                    set dayid=?, slotidx=?, slottime=?, isbreak=?
+                    where slotid=?`,
+				dayid, j+1, ts.Time, ts.IsBreak, ts.SlotID)
+			if err != nil {
+				return errOrRetry("Updating slot", err)
+			}
+			if ts.IsBreak {
+				_, err = eq.Exec(`delete from event_schedule where slotid = ?`, ts.SlotID)
+				if err != nil {
+					return errOrRetry("Deleting schedule entries for break", err)
+				}
+			}
+		}
+	}
+
+	// Any days left over are now empty
+	_, err = eq.Exec(`delete from event_days where dayid > ?`, len(tt.Days))
+	if err != nil {
+		return errOrRetry("Deleting days", err)
+	}
+	return nil
+}
+
 func timetableSetTx(eq sqlx.Ext, tt *Timetable) error {
+	for i := range tt.Days {
+		for j := range tt.Days[i].Slots {
+			if tt.Days[i].Slots[j].SlotID != "" {
+				return timetableSetByIDTx(eq, tt)
+			}
+		}
+	}
+
 	// NB DayIDs start at 1, so there's an offset of 1 between
 	// tt.Days index and dayid.
 	curmaxdayid, err := getMaxDay(eq)
@@ -494,22 +799,13 @@ func timetableSetTx(eq sqlx.Ext, tt *Timetable) error {
 			DayID:   DayID(i + 1),
 			DayName: td.DayName,
 		}
-		err = checkDayParams(&day)
+		err = checkTimetableDay(&day, td)
 		if err != nil {
 			return err
 		}
 
 		log.Printf("day index %d, passed check", i)
 
-		// Sanity-check: Distance between last slot and first slot must be < 24 hours
-		if len(td.Slots) > 2 {
-			if td.Slots[len(td.Slots)-1].Time.Sub(td.Slots[0].Time.Time).Hours() > 24.0 {
-				return fmt.Errorf("Slots span more than 24 hours!")
-			}
-		}
-
-		log.Printf("day index %d, passed slot check", i)
-
 		if i < curmaxdayid {
 			log.Printf("day index %d, Updating day", i)
 			err = timetableDayUpdateTx(eq, &day, td.Slots)
@@ -531,14 +827,77 @@ func timetableSetTx(eq sqlx.Ext, tt *Timetable) error {
 // currently in the database, creating, deleting, or updating days and
 // slots as necessary.
 //
-// If a <day, slot> combination disappears, the schedule entries for
-// that day will be deleted; otherwise they will remain.  If a <day,
+// If a <day, slot> combination disappears, or becomes a break, the
+// schedule entries for that slot will be deleted; otherwise they will
+// remain.  If a <day,
 // slot> combination which is locked would be deleted, an error will
 // be returned instead.
 //
+// If any slots have SlotIDs (as returned by GetTimetable), slots are
+// matched by ID rather than by position instead: existing slots keep
+// their schedule entries wherever they're moved to, slots without an
+// ID are added, and any slots which aren't in tt are deleted.  Slots
+// which become breaks lose their schedule entries.
+//
+// action says what to do if any schedule entries would be deleted.
+//
 // Dealing with time zones and so on is the concern of the caller.
-func TimetableSet(tt *Timetable) error {
-	return txLoop(func(eq sqlx.Ext) error {
-		return timetableSetTx(eq, tt)
+//
+// actor is the user making the change.
+func (store *EventStore) TimetableSet(actor UserID, tt *Timetable, action ImpactAction) error {
+	return store.txLoop(func(eq sqlx.Ext) error {
+		before, err := timetableAuditDaysTx(eq, "")
+		if err != nil {
+			return err
+		}
+
+		err = scheduleImpactTx(eq, action, func() error {
+			return timetableSetTx(eq, tt)
+		})
+		if err != nil {
+			return err
+		}
+
+		after, err := timetableAuditDaysTx(eq, "")
+		if err != nil {
+			return err
+		}
+		if err := auditTx(eq, actor, AuditTimetableSet, "", before, after); err != nil {
+			return err
+		}
+
+		return schedMarkModifiedTx(eq)
 	})
 }
+
+// auditDay is the part of a day recorded in the audit log.
+type auditDay struct {
+	DayID   DayID
+	DayName string
+	Slots   []struct {
+		Time    Time
+		IsBreak bool
+	}
+}
+
+// timetableAuditDaysTx gets the days matching where (which may be
+// empty), along with their slots, for recording in the audit log.
+func timetableAuditDaysTx(eq sqlx.Ext, where string, args ...interface{}) ([]auditDay, error) {
+	var days []auditDay
+	err := sqlx.Select(eq, &days,
+		`select dayid, dayname from event_days `+where+` order by dayid`, args...)
+	if err != nil {
+		return nil, errOrRetry("Getting days", err)
+	}
+	for i := range days {
+		err = sqlx.Select(eq, &days[i].Slots, `
+            select slottime as time, isbreak
+                from event_slots
+                where dayid = ?
+                order by slotidx`, days[i].DayID)
+		if err != nil {
+			return nil, errOrRetry("Getting day slots", err)
+		}
+	}
+	return days, nil
+}
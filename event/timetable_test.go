@@ -3,6 +3,8 @@ package event
 import (
 	"testing"
 	"time"
+
+	"github.com/jmoiron/sqlx"
 )
 
 func compareTimetables(a *Timetable, b *Timetable, t *testing.T) bool {
@@ -60,7 +62,7 @@ func testUnitTimetable(t *testing.T) (exit bool) {
 		return
 	}
 
-	gottt, err := GetTimetable("", nil)
+	gottt, err := event.GetTimetable("", nil)
 	if err != nil {
 		t.Errorf("ERROR Getting empty timetable: %v", err)
 		return
@@ -88,12 +90,12 @@ func testUnitTimetable(t *testing.T) (exit bool) {
 	}
 
 	t.Logf("Creating basic timetable with %d days", len(tt.Days))
-	err = TimetableSet(&tt)
+	err = event.TimetableSet("", &tt, ImpactAbort)
 	if err != nil {
 		t.Errorf("ERROR Basic TimetableSet: %v", err)
 		return
 	}
-	gottt, err = GetTimetable("", nil)
+	gottt, err = event.GetTimetable("", nil)
 	if err != nil {
 		t.Errorf("ERROR Getting non-empty timetable: %v", err)
 		return
@@ -105,12 +107,12 @@ func testUnitTimetable(t *testing.T) (exit bool) {
 
 	t.Logf("Updating to a break")
 	tt.Days[1].Slots[2].IsBreak = true
-	err = TimetableSet(&tt)
+	err = event.TimetableSet("", &tt, ImpactAbort)
 	if err != nil {
 		t.Errorf("ERROR Basic TimetableSet update: %v", err)
 		return
 	}
-	gottt, err = GetTimetable("", nil)
+	gottt, err = event.GetTimetable("", nil)
 	if err != nil {
 		t.Errorf("ERROR Getting non-empty timetable: %v", err)
 		return
@@ -122,7 +124,7 @@ func testUnitTimetable(t *testing.T) (exit bool) {
 
 	t.Logf("Trying an invalid range (should fail)")
 	tt.Days[1].Slots[3].Time = Date(2020, 7, 8, 16, 30, 0, 0, time.UTC)
-	err = TimetableSet(&tt)
+	err = event.TimetableSet("", &tt, ImpactAbort)
 	if err == nil {
 		t.Errorf("ERROR Invalid range succeeded!")
 		return
@@ -138,12 +140,12 @@ func testUnitTimetable(t *testing.T) (exit bool) {
 	tt.Days[0].Slots = append(tt.Days[0].Slots,
 		TimetableSlot{Time: Date(2020, 7, 6, 17, 15, 0, 0, time.UTC)})
 	t.Logf("%v", tt)
-	err = TimetableSet(&tt)
+	err = event.TimetableSet("", &tt, ImpactAbort)
 	if err != nil {
 		t.Errorf("ERROR Day / slot add failed: %v", err)
 		return
 	}
-	gottt, err = GetTimetable("", nil)
+	gottt, err = event.GetTimetable("", nil)
 	if err != nil {
 		t.Errorf("ERROR Getting non-empty timetable: %v", err)
 		return
@@ -157,12 +159,12 @@ func testUnitTimetable(t *testing.T) (exit bool) {
 	tt.Days = tt.Days[1:]
 	tt.Days[1].Slots = tt.Days[1].Slots[1:]
 	t.Logf("%v", tt)
-	err = TimetableSet(&tt)
+	err = event.TimetableSet("", &tt, ImpactAbort)
 	if err != nil {
 		t.Errorf("ERROR Day / slot removal failed: %v", err)
 		return
 	}
-	gottt, err = GetTimetable("", nil)
+	gottt, err = event.GetTimetable("", nil)
 	if err != nil {
 		t.Errorf("ERROR Getting non-empty timetable: %v", err)
 		return
@@ -176,3 +178,197 @@ func testUnitTimetable(t *testing.T) (exit bool) {
 
 	return false
 }
+
+func testUnitTimetableEdit(t *testing.T) (exit bool) {
+	// Any "early" exit is a failure
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+
+	m := &mirrorData{}
+	if testNewUsers(t, m, 2) {
+		return
+	}
+	disc, subexit := testNewDiscussion(t, m.users[0].UserID)
+	if subexit {
+		return
+	}
+	if err := event.DiscussionSetPublic("", disc.DiscussionID, true); err != nil {
+		t.Errorf("DiscussionSetPublic: %v", err)
+		return
+	}
+	if _, subexit := testNewLocation(t); subexit {
+		return
+	}
+
+	tt := Timetable{
+		Days: []TimetableDay{
+			{DayName: "Monday", Slots: []TimetableSlot{
+				{Time: Date(2020, 7, 6, 14, 30, 0, 0, time.UTC)},
+				{Time: Date(2020, 7, 6, 15, 15, 0, 0, time.UTC)},
+				{Time: Date(2020, 7, 6, 16, 00, 0, 0, time.UTC)},
+			}},
+			{DayName: "Tuesday", Slots: []TimetableSlot{
+				{Time: Date(2020, 7, 7, 14, 30, 0, 0, time.UTC)},
+			}},
+			{DayName: "Wednesday", Slots: []TimetableSlot{
+				{Time: Date(2020, 7, 8, 14, 30, 0, 0, time.UTC)},
+				{Time: Date(2020, 7, 8, 15, 15, 0, 0, time.UTC)},
+			}},
+		},
+	}
+	if err := event.TimetableSet("", &tt, ImpactAbort); err != nil {
+		t.Errorf("TimetableSet: %v", err)
+		return
+	}
+
+	// Only allow the first slot of Monday, so we know where the
+	// discussion ends up
+	gottt, err := event.GetTimetable("", nil)
+	if err != nil {
+		t.Errorf("GetTimetable: %v", err)
+		return
+	}
+	scheduled := gottt.Days[0].Slots[0].SlotID
+	if err := event.DiscussionSetPossibleSlots("", disc.DiscussionID, []SlotID{scheduled}); err != nil {
+		t.Errorf("DiscussionSetPossibleSlots: %v", err)
+		return
+	}
+	if err := event.MakeSchedule(SearchOptions{}); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+
+	scheduledSlot := func() SlotID {
+		var slotid SlotID
+		err := sqlx.Get(event.DB, &slotid,
+			`select ifnull(max(slotid), '') from event_schedule where discussionid = ?`,
+			disc.DiscussionID)
+		if err != nil {
+			t.Errorf("Getting scheduled slot: %v", err)
+		}
+		return slotid
+	}
+	if got := scheduledSlot(); got != scheduled {
+		t.Errorf("Expected discussion scheduled in %v, got %v", scheduled, got)
+		return
+	}
+
+	t.Logf("Moving the scheduled slot, adding and deleting slots")
+	// Swap the identities of Monday's first two slots, leaving the
+	// times where they are; delete the third, and add a new one.
+	mon := gottt.Days[0].Slots
+	mon[0].SlotID, mon[1].SlotID = mon[1].SlotID, mon[0].SlotID
+	gottt.Days[0].Slots = []TimetableSlot{mon[0], mon[1],
+		{Time: Date(2020, 7, 6, 17, 00, 0, 0, time.UTC)}}
+	if err := event.TimetableSet("", &gottt, ImpactAbort); err != nil {
+		t.Errorf("TimetableSet by ID: %v", err)
+		return
+	}
+	newtt, err := event.GetTimetable("", nil)
+	if err != nil {
+		t.Errorf("GetTimetable: %v", err)
+		return
+	}
+	if !compareTimetables(&gottt, &newtt, t) {
+		t.Errorf("Timetable mismatch")
+		return
+	}
+	if newtt.Days[0].Slots[1].SlotID != scheduled {
+		t.Errorf("Expected slot %v to have moved to index 1, found %v",
+			scheduled, newtt.Days[0].Slots[1].SlotID)
+		return
+	}
+	if newtt.Days[0].Slots[2].SlotID == "" || newtt.Days[0].Slots[2].SlotID == mon[2].SlotID {
+		t.Errorf("Expected a new slot, got %v", newtt.Days[0].Slots[2].SlotID)
+		return
+	}
+	if got := scheduledSlot(); got != scheduled {
+		t.Errorf("Expected discussion to stay in %v, got %v", scheduled, got)
+		return
+	}
+
+	t.Logf("Trying unknown and duplicate slots (should fail)")
+	badtt := newtt
+	badtt.Days = append([]TimetableDay{}, newtt.Days...)
+	badtt.Days[1].Slots = []TimetableSlot{{SlotID: "slotbogus", Time: newtt.Days[1].Slots[0].Time}}
+	if err := event.TimetableSet("", &badtt, ImpactAbort); err != errSlotNotFound {
+		t.Errorf("Expected errSlotNotFound, got %v", err)
+		return
+	}
+	badtt.Days[1].Slots = []TimetableSlot{newtt.Days[0].Slots[0]}
+	if err := event.TimetableSet("", &badtt, ImpactAbort); err != errSlotNotFound {
+		t.Errorf("Expected errSlotNotFound for duplicate, got %v", err)
+		return
+	}
+
+	t.Logf("Trying to delete a locked slot (should fail)")
+	if err := event.TimetableSetLockedSlots("", []SlotID{scheduled}); err != nil {
+		t.Errorf("TimetableSetLockedSlots: %v", err)
+		return
+	}
+	badtt.Days[1].Slots = newtt.Days[1].Slots
+	badtt.Days[0].Slots = []TimetableSlot{newtt.Days[0].Slots[0], newtt.Days[0].Slots[2]}
+	if err := event.TimetableSet("", &badtt, ImpactAbort); err != errSlotLocked {
+		t.Errorf("Expected errSlotLocked, got %v", err)
+		return
+	}
+	if err := event.TimetableSetLockedSlots("", nil); err != nil {
+		t.Errorf("TimetableSetLockedSlots: %v", err)
+		return
+	}
+
+	t.Logf("Making the scheduled slot a break")
+	newtt.Days[0].Slots[1].IsBreak = true
+	err = event.TimetableSet("", &newtt, ImpactAbort)
+	if impact := GetScheduleImpact(err); len(impact) != 1 ||
+		impact[0].DiscussionID != disc.DiscussionID || impact[0].SlotID != scheduled {
+		t.Errorf("Expected impact on %v in %v, got %v", disc.DiscussionID, scheduled, err)
+		return
+	}
+	if got := scheduledSlot(); got != scheduled {
+		t.Errorf("Expected aborted change to leave discussion in %v, got %v", scheduled, got)
+		return
+	}
+	if err := event.TimetableSet("", &newtt, ImpactUnschedule); err != nil {
+		t.Errorf("TimetableSet break: %v", err)
+		return
+	}
+	if got := scheduledSlot(); got != "" {
+		t.Errorf("Expected discussion to be unscheduled, still in %v", got)
+		return
+	}
+
+	t.Logf("Deleting the first day")
+	if err := event.DeleteDay("", 1, ImpactAbort); err != nil {
+		t.Errorf("DeleteDay: %v", err)
+		return
+	}
+	gottt, err = event.GetTimetable("", nil)
+	if err != nil {
+		t.Errorf("GetTimetable: %v", err)
+		return
+	}
+	newtt.Days = newtt.Days[1:]
+	if !compareTimetables(&newtt, &gottt, t) {
+		t.Errorf("Timetable mismatch after deleting day")
+		return
+	}
+	if gottt.Days[0].DayName != "Tuesday" || gottt.Days[1].DayName != "Wednesday" {
+		t.Errorf("Unexpected days after delete: %v", gottt.Days)
+		return
+	}
+
+	t.Logf("Renaming a day")
+	if err := event.DayUpdate("", &Day{DayID: 2, DayName: "Thursday"}); err != nil {
+		t.Errorf("DayUpdate: %v", err)
+		return
+	}
+
+	tc.cleanup()
+
+	return false
+}
@@ -35,7 +35,7 @@ func transactionRoutineUserCreateReadDelete(t *testing.T, iterations int, exitCh
 			return
 		}
 
-		gotuser, err := UserFind(user.UserID)
+		gotuser, err := event.UserFind(user.UserID)
 		if err != nil {
 			t.Errorf("ERROR: Finding the user we just created by ID: %v", err)
 			return
@@ -50,7 +50,7 @@ func transactionRoutineUserCreateReadDelete(t *testing.T, iterations int, exitCh
 			return
 		}
 
-		_, err = UserFindByUsername(user.Username)
+		_, err = event.UserFindByUsername(user.Username)
 		if err != nil {
 			t.Errorf("ERROR: UserFindByUsername: %v", err)
 			return
@@ -59,13 +59,13 @@ func transactionRoutineUserCreateReadDelete(t *testing.T, iterations int, exitCh
 		// Do some random user updates, make sure they "took"
 		user.RealName = fake.FullName()
 		user.Email = fake.EmailAddress()
-		err = UserUpdate(&user, nil, "", "")
+		err = event.UserUpdate(&user, nil, "", "")
 		if err != nil {
 			t.Errorf("ERROR: Updating user: %v", err)
 			return
 		}
 
-		gotuser, err = UserFind(user.UserID)
+		gotuser, err = event.UserFind(user.UserID)
 		if err != nil {
 			t.Errorf("ERROR: Finding the user we just created by ID: %v", err)
 			return
@@ -80,7 +80,7 @@ func transactionRoutineUserCreateReadDelete(t *testing.T, iterations int, exitCh
 			return
 		}
 
-		_, err = gotuser.GetLocationTZ()
+		_, err = event.UserGetLocationTZ(gotuser)
 		if err != nil {
 			t.Errorf("ERROR: Getting user's location: %v", err)
 			return
@@ -88,7 +88,7 @@ func transactionRoutineUserCreateReadDelete(t *testing.T, iterations int, exitCh
 
 		for j := 0; j < 5; j++ {
 			verified := rand.Intn(2) == 0
-			err := user.SetVerified(verified)
+			err := event.UserSetVerified("", &user, verified)
 			if err != nil {
 				t.Errorf("ERROR: Changing verification: %v", err)
 				return
@@ -98,7 +98,7 @@ func transactionRoutineUserCreateReadDelete(t *testing.T, iterations int, exitCh
 
 		// Get all users; make sure we get at least one.
 		users := []User{}
-		err = UserIterate(func(u *User) error {
+		err = event.UserIterate(func(u *User) error {
 			users = append(users, *u)
 			return nil
 		})
@@ -112,13 +112,13 @@ func transactionRoutineUserCreateReadDelete(t *testing.T, iterations int, exitCh
 		}
 		t.Logf("Found %d users total", len(users))
 
-		users, err = UserGetAll()
+		users, err = event.UserGetAll()
 		if err != nil {
 			t.Errorf("ERROR: UserGetAll: %v", err)
 			return
 		}
 
-		_, err = UserFindRandom()
+		_, err = event.UserFindRandom()
 		if err != nil {
 			t.Errorf("ERROR: UserFindRandom: %v", err)
 			return
@@ -132,7 +132,7 @@ func transactionRoutineUserCreateReadDelete(t *testing.T, iterations int, exitCh
 			}
 
 			// Look for that discussion by did
-			gotdisc, err := DiscussionFindByIdFull(disc.DiscussionID)
+			gotdisc, err := event.DiscussionFindByIdFull(disc.DiscussionID)
 			if err != nil {
 				t.Errorf("ERROR: Finding the discussion we just created by ID: %v", err)
 				return
@@ -149,7 +149,7 @@ func transactionRoutineUserCreateReadDelete(t *testing.T, iterations int, exitCh
 
 		// Get all discussions & set an interest in some of them
 		discussions := []Discussion{}
-		err = DiscussionIterate(func(d *DiscussionFull) error {
+		err = event.DiscussionIterate(func(d *DiscussionFull) error {
 			discussions = append(discussions, d.Discussion)
 			return nil
 		})
@@ -165,13 +165,13 @@ func transactionRoutineUserCreateReadDelete(t *testing.T, iterations int, exitCh
 			if interest < 20 {
 				interest = 0
 			}
-			err := user.SetInterest(&discussions[didx], interest)
+			err := event.UserSetInterest(&user, &discussions[didx], interest)
 			if err != nil && err != ErrUserOrDiscussionNotFound {
 				t.Errorf("ERROR: Setting interest: %v", err)
 				return
 			}
 
-			gotInterest, err := user.GetInterest(&discussions[didx])
+			gotInterest, err := event.UserGetInterest(&user, &discussions[didx])
 			if err != nil {
 				t.Errorf("ERROR user.GetInterest: %v", err)
 				return
@@ -182,7 +182,7 @@ func transactionRoutineUserCreateReadDelete(t *testing.T, iterations int, exitCh
 				return
 			}
 
-			maxInt, err := discussions[didx].GetMaxScore()
+			maxInt, err := event.DiscussionGetMaxScore(&discussions[didx])
 			if err != nil {
 				t.Errorf("ERROR discussions.MaxScore(): %v", err)
 				return
@@ -195,7 +195,7 @@ func transactionRoutineUserCreateReadDelete(t *testing.T, iterations int, exitCh
 
 		// Get discussions for this user and perform some operations on them
 		discussions = []Discussion{}
-		err = DiscussionIterateUser(user.UserID, func(df *DiscussionFull) error {
+		err = event.DiscussionIterateUser(user.UserID, func(df *DiscussionFull) error {
 			discussions = append(discussions, df.Discussion)
 			return nil
 		})
@@ -217,16 +217,23 @@ func transactionRoutineUserCreateReadDelete(t *testing.T, iterations int, exitCh
 			disc := &discussions[didx]
 
 			public := rand.Intn(2) == 0
-			err = DiscussionSetPublic(disc.DiscussionID, public)
+			err = event.DiscussionSetPublic("", disc.DiscussionID, public)
 			if err != nil {
 				t.Errorf("ERROR: DiscussionSetPublic: %v", err)
 				return
 			}
 			disc.IsPublic = public
 
-			disc.Title = fake.Title()
 			disc.Description = fake.Paragraphs()
-			err = DiscussionUpdate(disc)
+			// Titles of deleted discussions stay taken until
+			// they're purged, so collisions are likely
+			for {
+				disc.Title = fake.Title()
+				err = event.DiscussionUpdate("", disc)
+				if err != errTitleExists {
+					break
+				}
+			}
 			if err != nil {
 				t.Errorf("ERROR: DiscussionUpdate: %v", err)
 				return
@@ -249,7 +256,7 @@ func transactionRoutineUserCreateReadDelete(t *testing.T, iterations int, exitCh
 				return
 			}
 
-			err = DeleteDiscussion(discussions[didx].DiscussionID)
+			err = event.DeleteDiscussion("", discussions[didx].DiscussionID)
 			if err != nil {
 				t.Errorf("ERROR: Deleting discussion %v owned by %v: %v", discussions[didx].DiscussionID, discussions[didx].Owner, err)
 				return
@@ -257,13 +264,13 @@ func transactionRoutineUserCreateReadDelete(t *testing.T, iterations int, exitCh
 			discussions[didx].DiscussionID = ""
 		}
 
-		err = DeleteUser(user.UserID)
+		err = event.DeleteUser("", user.UserID, "")
 		if err != nil {
 			t.Errorf("ERROR: Deleting user %s: %v", user.UserID, err)
 			return
 		}
 
-		gotuser, err = UserFind(user.UserID)
+		gotuser, err = event.UserFind(user.UserID)
 		if err != nil {
 			t.Errorf("ERROR: Error getting deleted user: %v", err)
 			return
@@ -273,7 +280,7 @@ func transactionRoutineUserCreateReadDelete(t *testing.T, iterations int, exitCh
 			return
 		}
 
-		err = DeleteUser(user.UserID)
+		err = event.DeleteUser("", user.UserID, "")
 		if err != ErrUserNotFound {
 			t.Errorf("ERROR: Deleting non-existent user: wanted ErrUserNotfound, got %v", err)
 			return
@@ -0,0 +1,228 @@
+package event
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Users and discussions aren't deleted immediately, but moved to the
+// trash by setting their "deleted" column to the time of deletion.
+// Things in the trash are ignored everywhere else; they can be
+// restored until they're purged.
+
+// TrashedUser is a user in the trash.
+type TrashedUser struct {
+	UserID      UserID
+	Username    string
+	RealName    string
+	Deleted     int64 // Unix time
+	Discussions int   // Number of discussions deleted along with the user
+}
+
+// DeletedTime returns the time at which the user was deleted.
+func (tu *TrashedUser) DeletedTime() time.Time {
+	return time.Unix(tu.Deleted, 0)
+}
+
+// TrashedDiscussion is a discussion in the trash.
+type TrashedDiscussion struct {
+	DiscussionID  DiscussionID
+	Title         string
+	Owner         UserID
+	OwnerUsername string
+	OwnerDeleted  bool // Deleted along with its owner
+	Deleted       int64
+}
+
+// DeletedTime returns the time at which the discussion was deleted.
+func (td *TrashedDiscussion) DeletedTime() time.Time {
+	return time.Unix(td.Deleted, 0)
+}
+
+// TrashGetUsers returns all users in the trash, most recently deleted
+// first.
+func (store *EventStore) TrashGetUsers() (users []TrashedUser, err error) {
+	err = store.txLoop(func(eq sqlx.Ext) error {
+		users = nil
+		err := sqlx.Select(eq, &users, `
+            select userid, username, realname, deleted,
+                   (select count(*)
+                        from event_discussions
+                        where owner = userid
+                            and event_discussions.deleted = event_users.deleted) as discussions
+                from event_users
+                where deleted != 0
+                order by deleted desc, username`)
+		if err != nil {
+			return errOrRetry("Getting trashed users", err)
+		}
+		return nil
+	})
+	return
+}
+
+// TrashGetDiscussions returns all discussions in the trash, most
+// recently deleted first.  This includes discussions deleted along
+// with their owners.
+func (store *EventStore) TrashGetDiscussions() (discussions []TrashedDiscussion, err error) {
+	err = store.txLoop(func(eq sqlx.Ext) error {
+		discussions = nil
+		err := sqlx.Select(eq, &discussions, `
+            select discussionid, title, owner,
+                   event_users.username as ownerusername,
+                   event_users.deleted != 0 as ownerdeleted,
+                   event_discussions.deleted as deleted
+                from event_discussions
+                    join event_users on owner = userid
+                where event_discussions.deleted != 0
+                order by event_discussions.deleted desc, title`)
+		if err != nil {
+			return errOrRetry("Getting trashed discussions", err)
+		}
+		return nil
+	})
+	return
+}
+
+// UserRestore takes userid out of the trash, along with the
+// discussions which were deleted with them.  actor is the user doing
+// the restoring.
+func (store *EventStore) UserRestore(actor UserID, userid UserID) error {
+	return store.txLoop(func(eq sqlx.Ext) error {
+		var deleted int64
+		err := sqlx.Get(eq, &deleted, `
+            select deleted from event_users where userid = ? and deleted != 0`,
+			userid)
+		if err == sql.ErrNoRows {
+			return ErrUserNotFound
+		} else if err != nil {
+			return errOrRetry("Getting trashed user", err)
+		}
+
+		_, err = eq.Exec(`
+            update event_discussions set deleted = 0
+                where owner = ? and deleted = ?`, userid, deleted)
+		if err != nil {
+			return errOrRetry("Restoring user's discussions", err)
+		}
+
+		_, err = eq.Exec(`
+            update event_users set deleted = 0 where userid = ?`, userid)
+		if err != nil {
+			return errOrRetry("Restoring user", err)
+		}
+
+		var after struct {
+			User
+			Discussions []Discussion
+		}
+		if err = userGetTx(eq, userid, &after.User); err != nil {
+			return errOrRetry("Getting restored user", err)
+		}
+		err = sqlx.Select(eq, &after.Discussions, `
+            select * from event_discussions where owner = ? and deleted = 0`, userid)
+		if err != nil {
+			return errOrRetry("Getting user's discussions", err)
+		}
+		err = auditTx(eq, actor, AuditUserRestore, string(userid), nil, &after)
+		if err != nil {
+			return err
+		}
+
+		return schedMarkModifiedTx(eq)
+	})
+}
+
+// DiscussionRestore takes did out of the trash.  Its owner must not be
+// in the trash.  actor is the user doing the restoring.
+func (store *EventStore) DiscussionRestore(actor UserID, did DiscussionID) error {
+	return store.txLoop(func(eq sqlx.Ext) error {
+		var ownerDeleted bool
+		err := sqlx.Get(eq, &ownerDeleted, `
+            select event_users.deleted != 0
+                from event_discussions
+                    join event_users on owner = userid
+                where discussionid = ? and event_discussions.deleted != 0`,
+			did)
+		if err == sql.ErrNoRows {
+			return ErrDiscussionNotFound
+		} else if err != nil {
+			return errOrRetry("Getting trashed discussion", err)
+		}
+		if ownerDeleted {
+			return errRestoreOwnerDeleted
+		}
+
+		_, err = eq.Exec(`
+            update event_discussions set deleted = 0 where discussionid = ?`, did)
+		if err != nil {
+			return errOrRetry("Restoring discussion", err)
+		}
+
+		var after Discussion
+		if err = discussionGetTx(eq, did, &after); err != nil {
+			return errOrRetry("Getting restored discussion", err)
+		}
+		err = auditTx(eq, actor, AuditDiscussionRestore, string(did), nil, &after)
+		if err != nil {
+			return err
+		}
+
+		return schedMarkModifiedTx(eq)
+	})
+}
+
+// TrashPurge permanently deletes everything which was moved to the
+// trash before the given time, returning the number of users and
+// discussions deleted.  (Discussions deleted along with a user are
+// only counted with the user.)
+func (store *EventStore) TrashPurge(before time.Time) (users, discussions int, err error) {
+	err = store.txLoop(func(eq sqlx.Ext) error {
+		users, discussions = 0, 0
+
+		var userids []UserID
+		err := sqlx.Select(eq, &userids, `
+            select userid from event_users
+                where deleted != 0 and deleted < ?`, before.Unix())
+		if err != nil {
+			return errOrRetry("Getting users to purge", err)
+		}
+		for _, userid := range userids {
+			if err := purgeUserTx(eq, userid); err != nil {
+				return err
+			}
+			err = auditTx(eq, "", AuditUserPurge, string(userid), nil, nil)
+			if err != nil {
+				return err
+			}
+		}
+		users = len(userids)
+
+		var dids []DiscussionID
+		err = sqlx.Select(eq, &dids, `
+            select discussionid from event_discussions
+                where deleted != 0 and deleted < ?`, before.Unix())
+		if err != nil {
+			return errOrRetry("Getting discussions to purge", err)
+		}
+		for _, did := range dids {
+			if _, err := deleteDiscussionCommon(eq, "discussionid = ?", did); err != nil {
+				return err
+			}
+			err = auditTx(eq, "", AuditDiscussionPurge, string(did), nil, nil)
+			if err != nil {
+				return err
+			}
+		}
+		discussions = len(dids)
+
+		return nil
+	})
+	if err == nil && (users > 0 || discussions > 0) {
+		log.Printf("Purged %d users and %d discussions from the trash", users, discussions)
+	}
+	return
+}
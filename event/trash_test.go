@@ -0,0 +1,185 @@
+package event
+
+import (
+	"testing"
+	"time"
+)
+
+func testUnitTrash(t *testing.T) (exit bool) {
+	// Any "early" exit is a failure
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+
+	m := &mirrorData{}
+
+	if testNewUsers(t, m, 2) {
+		return
+	}
+	owner, other := &m.users[0], &m.users[1]
+
+	t.Logf("Creating discussions")
+	kept := Discussion{Owner: owner.UserID, Title: "Kept", Description: "Deleted with its owner"}
+	single := Discussion{Owner: owner.UserID, Title: "Single", Description: "Deleted on its own"}
+	for _, d := range []*Discussion{&kept, &single} {
+		if err := event.NewDiscussion(d); err != nil {
+			t.Errorf("NewDiscussion: %v", err)
+			return
+		}
+		if err := event.UserSetInterest(other, d, 50); err != nil {
+			t.Errorf("SetInterest: %v", err)
+			return
+		}
+	}
+
+	countDiscussions := func() int {
+		count := 0
+		if err := event.DiscussionIterate(func(*DiscussionFull) error {
+			count++
+			return nil
+		}); err != nil {
+			t.Errorf("DiscussionIterate: %v", err)
+			return -1
+		}
+		return count
+	}
+
+	t.Logf("Deleting and restoring a discussion")
+	if err := event.DeleteDiscussion("", single.DiscussionID); err != nil {
+		t.Errorf("DeleteDiscussion: %v", err)
+		return
+	}
+	if err := event.DeleteDiscussion("", single.DiscussionID); err != ErrDiscussionNotFound {
+		t.Errorf("Deleting discussion twice: expected ErrDiscussionNotFound, got %v", err)
+		return
+	}
+	if df, err := event.DiscussionFindByIdFull(single.DiscussionID); err != nil || df != nil {
+		t.Errorf("Deleted discussion still found: %v, err %v", df, err)
+		return
+	}
+	if n := countDiscussions(); n != 1 {
+		t.Errorf("Expected 1 discussion, got %d", n)
+		return
+	}
+	if interest, err := event.UserGetInterest(other, &single); err != nil || interest != 0 {
+		t.Errorf("Interest in deleted discussion: got %d, err %v", interest, err)
+		return
+	}
+
+	tds, err := event.TrashGetDiscussions()
+	if err != nil || len(tds) != 1 || tds[0].DiscussionID != single.DiscussionID || tds[0].OwnerDeleted {
+		t.Errorf("TrashGetDiscussions: got %v, err %v", tds, err)
+		return
+	}
+
+	if err := event.DiscussionRestore("", single.DiscussionID); err != nil {
+		t.Errorf("DiscussionRestore: %v", err)
+		return
+	}
+	if err := event.DiscussionRestore("", single.DiscussionID); err != ErrDiscussionNotFound {
+		t.Errorf("Restoring live discussion: expected ErrDiscussionNotFound, got %v", err)
+		return
+	}
+	if interest, err := event.UserGetInterest(other, &single); err != nil || interest != 50 {
+		t.Errorf("Interest in restored discussion: got %d, err %v", interest, err)
+		return
+	}
+
+	t.Logf("Deleting and restoring a user")
+	// Delete one discussion on its own first, so we can check it
+	// isn't restored along with the owner
+	if err := event.DeleteDiscussion("", single.DiscussionID); err != nil {
+		t.Errorf("DeleteDiscussion: %v", err)
+		return
+	}
+	// Make sure the deletion times differ
+	time.Sleep(time.Second)
+	if err := event.DeleteUser("", owner.UserID, ""); err != nil {
+		t.Errorf("DeleteUser: %v", err)
+		return
+	}
+	if u, err := event.UserFind(owner.UserID); err != nil || u != nil {
+		t.Errorf("Deleted user still found: %v, err %v", u, err)
+		return
+	}
+	if u, err := event.UserFindByUsername(owner.Username); err != nil || u != nil {
+		t.Errorf("Deleted user still found by username: %v, err %v", u, err)
+		return
+	}
+	if n := countDiscussions(); n != 0 {
+		t.Errorf("Expected no discussions, got %d", n)
+		return
+	}
+	// Usernames and titles are still reserved
+	if _, err := event.NewUser("xenuser123", &User{Username: owner.Username}); err != errUsernameExists {
+		t.Errorf("Creating user with the name of a deleted one: expected errUsernameExists, got %v", err)
+		return
+	}
+
+	tus, err := event.TrashGetUsers()
+	if err != nil || len(tus) != 1 || tus[0].UserID != owner.UserID || tus[0].Discussions != 1 {
+		t.Errorf("TrashGetUsers: got %v, err %v", tus, err)
+		return
+	}
+	tds, err = event.TrashGetDiscussions()
+	if err != nil || len(tds) != 2 || !tds[0].OwnerDeleted {
+		t.Errorf("TrashGetDiscussions: got %v, err %v", tds, err)
+		return
+	}
+	if err := event.DiscussionRestore("", kept.DiscussionID); err != errRestoreOwnerDeleted {
+		t.Errorf("Restoring discussion of deleted user: expected errRestoreOwnerDeleted, got %v", err)
+		return
+	}
+
+	if err := event.UserRestore("", owner.UserID); err != nil {
+		t.Errorf("UserRestore: %v", err)
+		return
+	}
+	if u, err := event.UserFind(owner.UserID); err != nil || u == nil {
+		t.Errorf("Restored user not found: err %v", err)
+		return
+	}
+	if n := countDiscussions(); n != 1 {
+		t.Errorf("Expected 1 discussion after restoring user, got %d", n)
+		return
+	}
+
+	t.Logf("Purging")
+	if err := event.DeleteUser("", other.UserID, ""); err != nil {
+		t.Errorf("DeleteUser: %v", err)
+		return
+	}
+	if users, discussions, err := event.TrashPurge(time.Now().Add(-time.Hour)); err != nil || users != 0 || discussions != 0 {
+		t.Errorf("Purging old items: got %d users, %d discussions, err %v", users, discussions, err)
+		return
+	}
+	users, discussions, err := event.TrashPurge(time.Now().Add(time.Second))
+	if err != nil || users != 1 || discussions != 1 {
+		t.Errorf("TrashPurge: got %d users, %d discussions, err %v", users, discussions, err)
+		return
+	}
+	if tus, err := event.TrashGetUsers(); err != nil || len(tus) != 0 {
+		t.Errorf("TrashGetUsers after purge: got %v, err %v", tus, err)
+		return
+	}
+	if tds, err := event.TrashGetDiscussions(); err != nil || len(tds) != 0 {
+		t.Errorf("TrashGetDiscussions after purge: got %v, err %v", tds, err)
+		return
+	}
+	if interest, err := event.UserGetInterest(other, &kept); err != nil || interest != 0 {
+		t.Errorf("Interest of purged user: got %d, err %v", interest, err)
+		return
+	}
+	// The name is free again
+	if err := event.NewDiscussion(&single); err != nil {
+		t.Errorf("Re-creating purged discussion: %v", err)
+		return
+	}
+
+	tc.cleanup()
+
+	return false
+}
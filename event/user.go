@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"golang.org/x/crypto/bcrypt"
@@ -26,15 +27,21 @@ func (uid *UserID) generate() {
 
 type User struct {
 	UserID         UserID
-	HashedPassword string
+	HashedPassword string `json:"-"`
 	Username       string
 	IsAdmin        bool
 	IsVerified     bool // Has entered the verification code
+	Role           Role
 	Location       TZLocation
 	RealName       string
 	Email          string
 	Company        string
 	Description    string
+
+	// Unix time at which the user was moved to the trash, or 0 if
+	// they haven't been.  Users in the trash are treated as though
+	// they don't exist, except by the Trash* functions.
+	Deleted int64
 }
 
 func (u *User) MayEditUser(tgt *User) bool {
@@ -42,10 +49,37 @@ func (u *User) MayEditUser(tgt *User) bool {
 }
 
 func (u *User) MayEditDiscussion(d *Discussion) bool {
-	return u.IsAdmin || u.UserID == d.Owner
+	return u.IsAdmin || u.Role.AtLeast(RoleProgramCommittee) || u.IsFacilitator(d)
+}
+
+// IsFacilitator returns true if u is either the owner or a
+// co-facilitator of d.
+func (u *User) IsFacilitator(d *Discussion) bool {
+	if u.UserID == d.Owner {
+		return true
+	}
+	for _, uid := range d.Facilitators {
+		if uid == u.UserID {
+			return true
+		}
+	}
+	return false
+}
+
+func (store *EventStore) NewUser(password string, user *User) (UserID, error) {
+	return store.newUser(password, user, "")
+}
+
+// NewUserWithInvite creates a new user, like NewUser, and redeems the
+// invitation code invite for them in the same transaction, so that
+// two people can't both use up its last redemption.  If the code
+// can't be redeemed, the user isn't created, and ErrInviteInvalid is
+// returned.
+func (store *EventStore) NewUserWithInvite(password string, user *User, invite string) (UserID, error) {
+	return store.newUser(password, user, invite)
 }
 
-func NewUser(password string, user *User) (UserID, error) {
+func (store *EventStore) newUser(password string, user *User, invite string) (UserID, error) {
 	log.Printf("New user post: '%s'", user.Username)
 
 	if user.Username == "" || AllWhitespace(user.Username) {
@@ -59,7 +93,7 @@ func NewUser(password string, user *User) (UserID, error) {
 	}
 
 	if user.Location.Location == nil {
-		user.Location.Location = event.defaultLocation
+		user.Location.Location = defaultLocation
 	}
 
 	switch {
@@ -83,35 +117,145 @@ func NewUser(password string, user *User) (UserID, error) {
 	}
 	user.UserID.generate()
 
-	for {
-		_, err := event.Exec(`
+	if user.Role == "" {
+		user.Role = RoleAttendee
+		if user.IsAdmin {
+			user.Role = RoleAdmin
+		}
+	}
+
+	err := store.txLoop(func(eq sqlx.Ext) error {
+		_, err := eq.Exec(`
         insert into event_users(
             userid,
             hashedpassword,
             username,
             isadmin, isverified,
             realname, email, company, description,
-            location) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+            location, role) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 			user.UserID,
 			user.HashedPassword,
 			user.Username,
 			user.IsAdmin, user.IsVerified,
 			user.RealName, user.Email, user.Company, user.Description,
-			user.Location)
-		switch {
-		case shouldRetry(err):
-			continue
-		case isErrorConstraintUnique(err):
+			user.Location, user.Role)
+		if isErrorConstraintUnique(err) {
 			log.Printf("New user failed: user exists")
-			return user.UserID, errUsernameExists
-		case err != nil:
-			log.Printf("New user failed: %v", err)
-			return user.UserID, err
+			return errUsernameExists
+		} else if err != nil {
+			return errOrRetry("Inserting user", err)
 		}
-		break
+		if err := auditTx(eq, user.UserID, AuditUserCreate, string(user.UserID), nil, user); err != nil {
+			return err
+		}
+
+		if invite == "" {
+			return nil
+		}
+		err = inviteRedeemTx(eq, user.UserID, invite)
+		if IsValidationError(err) {
+			log.Printf("New user failed: can't redeem invite %s: %v", invite, err)
+			return ErrInviteInvalid
+		} else if err != nil {
+			return err
+		}
+		user.IsVerified = true
+		return nil
+	})
+
+	return user.UserID, err
+}
+
+// UserCopy adds a user with an account in another event to this one,
+// keeping their UserID and password so that the accounts stay linked.
+// The profile is copied, but not the role or verification, which
+// belong to each event.
+func (store *EventStore) UserCopy(user *User) (*User, error) {
+	if user.IsAdminAccount() {
+		return nil, errUsernameExists
 	}
 
-	return user.UserID, nil
+	copy := *user
+	copy.IsAdmin = false
+	copy.IsVerified = false
+	copy.Role = RoleAttendee
+	if copy.Location.Location == nil {
+		copy.Location.Location = defaultLocation
+	}
+
+	err := store.txLoop(func(eq sqlx.Ext) error {
+		_, err := eq.Exec(`
+        insert into event_users(
+            userid,
+            hashedpassword,
+            username,
+            isadmin, isverified,
+            realname, email, company, description,
+            location, role) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			copy.UserID,
+			copy.HashedPassword,
+			copy.Username,
+			copy.IsAdmin, copy.IsVerified,
+			copy.RealName, copy.Email, copy.Company, copy.Description,
+			copy.Location, copy.Role)
+		if isErrorConstraintUnique(err) {
+			return errUsernameExists
+		} else if err != nil {
+			return errOrRetry("Copying user", err)
+		}
+		return auditTx(eq, copy.UserID, AuditUserCreate, string(copy.UserID), nil, &copy)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &copy, nil
+}
+
+// UserSetPasswordHash sets the password of userid to one which has
+// already been hashed, as when a new event's admin account is given
+// the same password as the default event's.
+func (store *EventStore) UserSetPasswordHash(userid UserID, hashedPassword string) error {
+	return store.txLoop(func(eq sqlx.Ext) error {
+		res, err := eq.Exec(`
+            update event_users set hashedpassword = ? where userid = ? and deleted = 0`,
+			hashedPassword, userid)
+		if err != nil {
+			return errOrRetry("Setting password", err)
+		}
+		if rcount, err := res.RowsAffected(); err != nil {
+			return errOrRetry("Getting number of affected rows", err)
+		} else if rcount == 0 {
+			return ErrUserNotFound
+		}
+		return auditTx(eq, userid, AuditUserResetPassword, string(userid), nil, nil)
+	})
+}
+
+// UserSyncPasswordHash changes the password of userid from
+// oldHashedPassword to hashedPassword, as when a user with accounts in
+// several events has changed it in one of them.  The account is only
+// changed if its password is still oldHashedPassword, i.e., if the
+// user has shown they know its current password; changed is false if
+// it wasn't.
+func (store *EventStore) UserSyncPasswordHash(userid UserID, oldHashedPassword, hashedPassword string) (changed bool, err error) {
+	err = store.txLoop(func(eq sqlx.Ext) error {
+		changed = false
+		res, err := eq.Exec(`
+            update event_users set hashedpassword = ?
+                where userid = ? and hashedpassword = ? and deleted = 0`,
+			hashedPassword, userid, oldHashedPassword)
+		if err != nil {
+			return errOrRetry("Setting password", err)
+		}
+		if rcount, err := res.RowsAffected(); err != nil {
+			return errOrRetry("Getting number of affected rows", err)
+		} else if rcount == 0 {
+			return nil
+		}
+		changed = true
+		return auditTx(eq, userid, AuditUserResetPassword, string(userid), nil, nil)
+	})
+	return changed, err
 }
 
 func (u *User) CheckPassword(password string) bool {
@@ -134,48 +278,55 @@ func setInterestTx(ext sqlx.Ext, uid UserID, did DiscussionID, interest int) err
 	return err
 }
 
-func (user *User) SetInterest(disc *Discussion, interest int) error {
-	switch {
-	case interest > InterestMax || interest < 0:
+func (store *EventStore) UserSetInterest(user *User, disc *Discussion, interest int) error {
+	if interest > InterestMax || interest < 0 {
 		return errInvalidInterest
-	case interest == 0:
-		for {
-			_, err := event.Exec(`
+	}
+
+	return store.txLoop(func(eq sqlx.Ext) error {
+		var before int
+		err := userGetInterestTx(eq, user.UserID, disc.DiscussionID, &before)
+		if err != nil && err != sql.ErrNoRows {
+			return errOrRetry("Getting current interest", err)
+		}
+
+		if interest == 0 {
+			_, err = eq.Exec(`
             delete from event_interest
                 where discussionid = ? and userid = ?`, disc.DiscussionID, user.UserID)
-			switch {
-			case shouldRetry(err):
-				continue
-			case err == sql.ErrNoRows:
-				return nil
-			default:
-				return err
+			if err != nil {
+				return errOrRetry("Deleting interest", err)
 			}
-		}
-	default:
-		for {
-			err := setInterestTx(event, user.UserID, disc.DiscussionID, interest)
-			switch {
-			case shouldRetry(err):
-				continue
-			case isErrorForeignKey(err):
+		} else {
+			err = setInterestTx(eq, user.UserID, disc.DiscussionID, interest)
+			if isErrorForeignKey(err) {
 				return ErrUserOrDiscussionNotFound
-			default:
-				return err
+			} else if err != nil {
+				return errOrRetry("Setting interest", err)
 			}
 		}
-	}
+
+		err = auditTx(eq, user.UserID, AuditInterestSet, string(disc.DiscussionID),
+			struct{ Interest int }{before}, struct{ Interest int }{interest})
+		if err != nil {
+			return err
+		}
+
+		return schedMarkModifiedTx(eq)
+	})
 }
 
 func userGetInterestTx(q sqlx.Queryer, uid UserID, did DiscussionID, interest *int) error {
 	return sqlx.Get(q, interest, `
 		select interest
             from event_interest
-            where userid=? and discussionid=?`,
+            where userid=? and discussionid=?
+                and userid in (select userid from event_users where deleted = 0)
+                and discussionid in (select discussionid from event_discussions where deleted = 0)`,
 		uid, did)
 }
 
-func (user *User) GetInterest(disc *Discussion) (int, error) {
+func (store *EventStore) UserGetInterest(user *User, disc *Discussion) (int, error) {
 	// NB this will return 0 even for non-existent users and
 	// discussions.  If we wanted to change this, we'd have to return
 	// an error code.  We'd also need to either set interest to 0
@@ -186,7 +337,7 @@ func (user *User) GetInterest(disc *Discussion) (int, error) {
 	// "user/discussion pair exists but has no interest entry" and
 	// "user/discussion pair does not exist".
 	var interest int
-	err := txLoop(func(eq sqlx.Ext) error {
+	err := store.txLoop(func(eq sqlx.Ext) error {
 		err := userGetInterestTx(eq, user.UserID, disc.DiscussionID, &interest)
 		if err == sql.ErrNoRows {
 			err = nil
@@ -198,9 +349,9 @@ func (user *User) GetInterest(disc *Discussion) (int, error) {
 	return interest, err
 }
 
-func (user *User) GetLocationTZ() (TZLocation, error) {
+func (store *EventStore) UserGetLocationTZ(user *User) (TZLocation, error) {
 	var tz TZLocation
-	err := txLoop(func(eq sqlx.Ext) error {
+	err := store.txLoop(func(eq sqlx.Ext) error {
 		err := sqlx.Get(eq, &tz, `
             select location
                 from event_users
@@ -218,14 +369,14 @@ func passwordHash(newPassword string) (string, error) {
 	return string(hashedPasswordBytes), err
 }
 
-func (user *User) setPassword(newPassword string) error {
+func (store *EventStore) userSetPassword(user *User, newPassword string) error {
 	hashedPassword, err := passwordHash(newPassword)
 	if err != nil {
 		return err
 	}
 
 	for {
-		_, err := event.Exec(`
+		_, err := store.Exec(`
         update event_users set hashedpassword = ? where userid = ?`,
 			hashedPassword, user.UserID)
 		switch {
@@ -240,18 +391,26 @@ func (user *User) setPassword(newPassword string) error {
 	}
 }
 
-func (user *User) SetVerified(isVerified bool) error {
-	for {
-		_, err := event.Exec(`
+func (store *EventStore) UserSetVerified(actor UserID, user *User, isVerified bool) error {
+	return store.txLoop(func(eq sqlx.Ext) error {
+		var before bool
+		err := sqlx.Get(eq, &before, `
+        select isverified from event_users where userid = ?`, user.UserID)
+		if err == sql.ErrNoRows {
+			return ErrUserNotFound
+		} else if err != nil {
+			return errOrRetry("Getting user verification", err)
+		}
+
+		_, err = eq.Exec(`
         update event_users set isverified = ? where userid = ?`,
 			isVerified, user.UserID)
-		switch {
-		case shouldRetry(err):
-			continue
-		default:
-			return err
+		if err != nil {
+			return errOrRetry("Setting user verification", err)
 		}
-	}
+		return auditTx(eq, actor, AuditUserSetVerified, string(user.UserID),
+			struct{ IsVerified bool }{before}, struct{ IsVerified bool }{isVerified})
+	})
 }
 
 // UserUpdate will update "user-facing" data associated with the user.
@@ -259,13 +418,15 @@ func (user *User) SetVerified(isVerified bool) error {
 // It can also inlude the password *via* the new/currentPassword
 // fields (not the HashedPassword field).
 //
-// UserUpdate will *not* update Username, IsAdmin or IsVerified.  IsVerified
-// should be updated with SetVerified instead.
+// UserUpdate will *not* update Username, IsAdmin, IsVerified or Role.
+// IsVerified should be updated with UserSetVerified, and IsAdmin and Role
+// with UserSetRole, instead.
 //
 // If newPassword is "", HashedPassword will not be changed. If
 // newPassword is non-null, currentPassword will be checked against
-// modifier.HashedPassword.
-func UserUpdate(userNext, modifier *User, currentPassword, newPassword string) error {
+// modifier.HashedPassword.  modifier is also recorded as having made
+// the change in the audit log.
+func (store *EventStore) UserUpdate(userNext, modifier *User, currentPassword, newPassword string) error {
 	setPassword := false
 
 	var hashedPassword string
@@ -311,73 +472,105 @@ func UserUpdate(userNext, modifier *User, currentPassword, newPassword string) e
 	args = append(args, userNext.Location)
 	args = append(args, userNext.UserID)
 
-	for {
-		res, err := event.Exec(q, args...)
-		switch {
-		case shouldRetry(err):
-			continue
-		case err != nil:
-			return err
+	err := store.txLoop(func(eq sqlx.Ext) error {
+		var before User
+		err := userGetTx(eq, userNext.UserID, &before)
+		if err == sql.ErrNoRows {
+			return ErrUserNotFound
+		} else if err != nil {
+			return errOrRetry("Getting user", err)
 		}
 
-		rcount, err := res.RowsAffected()
+		_, err = eq.Exec(q, args...)
 		if err != nil {
-			log.Printf("ERROR Getting number of affected rows: %v; continuing", err)
-			return ErrInternal
+			return errOrRetry("Updating user", err)
 		}
 
-		switch {
-		case rcount == 0:
-			return ErrUserNotFound
-		case rcount > 1:
-			log.Printf("ERROR Expected to change 1 row, changed %d", rcount)
-			return ErrInternal
-		}
-
-		// Only update the password hash if we succeeded in the update
-		if setPassword {
-			userNext.HashedPassword = hashedPassword
+		after := before
+		after.RealName = userNext.RealName
+		after.Email = userNext.Email
+		after.Company = userNext.Company
+		after.Description = userNext.Description
+		after.Location = userNext.Location
+		var actor UserID
+		if modifier != nil {
+			actor = modifier.UserID
 		}
+		return auditTx(eq, actor, AuditUserUpdate, string(userNext.UserID),
+			&before, &after)
+	})
+	if err != nil {
+		return err
+	}
 
-		return nil
+	// Only update the password hash if we succeeded in the update
+	if setPassword {
+		userNext.HashedPassword = hashedPassword
 	}
-}
 
-func DeleteUser(userid UserID) error {
-	return txLoop(func(eq sqlx.Ext) error {
-		// Delete foreign key references first
+	return nil
+}
 
-		// Delete interest of this user in any discussion
-		_, err := eq.Exec(`
-           delete from event_interest
-               where userid = ?`, userid)
+// DeleteUser moves userid, along with all their discussions, to the
+// trash; they can be restored with UserRestore until they're purged
+// by TrashPurge.  If reassignTo is non-empty, the user's discussions
+// are given to that user instead, so long as it doesn't take them
+// over maxDiscussionsPerUser.  actor is the user doing the deleting.
+func (store *EventStore) DeleteUser(actor UserID, userid UserID, reassignTo UserID) error {
+	return store.txLoop(func(eq sqlx.Ext) error {
+		// Record what's being deleted while it's still there
+		var before struct {
+			User
+			Discussions []Discussion
+		}
+		err := userGetTx(eq, userid, &before.User)
+		if err == sql.ErrNoRows {
+			return ErrUserNotFound
+		} else if err != nil {
+			return errOrRetry("Getting user", err)
+		}
+		err = sqlx.Select(eq, &before.Discussions, `
+            select * from event_discussions where owner = ? and deleted = 0`, userid)
+		if err != nil {
+			return errOrRetry("Getting user's discussions", err)
+		}
+		err = auditTx(eq, actor, AuditUserDelete, string(userid), &before, nil)
 		if err != nil {
-			return errOrRetry("Deleting discussion from event_interest", err)
+			return err
 		}
 
-		// Delete interest in any users in discussions owned by this user
+		if reassignTo == userid {
+			return errReassignSameUser
+		} else if reassignTo != "" {
+			err = reassignDiscussionsTx(eq, actor, before.Discussions, reassignTo)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Any remaining discussions are given the same deletion time
+		// as the user, so that UserRestore can tell which ones to
+		// bring back.
+		now := time.Now().Unix()
 		_, err = eq.Exec(`
-           delete from event_interest
-               where discussionid in (
-                   select discussionid
-                       from event_discussions
-                       where owner = ?)`, userid)
+            update event_discussions set deleted = ?
+                where owner = ? and deleted = 0`, now, userid)
 		if err != nil {
-			return errOrRetry("Deleting interest in discussions owned by user", err)
+			return errOrRetry("Deleting all discussions for user", err)
 		}
 
-		// And delete any discussions owned by this user
-		_, err = deleteDiscussionCommon(eq,
-			`discussionid in 
-                 (select discussionid from event_discussions where owner = ?)`,
+		_, err = eq.Exec(`
+            delete from event_schedule
+                where discussionid in
+                    (select discussionid from event_discussions where owner = ?)`,
 			userid)
 		if err != nil {
-			return errOrRetry("Deleting all discussions for user", err)
+			return errOrRetry("Unscheduling discussions for user", err)
 		}
 
 		res, err := eq.Exec(`
-        delete from event_users where userid=?`,
-			userid)
+            update event_users set deleted = ? where userid = ? and deleted = 0`,
+			now, userid)
 		if err != nil {
 			return errOrRetry("Deleting record for user", err)
 		}
@@ -395,19 +588,153 @@ func DeleteUser(userid UserID) error {
 			return ErrInternal
 		}
 
-		return nil
+		return schedMarkModifiedTx(eq)
 	})
 }
 
+// reassignDiscussionsTx gives discussions to newOwner, as part of
+// deleting their current owner.  As when an admin changes the owner of
+// a single discussion, the discussions are only public if the new
+// owner is verified.  Unlike then, the discussion limit applies
+// (except to admins, as when creating discussions): otherwise deleting
+// a handful of users could leave one user with an arbitrary number of
+// discussions.
+func reassignDiscussionsTx(eq sqlx.Ext, actor UserID, discussions []Discussion, newOwner UserID) error {
+	var owner User
+	err := userGetTx(eq, newOwner, &owner)
+	if err == sql.ErrNoRows {
+		return errReassignUserNotFound
+	} else if err != nil {
+		return errOrRetry("Getting new owner", err)
+	}
+	count := 0
+	err = sqlx.Get(eq, &count,
+		`select count(*) from event_discussions where owner=? and deleted = 0`,
+		newOwner)
+	if err != nil {
+		return errOrRetry("Getting discussion count for new owner", err)
+	}
+	if count+len(discussions) > maxDiscussionsPerUser && !owner.IsAdmin {
+		return errReassignTooManyDiscussions
+	}
+
+	for i := range discussions {
+		before := &discussions[i]
+
+		isVerified, err := discussionNewOwnerTx(eq, before.DiscussionID, newOwner)
+		if err != nil {
+			return err
+		}
+
+		after := *before
+		after.Owner = newOwner
+		after.IsPublic = isVerified
+		if after.IsPublic {
+			after.ApprovedTitle = after.Title
+			after.ApprovedDescription = after.Description
+		}
+		_, err = eq.Exec(`
+            update event_discussions
+                set owner = ?, ispublic = ?, approvedtitle = ?, approveddescription = ?
+                where discussionid = ?`,
+			after.Owner, after.IsPublic, after.ApprovedTitle, after.ApprovedDescription,
+			before.DiscussionID)
+		if err != nil {
+			return errOrRetry("Reassigning discussion", err)
+		}
+
+		err = auditTx(eq, actor, AuditDiscussionUpdate, string(before.DiscussionID), before, &after)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// purgeUserTx permanently deletes userid, along with all their
+// discussions and anything else referring to them.
+func purgeUserTx(eq sqlx.Ext, userid UserID) error {
+	// Delete foreign key references first
+
+	// Delete interest of this user in any discussion
+	_, err := eq.Exec(`
+           delete from event_interest
+               where userid = ?`, userid)
+	if err != nil {
+		return errOrRetry("Deleting discussion from event_interest", err)
+	}
+
+	_, err = eq.Exec(`
+           delete from event_calendar_tokens
+               where userid = ?`, userid)
+	if err != nil {
+		return errOrRetry("Deleting user from event_calendar_tokens", err)
+	}
+
+	_, err = eq.Exec(`
+           delete from event_api_tokens
+               where userid = ?`, userid)
+	if err != nil {
+		return errOrRetry("Deleting user from event_api_tokens", err)
+	}
+
+	for _, table := range []string{"event_email_tokens", "event_email_verified", "event_invite_redemptions"} {
+		_, err = eq.Exec(`delete from `+table+` where userid = ?`, userid)
+		if err != nil {
+			return errOrRetry("Deleting user from "+table, err)
+		}
+	}
+
+	// Remove this user as a co-facilitator of any discussions
+	_, err = eq.Exec(`
+           delete from event_discussions_facilitators
+               where userid = ?`, userid)
+	if err != nil {
+		return errOrRetry("Deleting user from event_discussions_facilitators", err)
+	}
+
+	// And delete any discussions owned by this user
+	_, err = deleteDiscussionCommon(eq,
+		`discussionid in
+                 (select discussionid from event_discussions where owner = ?)`,
+		userid)
+	if err != nil {
+		return err
+	}
+
+	res, err := eq.Exec(`
+        delete from event_users where userid=?`,
+		userid)
+	if err != nil {
+		return errOrRetry("Deleting record for user", err)
+	}
+	rcount, err := res.RowsAffected()
+	if shouldRetry(err) {
+		return err
+	} else if err != nil {
+		log.Printf("ERROR Getting number of affected rows: %v; continuing", err)
+	}
+	switch {
+	case rcount == 0:
+		return ErrUserNotFound
+	case rcount > 1:
+		log.Printf("ERROR Expected to change 1 row, changed %d", rcount)
+		return ErrInternal
+	}
+
+	return nil
+}
+
 func userGetTx(q sqlx.Queryer, userid UserID, user *User) error {
 	return sqlx.Get(q, user,
-		`select * from event_users where userid=?`,
+		`select * from event_users where userid=? and deleted = 0`,
 		userid)
 }
 
-func UserFind(userid UserID) (*User, error) {
+func (store *EventStore) UserFind(userid UserID) (*User, error) {
 	user := &User{}
-	err := txLoop(func(eq sqlx.Ext) error {
+	err := store.txLoop(func(eq sqlx.Ext) error {
 		err := userGetTx(eq, userid, user)
 		if err == sql.ErrNoRows {
 			user = nil
@@ -419,7 +746,7 @@ func UserFind(userid UserID) (*User, error) {
 }
 
 // Return nil for user not present
-func UserFindByUsername(username string) (*User, error) {
+func (store *EventStore) UserFindByUsername(username string) (*User, error) {
 	var user User
 	// FIXME: Consider making usernames case-insensitive.  This
 	// involves making the whole column case-insensitive (with collate
@@ -428,7 +755,8 @@ func UserFindByUsername(username string) (*User, error) {
 	// usernames collide, and that case-differeng usernames are found
 	// by the various searches.
 	for {
-		err := event.Get(&user, `select * from event_users where username=?`,
+		err := store.Get(&user, `
+            select * from event_users where username=? and deleted = 0`,
 			username)
 		switch {
 		case shouldRetry(err):
@@ -441,12 +769,12 @@ func UserFindByUsername(username string) (*User, error) {
 	}
 }
 
-func UserFindRandom() (*User, error) {
+func (store *EventStore) UserFindRandom() (*User, error) {
 	var user User
 	for {
-		err := event.Get(&user, `
+		err := store.Get(&user, `
         select * from event_users
-           where username != ?
+           where username != ? and deleted = 0
            order by RANDOM() limit 1`, AdminUsername)
 		switch {
 		case shouldRetry(err):
@@ -462,9 +790,10 @@ func UserFindRandom() (*User, error) {
 }
 
 // Iterate over all users, calling f(u) for each user.
-func UserIterate(f func(u *User) error) error {
+func (store *EventStore) UserIterate(f func(u *User) error) error {
 	for {
-		rows, err := event.Queryx(`select * from event_users order by userid`)
+		rows, err := store.Queryx(`
+            select * from event_users where deleted = 0 order by userid`)
 		switch {
 		case shouldRetry(err):
 			continue
@@ -505,12 +834,13 @@ func UserIterate(f func(u *User) error) error {
 }
 
 func userGetAllTx(q sqlx.Queryer, usersp *[]User) error {
-	return sqlx.Select(q, usersp, `select * from event_users order by userid`)
+	return sqlx.Select(q, usersp, `
+        select * from event_users where deleted = 0 order by userid`)
 }
 
-func UserGetAll() ([]User, error) {
+func (store *EventStore) UserGetAll() ([]User, error) {
 	var users []User
-	err := txLoop(func(eq sqlx.Ext) error {
+	err := store.txLoop(func(eq sqlx.Ext) error {
 		err := userGetAllTx(eq, &users)
 		if err != nil {
 			return errOrRetry("Getting list of all users", err)
@@ -37,7 +37,7 @@ func testNewUser(t *testing.T) (User, bool) {
 		}
 	}
 
-	for _, err = NewUser(TestPassword, &user); err != nil; _, err = NewUser(TestPassword, &user) {
+	for _, err = event.NewUser(TestPassword, &user); err != nil; _, err = event.NewUser(TestPassword, &user) {
 		// Just keep trying random usernames until we get a new one
 		if err == errUsernameExists {
 			user.Username = fake.UserName()
@@ -95,6 +95,10 @@ func compareUsers(u1, u2 *User, t *testing.T) bool {
 		t.Logf("mismatch IsVerified: %v != %v", u1.IsVerified, u2.IsVerified)
 		ret = false
 	}
+	if u1.Role != u2.Role {
+		t.Logf("mismatch Role: %v != %v", u1.Role, u2.Role)
+		ret = false
+	}
 	if u1.RealName != u2.RealName {
 		t.Logf("mismatch RealName: %v != %v", u1.RealName, u2.RealName)
 		ret = false
@@ -130,7 +134,7 @@ func testUnitUser(t *testing.T) (exit bool) {
 
 	t.Logf("Testing UserFindRandom when there are no users")
 	{
-		gotuser, err := UserFindRandom()
+		gotuser, err := event.UserFindRandom()
 		if err != nil {
 			t.Errorf("ERROR: UserFindRandom: %v", err)
 			return
@@ -143,13 +147,13 @@ func testUnitUser(t *testing.T) (exit bool) {
 
 	t.Logf("Testing admin password reset")
 	{
-		gotuser, err := UserFindByUsername(AdminUsername)
+		gotuser, err := event.UserFindByUsername(AdminUsername)
 		if err != nil {
 			t.Errorf("Finding the user we just created by username: %v", err)
 			return
 		}
 
-		err = gotuser.setPassword(TestPassword)
+		err = event.userSetPassword(gotuser, TestPassword)
 		if err != nil {
 			t.Errorf("ERROR: Setting admin password: %v", err)
 			return
@@ -176,14 +180,14 @@ func testUnitUser(t *testing.T) (exit bool) {
 
 		// Try creating a new user with the same userid
 		userCopy := m.users[i]
-		_, err := NewUser(TestPassword, &userCopy)
+		_, err := event.NewUser(TestPassword, &userCopy)
 		if err != errUsernameExists {
 			t.Errorf("Testing duplicate username: expected errUsernameExists, got %v!", err)
 			return
 		}
 
 		// Look for that user by uid
-		gotuser, err := UserFind(m.users[i].UserID)
+		gotuser, err := event.UserFind(m.users[i].UserID)
 		if err != nil {
 			t.Errorf("Finding the user we just created by ID: %v", err)
 			return
@@ -204,7 +208,7 @@ func testUnitUser(t *testing.T) (exit bool) {
 		}
 
 		// Look for that user by username
-		gotuser, err = UserFindByUsername(m.users[i].Username)
+		gotuser, err = event.UserFindByUsername(m.users[i].Username)
 		if err != nil {
 			t.Errorf("Finding the user we just created by username: %v", err)
 			return
@@ -216,7 +220,7 @@ func testUnitUser(t *testing.T) (exit bool) {
 		}
 
 		// Look for that user by username, checking password
-		gotuser, err = UserFindByUsername(m.users[i].Username)
+		gotuser, err = event.UserFindByUsername(m.users[i].Username)
 		if err != nil {
 			t.Errorf("Finding the user we just created by username: %v", err)
 			return
@@ -227,7 +231,7 @@ func testUnitUser(t *testing.T) (exit bool) {
 			return
 		}
 
-		gotuser, err = UserFindRandom()
+		gotuser, err = event.UserFindRandom()
 		if err != nil {
 			t.Errorf("Finding a random user: %v", err)
 			return
@@ -249,7 +253,7 @@ func testUnitUser(t *testing.T) (exit bool) {
 		t.Logf("Testing searching for non-existing UserID")
 		var userid UserID
 		userid.generate()
-		gotuser, err := UserFind(userid)
+		gotuser, err := event.UserFind(userid)
 		if err != nil {
 			t.Errorf("UserFind on non-existend userid: %v", err)
 			return
@@ -261,7 +265,7 @@ func testUnitUser(t *testing.T) (exit bool) {
 
 		username := fake.UserName()
 		// FIXME: Maybe check to see if the username doesn't exist already
-		gotuser, err = UserFindByUsername(username)
+		gotuser, err = event.UserFindByUsername(username)
 		if err != nil {
 			t.Errorf("UserFindByUsername on non-existend username %s: %v", username, err)
 			return
@@ -278,7 +282,7 @@ func testUnitUser(t *testing.T) (exit bool) {
 
 	t.Logf("Testing UserGetAll")
 	{
-		gotusers, err := UserGetAll()
+		gotusers, err := event.UserGetAll()
 		if err != nil {
 			t.Errorf("Getting a list of all users: %v", err)
 			return
@@ -311,7 +315,7 @@ func testUnitUser(t *testing.T) (exit bool) {
 	t.Logf("Testing UserIterate")
 	{
 		i := 0
-		err := UserIterate(func(u *User) error {
+		err := event.UserIterate(func(u *User) error {
 			if u.Username == AdminUsername {
 				return nil
 			}
@@ -333,7 +337,7 @@ func testUnitUser(t *testing.T) (exit bool) {
 	t.Logf("Testing UserIterate error reporting")
 	{
 		i := 0
-		err := UserIterate(func(u *User) error {
+		err := event.UserIterate(func(u *User) error {
 			if u.Username == AdminUsername {
 				return ErrInternal
 			}
@@ -361,14 +365,14 @@ func testUnitUser(t *testing.T) (exit bool) {
 		m.users[i].RealName = fake.FullName()
 		m.users[i].Description = fake.Paragraphs()
 		// Don't update password
-		err := UserUpdate(&m.users[i], nil, "", "")
+		err := event.UserUpdate(&m.users[i], nil, "", "")
 		if err != nil {
 			t.Errorf("Updating user: %v", err)
 			return
 		}
 
 		// Check to see that we still get the same result
-		gotuser, err := UserFindByUsername(m.users[i].Username)
+		gotuser, err := event.UserFindByUsername(m.users[i].Username)
 		if err != nil {
 			t.Errorf("Finding the user we just created by username: %v", err)
 			return
@@ -397,14 +401,14 @@ func testUnitUser(t *testing.T) (exit bool) {
 			return
 		}
 		// Don't update password
-		err = UserUpdate(&m.users[i], nil, "", "")
+		err = event.UserUpdate(&m.users[i], nil, "", "")
 		if err != nil {
 			t.Errorf("Updating user: %v", err)
 			return
 		}
 
 		// Check to see that we still get the same result
-		gotuser, err = UserFindByUsername(m.users[i].Username)
+		gotuser, err = event.UserFindByUsername(m.users[i].Username)
 		if err != nil {
 			t.Errorf("Finding the user we just created by username: %v", err)
 			return
@@ -424,14 +428,14 @@ func testUnitUser(t *testing.T) (exit bool) {
 		// Try changing the password
 		pwd2 := TestPassword + "2"
 
-		err = UserUpdate(&m.users[i], &m.users[i], TestPassword, pwd2)
+		err = event.UserUpdate(&m.users[i], &m.users[i], TestPassword, pwd2)
 		if err != nil {
 			t.Errorf("Changing password: %v", err)
 			return
 		}
 
 		// Check that the change worked
-		gotuser, err = UserFindByUsername(m.users[i].Username)
+		gotuser, err = event.UserFindByUsername(m.users[i].Username)
 		if err != nil {
 			t.Errorf("Finding the user we just created by username: %v", err)
 			return
@@ -449,20 +453,20 @@ func testUnitUser(t *testing.T) (exit bool) {
 		}
 
 		// Try using the wrong password
-		err = UserUpdate(&m.users[i], &m.users[i], TestPassword, pwd2)
+		err = event.UserUpdate(&m.users[i], &m.users[i], TestPassword, pwd2)
 		if err == nil {
 			t.Errorf("Expected wrong password to fail, but succeeded!")
 			return
 		}
 
 		// Change it back
-		err = UserUpdate(&m.users[i], &m.users[i], pwd2, TestPassword)
+		err = event.UserUpdate(&m.users[i], &m.users[i], pwd2, TestPassword)
 		if err != nil {
 			t.Errorf("Changing password back: %v", err)
 			return
 		}
 
-		gotuser, err = UserFindByUsername(m.users[i].Username)
+		gotuser, err = event.UserFindByUsername(m.users[i].Username)
 		if err != nil {
 			t.Errorf("Finding the user we just created by username: %v", err)
 			return
@@ -482,13 +486,13 @@ func testUnitUser(t *testing.T) (exit bool) {
 		// Try changing the username, and make sure it didn't actually change
 		copy := m.users[i]
 		copy.Username = "invalid"
-		err = UserUpdate(&copy, nil, "", "")
+		err = event.UserUpdate(&copy, nil, "", "")
 		if err != nil {
 			t.Errorf("Updating user: %v", err)
 			return
 		}
 
-		gotuser, err = UserFindByUsername(m.users[i].Username)
+		gotuser, err = event.UserFindByUsername(m.users[i].Username)
 		if err != nil {
 			t.Errorf("Finding the attempted-to-change username old username: %v", err)
 			return
@@ -504,13 +508,13 @@ func testUnitUser(t *testing.T) (exit bool) {
 		copy = m.users[i]
 		copy.IsAdmin = !copy.IsAdmin
 		copy.IsVerified = !copy.IsVerified
-		err = UserUpdate(&copy, nil, "", "")
+		err = event.UserUpdate(&copy, nil, "", "")
 		if err != nil {
 			t.Errorf("Updating user: %v", err)
 			return
 		}
 
-		gotuser, err = UserFindByUsername(m.users[i].Username)
+		gotuser, err = event.UserFindByUsername(m.users[i].Username)
 		if err != nil {
 			t.Errorf("Finding fake-modified user: %v", err)
 			return
@@ -524,7 +528,7 @@ func testUnitUser(t *testing.T) (exit bool) {
 
 		// Try using a bogus UserID and make sure it fails
 		copy.UserID = UserID("invalid")
-		err = UserUpdate(&copy, nil, "", "")
+		err = event.UserUpdate(&copy, nil, "", "")
 		if err == nil {
 			t.Errorf("Updating user with bad UserID didn't fail!")
 			return
@@ -533,13 +537,13 @@ func testUnitUser(t *testing.T) (exit bool) {
 		// Try modifying HashedPassword directly and make sure nothing changes
 		copy = m.users[i]
 		copy.HashedPassword, _ = passwordHash("Foo")
-		err = UserUpdate(&copy, nil, "", "")
+		err = event.UserUpdate(&copy, nil, "", "")
 		if err != nil {
 			t.Errorf("Updating user: %v", err)
 			return
 		}
 
-		gotuser, err = UserFindByUsername(m.users[i].Username)
+		gotuser, err = event.UserFindByUsername(m.users[i].Username)
 		if err != nil {
 			t.Errorf("Finding fake-modified user: %v", err)
 			return
@@ -559,13 +563,13 @@ func testUnitUser(t *testing.T) (exit bool) {
 	// DeleteUser
 	t.Logf("Testing DeleteUser")
 	for i := range m.users {
-		err := DeleteUser(m.users[i].UserID)
+		err := event.DeleteUser("", m.users[i].UserID, "")
 		if err != nil {
 			t.Errorf("Deleting user %s: %v", m.users[i].UserID, err)
 			return
 		}
 
-		gotuser, err := UserFind(m.users[i].UserID)
+		gotuser, err := event.UserFind(m.users[i].UserID)
 		if err != nil {
 			t.Errorf("Error getting deleted user: %v", err)
 			return
@@ -575,7 +579,7 @@ func testUnitUser(t *testing.T) (exit bool) {
 			return
 		}
 
-		err = DeleteUser(m.users[i].UserID)
+		err = event.DeleteUser("", m.users[i].UserID, "")
 		if err != ErrUserNotFound {
 			t.Errorf("Deleting non-existent user: wanted ErrUserNotfound, got %v", err)
 			return
@@ -586,3 +590,201 @@ func testUnitUser(t *testing.T) (exit bool) {
 
 	return false
 }
+
+func testUnitDeleteReassign(t *testing.T) (exit bool) {
+	// Any "early" exit is a failure
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+
+	m := &mirrorData{}
+
+	if testNewUsers(t, m, 3) {
+		return
+	}
+	deleted, heir, busy := &m.users[0], &m.users[1], &m.users[2]
+
+	admin, err := event.UserFindByUsername(AdminUsername)
+	if err != nil || admin == nil {
+		t.Errorf("Finding admin user: %v", err)
+		return
+	}
+
+	// Discussions reassigned to an unverified user stop being public
+	if err := event.UserSetVerified("", deleted, true); err != nil {
+		t.Errorf("UserSetVerified: %v", err)
+		return
+	}
+	if err := event.UserSetVerified("", heir, false); err != nil {
+		t.Errorf("UserSetVerified: %v", err)
+		return
+	}
+
+	t.Logf("Creating discussions")
+	disc := Discussion{Owner: deleted.UserID, Title: "Inherited", Description: "Changes hands",
+		Facilitators: []UserID{heir.UserID}}
+	if err := event.NewDiscussion(&disc); err != nil {
+		t.Errorf("NewDiscussion: %v", err)
+		return
+	}
+	if err := event.DiscussionSetPublic("", disc.DiscussionID, true); err != nil {
+		t.Errorf("DiscussionSetPublic: %v", err)
+		return
+	}
+	for i := 0; i < maxDiscussionsPerUser; i++ {
+		d := Discussion{Owner: busy.UserID, Title: fmt.Sprintf("Busy %d", i), Description: "Full up"}
+		if err := event.NewDiscussion(&d); err != nil {
+			t.Errorf("NewDiscussion: %v", err)
+			return
+		}
+	}
+
+	t.Logf("Trying invalid reassignments")
+	if err := event.DeleteUser("", deleted.UserID, deleted.UserID); err != errReassignSameUser {
+		t.Errorf("Reassigning to self: expected errReassignSameUser, got %v", err)
+		return
+	}
+	if err := event.DeleteUser("", deleted.UserID, UserID("invalid")); err != errReassignUserNotFound {
+		t.Errorf("Reassigning to invalid user: expected errReassignUserNotFound, got %v", err)
+		return
+	}
+	if err := event.DeleteUser("", deleted.UserID, busy.UserID); err != errReassignTooManyDiscussions {
+		t.Errorf("Reassigning to busy user: expected errReassignTooManyDiscussions, got %v", err)
+		return
+	}
+	// Failed attempts shouldn't have deleted anything
+	if u, err := event.UserFind(deleted.UserID); err != nil || u == nil {
+		t.Errorf("User deleted by failed reassignment: err %v", err)
+		return
+	}
+
+	t.Logf("Reassigning discussions")
+	if err := event.DeleteUser("", deleted.UserID, heir.UserID); err != nil {
+		t.Errorf("DeleteUser: %v", err)
+		return
+	}
+	df, err := event.DiscussionFindByIdFull(disc.DiscussionID)
+	if err != nil || df == nil {
+		t.Errorf("Reassigned discussion not found: err %v", err)
+		return
+	}
+	if df.Owner != heir.UserID || len(df.Facilitators) != 0 {
+		t.Errorf("Unexpected owner %v and facilitators %v", df.Owner, df.Facilitators)
+		return
+	}
+	if df.IsPublic {
+		t.Errorf("Discussion reassigned to unverified user is still public")
+		return
+	}
+	if interest, err := event.UserGetInterest(heir, &disc); err != nil || interest != InterestMax {
+		t.Errorf("New owner interest: got %d, err %v", interest, err)
+		return
+	}
+
+	// The admin isn't limited
+	if err := event.DeleteUser("", busy.UserID, admin.UserID); err != nil {
+		t.Errorf("Reassigning to admin: %v", err)
+		return
+	}
+	if err := event.DeleteUser("", heir.UserID, admin.UserID); err != nil {
+		t.Errorf("Reassigning to admin with %d discussions: %v", maxDiscussionsPerUser, err)
+		return
+	}
+	if df, err = event.DiscussionFindByIdFull(disc.DiscussionID); err != nil || df == nil ||
+		df.Owner != admin.UserID || !df.IsPublic {
+		t.Errorf("Expected discussion reassigned to admin to be public, got %v, err %v", df, err)
+		return
+	}
+
+	tc.cleanup()
+
+	return false
+}
+
+func testUnitUserCopy(t *testing.T) (exit bool) {
+	// Any "early" exit is a failure
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+
+	m := &mirrorData{}
+	if testNewUsers(t, m, 2) {
+		return
+	}
+
+	t.Logf("Opening a second event")
+	other, err := Load(EventOptions{Filename: tc.tmpdir + "/other.sqlite3",
+		DefaultLocation: TestDefaultLocation})
+	if err != nil {
+		t.Errorf("Opening second event: %v", err)
+		return
+	}
+	defer other.Close()
+
+	user := &m.users[0]
+	copied, err := other.UserCopy(user)
+	if err != nil {
+		t.Errorf("UserCopy: %v", err)
+		return
+	}
+	if copied.UserID != user.UserID || copied.IsVerified || copied.Role != RoleAttendee {
+		t.Errorf("Unexpected copy %v of %v", copied, user)
+		return
+	}
+	got, err := other.UserFindByUsername(user.Username)
+	if err != nil || got == nil || got.UserID != user.UserID || !got.CheckPassword(TestPassword) {
+		t.Errorf("Finding copied user: got %v, err %v", got, err)
+		return
+	}
+	if found, _ := event.UserFind(user.UserID); found == nil {
+		t.Errorf("Copying removed the user from the original event")
+		return
+	}
+
+	if _, err := other.UserCopy(user); err != errUsernameExists {
+		t.Errorf("Copying user twice: expected errUsernameExists, got %v", err)
+		return
+	}
+	admin, err := event.UserFindByUsername(AdminUsername)
+	if err != nil || admin == nil {
+		t.Errorf("Finding admin: %v", err)
+		return
+	}
+	if _, err := other.UserCopy(admin); err != errUsernameExists {
+		t.Errorf("Copying admin: expected errUsernameExists, got %v", err)
+		return
+	}
+
+	t.Logf("Changing a copied user's password")
+	hash, err := passwordHash("newpassword")
+	if err != nil {
+		t.Errorf("passwordHash: %v", err)
+		return
+	}
+	if err := other.UserSetPasswordHash(user.UserID, hash); err != nil {
+		t.Errorf("UserSetPasswordHash: %v", err)
+		return
+	}
+	if got, _ := other.UserFind(user.UserID); got == nil || !got.CheckPassword("newpassword") {
+		t.Errorf("Password not changed")
+		return
+	}
+	if got, _ := event.UserFind(user.UserID); got == nil || !got.CheckPassword(TestPassword) {
+		t.Errorf("Password changed in the wrong event")
+		return
+	}
+	if err := other.UserSetPasswordHash(m.users[1].UserID, hash); err != ErrUserNotFound {
+		t.Errorf("UserSetPasswordHash of missing user: expected ErrUserNotFound, got %v", err)
+		return
+	}
+
+	tc.cleanup()
+
+	return false
+}
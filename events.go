@@ -0,0 +1,380 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/gwd/session-scheduler/event"
+	"github.com/gwd/session-scheduler/keyvalue"
+)
+
+// A server can host several events, each with its own database.  The
+// default event is the one which was always there (in
+// event.DbFilename); it's served for any hostname which doesn't belong
+// to another event, and its administrators manage the others.  Each
+// event has its own users, but accounts can be shared: see
+// FindUser.
+const (
+	KeyEvents = "ServeEvents"
+	eventsDir = "data/events"
+)
+
+// EventInfo is what the server configuration records about an event.
+type EventInfo struct {
+	Name     string // Used for the database filename; "" for the default event
+	Title    string
+	Hostname string // Requests for this hostname are for this event
+	Archived bool   // Archived events can be viewed, but not changed
+}
+
+// Event is an event being served.
+type Event struct {
+	EventInfo
+	*event.EventStore
+}
+
+var (
+	eventsLock sync.RWMutex
+	// The default event is always first
+	events []*Event
+)
+
+var eventNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+var (
+	errEventName     = errors.New("Event names must be lower-case letters, digits and dashes")
+	errEventExists   = errors.New("An event with that name already exists")
+	errEventHostname = errors.New("Another event is already using that hostname")
+	errEventNoHost   = errors.New("Events other than this one need a hostname to be reached on")
+	errEventDefault  = errors.New("The default event can't be archived")
+	errEventDefHost  = errors.New("The default event is served on every other hostname")
+)
+
+// Key returns the server configuration key under which to store
+// per-event setting key for ev.
+func (ev *Event) Key(key string) string {
+	if ev.Name == "" {
+		return key
+	}
+	return key + "/" + ev.Name
+}
+
+// IsDefault returns true for the default event.
+func (ev *Event) IsDefault() bool {
+	return ev.Name == ""
+}
+
+// DisplayTitle returns the title to show at the top of every page.
+func (ev *Event) DisplayTitle() string {
+	if ev.Title == "" {
+		return "Xen Design Sessions"
+	}
+	return ev.Title
+}
+
+// BaseURL returns the external URL of ev's site: the server's base
+// URL, with the event's hostname if it has one.
+func (ev *Event) BaseURL() string {
+	base := baseURL()
+	if ev.Hostname == "" {
+		return base
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return "http://" + ev.Hostname
+	}
+	u.Host = ev.Hostname
+	return strings.TrimSuffix(u.String(), "/")
+}
+
+func eventFilename(name string) string {
+	if name == "" {
+		return event.DbFilename
+	}
+	return filepath.Join(eventsDir, name+".sqlite")
+}
+
+// getEventInfos returns the events other than the default one.
+func getEventInfos() ([]EventInfo, error) {
+	var infos []EventInfo
+	value, err := kvs.Get(KeyEvents)
+	switch {
+	case err == keyvalue.ErrNoRows:
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(value), &infos); err != nil {
+		return nil, fmt.Errorf("Parsing %s: %v", KeyEvents, err)
+	}
+	return infos, nil
+}
+
+// saveEventInfos records the events in the server configuration.
+// Must be called with eventsLock held.
+func saveEventInfos() error {
+	var infos []EventInfo
+	for _, ev := range events[1:] {
+		infos = append(infos, ev.EventInfo)
+	}
+	value, err := json.Marshal(infos)
+	if err != nil {
+		return err
+	}
+	return kvs.Set(KeyEvents, string(value))
+}
+
+// eventFilenames returns the database files of all the events.
+func eventFilenames() ([]string, error) {
+	infos, err := getEventInfos()
+	if err != nil {
+		return nil, err
+	}
+	filenames := []string{eventFilename("")}
+	for _, info := range infos {
+		filenames = append(filenames, eventFilename(info.Name))
+	}
+	return filenames, nil
+}
+
+// LoadEvents opens the databases of all the events.  adminPwd (if
+// set) is used for the admin account of the event called adminEvent.
+func LoadEvents(adminEvent, adminPwd, location string) {
+	infos, err := getEventInfos()
+	if err != nil {
+		log.Fatalf("Getting events: %v", err)
+	}
+	infos = append([]EventInfo{{}}, infos...)
+
+	found := false
+	for _, info := range infos {
+		opt := event.EventOptions{DefaultLocation: location, Filename: eventFilename(info.Name)}
+		if info.Name == adminEvent {
+			opt.AdminPwd = adminPwd
+			found = true
+		}
+		store, err := event.Load(opt)
+		if err != nil {
+			log.Fatalf("Loading event %s: %v", eventFilename(info.Name), err)
+		}
+		events = append(events, &Event{EventInfo: info, EventStore: store})
+	}
+	if !found {
+		log.Fatalf("No such event: %s", adminEvent)
+	}
+}
+
+// CloseEvents closes all the event databases.
+func CloseEvents() {
+	eventsLock.Lock()
+	defer eventsLock.Unlock()
+	for _, ev := range events {
+		ev.Close()
+	}
+	events = nil
+}
+
+// Events returns all the events, the default event first.
+func Events() []*Event {
+	eventsLock.RLock()
+	defer eventsLock.RUnlock()
+	return append([]*Event(nil), events...)
+}
+
+// EventFind returns the event called name, or nil if there isn't one.
+func EventFind(name string) *Event {
+	for _, ev := range Events() {
+		if ev.Name == name {
+			return ev
+		}
+	}
+	return nil
+}
+
+// DefaultEvent returns the default event.
+func DefaultEvent() *Event {
+	return EventFind("")
+}
+
+// eventForHost returns the event served on host, which is the
+// default event unless another event has claimed the hostname.
+func eventForHost(host string) *Event {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	evs := Events()
+	for _, ev := range evs[1:] {
+		if ev.Hostname != "" && strings.EqualFold(ev.Hostname, host) {
+			return ev
+		}
+	}
+	return evs[0]
+}
+
+type eventContextKey struct{}
+
+// RequestEvent returns the event a request is for.
+func RequestEvent(r *http.Request) *Event {
+	if ev, ok := r.Context().Value(eventContextKey{}).(*Event); ok {
+		return ev
+	}
+	return eventForHost(r.Host)
+}
+
+func withEvent(r *http.Request, ev *Event) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), eventContextKey{}, ev))
+}
+
+// EventCreate creates a new event.  If clone is non-nil, the new event
+// starts with a copy of its timetable and locations.  The new event's
+// admin account has the same password as the default event's.
+func EventCreate(actor event.UserID, info EventInfo, clone *Event) (*Event, error) {
+	info.Name = strings.TrimSpace(info.Name)
+	info.Title = strings.TrimSpace(info.Title)
+	info.Hostname = strings.ToLower(strings.TrimSpace(info.Hostname))
+	info.Archived = false
+	if !eventNameRegexp.MatchString(info.Name) {
+		return nil, errEventName
+	}
+	if info.Hostname == "" {
+		return nil, errEventNoHost
+	}
+
+	eventsLock.Lock()
+	defer eventsLock.Unlock()
+
+	for _, ev := range events {
+		if ev.Name == info.Name {
+			return nil, errEventExists
+		}
+		if strings.EqualFold(ev.Hostname, info.Hostname) {
+			return nil, errEventHostname
+		}
+	}
+
+	filename := eventFilename(info.Name)
+	if _, err := os.Stat(filename); err == nil {
+		return nil, errEventExists
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return nil, err
+	}
+
+	// The admin password generated here is replaced below
+	store, err := event.Load(event.EventOptions{
+		DefaultLocation: DefaultLocation,
+		Filename:        filename})
+	if err != nil {
+		return nil, err
+	}
+	ev := &Event{EventInfo: info, EventStore: store}
+
+	// From here on, undo everything on failure
+	err = func() error {
+		defAdmin, err := events[0].UserFindByUsername(event.AdminUsername)
+		if err != nil {
+			return err
+		}
+		admin, err := ev.UserFindByUsername(event.AdminUsername)
+		if err != nil {
+			return err
+		}
+		if defAdmin != nil && admin != nil {
+			if err := ev.UserSetPasswordHash(admin.UserID, defAdmin.HashedPassword); err != nil {
+				return err
+			}
+			log.Printf("Set admin password of event %s to that of the default event", info.Name)
+		}
+
+		if clone != nil {
+			ex, err := clone.ExportEvent(event.ExportOptions{TimetableOnly: true})
+			if err != nil {
+				return err
+			}
+			_, err = ev.ImportEvent(actor, ex, event.ImportOptions{TimetableOnly: true})
+			if err != nil {
+				return err
+			}
+		}
+
+		events = append(events, ev)
+		if err := saveEventInfos(); err != nil {
+			events = events[:len(events)-1]
+			return err
+		}
+		return nil
+	}()
+	if err != nil {
+		store.Close()
+		os.Remove(filename)
+		return nil, err
+	}
+
+	log.Printf("Created event %s (%s)", info.Name, filename)
+	return ev, nil
+}
+
+// EventSetArchived archives or unarchives ev.
+func EventSetArchived(ev *Event, archived bool) error {
+	if ev.IsDefault() {
+		return errEventDefault
+	}
+
+	eventsLock.Lock()
+	defer eventsLock.Unlock()
+
+	return eventReplace(ev, func(nev *Event) { nev.Archived = archived })
+}
+
+// EventSetHostname changes the hostname ev is served on.  The default
+// event doesn't have one, since it's served on all the others.
+func EventSetHostname(ev *Event, hostname string) error {
+	hostname = strings.ToLower(strings.TrimSpace(hostname))
+	if ev.IsDefault() {
+		return errEventDefHost
+	}
+	if hostname == "" {
+		return errEventNoHost
+	}
+
+	eventsLock.Lock()
+	defer eventsLock.Unlock()
+
+	for _, other := range events {
+		if other.Name != ev.Name && strings.EqualFold(other.Hostname, hostname) {
+			return errEventHostname
+		}
+	}
+	return eventReplace(ev, func(nev *Event) { nev.Hostname = hostname })
+}
+
+// eventReplace replaces ev with a copy changed by change, and saves
+// the event infos.  Events are replaced rather than changed, since
+// requests may be looking at them.  Must be called with eventsLock
+// held.
+func eventReplace(ev *Event, change func(nev *Event)) error {
+	for i := range events {
+		if events[i] == ev {
+			nev := *ev
+			change(&nev)
+			events[i] = &nev
+			if err := saveEventInfos(); err != nil {
+				events[i] = ev
+				return err
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("No such event: %s", ev.Name)
+}
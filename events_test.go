@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/gwd/session-scheduler/event"
+	"github.com/gwd/session-scheduler/keyvalue"
+)
+
+// testEventsInit opens a server configuration and an event for each of
+// infos (the first being the default event) in a temporary directory,
+// and makes them the server's events.  The returned function closes
+// and removes them again.
+func testEventsInit(t *testing.T, infos ...EventInfo) func() {
+	tmpdir, err := ioutil.TempDir("", "serve")
+	if err != nil {
+		t.Fatalf("Creating temporary directory: %v", err)
+	}
+
+	kvs, err = keyvalue.OpenFile(tmpdir + "/serverconfig.sqlite")
+	if err != nil {
+		os.RemoveAll(tmpdir)
+		t.Fatalf("Opening server config: %v", err)
+	}
+
+	var evs []*Event
+	cleanup := func() {
+		for _, ev := range evs {
+			ev.Close()
+		}
+		kvs.Close()
+		os.RemoveAll(tmpdir)
+	}
+
+	for i, info := range infos {
+		store, err := event.Load(event.EventOptions{
+			Filename:        fmt.Sprintf("%s/event%d.sqlite", tmpdir, i),
+			DefaultLocation: DefaultLocation})
+		if err != nil {
+			cleanup()
+			t.Fatalf("Opening event %q: %v", info.Name, err)
+		}
+		evs = append(evs, &Event{EventInfo: info, EventStore: store})
+	}
+
+	eventsLock.Lock()
+	events = evs
+	eventsLock.Unlock()
+	return cleanup
+}
+
+func TestEventHostname(t *testing.T) {
+	defer testEventsInit(t, EventInfo{},
+		EventInfo{Name: "one", Hostname: "one.example"},
+		EventInfo{Name: "two"})()
+
+	t.Logf("Creating an event without a hostname")
+	if _, err := EventCreate("", EventInfo{Name: "three"}, nil); err != errEventNoHost {
+		t.Errorf("Expected errEventNoHost, got %v", err)
+	}
+	if _, err := EventCreate("", EventInfo{Name: "three", Hostname: "One.Example"}, nil); err != errEventHostname {
+		t.Errorf("Expected errEventHostname, got %v", err)
+	}
+
+	t.Logf("Giving an existing event a hostname")
+	if err := EventSetHostname(EventFind("two"), " "); err != errEventNoHost {
+		t.Errorf("Expected errEventNoHost, got %v", err)
+	}
+	if err := EventSetHostname(EventFind("two"), "one.example"); err != errEventHostname {
+		t.Errorf("Expected errEventHostname, got %v", err)
+	}
+	if err := EventSetHostname(DefaultEvent(), "default.example"); err != errEventDefHost {
+		t.Errorf("Expected errEventDefHost, got %v", err)
+	}
+	if ev := eventForHost("two.example:8080"); ev != DefaultEvent() {
+		t.Errorf("Expected default event for unknown hostname, got %q", ev.Name)
+	}
+	if err := EventSetHostname(EventFind("two"), "Two.Example"); err != nil {
+		t.Fatalf("EventSetHostname: %v", err)
+	}
+	if ev := eventForHost("two.example:8080"); ev.Name != "two" {
+		t.Errorf("Expected event two, got %q", ev.Name)
+	}
+
+	// Changing an event's own hostname (e.g., only its case) is fine
+	if err := EventSetHostname(EventFind("one"), "ONE.example"); err != nil {
+		t.Errorf("EventSetHostname: %v", err)
+	}
+
+	infos, err := getEventInfos()
+	if err != nil {
+		t.Fatalf("getEventInfos: %v", err)
+	}
+	if len(infos) != 2 || infos[0].Hostname != "one.example" || infos[1].Hostname != "two.example" {
+		t.Errorf("Unexpected saved events %v", infos)
+	}
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 
@@ -19,6 +23,7 @@ const (
 )
 
 func HandleAdminConsole(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ev := RequestEvent(r)
 	user := RequestUser(r)
 
 	if user == nil || !user.IsAdmin {
@@ -33,9 +38,10 @@ func HandleAdminConsole(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 		return
 
 	case "console":
-		content["Vcode"], _ = kvs.Get(VerificationCode)
-		content["SinceLastSchedule"] = event.SchedLastUpdate()
-		switch event.SchedGetState() {
+		content["Vcode"], _ = kvs.Get(ev.Key(VerificationCode))
+		content["SinceLastSchedule"] = ev.SchedLastUpdate()
+		content["LastScheduleResult"] = ev.SchedLastResult()
+		switch ev.SchedGetState() {
 		case event.SchedStateRunning:
 			content["IsInProgress"] = true
 		case event.SchedStateModified:
@@ -43,16 +49,48 @@ func HandleAdminConsole(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 		default:
 			content["IsCurrent"] = true
 		}
-		ls := event.TimetableGetLockedSlots()
+		ls := ev.TimetableGetLockedSlots()
 		SlotsSetTimeDisplay(ls, slotTimeFormat)
 		content["LockedSlots"] = ls
+		if pending, err := ev.DiscussionGetPending(); err != nil {
+			log.Printf("Error getting pending discussions: %v", err)
+		} else {
+			content["PendingCount"] = len(pending)
+		}
 		fallthrough
 	case "locations":
 		var err error
-		content["Locations"], err = event.LocationGetAll()
+		content["Locations"], err = ev.LocationGetAll()
 		if err != nil {
 			log.Printf("Error getting locations: %v", err)
 		}
+	case "timetable":
+		adminTimetableContent(ev, content)
+	case "invites":
+		adminInvitesContent(ev, content)
+	case "audit":
+		adminAuditContent(content, r)
+	case "trash":
+		adminTrashContent(ev, content)
+	case "backups":
+		if !ev.IsDefault() {
+			return
+		}
+		adminBackupsContent(content)
+	case "events":
+		if !ev.IsDefault() {
+			return
+		}
+		adminEventsContent(content)
+	case "explain":
+		ex, err := ev.ScheduleExplain(slotTimeFormat, &DefaultLocationTZ)
+		if err != nil {
+			log.Printf("Error explaining schedule: %v", err)
+		}
+		content["Explanation"] = ex
+		if ex != nil && ex.MaxUtility > 0 {
+			content["UtilityPercent"] = ex.TotalUtility * 100 / ex.MaxUtility
+		}
 	}
 
 	content[tmpl] = true
@@ -62,6 +100,7 @@ func HandleAdminConsole(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 var OptSearchAlgo string
 
 func HandleAdminAction(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ev := RequestEvent(r)
 	user := RequestUser(r)
 
 	if user == nil || !user.IsAdmin {
@@ -75,35 +114,55 @@ func HandleAdminAction(w http.ResponseWriter, r *http.Request, ps httprouter.Par
 		action == "resetEventData" ||
 		action == "setLocked" ||
 		action == "newLocation" ||
-		action == "updateLocation") {
+		action == "updateLocation" ||
+		action == "deleteLocation" ||
+		action == "newday" ||
+		action == "renameday" ||
+		action == "deleteday" ||
+		action == "timetableday" ||
+		action == "newinvites" ||
+		action == "revokeinvite" ||
+		action == "restoreuser" ||
+		action == "restorediscussion" ||
+		action == "backup" ||
+		action == "newevent" ||
+		action == "archiveevent" ||
+		action == "unarchiveevent" ||
+		action == "eventhostname") {
+		return
+	}
+
+	// Things which affect the whole server can only be done from the
+	// default event
+	if !ev.IsDefault() && (action == "backup" || action == "newevent" ||
+		action == "archiveevent" || action == "unarchiveevent" ||
+		action == "eventhostname") {
 		return
 	}
 
 	switch action {
 	case "runschedule":
-		err := MakeSchedule(false)
-		if err == nil {
-			http.Redirect(w, r, "console?flash=Schedule+Finished", http.StatusFound)
-		} else {
+		err := MakeSchedule(ev, user.UserID, true)
+		switch {
+		case err == nil:
+			http.Redirect(w, r, "console?flash=Schedule+Started", http.StatusFound)
+		case event.IsValidationError(err):
+			http.Redirect(w, r, "console?flash="+url.QueryEscape(err.Error()), http.StatusFound)
+		default:
 			log.Printf("Error generating schedule: %v", err)
 			http.Redirect(w, r, "console?flash=Error+starting+schedule: See Log", http.StatusFound)
 		}
 	case "setvcode":
-		newvcode := r.FormValue("vcode")
-		if newvcode == "" {
-			vcode, _ := kvs.Get(VerificationCode)
-			RenderTemplate(w, r, "console?flash=Invalid+Vcode",
-				map[string]interface{}{
-					"User":    user,
-					"console": true,
-					"Vcode":   vcode,
-				})
-			return
-		}
+		// An empty code disables the shared code, so that only
+		// invitation codes can be used.
+		newvcode := strings.TrimSpace(r.FormValue("vcode"))
 
 		log.Printf("New vcode: %s", newvcode)
-		err := kvs.Set(VerificationCode, newvcode)
+		err := kvs.Set(ev.Key(VerificationCode), newvcode)
 		flash := "Verification+code+updated"
+		if newvcode == "" {
+			flash = "Shared+verification+code+disabled"
+		}
 		if err != nil {
 			flash = "Verification+code+not+updated"
 			log.Printf("Error setting verification code: %v", err)
@@ -156,16 +215,16 @@ func HandleAdminAction(w http.ResponseWriter, r *http.Request, ps httprouter.Par
 		}
 
 		flash, err := flashAccumulator("", nil, "website",
-			FlagActive, "Website+Activated", "Website+Deactivated")
+			ev.Key(FlagActive), "Website+Activated", "Website+Deactivated")
 
 		flash, err = flashAccumulator(flash, err, "schedule",
-			FlagScheduleActive, "Schedule+Activated", "Schedule+Deactivated")
+			ev.Key(FlagScheduleActive), "Schedule+Activated", "Schedule+Deactivated")
 
 		flash, err = flashAccumulator(flash, err, "vcodesent",
-			FlagVerificationCodeSent, "Verification+Code+Sent", "Verification+Code+Not+Sent")
+			ev.Key(FlagVerificationCodeSent), "Verification+Code+Sent", "Verification+Code+Not+Sent")
 
 		flash, err = flashAccumulator(flash, err, "verification",
-			FlagRequireVerification, "Verification+Required", "Verification+Not+Required")
+			ev.Key(FlagRequireVerification), "Verification+Required", "Verification+Not+Required")
 
 		http.Redirect(w, r, "console?flash="+flash, http.StatusFound)
 		return
@@ -176,7 +235,7 @@ func HandleAdminAction(w http.ResponseWriter, r *http.Request, ps httprouter.Par
 			return
 		}
 		log.Printf("New locked slots: %v", locked)
-		err = event.TimetableSetLockedSlots(locked)
+		err = ev.TimetableSetLockedSlots(user.UserID, locked)
 		if err != nil {
 			log.Printf("INTERNAL ERROR setting slots: %v", err)
 			http.Redirect(w, r, "console?flash=Error+setting+slots", http.StatusFound)
@@ -220,11 +279,14 @@ func HandleAdminAction(w http.ResponseWriter, r *http.Request, ps httprouter.Par
 			var err error
 			switch action {
 			case "newLocation":
-				_, err = event.NewLocation(&l)
+				_, err = ev.NewLocation(user.UserID, &l)
 			case "updateLocation":
-				err = event.LocationUpdate(&l)
+				err = ev.LocationUpdate(user.UserID, &l, formImpactAction(r))
 			}
-			if event.IsValidationError(err) {
+			if impact := event.GetScheduleImpact(err); impact != nil {
+				renderAdminLocationImpact(w, r, user, action, &l, impact)
+				return
+			} else if event.IsValidationError(err) {
 				log.Printf("Error creating new location: %v", err)
 				flash = "?flash=Validation+Error"
 			} else if err != nil {
@@ -233,10 +295,244 @@ func HandleAdminAction(w http.ResponseWriter, r *http.Request, ps httprouter.Par
 			}
 		}
 		http.Redirect(w, r, "locations"+flash, http.StatusFound)
+	case "newday":
+		handleAdminNewDay(w, r, user)
+	case "renameday", "deleteday":
+		handleAdminDayAction(w, r, user, action)
+	case "timetableday":
+		handleAdminTimetableDay(w, r, user)
+	case "deleteLocation":
+		flash := "Location+deleted"
+		lid, err := strconv.Atoi(r.FormValue("locID"))
+		if err != nil {
+			log.Printf("Error parsing locationid: %v", err)
+			flash = "Website+Error"
+		} else {
+			err = ev.DeleteLocation(user.UserID, event.LocationID(lid), formImpactAction(r))
+			if impact := event.GetScheduleImpact(err); impact != nil {
+				l := event.Location{LocationID: event.LocationID(lid)}
+				renderAdminLocationImpact(w, r, user, action, &l, impact)
+				return
+			}
+			switch {
+			case err == event.ErrLocationNotFound:
+				flash = "Location+not+found"
+			case event.IsValidationError(err):
+				flash = url.QueryEscape(err.Error())
+			case err != nil:
+				log.Printf("Error deleting location: %v", err)
+				flash = "Internal+Error"
+			}
+		}
+		http.Redirect(w, r, "locations?flash="+flash, http.StatusFound)
+	case "newinvites":
+		handleAdminNewInvites(w, r, user)
+	case "revokeinvite":
+		flash := "Invitation+code+revoked"
+		if err := ev.RevokeInvite(user.UserID, r.FormValue("code")); err != nil {
+			log.Printf("Error revoking invite: %v", err)
+			flash = "Error+revoking+invitation+code"
+		}
+		http.Redirect(w, r, "invites?flash="+flash, http.StatusFound)
+	case "restoreuser", "restorediscussion":
+		var err error
+		flash := "Restored"
+		if action == "restoreuser" {
+			err = ev.UserRestore(user.UserID, event.UserID(r.FormValue("uid")))
+		} else {
+			err = ev.DiscussionRestore(user.UserID, event.DiscussionID(r.FormValue("did")))
+		}
+		switch {
+		case err == event.ErrUserNotFound || err == event.ErrDiscussionNotFound:
+			flash = "Not+found+in+the+trash"
+		case event.IsValidationError(err):
+			flash = url.QueryEscape(err.Error())
+		case err != nil:
+			log.Printf("Error restoring from trash: %v", err)
+			flash = "Error+restoring:+See+Log"
+		}
+		http.Redirect(w, r, "trash?flash="+flash, http.StatusFound)
+	case "backup":
+		flash := "Backup+taken"
+		if _, err := Backup(); err != nil {
+			log.Printf("Error backing up: %v", err)
+			flash = "Error+backing+up:+See+Log"
+		}
+		http.Redirect(w, r, "backups?flash="+flash, http.StatusFound)
+	case "newevent":
+		handleAdminNewEvent(w, r, user)
+	case "archiveevent", "unarchiveevent":
+		handleAdminArchiveEvent(w, r, action == "archiveevent")
+	case "eventhostname":
+		handleAdminEventHostname(w, r)
+	}
+}
+
+// renderAdminLocationImpact shows the locations page with what
+// action (updating or deleting l) would unschedule, so that the user
+// can confirm it.
+func renderAdminLocationImpact(w http.ResponseWriter, r *http.Request, user *event.User,
+	action string, l *event.Location, impact []event.ScheduleImpact) {
+	ev := RequestEvent(r)
+	content := map[string]interface{}{"User": user, "locations": true}
+	var err error
+	content["Locations"], err = ev.LocationGetAll()
+	if err != nil {
+		log.Printf("Error getting locations: %v", err)
+	}
+	ImpactSetTimeDisplay(impact, slotTimeFormat)
+	content["Impact"] = impact
+	content["ImpactAction"] = action
+	content["ImpactLocation"] = l
+	RenderTemplate(w, r, "admin/locations", content)
+}
+
+func adminInvitesContent(ev *Event, content map[string]interface{}) {
+	var err error
+	content["Invites"], err = ev.InviteGetAll()
+	if err != nil {
+		log.Printf("Error getting invites: %v", err)
+	}
+	content["Redemptions"], err = ev.InviteRedemptions()
+	if err != nil {
+		log.Printf("Error getting invite redemptions: %v", err)
+	}
+}
+
+func adminTrashContent(ev *Event, content map[string]interface{}) {
+	var err error
+	content["Users"], err = ev.TrashGetUsers()
+	if err != nil {
+		log.Printf("Error getting trashed users: %v", err)
 	}
+	content["Discussions"], err = ev.TrashGetDiscussions()
+	if err != nil {
+		log.Printf("Error getting trashed discussions: %v", err)
+	}
+	retention := getTrashRetention()
+	content["Retention"] = retention
+	if day := 24 * time.Hour; retention%day == 0 {
+		content["RetentionText"] = fmt.Sprintf("%d days", retention/day)
+	} else {
+		content["RetentionText"] = retention.String()
+	}
+	content["TZ"] = DefaultLocationTZ.Location
+}
+
+func adminBackupsContent(content map[string]interface{}) {
+	var err error
+	content["Snapshots"], err = BackupList()
+	if err != nil {
+		log.Printf("Error listing backups: %v", err)
+	}
+	content["BackupDir"] = getBackupDir()
+	content["Keep"] = getBackupKeep()
+	if interval := getBackupInterval(); interval > 0 {
+		content["Interval"] = interval.String()
+	}
+	content["TZ"] = DefaultLocationTZ.Location
+}
+
+// Number of audit entries to show per page
+const auditPageSize = 100
+
+func adminAuditContent(content map[string]interface{}, r *http.Request) {
+	ev := RequestEvent(r)
+	q := r.URL.Query()
+	f := event.AuditFilter{
+		Actor:  strings.TrimSpace(q.Get("actor")),
+		Action: event.AuditAction(q.Get("action")),
+		Target: strings.TrimSpace(q.Get("target")),
+		Text:   q.Get("text"),
+		Limit:  auditPageSize,
+	}
+	f.Offset, _ = strconv.Atoi(q.Get("offset"))
+	if f.Offset < 0 {
+		f.Offset = 0
+	}
+
+	// Dates are whole days in the default location; "until" is
+	// inclusive
+	if date := q.Get("since"); date != "" {
+		t, err := time.ParseInLocation("2006-01-02", date, DefaultLocationTZ.Location)
+		if err != nil {
+			content["Error"] = "Invalid start date"
+			return
+		}
+		f.Since = t
+	}
+	if date := q.Get("until"); date != "" {
+		t, err := time.ParseInLocation("2006-01-02", date, DefaultLocationTZ.Location)
+		if err != nil {
+			content["Error"] = "Invalid end date"
+			return
+		}
+		f.Until = t.AddDate(0, 0, 1)
+	}
+
+	entries, err := ev.AuditGet(&f)
+	if err != nil {
+		log.Printf("Error getting audit log: %v", err)
+		content["Error"] = "Internal error"
+		return
+	}
+
+	content["Entries"] = entries
+	content["Filter"] = q
+	content["Actions"] = event.AuditActions
+	content["TZ"] = DefaultLocationTZ.Location
+
+	if f.Offset > 0 {
+		q.Set("offset", strconv.Itoa(f.Offset-auditPageSize))
+		content["NewerURL"] = "?" + q.Encode()
+	}
+	if len(entries) == auditPageSize {
+		q.Set("offset", strconv.Itoa(f.Offset+auditPageSize))
+		content["OlderURL"] = "?" + q.Encode()
+	}
+}
+
+func handleAdminNewInvites(w http.ResponseWriter, r *http.Request, user *event.User) {
+	ev := RequestEvent(r)
+	content := map[string]interface{}{"User": user, "invites": true}
+
+	count, err := strconv.Atoi(r.FormValue("count"))
+	if err != nil {
+		content["Error"] = "Number of codes must be a number"
+	}
+	maxUses, err := strconv.Atoi(r.FormValue("maxuses"))
+	if err != nil && content["Error"] == nil {
+		content["Error"] = "Number of uses must be a number"
+	}
+
+	// Codes expire at the end of the given day
+	var expiry time.Time
+	if date := r.FormValue("expiry"); date != "" && content["Error"] == nil {
+		t, err := time.ParseInLocation("2006-01-02", date, DefaultLocationTZ.Location)
+		if err != nil {
+			content["Error"] = "Invalid expiry date"
+		} else {
+			expiry = t.AddDate(0, 0, 1)
+		}
+	}
+
+	if content["Error"] == nil {
+		content["NewInvites"], err = ev.NewInviteBatch(user.UserID, count, maxUses, expiry,
+			r.FormValue("note"))
+		if event.IsValidationError(err) {
+			content["Error"] = err.Error()
+		} else if err != nil {
+			log.Printf("Error creating invites: %v", err)
+			content["Error"] = "Internal error"
+		}
+	}
+
+	adminInvitesContent(ev, content)
+	RenderTemplate(w, r, "admin/invites", content)
 }
 
 func HandleTestAction(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ev := RequestEvent(r)
 	user := RequestUser(r)
 
 	if user == nil || !user.IsAdmin {
@@ -247,7 +543,7 @@ func HandleTestAction(w http.ResponseWriter, r *http.Request, ps httprouter.Para
 
 	action := ps.ByName("action")
 
-	if !kvs.GetBoolDef(FlagTestMode) {
+	if !kvs.GetBoolDef(ev.Key(FlagTestMode)) {
 		if action != "enabletest" {
 			return
 		}
@@ -259,7 +555,7 @@ func HandleTestAction(w http.ResponseWriter, r *http.Request, ps httprouter.Para
 			return
 		}
 
-		err := kvs.SetBool(FlagTestMode, true)
+		err := kvs.SetBool(ev.Key(FlagTestMode), true)
 		if err != nil {
 			log.Printf("ERROR: Setting test mode failed: %v", err)
 			flash = "Set+test+mode+failed"
@@ -269,7 +565,7 @@ func HandleTestAction(w http.ResponseWriter, r *http.Request, ps httprouter.Para
 	} else {
 		switch action {
 		case "disabletest":
-			err := kvs.SetBool(FlagTestMode, false)
+			err := kvs.SetBool(ev.Key(FlagTestMode), false)
 			if err != nil {
 				log.Printf("ERROR: Setting test mode failed: %v", err)
 				flash = "Set+test+mode+failed"
@@ -290,7 +586,7 @@ func HandleTestAction(w http.ResponseWriter, r *http.Request, ps httprouter.Para
 		// 		flash = countString + " discussions generated"
 		// 	}
 		// case "geninterest":
-		// 	event.TestGenerateInterest()
+		// 	ev.TestGenerateInterest()
 		// 	flash = "Interest generated"
 		default:
 			return
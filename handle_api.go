@@ -0,0 +1,670 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/gwd/session-scheduler/event"
+)
+
+// JSON API.
+//
+// Unlike the HTML handlers, which silently redirect or show a "not
+// found" page, API handlers always respond with a JSON body and an
+// appropriate status code: 401 if a login is required, 403 if the
+// current user isn't allowed to perform the action, 404 if the object
+// doesn't exist (or isn't visible to the current user), 400 for
+// invalid input, and 500 for internal errors.  Errors are reported as
+// an APIError.
+//
+// Request bodies must be JSON, with a Content-Type of
+// application/json; among other things, this prevents other sites
+// from submitting requests using HTML forms.
+
+const apiPrefix = "/api/v1"
+
+type APIError struct {
+	Error string
+}
+
+// APIUserRef identifies a user which is part of another object.
+type APIUserRef struct {
+	UserID   event.UserID
+	Username string
+}
+
+// APIUser is a user, with the same level of privacy as the HTML
+// profile page: profile information is only shown to users who are
+// logged in.
+type APIUser struct {
+	UserID      event.UserID
+	Username    string
+	IsAdmin     bool
+	IsVerified  bool
+	Role        event.Role
+	RealName    string `json:",omitempty"`
+	Email       string `json:",omitempty"`
+	Company     string `json:",omitempty"`
+	Description string `json:",omitempty"`
+	Location    string `json:",omitempty"`
+}
+
+// APIDiscussion is a discussion as visible to the current user.
+// Interest is only present for logged-in users who can express
+// interest; PossibleSlots and Pin only for admins.  Length is the number of
+// slots the owner asked for, ApprovedLength the number it will be
+// scheduled for.
+type APIDiscussion struct {
+	DiscussionID   event.DiscussionID
+	Title          string
+	Description    string
+	IsPublic       bool
+	Owner          APIUserRef
+	Length         int
+	ApprovedLength int
+	Facilitators   []APIUserRef        `json:",omitempty"`
+	Location       *event.Location     `json:",omitempty"`
+	Time           *event.Time         `json:",omitempty"`
+	IsFinal        bool                `json:",omitempty"`
+	MayEdit        bool                `json:",omitempty"`
+	Interest       *int                `json:",omitempty"`
+	PossibleSlots  []event.DisplaySlot `json:",omitempty"`
+	Pin            *event.Pin          `json:",omitempty"`
+}
+
+// APIDiscussionRequest is the body for creating or updating a
+// discussion.  When updating, fields which are missing are left
+// unchanged.  A Pin with no SlotID unpins the discussion.
+type APIDiscussionRequest struct {
+	Title         *string
+	Description   *string
+	Facilitators  *[]event.UserID
+	Owner         *event.UserID
+	PossibleSlots *[]event.SlotID
+	Length        *int
+	Pin           *event.Pin
+}
+
+type APIInterest struct {
+	Interest int
+}
+
+type APIPublic struct {
+	IsPublic bool
+}
+
+func apiWrite(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if v == nil {
+		return
+	}
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Encoding API response: %v", err)
+	}
+}
+
+func apiError(w http.ResponseWriter, status int, msg string) {
+	apiWrite(w, status, APIError{Error: msg})
+}
+
+func apiInternalError(w http.ResponseWriter, context string, err error) {
+	log.Printf("INTERNAL ERROR: %s: %v", context, err)
+	apiError(w, http.StatusInternalServerError, "Internal error")
+}
+
+// HandleAPINotFound responds to any path under /api which doesn't
+// match an API handler.
+func HandleAPINotFound(w http.ResponseWriter, r *http.Request) {
+	apiError(w, http.StatusNotFound, "No such API endpoint")
+}
+
+func isAPIPath(path string) bool {
+	return path == "/api" || strings.HasPrefix(path, "/api/")
+}
+
+// apiReadRequest decodes the JSON body of r into v.  If it returns
+// false, an error has already been sent.
+func apiReadRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	mediatype, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediatype != "application/json" {
+		apiError(w, http.StatusUnsupportedMediaType, "Request body must be application/json")
+		return false
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		apiError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return false
+	}
+
+	return true
+}
+
+// apiRequireUser returns the current user, or sends an error and
+// returns nil if nobody is logged in.
+func apiRequireUser(w http.ResponseWriter, r *http.Request) *event.User {
+	cur := RequestUser(r)
+	if cur == nil {
+		apiError(w, http.StatusUnauthorized, "Login required")
+	}
+	return cur
+}
+
+// apiRequireVerified checks that cur is allowed to create or edit
+// discussions.
+func apiRequireVerified(ev *Event, w http.ResponseWriter, cur *event.User) bool {
+	if kvs.GetBoolDef(ev.Key(FlagRequireVerification)) && !IsVerified(cur) {
+		apiError(w, http.StatusForbidden, "Account verification required")
+		return false
+	}
+	return true
+}
+
+// apiFindDiscussion looks up the discussion named in the URL, sending
+// an error and returning nil if it doesn't exist or cur can't see it.
+func apiFindDiscussion(ev *Event, w http.ResponseWriter, ps httprouter.Params, cur *event.User) *event.DiscussionFull {
+	df, err := ev.DiscussionFindByIdFull(event.DiscussionID(ps.ByName("did")))
+	if err != nil {
+		apiInternalError(w, "Finding discussion", err)
+		return nil
+	}
+	if df == nil {
+		apiError(w, http.StatusNotFound, "Discussion not found")
+		return nil
+	}
+	if _, _, ok := DiscussionVisibleText(df, cur); !ok {
+		apiError(w, http.StatusNotFound, "Discussion not found")
+		return nil
+	}
+	return df
+}
+
+func APIUserGet(u *event.User, cur *event.User) *APIUser {
+	au := &APIUser{
+		UserID:     u.UserID,
+		Username:   u.Username,
+		IsAdmin:    u.IsAdmin,
+		IsVerified: u.IsVerified,
+		Role:       u.Role,
+	}
+	// Only display profile information to people who are logged in
+	if cur != nil {
+		au.RealName = u.RealName
+		au.Email = u.Email
+		au.Company = u.Company
+		au.Description = u.Description
+		au.Location = u.Location.String()
+	}
+	return au
+}
+
+// APIDiscussionGet converts df into the form visible to cur, or
+// returns nil if cur can't see it.
+func APIDiscussionGet(ev *Event, df *event.DiscussionFull, cur *event.User) *APIDiscussion {
+	title, description, ok := DiscussionVisibleText(df, cur)
+	if !ok {
+		return nil
+	}
+
+	ad := &APIDiscussion{
+		DiscussionID:   df.DiscussionID,
+		Title:          title,
+		Description:    description,
+		IsPublic:       df.IsPublic,
+		Owner:          APIUserRef{df.OwnerInfo.UserID, df.OwnerInfo.Username},
+		Length:         df.Length,
+		ApprovedLength: df.ApprovedLength,
+		IsFinal:        df.IsFinal,
+	}
+
+	for i := range df.FacilitatorInfo {
+		f := &df.FacilitatorInfo[i]
+		ad.Facilitators = append(ad.Facilitators, APIUserRef{f.UserID, f.Username})
+	}
+
+	if df.Location.LocationID != 0 {
+		location := df.Location
+		ad.Location = &location
+	}
+
+	if !df.Time.IsZero() {
+		t := df.Time
+		ad.Time = &t
+	}
+
+	if cur != nil {
+		ad.MayEdit = cur.MayEditDiscussion(&df.Discussion)
+		if cur.Username != event.AdminUsername {
+			interest, err := ev.UserGetInterest(cur, &df.Discussion)
+			if err != nil {
+				log.Printf("Getting interest for user %s: %v", cur.Username, err)
+			} else {
+				ad.Interest = &interest
+			}
+		}
+		if cur.IsAdmin {
+			ad.PossibleSlots = df.PossibleSlots
+			ad.Pin = df.Pin
+		}
+	}
+
+	return ad
+}
+
+func HandleAPIDiscussionList(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ev := RequestEvent(r)
+	cur := RequestUser(r)
+
+	list := []*APIDiscussion{}
+	err := ev.DiscussionIterate(func(df *event.DiscussionFull) error {
+		if ad := APIDiscussionGet(ev, df, cur); ad != nil {
+			list = append(list, ad)
+		}
+		return nil
+	})
+	if err != nil {
+		apiInternalError(w, "Listing discussions", err)
+		return
+	}
+
+	apiWrite(w, http.StatusOK, list)
+}
+
+func HandleAPIDiscussionGet(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ev := RequestEvent(r)
+	cur := RequestUser(r)
+
+	df := apiFindDiscussion(ev, w, ps, cur)
+	if df == nil {
+		return
+	}
+
+	apiWrite(w, http.StatusOK, APIDiscussionGet(ev, df, cur))
+}
+
+func HandleAPIDiscussionCreate(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ev := RequestEvent(r)
+	cur := apiRequireUser(w, r)
+	if cur == nil || !apiRequireVerified(ev, w, cur) {
+		return
+	}
+
+	var req APIDiscussionRequest
+	if !apiReadRequest(w, r, &req) {
+		return
+	}
+
+	if req.Owner != nil || req.PossibleSlots != nil || req.Pin != nil {
+		apiError(w, http.StatusBadRequest, "Owner, PossibleSlots and Pin can only be set when updating")
+		return
+	}
+
+	disc := event.Discussion{Owner: cur.UserID}
+	if req.Title != nil {
+		disc.Title = *req.Title
+	}
+	if req.Description != nil {
+		disc.Description = *req.Description
+	}
+	if req.Length != nil {
+		disc.Length = *req.Length
+	}
+	if req.Facilitators != nil {
+		disc.Facilitators = *req.Facilitators
+	}
+
+	// Co-facilitators are added along with the discussion, so that
+	// it isn't created if any of them don't exist
+	if err := ev.NewDiscussion(&disc); err != nil {
+		switch {
+		case err == event.ErrUserNotFound:
+			apiError(w, http.StatusBadRequest, "Co-facilitator not found")
+		case event.IsValidationError(err):
+			apiError(w, http.StatusBadRequest, err.Error())
+		default:
+			apiInternalError(w, "Creating discussion", err)
+		}
+		return
+	}
+
+	// Admins don't need to ask for a length to be approved
+	if cur.IsAdmin {
+		err := ev.DiscussionSetApprovedLength(cur.UserID, disc.DiscussionID, disc.Length)
+		if err != nil {
+			log.Printf("Error setting approved length: %v", err)
+		}
+	}
+
+	df, err := ev.DiscussionFindByIdFull(disc.DiscussionID)
+	if err != nil || df == nil {
+		apiInternalError(w, "Getting new discussion", err)
+		return
+	}
+
+	w.Header().Set("Location", apiPrefix+"/discussions/"+string(df.DiscussionID))
+	apiWrite(w, http.StatusCreated, APIDiscussionGet(ev, df, cur))
+}
+
+func HandleAPIDiscussionUpdate(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ev := RequestEvent(r)
+	cur := apiRequireUser(w, r)
+	if cur == nil {
+		return
+	}
+
+	df := apiFindDiscussion(ev, w, ps, cur)
+	if df == nil {
+		return
+	}
+
+	if !cur.MayEditDiscussion(&df.Discussion) {
+		apiError(w, http.StatusForbidden, "Permission denied")
+		return
+	}
+
+	// Unverified accounts can't create or edit sessions
+	if !apiRequireVerified(ev, w, cur) {
+		return
+	}
+
+	var req APIDiscussionRequest
+	if !apiReadRequest(w, r, &req) {
+		return
+	}
+
+	// Only the owner or an admin may change co-facilitators; only
+	// admins may change the owner, possible slots or pin.
+	if req.Facilitators != nil && !MayEditFacilitators(cur, &df.Discussion) {
+		apiError(w, http.StatusForbidden, "Only the owner may change co-facilitators")
+		return
+	}
+	if (req.Owner != nil || req.PossibleSlots != nil || req.Pin != nil) && !cur.IsAdmin {
+		apiError(w, http.StatusForbidden, "Only administrators may change the owner, possible slots or pin")
+		return
+	}
+
+	discussionNext := df.Discussion
+	if req.Title != nil {
+		discussionNext.Title = *req.Title
+	}
+	if req.Description != nil {
+		discussionNext.Description = *req.Description
+	}
+	if req.Owner != nil {
+		discussionNext.Owner = *req.Owner
+	}
+	if req.Length != nil {
+		discussionNext.Length = *req.Length
+	}
+
+	if err := ev.DiscussionUpdate(cur.UserID, &discussionNext); err != nil {
+		switch {
+		case err == event.ErrUserNotFound:
+			apiError(w, http.StatusBadRequest, "Owner not found")
+		case event.IsValidationError(err):
+			apiError(w, http.StatusBadRequest, err.Error())
+		default:
+			apiInternalError(w, "Updating discussion", err)
+		}
+		return
+	}
+
+	// Admins don't need to ask for a length to be approved
+	if req.Length != nil && cur.IsAdmin {
+		err := ev.DiscussionSetApprovedLength(cur.UserID, discussionNext.DiscussionID, *req.Length)
+		if event.IsValidationError(err) {
+			apiError(w, http.StatusBadRequest, err.Error())
+			return
+		} else if err != nil {
+			apiInternalError(w, "Setting approved length", err)
+			return
+		}
+	}
+
+	if req.PossibleSlots != nil {
+		err := ev.DiscussionSetPossibleSlots(cur.UserID, discussionNext.DiscussionID, *req.PossibleSlots)
+		if err != nil {
+			apiInternalError(w, "Setting possible slots", err)
+			return
+		}
+	}
+
+	if req.Pin != nil {
+		pin := req.Pin
+		if pin.SlotID == "" {
+			pin = nil
+		}
+		err := ev.DiscussionSetPin(cur.UserID, discussionNext.DiscussionID, pin)
+		switch {
+		case err == event.ErrLocationNotFound:
+			apiError(w, http.StatusBadRequest, "Location not found")
+			return
+		case event.IsValidationError(err):
+			apiError(w, http.StatusBadRequest, err.Error())
+			return
+		case err != nil:
+			apiInternalError(w, "Setting pin", err)
+			return
+		}
+	}
+
+	if req.Facilitators != nil {
+		err := ev.DiscussionSetFacilitators(cur.UserID, discussionNext.DiscussionID, *req.Facilitators)
+		if err == event.ErrUserNotFound {
+			apiError(w, http.StatusBadRequest, "Co-facilitator not found")
+			return
+		} else if err != nil {
+			apiInternalError(w, "Setting co-facilitators", err)
+			return
+		}
+	}
+
+	df, err := ev.DiscussionFindByIdFull(discussionNext.DiscussionID)
+	if err != nil || df == nil {
+		apiInternalError(w, "Getting updated discussion", err)
+		return
+	}
+
+	apiWrite(w, http.StatusOK, APIDiscussionGet(ev, df, cur))
+}
+
+func HandleAPIDiscussionDelete(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ev := RequestEvent(r)
+	cur := apiRequireUser(w, r)
+	if cur == nil {
+		return
+	}
+
+	df := apiFindDiscussion(ev, w, ps, cur)
+	if df == nil {
+		return
+	}
+
+	if !cur.MayEditDiscussion(&df.Discussion) {
+		apiError(w, http.StatusForbidden, "Permission denied")
+		return
+	}
+
+	if err := ev.DeleteDiscussion(cur.UserID, df.DiscussionID); err != nil {
+		apiInternalError(w, "Deleting discussion", err)
+		return
+	}
+
+	apiWrite(w, http.StatusNoContent, nil)
+}
+
+func HandleAPIDiscussionSetPublic(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ev := RequestEvent(r)
+	cur := apiRequireUser(w, r)
+	if cur == nil {
+		return
+	}
+
+	df := apiFindDiscussion(ev, w, ps, cur)
+	if df == nil {
+		return
+	}
+
+	// Only moderators can change public
+	if !cur.MayModerate() {
+		apiError(w, http.StatusForbidden, "Permission denied")
+		return
+	}
+
+	var req APIPublic
+	if !apiReadRequest(w, r, &req) {
+		return
+	}
+
+	if err := ev.DiscussionSetPublic(cur.UserID, df.DiscussionID, req.IsPublic); err != nil {
+		apiInternalError(w, "Setting discussion public", err)
+		return
+	}
+
+	df, err := ev.DiscussionFindByIdFull(df.DiscussionID)
+	if err != nil || df == nil {
+		apiInternalError(w, "Getting updated discussion", err)
+		return
+	}
+
+	apiWrite(w, http.StatusOK, APIDiscussionGet(ev, df, cur))
+}
+
+func HandleAPIInterestGet(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ev := RequestEvent(r)
+	cur := apiRequireUser(w, r)
+	if cur == nil {
+		return
+	}
+
+	df := apiFindDiscussion(ev, w, ps, cur)
+	if df == nil {
+		return
+	}
+
+	interest, err := ev.UserGetInterest(cur, &df.Discussion)
+	if err != nil {
+		apiInternalError(w, "Getting interest", err)
+		return
+	}
+
+	apiWrite(w, http.StatusOK, APIInterest{Interest: interest})
+}
+
+func HandleAPIInterestSet(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ev := RequestEvent(r)
+	cur := apiRequireUser(w, r)
+	if cur == nil {
+		return
+	}
+
+	// Administrators can't express interest in discussions
+	if cur.Username == event.AdminUsername {
+		apiError(w, http.StatusForbidden, event.AdminUsername+" user can't express interest")
+		return
+	}
+
+	df := apiFindDiscussion(ev, w, ps, cur)
+	if df == nil {
+		return
+	}
+
+	var req APIInterest
+	if !apiReadRequest(w, r, &req) {
+		return
+	}
+
+	if req.Interest < 0 || req.Interest > event.InterestMax {
+		apiError(w, http.StatusBadRequest, "Interest value out of range")
+		return
+	}
+
+	if err := ev.UserSetInterest(cur, &df.Discussion, req.Interest); err != nil {
+		apiInternalError(w, "Setting interest", err)
+		return
+	}
+
+	apiWrite(w, http.StatusOK, req)
+}
+
+func HandleAPIUserList(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ev := RequestEvent(r)
+	cur := RequestUser(r)
+
+	list := []*APIUser{}
+	err := ev.UserIterate(func(u *event.User) error {
+		if u.Username != event.AdminUsername {
+			list = append(list, APIUserGet(u, cur))
+		}
+		return nil
+	})
+	if err != nil {
+		apiInternalError(w, "Listing users", err)
+		return
+	}
+
+	apiWrite(w, http.StatusOK, list)
+}
+
+func HandleAPIUserGet(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ev := RequestEvent(r)
+	cur := RequestUser(r)
+
+	uid := event.UserID(ps.ByName("uid"))
+	if uid == "self" {
+		if cur == nil {
+			apiError(w, http.StatusUnauthorized, "Login required")
+			return
+		}
+		uid = cur.UserID
+	}
+
+	user, err := ev.UserFind(uid)
+	if err != nil {
+		apiInternalError(w, "Finding user", err)
+		return
+	}
+	if user == nil {
+		apiError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	apiWrite(w, http.StatusOK, APIUserGet(user, cur))
+}
+
+func HandleAPITimetable(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ev := RequestEvent(r)
+	if !kvs.GetBoolDef(ev.Key(FlagScheduleActive)) {
+		apiError(w, http.StatusNotFound, "Schedule not available yet")
+		return
+	}
+
+	_, curLocationTZ := scheduleLocation(r, RequestUser(r))
+
+	tt, err := ev.GetTimetable("3:04pm Jan 2", &curLocationTZ)
+	if err != nil {
+		apiInternalError(w, "Getting timetable", err)
+		return
+	}
+
+	apiWrite(w, http.StatusOK, tt)
+}
+
+func HandleAPILocations(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ev := RequestEvent(r)
+	locations, err := ev.LocationGetAll()
+	if err != nil {
+		apiInternalError(w, "Getting locations", err)
+		return
+	}
+	if locations == nil {
+		locations = []event.Location{}
+	}
+
+	apiWrite(w, http.StatusOK, locations)
+}
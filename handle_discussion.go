@@ -4,6 +4,7 @@ import (
 	"github.com/julienschmidt/httprouter"
 	"log"
 	"net/http"
+	"net/url"
 	"reflect"
 	"strconv"
 
@@ -11,7 +12,8 @@ import (
 )
 
 func HandleDiscussionNew(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	if kvs.GetBoolDef(FlagRequireVerification) {
+	ev := RequestEvent(r)
+	if kvs.GetBoolDef(ev.Key(FlagRequireVerification)) {
 		cur := RequestUser(r)
 		if !IsVerified(cur) {
 			http.Redirect(w, r, "/uid/user/self/view", http.StatusFound)
@@ -19,7 +21,11 @@ func HandleDiscussionNew(w http.ResponseWriter, r *http.Request, _ httprouter.Pa
 		}
 	}
 
-	RenderTemplate(w, r, "discussion/new", nil)
+	RenderTemplate(w, r, "discussion/new", map[string]interface{}{
+		"Discussion": &DiscussionDisplay{
+			DiscussionFull: event.DiscussionFull{Discussion: event.Discussion{Length: 1}},
+		},
+	})
 }
 
 func HandleDiscussionNotFound(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
@@ -27,9 +33,10 @@ func HandleDiscussionNotFound(w http.ResponseWriter, r *http.Request, _ httprout
 }
 
 func HandleDiscussionCreate(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ev := RequestEvent(r)
 	owner := RequestUser(r)
 
-	if kvs.GetBoolDef(FlagRequireVerification) && !IsVerified(owner) {
+	if kvs.GetBoolDef(ev.Key(FlagRequireVerification)) && !IsVerified(owner) {
 		http.Redirect(w, r, "/uid/user/self/view", http.StatusFound)
 		return
 	}
@@ -38,19 +45,28 @@ func HandleDiscussionCreate(w http.ResponseWriter, r *http.Request, _ httprouter
 		Owner:       owner.UserID,
 		Title:       r.FormValue("title"),
 		Description: r.FormValue("description")}}
-	err := event.NewDiscussion(&d.Discussion)
+	FormDiscussionLength(r, &d.Length)
+	err := ev.NewDiscussion(&d.Discussion)
 
 	if err != nil {
 		if event.IsValidationError(err) {
 			RenderTemplate(w, r, "discussion/new", map[string]interface{}{
 				"Error":      err.Error(),
-				"Discussion": DiscussionGetDisplayRetry(&d, owner),
+				"Discussion": DiscussionGetDisplayRetry(ev, &d, owner),
 			})
 			return
 		}
 		panic(err)
 	}
 
+	// Admins don't need to ask for a length to be approved
+	if owner.IsAdmin {
+		err = ev.DiscussionSetApprovedLength(owner.UserID, d.DiscussionID, d.Length)
+		if err != nil {
+			log.Printf("Error setting approved length: %v", err)
+		}
+	}
+
 	http.Redirect(w, r, d.GetURL()+"?flash=Session+Created", http.StatusFound)
 }
 
@@ -78,6 +94,7 @@ func IsVerified(u *event.User) bool {
 }
 
 func HandleUid(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ev := RequestEvent(r)
 	cur := RequestUser(r)
 
 	uid := ps.ByName("uid")
@@ -107,14 +124,14 @@ func HandleUid(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 
 	switch itype {
 	case "discussion":
-		df, _ := event.DiscussionFindByIdFull(event.DiscussionID(uid))
+		df, _ := ev.DiscussionFindByIdFull(event.DiscussionID(uid))
 
 		if df == nil {
 			break
 		}
 
 		// Unverified accounts can't create or edit sessions
-		if action == "edit" && kvs.GetBoolDef(FlagRequireVerification) && !IsVerified(cur) {
+		if action == "edit" && kvs.GetBoolDef(ev.Key(FlagRequireVerification)) && !IsVerified(cur) {
 			http.Redirect(w, r, "/uid/user/self/view", http.StatusFound)
 			return
 		}
@@ -126,9 +143,15 @@ func HandleUid(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 			break
 		}
 
-		data["Display"] = DiscussionGetDisplay(df, cur)
+		dd := DiscussionGetDisplay(ev, df, cur)
+		// Only the edit form needs the list of everyone who could
+		// co-facilitate, so it isn't fetched for lists of discussions
+		if action == "edit" && dd != nil && MayEditFacilitators(cur, &df.Discussion) {
+			dd.FacilitatorChoices = FacilitatorChoicesGet(ev, &df.Discussion)
+		}
+		data["Display"] = dd
 	case "user":
-		user, _ := event.UserFind(event.UserID(uid))
+		user, _ := ev.UserFind(event.UserID(uid))
 
 		if user == nil {
 			break
@@ -140,14 +163,18 @@ func HandleUid(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 				break
 			}
 			data["Locations"] = TimezoneList
+			data["Roles"] = event.Roles
 		}
 
 		// Only display a delete confirmation page for admins
-		if action == "delete" && !IsAdmin(cur) {
-			break
+		if action == "delete" {
+			if !IsAdmin(cur) {
+				break
+			}
+			data["ReassignUsers"] = userGetReassignChoices(ev, user)
 		}
 
-		data["Display"] = UserGetDisplay(user, cur, true)
+		data["Display"] = UserGetDisplay(ev, user, cur, true)
 	default:
 		return
 	}
@@ -176,7 +203,34 @@ func FormCheckToSlotID(formData []string) (slots []event.SlotID, err error) {
 	return slots, nil
 }
 
+// FormDiscussionLength sets *length from the "length" form value, if
+// there is one.  Anything which isn't a number is left for
+// validation to reject.
+func FormDiscussionLength(r *http.Request, length *int) {
+	s := r.FormValue("length")
+	if s == "" {
+		return
+	}
+	l, err := strconv.Atoi(s)
+	if err != nil {
+		l = -1
+	}
+	*length = l
+}
+
+// FormPin returns the pin chosen with the "pinslot" and "pinlocation"
+// form values, or nil if the discussion isn't to be pinned.
+func FormPin(r *http.Request) *event.Pin {
+	slotid := r.FormValue("pinslot")
+	if slotid == "" {
+		return nil
+	}
+	lid, _ := strconv.Atoi(r.FormValue("pinlocation"))
+	return &event.Pin{SlotID: event.SlotID(slotid), LocationID: event.LocationID(lid)}
+}
+
 func HandleUidPost(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ev := RequestEvent(r)
 	cur := RequestUser(r)
 
 	if cur == nil {
@@ -199,7 +253,9 @@ func HandleUidPost(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 
 	if !((itype == "discussion" &&
 		(action == "setinterest" || action == "edit" || action == "delete" || action == "setpublic")) ||
-		(itype == "user" && (action == "edit" || action == "setverified" || action == "verify" || action == "delete"))) {
+		(itype == "user" && (action == "edit" || action == "setverified" || action == "verify" || action == "delete" ||
+			action == "resetcalendar" || action == "newtoken" || action == "revoketoken" ||
+			action == "sendverification"))) {
 		log.Printf(" Disallowed action")
 		return
 	}
@@ -210,7 +266,7 @@ func HandleUidPost(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 
 	switch itype {
 	case "discussion":
-		df, _ := event.DiscussionFindByIdFull(event.DiscussionID(uid))
+		df, _ := ev.DiscussionFindByIdFull(event.DiscussionID(uid))
 		if df == nil {
 			log.Printf("Invalid discussion: %s", uid)
 			return
@@ -234,7 +290,7 @@ func HandleUidPost(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 				log.Printf("Negative interest (%d)", interest)
 				return
 			}
-			cur.SetInterest(&df.Discussion, interest)
+			ev.UserSetInterest(cur, &df.Discussion, interest)
 
 			if tmp := r.FormValue("redirectURL"); tmp != "" {
 				redirectURL = tmp
@@ -248,17 +304,30 @@ func HandleUidPost(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 			}
 
 			// Unverified accounts can't create or edit sessions
-			if kvs.GetBoolDef(FlagRequireVerification) &&
+			if kvs.GetBoolDef(ev.Key(FlagRequireVerification)) &&
 				!IsVerified(cur) {
 				http.Redirect(w, r, "/uid/user/self/view", http.StatusFound)
 				return
 			}
 
+			// Only the owner or an admin may change co-facilitators
+			mayEditFacilitators := MayEditFacilitators(cur, &df.Discussion)
+
 			discussionNext := df
 			discussionNext.Title = r.FormValue("title")
 			discussionNext.Description = r.FormValue("description")
+			FormDiscussionLength(r, &discussionNext.Length)
+
+			if mayEditFacilitators {
+				discussionNext.Facilitators = nil
+				for _, uid := range r.Form["facilitators"] {
+					discussionNext.Facilitators = append(discussionNext.Facilitators,
+						event.UserID(uid))
+				}
+			}
 
 			var possibleSlots []event.SlotID
+			var pin *event.Pin
 
 			if cur.IsAdmin {
 				var err error
@@ -268,9 +337,10 @@ func HandleUidPost(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 					possibleSlots = nil
 				}
 				discussionNext.Owner = event.UserID(r.FormValue("owner"))
+				pin = FormPin(r)
 			}
 
-			err := event.DiscussionUpdate(&discussionNext.Discussion)
+			err := ev.DiscussionUpdate(cur.UserID, &discussionNext.Discussion)
 			if err != nil {
 				errDisplay := err.Error()
 				if !event.IsValidationError(err) {
@@ -280,18 +350,46 @@ func HandleUidPost(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 				if event.IsValidationError(err) {
 					RenderTemplate(w, r, "discussion/edit", map[string]interface{}{
 						"Error":   errDisplay,
-						"Display": DiscussionGetDisplayRetry(discussionNext, cur),
+						"Display": DiscussionGetDisplayRetry(ev, discussionNext, cur),
 					})
 					return
 				}
 			}
 
 			if possibleSlots != nil {
-				err = event.DiscussionSetPossibleSlots(discussionNext.DiscussionID, possibleSlots)
+				err = ev.DiscussionSetPossibleSlots(cur.UserID, discussionNext.DiscussionID, possibleSlots)
 				if err != nil {
 					log.Printf("Error setting possible slots: %v", err)
 				}
 			}
+
+			// Admins don't need to ask for a length to be approved
+			if cur.IsAdmin {
+				err = ev.DiscussionSetApprovedLength(cur.UserID, discussionNext.DiscussionID,
+					discussionNext.Length)
+				if err != nil {
+					log.Printf("Error setting approved length: %v", err)
+				}
+			}
+
+			// Only change the pin if it's different, so that
+			// discussions in locked slots can still be edited
+			if cur.IsAdmin && !reflect.DeepEqual(pin, df.Pin) {
+				err = ev.DiscussionSetPin(cur.UserID, discussionNext.DiscussionID, pin)
+				if event.IsValidationError(err) {
+					redirectURL = "view?flash=" + url.QueryEscape(err.Error())
+				} else if err != nil {
+					log.Printf("Error setting pin: %v", err)
+				}
+			}
+
+			if mayEditFacilitators {
+				err = ev.DiscussionSetFacilitators(cur.UserID, discussionNext.DiscussionID,
+					discussionNext.Facilitators)
+				if err != nil {
+					log.Printf("Error setting co-facilitators: %v", err)
+				}
+			}
 		case "delete":
 			if !cur.MayEditDiscussion(&df.Discussion) {
 				log.Printf("WARNING user %s doesn't have permission to edit discussion %s",
@@ -299,19 +397,19 @@ func HandleUidPost(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 				return
 			}
 
-			event.DeleteDiscussion(df.DiscussionID)
+			ev.DeleteDiscussion(cur.UserID, df.DiscussionID)
 
 			// Can't redirect to 'view' as it's been deleted
 			http.Redirect(w, r, "/list/discussion", http.StatusFound)
 			return
 		case "setpublic":
-			// Only administrators can change public
-			if !cur.IsAdmin {
-				log.Printf("%s isn't an admin")
+			// Only moderators can change public
+			if !cur.MayModerate() {
+				log.Printf("%s isn't a moderator", cur.Username)
 				return
 			}
 
-			if err := event.DiscussionSetPublic(df.DiscussionID, r.FormValue("newvalue") == "true"); err != nil {
+			if err := ev.DiscussionSetPublic(cur.UserID, df.DiscussionID, r.FormValue("newvalue") == "true"); err != nil {
 				// FIXME
 				log.Printf("DiscussionSetPublic: %v", err)
 			}
@@ -324,14 +422,20 @@ func HandleUidPost(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 			return
 		}
 	case "user":
-		user, _ := event.UserFind(event.UserID(uid))
+		user, _ := ev.UserFind(event.UserID(uid))
 		if user == nil {
 			log.Printf("Invalid user: %s", uid)
 			return
 		}
 
-		// Only allowed to edit our own profile unless you're an admin
-		if !cur.MayEditUser(user) {
+		// Only allowed to edit our own profile unless you're an
+		// admin; but moderators can verify other users
+		if action == "setverified" {
+			if !cur.MayModerate() {
+				log.Printf("%s isn't a moderator", cur.Username)
+				return
+			}
+		} else if !cur.MayEditUser(user) {
 			log.Printf(" uid %s tried to edit uid %s", string(cur.UserID), uid)
 			return
 		}
@@ -348,27 +452,59 @@ func HandleUidPost(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 
 			log.Printf(" new user info %v", userNext)
 
-			err := event.UserUpdate(&userNext, cur, currentPassword, newPassword)
+			// Only administrators can change roles.  Check the new
+			// role first, so that the profile isn't changed if it
+			// can't be.
+			role := event.Role(r.FormValue("Role"))
+			if role == user.Role || !cur.IsAdmin {
+				role = ""
+			}
+			var err error
+			if role != "" {
+				err = user.CheckRole(role)
+			}
+
+			if err == nil {
+				err = ev.UserUpdate(&userNext, cur, currentPassword, newPassword)
+			}
+
+			// Only users changing their own password (having
+			// given the current one) change it in other events
+			// too; an admin resetting it only affects this one.
+			if err == nil && cur.UserID == user.UserID &&
+				userNext.HashedPassword != user.HashedPassword {
+				syncPassword(ev, &userNext, user.HashedPassword)
+			}
+
+			if err == nil && role != "" {
+				err = ev.UserSetRole(cur.UserID, user.UserID, role)
+			}
 
 			if err != nil {
 				if event.IsValidationError(err) {
 					RenderTemplate(w, r, "user/edit", map[string]interface{}{
-						"Error": err.Error(),
-						"User":  userNext,
+						"Error":     err.Error(),
+						"Display":   UserGetDisplay(ev, &userNext, cur, true),
+						"Locations": TimezoneList,
+						"Roles":     event.Roles,
 					})
 					return
 				}
 				panic(err)
 			}
-		case "setverified":
-			// Only administrators can change verification status
-			if !cur.IsAdmin {
-				log.Printf("%s isn't an admin")
-				return
-			}
 
+			// Ask the user to prove they own their new address
+			if userNext.Email != user.Email && userNext.Email != "" && mail != nil {
+				if err := sendEmailVerification(ev, &userNext); err != nil {
+					log.Printf("ERROR: Sending verification email to user %s: %v",
+						userNext.Username, err)
+				} else {
+					redirectURL = "view?flash=Verification+email+sent"
+				}
+			}
+		case "setverified":
 			newValueString := r.FormValue("newvalue")
-			user.SetVerified(newValueString == "true")
+			ev.UserSetVerified(cur.UserID, user, newValueString == "true")
 
 			if tmp := r.FormValue("redirectURL"); tmp != "" {
 				redirectURL = tmp
@@ -376,12 +512,68 @@ func HandleUidPost(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 		case "verify":
 			vcode := r.FormValue("Vcode")
 
-			evcode, _ := kvs.Get(VerificationCode)
-			if vcode != evcode {
-				redirectURL = "view?flash=Invalid+Validation+Code"
-			} else {
-				user.SetVerified(true)
+			shared, err := checkVerificationCode(ev, vcode)
+			if shared {
+				err = ev.UserSetVerified(cur.UserID, user, true)
+			} else if err == nil {
+				err = ev.InviteRedeem(user.UserID, vcode)
+			}
+			switch {
+			case err == nil:
 				redirectURL = "view?flash=Account+Verified"
+			case event.IsValidationError(err):
+				redirectURL = "view?flash=" + url.QueryEscape(err.Error())
+			default:
+				log.Printf("Verifying user %s: %v", user.Username, err)
+				redirectURL = "view?flash=Internal+Error"
+			}
+		case "newtoken", "revoketoken":
+			// Tokens can only be managed by their owners
+			if user.UserID != cur.UserID {
+				log.Printf("WARNING user %s tried to manage API tokens of %s",
+					cur.Username, user.Username)
+				return
+			}
+
+			if action == "revoketoken" {
+				err := ev.DeleteAPIToken(user.UserID, event.APITokenID(r.FormValue("tokenid")))
+				if err != nil {
+					log.Printf("Revoking API token for user %s: %v", user.Username, err)
+					redirectURL = "view?flash=Error+revoking+API+token"
+				} else {
+					redirectURL = "view?flash=API+token+revoked"
+				}
+				break
+			}
+
+			token, _, err := ev.NewAPIToken(user.UserID, r.FormValue("name"),
+				r.FormValue("readonly") == "true")
+			if err != nil {
+				log.Printf("Creating API token for user %s: %v", user.Username, err)
+				redirectURL = "view?flash=" + url.QueryEscape(err.Error())
+				break
+			}
+
+			// The token can only be shown this once
+			RenderTemplate(w, r, "user/view", map[string]interface{}{
+				"Display":  UserGetDisplay(ev, user, cur, true),
+				"NewToken": token,
+			})
+			return
+		case "sendverification":
+			if err := sendEmailVerification(ev, user); err != nil {
+				log.Printf("ERROR: Sending verification email to user %s: %v",
+					user.Username, err)
+				redirectURL = "view?flash=Error+sending+verification+email"
+			} else {
+				redirectURL = "view?flash=Verification+email+sent"
+			}
+		case "resetcalendar":
+			if _, err := ev.UserResetCalendarToken(user.UserID); err != nil {
+				log.Printf("Resetting calendar token for user %s: %v", user.Username, err)
+				redirectURL = "view?flash=Error+resetting+calendar+feed"
+			} else {
+				redirectURL = "view?flash=Calendar+feed+reset"
 			}
 		case "delete":
 			if !cur.IsAdmin {
@@ -389,7 +581,31 @@ func HandleUidPost(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 				return
 			}
 
-			event.DeleteUser(user.UserID)
+			// Their discussions are either deleted along with them,
+			// or given to another user
+			var reassignTo event.UserID
+			switch r.FormValue("discussions") {
+			case "admin":
+				admin, err := ev.UserFindByUsername(event.AdminUsername)
+				if err != nil || admin == nil {
+					log.Printf("Finding admin user: %v", err)
+					http.Redirect(w, r, "delete?flash=Error+finding+admin+user", http.StatusFound)
+					return
+				}
+				reassignTo = admin.UserID
+			case "user":
+				reassignTo = event.UserID(r.FormValue("reassignto"))
+			}
+
+			err := ev.DeleteUser(cur.UserID, user.UserID, reassignTo)
+			if event.IsValidationError(err) {
+				redirectURL = "delete?flash=" + url.QueryEscape(err.Error())
+				break
+			} else if err != nil {
+				log.Printf("Deleting user %s: %v", user.Username, err)
+				redirectURL = "delete?flash=Error+deleting+user"
+				break
+			}
 
 			// Can't redirect to 'view' as it's been deleted
 			http.Redirect(w, r, "/list/user", http.StatusFound)
@@ -405,6 +621,7 @@ func HandleUidPost(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 }
 
 func HandleList(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ev := RequestEvent(r)
 	cur := RequestUser(r)
 
 	itype := ps.ByName("itype")
@@ -413,10 +630,10 @@ func HandleList(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 
 	switch itype {
 	case "discussion":
-		templateArgs["List"] = DiscussionGetList(cur)
+		templateArgs["List"] = DiscussionGetList(ev, cur)
 		templateArgs["redirectURL"] = ""
 	case "user":
-		templateArgs["List"] = UserGetUsersDisplay(cur)
+		templateArgs["List"] = UserGetUsersDisplay(ev, cur)
 	default:
 		return
 	}
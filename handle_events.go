@@ -0,0 +1,89 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/gwd/session-scheduler/event"
+)
+
+// The events page is only available in the default event, since it
+// affects the whole server.
+
+func adminEventsContent(content map[string]interface{}) {
+	evs := Events()
+	content["Events"] = evs[1:]
+	content["CloneSources"] = evs
+	if content["New"] == nil {
+		content["New"] = EventInfo{}
+	}
+}
+
+func handleAdminNewEvent(w http.ResponseWriter, r *http.Request, user *event.User) {
+	info := EventInfo{
+		Name:     r.FormValue("name"),
+		Title:    r.FormValue("title"),
+		Hostname: r.FormValue("hostname")}
+
+	var clone *Event
+	if source := r.FormValue("clone"); source != "-" {
+		if clone = EventFind(source); clone == nil {
+			http.Redirect(w, r, "events?flash=Event+not+found", http.StatusFound)
+			return
+		}
+	}
+
+	ev, err := EventCreate(user.UserID, info, clone)
+	if err != nil {
+		content := map[string]interface{}{"User": user, "events": true, "New": info}
+		switch err {
+		case errEventName, errEventExists, errEventHostname, errEventNoHost:
+			content["Error"] = err.Error()
+		default:
+			log.Printf("Error creating event %s: %v", info.Name, err)
+			content["Error"] = "Internal error: See log"
+		}
+		adminEventsContent(content)
+		RenderTemplate(w, r, "admin/events", content)
+		return
+	}
+	http.Redirect(w, r, "events?flash="+url.QueryEscape("Created event "+ev.Name), http.StatusFound)
+}
+
+func handleAdminArchiveEvent(w http.ResponseWriter, r *http.Request, archived bool) {
+	ev := EventFind(r.FormValue("name"))
+	if ev == nil || ev.IsDefault() {
+		http.Redirect(w, r, "events?flash=Event+not+found", http.StatusFound)
+		return
+	}
+
+	flash := "Event+archived"
+	if !archived {
+		flash = "Event+unarchived"
+	}
+	if err := EventSetArchived(ev, archived); err != nil {
+		log.Printf("Error archiving event %s: %v", ev.Name, err)
+		flash = "Internal+error:+See+log"
+	}
+	http.Redirect(w, r, "events?flash="+flash, http.StatusFound)
+}
+
+func handleAdminEventHostname(w http.ResponseWriter, r *http.Request) {
+	ev := EventFind(r.FormValue("name"))
+	if ev == nil || ev.IsDefault() {
+		http.Redirect(w, r, "events?flash=Event+not+found", http.StatusFound)
+		return
+	}
+
+	flash := "Hostname+changed"
+	switch err := EventSetHostname(ev, r.FormValue("hostname")); err {
+	case nil:
+	case errEventHostname, errEventNoHost:
+		flash = url.QueryEscape(err.Error())
+	default:
+		log.Printf("Error changing hostname of event %s: %v", ev.Name, err)
+		flash = "Internal+error:+See+log"
+	}
+	http.Redirect(w, r, "events?flash="+flash, http.StatusFound)
+}
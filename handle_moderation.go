@@ -0,0 +1,104 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/gwd/session-scheduler/event"
+)
+
+// ModerationItem is a discussion awaiting moderation, with the
+// changes since it was last approved (if it ever was).
+type ModerationItem struct {
+	event.PendingDiscussion
+
+	TitleDiff       template.HTML
+	DescriptionDiff template.HTML
+	DescriptionHTML template.HTML
+}
+
+func HandleModeration(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ev := RequestEvent(r)
+	cur := RequestUser(r)
+	if cur == nil || !cur.MayModerate() {
+		http.Error(w, "Permission denied", http.StatusForbidden)
+		return
+	}
+
+	pending, err := ev.DiscussionGetPending()
+	if err != nil {
+		log.Printf("Getting pending discussions: %v", err)
+	}
+
+	items := make([]ModerationItem, len(pending))
+	for i := range pending {
+		pd := &pending[i]
+		items[i].PendingDiscussion = *pd
+		if pd.IsNew() {
+			items[i].DescriptionHTML = ProcessText(pd.Description)
+		} else {
+			items[i].TitleDiff = DiffWords(pd.ApprovedTitle, pd.Title)
+			items[i].DescriptionDiff = DiffWords(pd.ApprovedDescription, pd.Description)
+		}
+	}
+
+	// Only admins may approve longer discussions, as they take up
+	// room in the schedule
+	var lengthRequests []event.PendingDiscussion
+	if cur.IsAdmin {
+		lengthRequests, err = ev.DiscussionGetLengthRequests()
+		if err != nil {
+			log.Printf("Getting length requests: %v", err)
+		}
+	}
+
+	RenderTemplate(w, r, "discussion/moderation", map[string]interface{}{
+		"Items":          items,
+		"LengthRequests": lengthRequests,
+	})
+}
+
+func HandleModerationAction(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ev := RequestEvent(r)
+	cur := RequestUser(r)
+	if cur == nil || !cur.MayModerate() {
+		http.Error(w, "Permission denied", http.StatusForbidden)
+		return
+	}
+
+	did := event.DiscussionID(r.FormValue("discussionid"))
+
+	var err error
+	var flash string
+	switch ps.ByName("action") {
+	case "approve":
+		err = ev.DiscussionSetPublic(cur.UserID, did, true)
+		flash = "Session approved"
+	case "reject":
+		err = ev.DiscussionReject(cur.UserID, did, r.FormValue("reason"))
+		flash = "Session rejected"
+	case "approvelength":
+		if !cur.IsAdmin {
+			http.Error(w, "Permission denied", http.StatusForbidden)
+			return
+		}
+		length, _ := strconv.Atoi(r.FormValue("length"))
+		err = ev.DiscussionSetApprovedLength(cur.UserID, did, length)
+		flash = "Session length approved"
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if err != nil {
+		log.Printf("Moderating discussion %s: %v", did, err)
+		flash = err.Error()
+	}
+
+	http.Redirect(w, r, "/moderation?flash="+url.QueryEscape(flash), http.StatusFound)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"log"
 	"net/http"
 	//"time"
 
@@ -13,14 +14,11 @@ const (
 	locationCookieName = "XenSummitTZLocation"
 )
 
-func HandleScheduleView(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	if !kvs.GetBoolDef(FlagScheduleActive) {
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
-	}
-
-	cur := RequestUser(r)
-
+// scheduleLocation returns the timezone location in which to display
+// the schedule: the one given in the URL if any, otherwise the
+// current user's preference, otherwise the default.
+func scheduleLocation(r *http.Request, cur *event.User) (string, event.TZLocation) {
+	ev := RequestEvent(r)
 	curLocationString := DefaultLocation
 	curLocationTZ := DefaultLocationTZ
 
@@ -33,18 +31,135 @@ func HandleScheduleView(w http.ResponseWriter, r *http.Request, _ httprouter.Par
 		}
 	} else if cur != nil {
 		// Otherwise, if we're logged in, use the user's preference
-		tz, err := cur.GetLocationTZ()
+		tz, err := ev.UserGetLocationTZ(cur)
 		if err == nil {
 			curLocationString = tz.String()
 			curLocationTZ = tz
 		}
 	}
 
+	return curLocationString, curLocationTZ
+}
+
+func HandleScheduleView(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ev := RequestEvent(r)
+	if !kvs.GetBoolDef(ev.Key(FlagScheduleActive)) {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	cur := RequestUser(r)
+
+	curLocationString, curLocationTZ := scheduleLocation(r, cur)
+
 	// FIXME: Handle the error
-	tt, _ := event.GetTimetable("3:04pm Jan 2", &curLocationTZ)
+	tt, _ := ev.GetTimetable("3:04pm Jan 2", &curLocationTZ)
 	RenderTemplate(w, r, "schedule/view", map[string]interface{}{
 		"Timetable":       tt,
 		"CurrentLocation": curLocationString,
 		"Locations":       TimezoneList,
 	})
 }
+
+func HandleScheduleMine(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ev := RequestEvent(r)
+	if !kvs.GetBoolDef(ev.Key(FlagScheduleActive)) {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	cur := RequestUser(r)
+
+	curLocationString, curLocationTZ := scheduleLocation(r, cur)
+
+	agenda, err := ev.GetAgenda(cur.UserID, "3:04pm Jan 2", &curLocationTZ)
+	if err != nil {
+		log.Printf("Getting agenda for user %s: %v", cur.Username, err)
+	}
+	RenderTemplate(w, r, "schedule/mine", map[string]interface{}{
+		"Agenda":          agenda,
+		"CurrentLocation": curLocationString,
+		"Locations":       TimezoneList,
+	})
+}
+
+func writeICal(w http.ResponseWriter, ical []byte) {
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Write(ical)
+}
+
+// HandleScheduleICal serves the whole schedule as an iCalendar feed.
+func HandleScheduleICal(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ev := RequestEvent(r)
+	if !kvs.GetBoolDef(ev.Key(FlagScheduleActive)) {
+		http.NotFound(w, r)
+		return
+	}
+
+	_, curLocationTZ := scheduleLocation(r, RequestUser(r))
+
+	entries, err := ev.GetCalendar()
+	if err != nil {
+		log.Printf("Getting calendar: %v", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeICal(w, ICalSchedule(entries, &curLocationTZ, "Schedule", r.Host))
+}
+
+// HandleAgendaICal serves a user's personal agenda as an iCalendar
+// feed.  Calendar applications can't log in, so the user is
+// identified by the secret token in the URL instead.
+func HandleAgendaICal(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ev := RequestEvent(r)
+	if !kvs.GetBoolDef(ev.Key(FlagScheduleActive)) {
+		http.NotFound(w, r)
+		return
+	}
+
+	user, err := ev.UserFindByCalendarToken(ps.ByName("token"))
+	if err != nil {
+		log.Printf("Looking up calendar token: %v", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	_, curLocationTZ := scheduleLocation(r, user)
+
+	agenda, err := ev.GetAgenda(user.UserID, "", nil)
+	if err != nil {
+		log.Printf("Getting agenda for user %s: %v", user.Username, err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	attending := map[event.DiscussionID]bool{}
+	for _, day := range agenda.Days {
+		for _, slot := range day.Slots {
+			if slot.Attending != nil {
+				attending[slot.Attending.DiscussionID] = true
+			}
+		}
+	}
+
+	all, err := ev.GetCalendar()
+	if err != nil {
+		log.Printf("Getting calendar: %v", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	var entries []event.CalendarEntry
+	for _, e := range all {
+		if attending[e.DiscussionID] {
+			entries = append(entries, e)
+		}
+	}
+
+	writeICal(w, ICalSchedule(entries, &curLocationTZ, "Schedule for "+user.Username, r.Host))
+}
@@ -25,12 +25,22 @@ func HandleSessionNew(w http.ResponseWriter, r *http.Request, _ httprouter.Param
 	})
 }
 
-func FindUser(username, password string) (*event.User, error) {
-	existingUser, err := event.UserFindByUsername(username)
+// FindUser returns the user in ev with the given username and
+// password.  Accounts are shared between events: someone who has an
+// account in another event, but not yet in ev, gets a copy of it (see
+// event.UserCopy) the first time they log in.
+func FindUser(ev *Event, username, password string) (*event.User, error) {
+	existingUser, err := ev.UserFindByUsername(username)
 	if err != nil {
-		log.Printf("INTERNAL ERROR: UserFindByUsername: %v")
+		log.Printf("INTERNAL ERROR: UserFindByUsername: %v", err)
 		return nil, event.ErrInternal
 	}
+	if existingUser == nil && username != event.AdminUsername {
+		existingUser, err = copyUserFromOtherEvent(ev, username, password)
+		if err != nil {
+			return nil, err
+		}
+	}
 	if existingUser == nil {
 		// Same error for no user / wrong password to avoid username fishing
 		return nil, event.ErrCredentialsIncorrect
@@ -43,12 +53,73 @@ func FindUser(username, password string) (*event.User, error) {
 	return existingUser, nil
 }
 
+// copyUserFromOtherEvent looks for an account with the given username
+// and password in the other events, and copies it into ev.  It
+// returns nil if there isn't one, or if ev is archived (and so can't
+// be changed).
+func copyUserFromOtherEvent(ev *Event, username, password string) (*event.User, error) {
+	if ev.Archived {
+		return nil, nil
+	}
+	for _, other := range Events() {
+		if other.EventStore == ev.EventStore {
+			continue
+		}
+		user, err := other.UserFindByUsername(username)
+		if err != nil {
+			log.Printf("INTERNAL ERROR: UserFindByUsername: %v", err)
+			return nil, event.ErrInternal
+		}
+		if user == nil || !user.CheckPassword(password) {
+			continue
+		}
+		copied, err := ev.UserCopy(user)
+		if event.IsValidationError(err) {
+			// The same user (perhaps under another name) is
+			// already here
+			return nil, nil
+		} else if err != nil {
+			log.Printf("INTERNAL ERROR: Copying user %s: %v", username, err)
+			return nil, event.ErrInternal
+		}
+		log.Printf("Copied user %s into event %s", username, ev.Filename())
+		return copied, nil
+	}
+	return nil, nil
+}
+
+// syncPassword copies user's new password to their accounts in the
+// other events, after they've changed it in ev from oldHashedPassword.
+// It must only be called when users changed it themselves, giving
+// their current password; and only accounts whose password was the
+// same as the old one in ev are changed.  Otherwise an admin of ev
+// could set a password (or an email address to send a reset link to)
+// and so take over the user's accounts in events where they have no
+// authority.
+func syncPassword(ev *Event, user *event.User, oldHashedPassword string) {
+	for _, other := range Events() {
+		if other.EventStore == ev.EventStore || other.Archived {
+			continue
+		}
+		changed, err := other.UserSyncPasswordHash(user.UserID,
+			oldHashedPassword, user.HashedPassword)
+		if err != nil {
+			log.Printf("ERROR: Copying password of user %s to %s: %v",
+				user.Username, other.Filename(), err)
+		} else if changed {
+			log.Printf("Copied password of user %s to %s",
+				user.Username, other.Filename())
+		}
+	}
+}
+
 func HandleSessionCreate(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ev := RequestEvent(r)
 	username := r.FormValue("username")
 	password := r.FormValue("password")
 	next := r.FormValue("next")
 
-	user, err := FindUser(username, password)
+	user, err := FindUser(ev, username, password)
 	if err != nil {
 		if event.IsValidationError(err) {
 			RenderTemplate(w, r, "sessions/new", map[string]interface{}{
@@ -61,7 +132,7 @@ func HandleSessionCreate(w http.ResponseWriter, r *http.Request, _ httprouter.Pa
 		panic(err)
 	}
 
-	if !kvs.GetBoolDef(FlagActive) && !user.IsAdmin {
+	if !kvs.GetBoolDef(ev.Key(FlagActive)) && !user.IsAdmin {
 		http.Redirect(w, r, "/login?flash=Website+Inactive", http.StatusFound)
 		return
 	}
@@ -82,3 +153,84 @@ func HandleSessionCreate(w http.ResponseWriter, r *http.Request, _ httprouter.Pa
 
 	http.Redirect(w, r, next+"?flash=Signed+in", http.StatusFound)
 }
+
+func HandlePasswordForgot(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	RenderTemplate(w, r, "sessions/forgot", map[string]interface{}{
+		"MailEnabled": mail != nil,
+	})
+}
+
+func HandlePasswordForgotPost(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ev := RequestEvent(r)
+	if mail == nil {
+		http.Redirect(w, r, "/password/forgot", http.StatusFound)
+		return
+	}
+
+	email := r.FormValue("email")
+	user, err := ev.UserFindByEmail(email)
+	if err != nil {
+		log.Printf("INTERNAL ERROR: UserFindByEmail: %v", err)
+	} else if user != nil {
+		if err := sendPasswordReset(ev, user); err != nil {
+			log.Printf("ERROR: Sending password reset to user %s: %v", user.Username, err)
+		} else {
+			log.Printf("Sent password reset to user %s", user.Username)
+		}
+	}
+
+	// Same response whether or not the address was found, to avoid
+	// email fishing
+	RenderTemplate(w, r, "sessions/forgot", map[string]interface{}{
+		"MailEnabled": true,
+		"Sent":        true,
+	})
+}
+
+func HandlePasswordReset(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	RenderTemplate(w, r, "sessions/reset", map[string]interface{}{
+		"Token": ps.ByName("token"),
+	})
+}
+
+func HandlePasswordResetPost(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ev := RequestEvent(r)
+	token := ps.ByName("token")
+
+	user, err := ev.UserResetPassword(token, r.FormValue("password"))
+	if err != nil {
+		if event.IsValidationError(err) {
+			RenderTemplate(w, r, "sessions/reset", map[string]interface{}{
+				"Error": err.Error(),
+				"Token": token,
+			})
+			return
+		}
+		panic(err)
+	}
+
+	// A reset link only shows that the user can read mail sent to the
+	// address stored in this event, which this event's admins can
+	// change; so the password isn't copied to other events.
+	log.Printf("User %s reset their password", user.Username)
+
+	http.Redirect(w, r, "/login?flash=Password+changed", http.StatusFound)
+}
+
+func HandleEmailVerify(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ev := RequestEvent(r)
+	_, err := ev.UserVerifyEmail(ps.ByName("token"))
+	flash := "Email+address+verified"
+	if err != nil {
+		if !event.IsValidationError(err) {
+			log.Printf("INTERNAL ERROR: UserVerifyEmail: %v", err)
+		}
+		flash = url.QueryEscape(err.Error())
+	}
+
+	if RequestUser(r) != nil {
+		http.Redirect(w, r, "/uid/user/self/view?flash="+flash, http.StatusFound)
+	} else {
+		http.Redirect(w, r, "/?flash="+flash, http.StatusFound)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/gwd/session-scheduler/event"
+)
+
+func TestPasswordSync(t *testing.T) {
+	defer testEventsInit(t, EventInfo{},
+		EventInfo{Name: "other", Hostname: "other.example"},
+		EventInfo{Name: "third", Hostname: "third.example"})()
+	def, other, third := DefaultEvent(), EventFind("other"), EventFind("third")
+
+	const password = "alicepassword"
+	alice := event.User{Username: "alice", Email: "alice@example.org"}
+	uid, err := def.NewUser(password, &alice)
+	if err != nil {
+		t.Fatalf("NewUser: %v", err)
+	}
+	user, err := def.UserFind(uid)
+	if err != nil || user == nil {
+		t.Fatalf("UserFind: %v (%v)", user, err)
+	}
+	for _, ev := range []*Event{other, third} {
+		if _, err := ev.UserCopy(user); err != nil {
+			t.Fatalf("UserCopy: %v", err)
+		}
+	}
+
+	// checkPassword checks that alice's password in ev is password
+	checkPassword := func(ev *Event, password string) {
+		t.Helper()
+		u, err := ev.UserFind(uid)
+		if err != nil || u == nil {
+			t.Fatalf("UserFind: %v (%v)", u, err)
+		}
+		if !u.CheckPassword(password) {
+			t.Errorf("Expected password of %s in event %q to be %q", u.Username, ev.Name, password)
+		}
+	}
+
+	t.Logf("Changing the email address as admin of the other event, then resetting the password")
+	admin, err := other.UserFindByUsername(event.AdminUsername)
+	if err != nil || admin == nil {
+		t.Fatalf("UserFindByUsername: %v (%v)", admin, err)
+	}
+	hijacked, err := other.UserFind(uid)
+	if err != nil || hijacked == nil {
+		t.Fatalf("UserFind: %v (%v)", hijacked, err)
+	}
+	hijacked.Email = "mallory@example.org"
+	if err := other.UserUpdate(hijacked, admin, "", ""); err != nil {
+		t.Fatalf("UserUpdate: %v", err)
+	}
+	token, email, err := other.NewEmailToken(uid, event.EmailTokenReset, time.Hour)
+	if err != nil || email != hijacked.Email {
+		t.Fatalf("NewEmailToken: %s (%v)", email, err)
+	}
+
+	const resetPassword = "mallorypassword"
+	r := httptest.NewRequest("POST", "http://other.example/password/reset/"+token,
+		strings.NewReader(url.Values{"password": {resetPassword}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	HandlePasswordResetPost(w, r, httprouter.Params{{Key: "token", Value: token}})
+	if w.Code != http.StatusFound {
+		t.Fatalf("Expected reset to redirect, got %d", w.Code)
+	}
+	checkPassword(other, resetPassword)
+	checkPassword(def, password)
+	checkPassword(third, password)
+
+	t.Logf("Changing the now-known password as the user in the other event")
+	hijacked, err = other.UserFind(uid)
+	if err != nil || hijacked == nil {
+		t.Fatalf("UserFind: %v (%v)", hijacked, err)
+	}
+	next := *hijacked
+	if err := other.UserUpdate(&next, hijacked, resetPassword, "mallorypassword2"); err != nil {
+		t.Fatalf("UserUpdate: %v", err)
+	}
+	syncPassword(other, &next, hijacked.HashedPassword)
+	checkPassword(other, "mallorypassword2")
+	checkPassword(def, password)
+	checkPassword(third, password)
+
+	t.Logf("Changing the password as the user, knowing the current one")
+	user, err = def.UserFind(uid)
+	if err != nil || user == nil {
+		t.Fatalf("UserFind: %v (%v)", user, err)
+	}
+	next = *user
+	if err := def.UserUpdate(&next, user, password, "alicepassword2"); err != nil {
+		t.Fatalf("UserUpdate: %v", err)
+	}
+	syncPassword(def, &next, user.HashedPassword)
+	checkPassword(def, "alicepassword2")
+	checkPassword(third, "alicepassword2")
+	checkPassword(other, "mallorypassword2")
+}
+
+func TestLoginArchived(t *testing.T) {
+	defer testEventsInit(t, EventInfo{},
+		EventInfo{Name: "old", Hostname: "old.example", Archived: true},
+		EventInfo{Name: "new", Hostname: "new.example"})()
+	def := DefaultEvent()
+
+	const password = "bobpassword"
+	if _, err := def.NewUser(password, &event.User{Username: "bob"}); err != nil {
+		t.Fatalf("NewUser: %v", err)
+	}
+
+	t.Logf("Logging in to an archived event with an account from another event")
+	old := EventFind("old")
+	if _, err := FindUser(old, "bob", password); err != event.ErrCredentialsIncorrect {
+		t.Errorf("Expected ErrCredentialsIncorrect, got %v", err)
+	}
+	if u, err := old.UserFindByUsername("bob"); err != nil || u != nil {
+		t.Errorf("Expected account not to be copied to archived event, got %v (%v)", u, err)
+	}
+
+	t.Logf("Logging in to an event which isn't archived")
+	if u, err := FindUser(EventFind("new"), "bob", password); err != nil || u == nil {
+		t.Errorf("Expected account to be copied, got %v (%v)", u, err)
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gwd/session-scheduler/event"
+)
+
+// The timetable editor works on one day at a time.  All the slots in
+// a day share a date, and times are in DefaultLocationTZ.
+const (
+	timetableDateFormat = "2006-01-02"
+	timetableTimeFormat = "15:04"
+)
+
+type timetableEditSlot struct {
+	SlotID   event.SlotID // "" for a slot which hasn't been saved yet
+	Time     string
+	IsBreak  bool
+	IsLocked bool
+	Sessions int
+	Error    string
+}
+
+type timetableEditDay struct {
+	DayID   event.DayID
+	DayName string
+	Date    string
+	Slots   []timetableEditSlot
+	Error   string
+	// What saving the slots, or deleting the day, would unschedule
+	Impact       []event.ScheduleImpact
+	DeleteImpact []event.ScheduleImpact
+}
+
+func timetableEditDayFrom(dayid event.DayID, td *event.TimetableDay) timetableEditDay {
+	ed := timetableEditDay{DayID: dayid, DayName: td.DayName}
+	for i := range td.Slots {
+		ts := &td.Slots[i]
+		t := ts.Time.In(DefaultLocationTZ.Location)
+		if i == 0 {
+			ed.Date = t.Format(timetableDateFormat)
+		}
+		ed.Slots = append(ed.Slots, timetableEditSlot{
+			SlotID:   ts.SlotID,
+			Time:     t.Format(timetableTimeFormat),
+			IsBreak:  ts.IsBreak,
+			IsLocked: ts.IsLocked,
+			Sessions: len(ts.Discussions)})
+	}
+	return ed
+}
+
+func adminTimetableContent(ev *Event, content map[string]interface{}) {
+	tt, err := ev.GetTimetable("", nil)
+	if err != nil {
+		log.Printf("Error getting timetable: %v", err)
+	}
+	days := make([]timetableEditDay, len(tt.Days))
+	for i := range tt.Days {
+		days[i] = timetableEditDayFrom(event.DayID(i+1), &tt.Days[i])
+		// Suggest the day after the previous day for days without slots
+		if days[i].Date == "" && i > 0 {
+			if prev, err := time.Parse(timetableDateFormat, days[i-1].Date); err == nil {
+				days[i].Date = prev.AddDate(0, 0, 1).Format(timetableDateFormat)
+			}
+		}
+	}
+	content["Days"] = days
+	content["TZ"] = DefaultLocationTZ.Location
+}
+
+// renderAdminTimetable shows the timetable page, with any
+// modifications made by fixup (such as errors to show, or the values
+// which were submitted) to the content.
+func renderAdminTimetable(w http.ResponseWriter, r *http.Request, user *event.User,
+	fixup func(content map[string]interface{}, days []timetableEditDay)) {
+	ev := RequestEvent(r)
+	content := map[string]interface{}{"User": user, "timetable": true}
+	adminTimetableContent(ev, content)
+	fixup(content, content["Days"].([]timetableEditDay))
+	RenderTemplate(w, r, "admin/timetable", content)
+}
+
+// formImpactAction returns the action to take for schedule entries
+// affected by a change, as confirmed by the user.
+func formImpactAction(r *http.Request) event.ImpactAction {
+	if r.FormValue("unschedule") != "" {
+		return event.ImpactUnschedule
+	}
+	return event.ImpactAbort
+}
+
+// timetableErrorString turns an error from the event package into
+// something which can be shown to the user.
+func timetableErrorString(err error) string {
+	switch {
+	case event.IsValidationError(err):
+		return err.Error()
+	default:
+		log.Printf("Error updating timetable: %v", err)
+		return "Internal error: See log"
+	}
+}
+
+func handleAdminNewDay(w http.ResponseWriter, r *http.Request, user *event.User) {
+	ev := RequestEvent(r)
+	d := event.Day{DayName: r.FormValue("dayname")}
+	if _, err := ev.NewDay(user.UserID, &d); err != nil {
+		renderAdminTimetable(w, r, user, func(content map[string]interface{}, _ []timetableEditDay) {
+			content["NewDayName"] = d.DayName
+			content["Error"] = timetableErrorString(err)
+		})
+		return
+	}
+	http.Redirect(w, r, "timetable?flash=Day+added", http.StatusFound)
+}
+
+// handleAdminDayAction handles the actions which act on a whole day.
+func handleAdminDayAction(w http.ResponseWriter, r *http.Request, user *event.User, action string) {
+	ev := RequestEvent(r)
+	dayid, err := strconv.Atoi(r.FormValue("dayid"))
+	if err != nil {
+		log.Printf("Error parsing dayid: %v", err)
+		http.Redirect(w, r, "timetable?flash=Website+Error", http.StatusFound)
+		return
+	}
+
+	var flash string
+	d := event.Day{DayID: event.DayID(dayid), DayName: r.FormValue("dayname")}
+	switch action {
+	case "renameday":
+		err = ev.DayUpdate(user.UserID, &d)
+		flash = "Day+renamed"
+	case "deleteday":
+		err = ev.DeleteDay(user.UserID, d.DayID, formImpactAction(r))
+		flash = "Day+deleted"
+	}
+	switch {
+	case err == event.ErrDayNotFound:
+		http.Redirect(w, r, "timetable?flash=Day+not+found", http.StatusFound)
+		return
+	case event.GetScheduleImpact(err) != nil:
+		impact := event.GetScheduleImpact(err)
+		ImpactSetTimeDisplay(impact, slotTimeFormat)
+		renderAdminTimetable(w, r, user, func(_ map[string]interface{}, days []timetableEditDay) {
+			if dayid >= 1 && dayid <= len(days) {
+				days[dayid-1].DeleteImpact = impact
+			}
+		})
+		return
+	case err != nil:
+		renderAdminTimetable(w, r, user, func(_ map[string]interface{}, days []timetableEditDay) {
+			if dayid >= 1 && dayid <= len(days) {
+				days[dayid-1].Error = timetableErrorString(err)
+				if action == "renameday" {
+					days[dayid-1].DayName = d.DayName
+				}
+			}
+		})
+		return
+	}
+	http.Redirect(w, r, "timetable?flash="+flash, http.StatusFound)
+}
+
+// timetableGuessTime suggests a time for a slot inserted after slot
+// idx: the same distance after it as it is after the slot before it,
+// or half an hour after it if that can't be worked out.  "" means no
+// guess could be made.
+func timetableGuessTime(slots []timetableEditSlot, idx int) string {
+	if idx < 0 {
+		return "09:00"
+	}
+	prev, err := time.Parse(timetableTimeFormat, slots[idx].Time)
+	if err != nil {
+		return ""
+	}
+	gap := 30 * time.Minute
+	if idx > 0 {
+		if before, err := time.Parse(timetableTimeFormat, slots[idx-1].Time); err == nil &&
+			prev.After(before) {
+			gap = prev.Sub(before)
+		}
+	}
+	guess := prev.Add(gap)
+	if idx+1 < len(slots) {
+		if next, err := time.Parse(timetableTimeFormat, slots[idx+1].Time); err == nil &&
+			!guess.Before(next) {
+			guess = prev.Add(next.Sub(prev) / 2)
+		}
+	}
+	if guess.Day() != prev.Day() {
+		return ""
+	}
+	return guess.Format(timetableTimeFormat)
+}
+
+// applyTimetableOp applies one of the per-slot buttons to ed: op is
+// "up-N", "down-N", "delete-N" or "insert-N" (insert after slot N),
+// or "add" to add a slot at the end.  Other ops (such as "save")
+// leave the slots as they are.  Moving a slot up or down moves
+// it (along with anything scheduled in it) to the neighbouring time,
+// rather than changing the times.
+func applyTimetableOp(ed *timetableEditDay, op string) {
+	slots := ed.Slots
+	kind, idx := "insert", len(slots)-1
+	if op != "add" {
+		i := strings.LastIndex(op, "-")
+		if i < 0 {
+			return
+		}
+		var err error
+		kind = op[:i]
+		idx, err = strconv.Atoi(op[i+1:])
+		if err != nil || idx < 0 || idx >= len(slots) {
+			return
+		}
+	}
+
+	swap := func(a, b int) {
+		if a < 0 || b >= len(slots) {
+			return
+		}
+		slots[a].Time, slots[b].Time = slots[b].Time, slots[a].Time
+		slots[a], slots[b] = slots[b], slots[a]
+	}
+
+	switch kind {
+	case "up":
+		swap(idx-1, idx)
+	case "down":
+		swap(idx, idx+1)
+	case "delete":
+		ed.Slots = append(slots[:idx], slots[idx+1:]...)
+	case "insert":
+		s := timetableEditSlot{Time: timetableGuessTime(slots, idx)}
+		ed.Slots = append(slots[:idx+1], append([]timetableEditSlot{s}, slots[idx+1:]...)...)
+	}
+}
+
+// handleAdminTimetableDay saves the slots for one day, after first
+// applying whichever button was pressed.  If anything's wrong, the
+// page is shown again with what was submitted and the errors next to
+// the slots they apply to.
+func handleAdminTimetableDay(w http.ResponseWriter, r *http.Request, user *event.User) {
+	ev := RequestEvent(r)
+	dayid, err := strconv.Atoi(r.FormValue("dayid"))
+	if err != nil {
+		log.Printf("Error parsing dayid: %v", err)
+		http.Redirect(w, r, "timetable?flash=Website+Error", http.StatusFound)
+		return
+	}
+	count, err := strconv.Atoi(r.FormValue("count"))
+	if err != nil {
+		log.Printf("Error parsing slot count: %v", err)
+		http.Redirect(w, r, "timetable?flash=Website+Error", http.StatusFound)
+		return
+	}
+
+	tt, err := ev.GetTimetable("", nil)
+	if err != nil {
+		log.Printf("Error getting timetable: %v", err)
+		http.Redirect(w, r, "timetable?flash=Internal+Error", http.StatusFound)
+		return
+	}
+	if dayid < 1 || dayid > len(tt.Days) {
+		http.Redirect(w, r, "timetable?flash=Day+not+found", http.StatusFound)
+		return
+	}
+
+	// Things which can't be changed here come from the database
+	// rather than the form
+	current := timetableEditDayFrom(event.DayID(dayid), &tt.Days[dayid-1])
+	existing := map[event.SlotID]*timetableEditSlot{}
+	for i := range current.Slots {
+		existing[current.Slots[i].SlotID] = &current.Slots[i]
+	}
+
+	ed := timetableEditDay{
+		DayID:   current.DayID,
+		DayName: current.DayName,
+		Date:    strings.TrimSpace(r.FormValue("date"))}
+	for i := 0; i < count; i++ {
+		s := timetableEditSlot{
+			SlotID:  event.SlotID(r.FormValue(fmt.Sprintf("slot-%d", i))),
+			Time:    strings.TrimSpace(r.FormValue(fmt.Sprintf("time-%d", i))),
+			IsBreak: r.FormValue(fmt.Sprintf("break-%d", i)) != ""}
+		if cur, ok := existing[s.SlotID]; ok {
+			s.IsLocked = cur.IsLocked
+			s.Sessions = cur.Sessions
+		} else if s.SlotID != "" {
+			ed.Error = "Some slots have been deleted; has someone else changed the timetable?"
+		}
+		ed.Slots = append(ed.Slots, s)
+	}
+
+	applyTimetableOp(&ed, r.FormValue("op"))
+
+	// Validate
+	var slots []event.TimetableSlot
+	date, err := time.ParseInLocation(timetableDateFormat, ed.Date, DefaultLocationTZ.Location)
+	if err != nil && len(ed.Slots) > 0 {
+		ed.Error = "Invalid date"
+	}
+	var prev time.Time
+	for i := range ed.Slots {
+		s := &ed.Slots[i]
+		t, err := time.Parse(timetableTimeFormat, s.Time)
+		if err != nil {
+			s.Error = "Invalid time"
+			continue
+		}
+		st := time.Date(date.Year(), date.Month(), date.Day(), t.Hour(), t.Minute(), 0, 0,
+			DefaultLocationTZ.Location)
+		if i > 0 && !st.After(prev) {
+			s.Error = "Must be after the previous slot"
+		}
+		prev = st
+		slots = append(slots, event.TimetableSlot{
+			SlotID:  s.SlotID,
+			Time:    event.Time{Time: st},
+			IsBreak: s.IsBreak})
+	}
+
+	valid := ed.Error == ""
+	for i := range ed.Slots {
+		valid = valid && ed.Slots[i].Error == ""
+	}
+
+	if valid {
+		tt.Days[dayid-1].Slots = slots
+		err = ev.TimetableSet(user.UserID, &tt, formImpactAction(r))
+		if err == nil {
+			http.Redirect(w, r, "timetable?flash=Timetable+updated", http.StatusFound)
+			return
+		}
+		// Show what would be unscheduled, so that the user can
+		// confirm it
+		if ed.Impact = event.GetScheduleImpact(err); ed.Impact != nil {
+			ImpactSetTimeDisplay(ed.Impact, slotTimeFormat)
+		} else {
+			ed.Error = timetableErrorString(err)
+		}
+	}
+
+	renderAdminTimetable(w, r, user, func(_ map[string]interface{}, days []timetableEditDay) {
+		days[dayid-1] = ed
+	})
+}
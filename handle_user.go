@@ -7,6 +7,7 @@ import (
 	"github.com/julienschmidt/httprouter"
 
 	"github.com/gwd/session-scheduler/event"
+	"github.com/gwd/session-scheduler/keyvalue"
 	"github.com/gwd/session-scheduler/sessions"
 )
 
@@ -31,32 +32,47 @@ func parseProfile(r *http.Request, user *event.User) {
 }
 
 func HandleUserCreate(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ev := RequestEvent(r)
 	var e string
 	var err error
 	var uid event.UserID
 	var user event.User
+	var vcode string
+	var redeem bool
 
 	user.Username = r.FormValue("Username")
 	parseProfile(r, &user)
 
+	vcode = r.FormValue("Vcode")
 	{
-		evcode, err := kvs.Get(VerificationCode)
-		if err != nil {
-			log.Printf("INTERNAL ERROR: Couldn't get event verification code: %v", err)
+		shared, err := checkVerificationCode(ev, vcode)
+		switch {
+		case shared:
+			user.IsVerified = true
+		case err == nil:
+			redeem = true
+		case !event.IsValidationError(err):
+			log.Printf("INTERNAL ERROR: Checking verification code: %v", err)
 			e = "Internal error"
 			goto fail
-		}
-		vcode := r.FormValue("Vcode")
-		if vcode == evcode {
-			user.IsVerified = true
-		} else if kvs.GetBoolDef(FlagRequireVerification) {
-			log.Printf("New user failed: Bad vcode %s", vcode)
+		case kvs.GetBoolDef(ev.Key(FlagRequireVerification)):
+			log.Printf("New user failed: Bad vcode %s: %v", vcode, err)
 			e = "Incorrect Verification Code"
 			goto fail
 		}
 	}
 
-	uid, err = event.NewUser(r.FormValue("Password"), &user)
+	if redeem {
+		// The code may have been used up since we checked it, so
+		// it's redeemed along with creating the user
+		uid, err = ev.NewUserWithInvite(r.FormValue("Password"), &user, vcode)
+		if err == event.ErrInviteInvalid {
+			e = "Incorrect Verification Code"
+			goto fail
+		}
+	} else {
+		uid, err = ev.NewUser(r.FormValue("Password"), &user)
+	}
 
 	if err != nil {
 		if event.IsValidationError(err) {
@@ -78,6 +94,13 @@ func HandleUserCreate(w http.ResponseWriter, r *http.Request, _ httprouter.Param
 		panic(err)
 	}
 
+	if user.Email != "" && mail != nil {
+		if err := sendEmailVerification(ev, &user); err != nil {
+			log.Printf("ERROR: Sending verification email to user %s: %v",
+				user.Username, err)
+		}
+	}
+
 	http.Redirect(w, r, "/?flash=User+created", http.StatusFound)
 	return
 
@@ -94,3 +117,23 @@ fail:
 	})
 	return
 }
+
+// checkVerificationCode returns true if vcode is the shared
+// verification code.  Otherwise it checks whether vcode is an
+// invitation code which can be redeemed, returning nil if so.
+func checkVerificationCode(ev *Event, vcode string) (shared bool, err error) {
+	if vcode == "" {
+		return false, event.ErrInviteInvalid
+	}
+
+	evcode, err := kvs.Get(ev.Key(VerificationCode))
+	if err != nil && err != keyvalue.ErrNoRows {
+		return false, err
+	}
+	// An empty shared code means only invitation codes are accepted
+	if evcode != "" && vcode == evcode {
+		return true, nil
+	}
+
+	return false, ev.InviteCheck(vcode)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerificationCode(t *testing.T) {
+	defer testEventsInit(t, EventInfo{})()
+	ev := DefaultEvent()
+
+	t.Logf("Checking a fresh event only accepts invitation codes")
+	for _, vcode := range []string{"", "x", "abcdefgh", "0123456789abcdef"} {
+		if shared, err := checkVerificationCode(ev, vcode); shared || err == nil {
+			t.Errorf("Expected code %q to be rejected, got shared %v err %v", vcode, shared, err)
+		}
+	}
+
+	invites, err := ev.NewInviteBatch("", 1, 1, time.Time{}, "test")
+	if err != nil || len(invites) != 1 {
+		t.Fatalf("NewInviteBatch: %v (%v)", invites, err)
+	}
+	if shared, err := checkVerificationCode(ev, invites[0].Code); shared || err != nil {
+		t.Errorf("Expected invitation code to be accepted, got shared %v err %v", shared, err)
+	}
+
+	t.Logf("Checking a shared code set by an admin is accepted")
+	if err := kvs.Set(ev.Key(VerificationCode), "sharedcode"); err != nil {
+		t.Fatalf("Setting verification code: %v", err)
+	}
+	if shared, err := checkVerificationCode(ev, "sharedcode"); !shared || err != nil {
+		t.Errorf("Expected shared code to be accepted, got shared %v err %v", shared, err)
+	}
+	if shared, err := checkVerificationCode(ev, "othercode"); shared || err == nil {
+		t.Errorf("Expected other code to be rejected, got shared %v err %v", shared, err)
+	}
+}
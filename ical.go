@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gwd/session-scheduler/event"
+)
+
+// Minimal iCalendar (RFC 5545) output for the schedule.
+
+const (
+	icalDateTimeFormat = "20060102T150405"
+	icalLineMax        = 75
+)
+
+var icalEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`;`, `\;`,
+	`,`, `\,`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+	"\r", `\n`)
+
+type icalWriter struct {
+	bytes.Buffer
+}
+
+// line writes a single content line, folding it as required.  Lines
+// are limited to icalLineMax octets, including the leading space of
+// continuation lines.
+func (w *icalWriter) line(name, value string) {
+	l := name + ":" + value
+	max := icalLineMax
+	for len(l) > max {
+		// Don't split UTF-8 sequences
+		n := max
+		for n > 0 && l[n]&0xC0 == 0x80 {
+			n--
+		}
+		w.WriteString(l[:n] + "\r\n ")
+		l = l[n:]
+		max = icalLineMax - 1
+	}
+	w.WriteString(l + "\r\n")
+}
+
+func (w *icalWriter) text(name, value string) {
+	w.line(name, icalEscaper.Replace(value))
+}
+
+func (w *icalWriter) time(name string, t time.Time, tzl *event.TZLocation) {
+	if tzl == nil || tzl.Location == nil || tzl.String() == "UTC" {
+		w.line(name, t.UTC().Format(icalDateTimeFormat)+"Z")
+		return
+	}
+	w.line(name+";TZID="+tzl.String(), t.In(tzl.Location).Format(icalDateTimeFormat))
+}
+
+func icalOffset(offset int) string {
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, offset/3600, (offset/60)%60)
+}
+
+// icalZoneTransition finds the time between start and end (which have
+// different UTC offsets) at which the offset changes.
+func icalZoneTransition(start, end time.Time) time.Time {
+	_, startOffset := start.Zone()
+	for end.Sub(start) > time.Second {
+		mid := start.Add(end.Sub(start) / 2)
+		if _, offset := mid.Zone(); offset == startOffset {
+			start = mid
+		} else {
+			end = mid
+		}
+	}
+	return end.Truncate(time.Second)
+}
+
+// vtimezone writes a VTIMEZONE for tzl covering times from start to
+// end.  Rather than reproducing the rules for the zone, it lists each
+// UTC offset in effect during that period, along with when it took
+// effect; this is sufficient for the events in the feed.
+func (w *icalWriter) vtimezone(tzl *event.TZLocation, start, end time.Time) {
+	if tzl == nil || tzl.Location == nil || tzl.String() == "UTC" {
+		return
+	}
+
+	type period struct {
+		start    time.Time
+		from, to int
+	}
+
+	// Look at the offset once a day, and search for the transition
+	// whenever it changes.
+	t := start.In(tzl.Location).Add(-24 * time.Hour)
+	end = end.In(tzl.Location).Add(24 * time.Hour)
+	_, offset := t.Zone()
+	periods := []period{{start: t, from: offset, to: offset}}
+	for t.Before(end) {
+		next := t.Add(24 * time.Hour)
+		if _, nextOffset := next.Zone(); nextOffset != offset {
+			periods = append(periods, period{
+				start: icalZoneTransition(t, next),
+				from:  offset,
+				to:    nextOffset,
+			})
+			offset = nextOffset
+		}
+		t = next
+	}
+
+	w.line("BEGIN", "VTIMEZONE")
+	w.line("TZID", tzl.String())
+	for _, p := range periods {
+		component := "STANDARD"
+		if p.start.IsDST() {
+			component = "DAYLIGHT"
+		}
+		name, _ := p.start.Zone()
+
+		// DTSTART is in local time, before the transition
+		local := p.start.In(time.FixedZone("", p.from))
+
+		w.line("BEGIN", component)
+		w.line("DTSTART", local.Format(icalDateTimeFormat))
+		w.line("TZOFFSETFROM", icalOffset(p.from))
+		w.line("TZOFFSETTO", icalOffset(p.to))
+		w.line("TZNAME", name)
+		w.line("END", component)
+	}
+	w.line("END", "VTIMEZONE")
+}
+
+// ICalSchedule renders entries as an iCalendar feed called name.
+// Times are given in tzl; host is used to make each event's UID
+// globally unique.
+func ICalSchedule(entries []event.CalendarEntry, tzl *event.TZLocation, name, host string) []byte {
+	w := &icalWriter{}
+
+	w.line("BEGIN", "VCALENDAR")
+	w.line("VERSION", "2.0")
+	w.line("PRODID", "-//session-scheduler//EN")
+	w.line("CALSCALE", "GREGORIAN")
+	w.text("X-WR-CALNAME", name)
+	if tzl != nil && tzl.Location != nil {
+		w.text("X-WR-TIMEZONE", tzl.String())
+	}
+
+	if len(entries) > 0 {
+		start, end := entries[0].Start.Time, entries[0].End.Time
+		for i := range entries {
+			if entries[i].Start.Before(start) {
+				start = entries[i].Start.Time
+			}
+			if entries[i].End.After(end) {
+				end = entries[i].End.Time
+			}
+		}
+		w.vtimezone(tzl, start, end)
+	}
+
+	stamp := time.Now().UTC().Format(icalDateTimeFormat) + "Z"
+	for i := range entries {
+		e := &entries[i]
+		w.line("BEGIN", "VEVENT")
+		w.line("UID", string(e.DiscussionID)+"@"+host)
+		w.line("DTSTAMP", stamp)
+		w.time("DTSTART", e.Start.Time, tzl)
+		w.time("DTEND", e.End.Time, tzl)
+		w.text("SUMMARY", e.Title)
+		w.text("DESCRIPTION", ProcessTextPlain(e.Description))
+		w.text("LOCATION", e.LocationName)
+		if e.LocationURL != "" {
+			w.line("URL", e.LocationURL)
+		}
+		w.line("END", "VEVENT")
+	}
+
+	w.line("END", "VCALENDAR")
+
+	return w.Bytes()
+}
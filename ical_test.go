@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestIcalLineFolding(t *testing.T) {
+	description := strings.Repeat("Größenänderung – ünïcödé ✓ ", 20)
+
+	var w icalWriter
+	w.text("DESCRIPTION", description)
+	out := w.String()
+
+	if !strings.HasSuffix(out, "\r\n") {
+		t.Fatalf("Output doesn't end with CRLF: %q", out)
+	}
+	lines := strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n")
+	if len(lines) < 2 {
+		t.Fatalf("Expected long description to be folded, got %q", out)
+	}
+	for i, l := range lines {
+		if len(l) > icalLineMax {
+			t.Errorf("Line %d is %d octets long: %q", i, len(l), l)
+		}
+		if !utf8.ValidString(l) {
+			t.Errorf("Line %d splits a UTF-8 sequence: %q", i, l)
+		}
+		if i > 0 && !strings.HasPrefix(l, " ") {
+			t.Errorf("Continuation line %d doesn't start with a space: %q", i, l)
+		}
+	}
+
+	// Unfolding should give back the original line
+	unfolded := strings.Replace(strings.TrimSuffix(out, "\r\n"), "\r\n ", "", -1)
+	if want := "DESCRIPTION:" + icalEscaper.Replace(description); unfolded != want {
+		t.Errorf("Unfolded line doesn't match:\n got %q\nwant %q", unfolded, want)
+	}
+}
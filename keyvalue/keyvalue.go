@@ -91,6 +91,42 @@ func (store *KeyValueStore) Close() {
 	store.DB = nil
 }
 
+// Backup writes a consistent snapshot of the store's database to
+// filename, which must not exist yet.
+func (kv KeyValueStore) Backup(filename string) error {
+	_, err := kv.Exec(`vacuum into ?`, filename)
+	return err
+}
+
+// CheckBackup makes sure the file name is an intact database
+// containing a key-value store.
+func CheckBackup(name string) error {
+	db, err := sqlx.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro", name))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.Get(&result, "pragma integrity_check"); err != nil {
+		return fmt.Errorf("Checking integrity: %v", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("Integrity check failed: %s", result)
+	}
+
+	var tableCount int
+	err = db.Get(&tableCount,
+		"SELECT count(*) FROM sqlite_master WHERE type='table' AND name='keyvalue_keyvalues';")
+	if err != nil {
+		return fmt.Errorf("Checking for existence of tables: %v", err)
+	}
+	if tableCount != 1 {
+		return fmt.Errorf("%s doesn't contain a key-value store", name)
+	}
+	return nil
+}
+
 func get(q sqlx.Queryer, key string, value *string) error {
 	return sqlx.Get(q, value, `select value from keyvalue_keyvalues where key=?`,
 		key)
@@ -128,3 +128,70 @@ func TestKeyStore(t *testing.T) {
 	// Only remove the file if we were successful
 	os.Remove(fname)
 }
+
+func TestBackup(t *testing.T) {
+	fname := os.TempDir() + "/keyvalue-backup.db"
+	bname := fname + ".bak"
+
+	// Remove the files first, just in case
+	os.Remove(fname)
+	os.Remove(bname)
+
+	kv, err := OpenFile(fname)
+	if err != nil {
+		t.Errorf("Creating session store: %v", err)
+		return
+	}
+	defer kv.Close()
+
+	expected := map[string]string{"key1": "value1"}
+	for k, v := range expected {
+		if err := kv.Set(k, v); err != nil {
+			t.Errorf("Setting key-value (%s, %s): %v", k, v, err)
+			return
+		}
+	}
+
+	if err := kv.Backup(bname); err != nil {
+		t.Errorf("Backup: %v", err)
+		return
+	}
+	if err := kv.Backup(bname); err == nil {
+		t.Errorf("Backing up over an existing file succeeded")
+		return
+	}
+	if err := CheckBackup(bname); err != nil {
+		t.Errorf("CheckBackup: %v", err)
+		return
+	}
+
+	bkv, err := OpenFile(bname)
+	if err != nil {
+		t.Errorf("Opening backup: %v", err)
+		return
+	}
+	if VerifyExpected(t, expected, bkv) {
+		bkv.Close()
+		return
+	}
+	bkv.Close()
+
+	// A database without a key-value store isn't a valid backup
+	if _, err := kv.Exec(`drop table keyvalue_keyvalues`); err != nil {
+		t.Errorf("Dropping table: %v", err)
+		return
+	}
+	os.Remove(bname)
+	if err := kv.Backup(bname); err != nil {
+		t.Errorf("Backup: %v", err)
+		return
+	}
+	if err := CheckBackup(bname); err == nil {
+		t.Errorf("CheckBackup of database without a key-value store succeeded")
+		return
+	}
+
+	// Only remove the files if we were successful
+	os.Remove(fname)
+	os.Remove(bname)
+}
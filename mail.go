@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/gwd/session-scheduler/event"
+	"github.com/gwd/session-scheduler/keyvalue"
+	"github.com/gwd/session-scheduler/mailer"
+)
+
+const (
+	KeyMailMethod       = "MailMethod"
+	KeyMailFrom         = "MailFrom"
+	KeyMailSMTPAddress  = "MailSMTPAddress"
+	KeyMailSMTPUsername = "MailSMTPUsername"
+	KeyMailSMTPPassword = "MailSMTPPassword"
+	KeyMailMaildir      = "MailMaildir"
+	KeyServeBaseURL     = "ServeBaseURL"
+)
+
+const defaultMaildir = "data/mail"
+
+// Mailer used to send password reset and verification emails; nil if
+// none is configured.
+var mail mailer.Mailer
+
+func kvsGetDef(key, def string) string {
+	value, err := kvs.Get(key)
+	switch {
+	case err == keyvalue.ErrNoRows:
+		return def
+	case err != nil:
+		log.Fatalf("Getting %s: %v", key, err)
+	}
+	return value
+}
+
+func validateMailMethod(method string) error {
+	switch method {
+	case "none", "smtp", "maildir":
+		return nil
+	}
+	return fmt.Errorf("Invalid mail method %s", method)
+}
+
+func mailInit() {
+	method := kvsGetDef(KeyMailMethod, "none")
+	from := kvsGetDef(KeyMailFrom, "")
+
+	switch method {
+	case "none":
+		return
+	case "smtp":
+		mail = &mailer.SMTPMailer{
+			Addr:     kvsGetDef(KeyMailSMTPAddress, "localhost:25"),
+			From:     from,
+			Username: kvsGetDef(KeyMailSMTPUsername, ""),
+			Password: kvsGetDef(KeyMailSMTPPassword, ""),
+		}
+	case "maildir":
+		mail = &mailer.MaildirMailer{
+			Dir:  kvsGetDef(KeyMailMaildir, defaultMaildir),
+			From: from,
+		}
+	default:
+		log.Fatalf("Invalid mail method %s", method)
+	}
+
+	if from == "" {
+		log.Fatalf("Mail method %s requires a From address (%s)", method, KeyMailFrom)
+	}
+}
+
+// baseURL returns the URL at which the site can be reached from the
+// outside, for use in links sent by email.  It's deliberately not
+// taken from the request, since the Host header can't be trusted.
+func baseURL() string {
+	base := kvsGetDef(KeyServeBaseURL, "")
+	if base == "" {
+		base = "http://" + kvsGetDef(KeyServeAddress, "localhost")
+	}
+	return strings.TrimRight(base, "/")
+}
+
+// sendPasswordReset mails user a link to reset their password.
+func sendPasswordReset(ev *Event, user *event.User) error {
+	if mail == nil {
+		return fmt.Errorf("No mailer configured")
+	}
+
+	token, email, err := ev.NewEmailToken(user.UserID, event.EmailTokenReset,
+		event.PasswordResetLifetime)
+	if err != nil {
+		return err
+	}
+
+	return mail.Send(&mailer.Message{
+		To:      email,
+		Subject: "Password reset",
+		Body: fmt.Sprintf(`Hello %s,
+
+Someone (hopefully you) asked to reset the password for your account.
+To choose a new password, follow this link within the next hour:
+
+%s/password/reset/%s
+
+If you didn't ask for this, you can ignore this message.
+`, user.Username, ev.BaseURL(), token),
+	})
+}
+
+// sendEmailVerification mails user a link to verify that they own
+// their email address.
+func sendEmailVerification(ev *Event, user *event.User) error {
+	if mail == nil {
+		return fmt.Errorf("No mailer configured")
+	}
+
+	token, email, err := ev.NewEmailToken(user.UserID, event.EmailTokenVerify,
+		event.EmailVerifyLifetime)
+	if err != nil {
+		return err
+	}
+
+	return mail.Send(&mailer.Message{
+		To:      email,
+		Subject: "Please verify your email address",
+		Body: fmt.Sprintf(`Hello %s,
+
+To confirm that this is your email address, please follow this link:
+
+%s/email/verify/%s
+
+If you didn't give this address to us, you can ignore this message.
+`, user.Username, ev.BaseURL(), token),
+	})
+}
@@ -0,0 +1,112 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gwd/session-scheduler/id"
+)
+
+// Message is a plain-text email message.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends messages.
+type Mailer interface {
+	Send(msg *Message) error
+}
+
+// Don't allow header injection via addresses
+var headerSanitizer = strings.NewReplacer("\r", "", "\n", "")
+
+// Bytes formats msg as an RFC 5322 message from from.
+func (msg *Message) Bytes(from string) []byte {
+	var b bytes.Buffer
+
+	now := time.Now()
+	host := "localhost"
+	if hostname, err := os.Hostname(); err == nil {
+		host = hostname
+	}
+
+	fmt.Fprintf(&b, "From: %s\r\n", headerSanitizer.Replace(from))
+	fmt.Fprintf(&b, "To: %s\r\n", headerSanitizer.Replace(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%d.%s@%s>\r\n", now.UnixNano(), id.GenerateRawID(8), host)
+	fmt.Fprintf(&b, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&b, "Content-Transfer-Encoding: 8bit\r\n")
+	fmt.Fprintf(&b, "\r\n")
+	body := strings.ReplaceAll(msg.Body, "\r\n", "\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return b.Bytes()
+}
+
+// SMTPMailer sends messages via an SMTP server.  STARTTLS is used if
+// the server supports it.  If Username is empty, no authentication
+// is attempted.
+type SMTPMailer struct {
+	Addr     string // host:port
+	From     string
+	Username string
+	Password string
+}
+
+func (m *SMTPMailer) Send(msg *Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, err := net.SplitHostPort(m.Addr)
+		if err != nil {
+			return fmt.Errorf("Parsing SMTP address %s: %v", m.Addr, err)
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	return smtp.SendMail(m.Addr, auth, m.From, []string{msg.To}, msg.Bytes(m.From))
+}
+
+// MaildirMailer delivers messages into a local maildir, rather than
+// sending them anywhere; useful for testing, or for sites which
+// don't have an SMTP server available (in which case an administrator
+// can forward messages by hand).
+type MaildirMailer struct {
+	Dir  string
+	From string
+}
+
+func (m *MaildirMailer) Send(msg *Message) error {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(m.Dir, sub), 0700); err != nil {
+			return fmt.Errorf("Creating maildir: %v", err)
+		}
+	}
+
+	// Write to tmp/ and then rename into new/, so that readers
+	// never see partial messages
+	name := fmt.Sprintf("%d.%s.session-scheduler", time.Now().UnixNano(), id.GenerateRawID(8))
+	tmpname := filepath.Join(m.Dir, "tmp", name)
+
+	if err := ioutil.WriteFile(tmpname, msg.Bytes(m.From), 0600); err != nil {
+		return fmt.Errorf("Writing message: %v", err)
+	}
+
+	if err := os.Rename(tmpname, filepath.Join(m.Dir, "new", name)); err != nil {
+		os.Remove(tmpname)
+		return fmt.Errorf("Delivering message: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package mailer
+
+import (
+	"io/ioutil"
+	"mime"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMaildir(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "mailer")
+	if err != nil {
+		t.Fatalf("Creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m := &MaildirMailer{Dir: filepath.Join(tmpdir, "mail"), From: "scheduler@example.org"}
+
+	msgs := []Message{
+		{To: "one@example.org", Subject: "First", Body: "Line one\nLine two\n"},
+		{To: "two@example.org\r\nBcc: evil@example.org", Subject: "Zweite Nachricht für dich", Body: "Hallo\r\n"},
+	}
+	for i := range msgs {
+		if err := m.Send(&msgs[i]); err != nil {
+			t.Fatalf("Sending message %d: %v", i, err)
+		}
+	}
+
+	if files, err := ioutil.ReadDir(filepath.Join(m.Dir, "tmp")); err != nil || len(files) != 0 {
+		t.Errorf("Expected empty tmp directory, got %d files (err %v)", len(files), err)
+	}
+
+	files, err := ioutil.ReadDir(filepath.Join(m.Dir, "new"))
+	if err != nil || len(files) != len(msgs) {
+		t.Fatalf("Expected %d messages, got %d (err %v)", len(msgs), len(files), err)
+	}
+
+	found := 0
+	for _, fi := range files {
+		f, err := os.Open(filepath.Join(m.Dir, "new", fi.Name()))
+		if err != nil {
+			t.Fatalf("Opening message: %v", err)
+		}
+		parsed, err := mail.ReadMessage(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("Parsing message: %v", err)
+		}
+
+		if parsed.Header.Get("Bcc") != "" {
+			t.Errorf("Header injected via To address")
+		}
+
+		from, err := parsed.Header.AddressList("From")
+		if err != nil || len(from) != 1 || from[0].Address != m.From {
+			t.Errorf("Unexpected From %v (err %v)", from, err)
+		}
+
+		subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+		if err != nil {
+			t.Errorf("Decoding subject: %v", err)
+		}
+
+		body, _ := ioutil.ReadAll(parsed.Body)
+		for i := range msgs {
+			if subject != msgs[i].Subject {
+				continue
+			}
+			found++
+			want := strings.ReplaceAll(strings.ReplaceAll(msgs[i].Body, "\r\n", "\n"), "\n", "\r\n")
+			if string(body) != want {
+				t.Errorf("Message %d: body %q, wanted %q", i, body, want)
+			}
+		}
+	}
+
+	if found != len(msgs) {
+		t.Errorf("Only found %d of %d messages", found, len(msgs))
+	}
+}
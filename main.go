@@ -10,18 +10,20 @@ import (
 	"time"
 
 	"github.com/gwd/session-scheduler/event"
-	"github.com/gwd/session-scheduler/id"
 	"github.com/gwd/session-scheduler/keyvalue"
 	"github.com/gwd/session-scheduler/timezones"
 )
 
 var kvs *keyvalue.KeyValueStore
 
+const serverConfigFilename = "data/serverconfig.sqlite"
+
 const (
 	ScheduleDebug        = "EventScheduleDebug"
 	ScheduleDebugVerbose = "EventScheduleDebugVerbose"
 	SearchAlgo           = "EventSearchAlgo"
 	SearchDuration       = "EventSearchDuration"
+	TrashRetention       = "EventTrashRetention"
 	Validate             = "EventValidate"
 	KeyDefaultLocation   = "EventDefaultLocation"
 	VerificationCode     = "ServeVerificationCode"
@@ -57,27 +59,39 @@ func main() {
 
 	TimezoneList, err = timezones.GetTimezoneList()
 	if err != nil {
-		log.Fatal("Getting timezone list: %v", err)
+		log.Fatalf("Getting timezone list: %v", err)
 	}
 
 	cwd()
 
 	templatesInit()
 
-	kvs, err = keyvalue.OpenFile("data/serverconfig.sqlite")
+	kvs, err = keyvalue.OpenFile(serverConfigFilename)
 	if err != nil {
-		log.Fatal("Opening serverconfig: %v", err)
+		log.Fatalf("Opening serverconfig: %v", err)
 	}
 
 	adminPwd := flag.String("admin-password", "", "Set admin password")
+	eventName := flag.String("event", "", "Event for commands (and -admin-password) to act on (default the default event)")
 
 	flag.Var(kvs.GetFlagValue(KeyServeAddress), "address", "Address to serve http from")
 	flag.Var(kvs.GetFlagValue(ScheduleDebug), "sched-debug", "Debug level for logging (default 0)")
 	flag.Var(kvs.GetFlagValue(SearchAlgo), "searchalgo", "Search algorithm.  Options are heuristic, genetic, and random.")
 	flag.Var(kvs.GetFlagValue(SearchDuration), "searchtime", "Duration to run search")
+	flag.Var(kvs.GetFlagValue(TrashRetention), "trash-retention", "How long to keep deleted users and discussions before purging them (default 720h)")
+	flag.Var(kvs.GetFlagValue(KeyBackupInterval), "backup-interval", "How often to back up the databases while serving; 0 disables (default 24h)")
+	flag.Var(kvs.GetFlagValue(KeyBackupKeep), "backup-keep", "Number of backups to keep; 0 keeps all of them (default 7)")
+	flag.Var(kvs.GetFlagValue(KeyBackupDir), "backup-dir", "Directory to keep backups in (default "+defaultBackupDir+")")
 	flag.Var(kvs.GetFlagValue(Validate), "validate", "Extra validation of schedule consistency")
 	flag.Var(kvs.GetFlagValue(KeyDefaultLocation), "default-location", "Default location to use for times")
 	flag.Var(kvs.GetFlagValue(LockingMethod), "servelock", "Server locking method.  Valid options are none, quit, wait, and error (default quit)")
+	flag.Var(kvs.GetFlagValue(KeyServeBaseURL), "base-url", "External URL of the site, for links in emails (default http://<address>)")
+	flag.Var(kvs.GetFlagValue(KeyMailMethod, validateMailMethod), "mail-method", "How to send mail.  Options are none, smtp, and maildir (default none)")
+	flag.Var(kvs.GetFlagValue(KeyMailFrom), "mail-from", "From address for mail")
+	flag.Var(kvs.GetFlagValue(KeyMailSMTPAddress), "mail-smtp-address", "SMTP server host:port (default localhost:25)")
+	flag.Var(kvs.GetFlagValue(KeyMailSMTPUsername), "mail-smtp-username", "SMTP username (default no authentication)")
+	flag.Var(kvs.GetFlagValue(KeyMailSMTPPassword), "mail-smtp-password", "SMTP password")
+	flag.Var(kvs.GetFlagValue(KeyMailMaildir), "mail-maildir", "Maildir to deliver mail to (default "+defaultMaildir+")")
 
 	cpuprofile := flag.String("cpuprofile", "", "write cpu profile to `file`")
 
@@ -94,19 +108,6 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	{
-		vcode, err := kvs.Get(VerificationCode)
-		switch {
-		case err == keyvalue.ErrNoRows:
-			vcode = id.GenerateRawID(8)
-			if err = kvs.Set(VerificationCode, vcode); err != nil {
-				log.Fatalf("Setting Event Verification Code: %v", err)
-			}
-		case err != nil:
-			log.Fatalf("Getting Event Verification Code: %v", err)
-		}
-	}
-
 	locstring, err := kvs.Get(KeyDefaultLocation)
 	switch {
 	case err == keyvalue.ErrNoRows:
@@ -126,23 +127,40 @@ func main() {
 		log.Fatalf("Couldn't load location %s: %v", locstring, err)
 	}
 
-	err = event.Load(event.EventOptions{AdminPwd: *adminPwd, DefaultLocation: locstring})
-	if err != nil {
-		log.Fatalf("Loading schedule data: %v", err)
-	}
-
 	cmd := flag.Arg(0)
 	if cmd == "" {
 		cmd = "serve"
 	}
 
+	// Loading would upgrade the database itself, and restoring
+	// must work even if the current database can't be loaded
+	switch cmd {
+	case "migrate":
+		Migrate(flag.Args()[1:])
+		return
+	case "restore":
+		Restore(flag.Args()[1:])
+		return
+	}
+
+	LoadEvents(*eventName, *adminPwd, locstring)
+	ev := EventFind(*eventName)
+
 	switch cmd {
 	case "serve":
 		serve()
 	case "schedule":
-		MakeSchedule(false)
+		if err := MakeSchedule(ev, "", false); err != nil {
+			log.Fatalf("Making schedule: %v", err)
+		}
 	case "editTimetable":
-		EditTimetable()
+		EditTimetable(ev)
+	case "explain":
+		Explain(ev)
+	case "export":
+		Export(ev, flag.Args()[1:])
+	case "import":
+		Import(ev, flag.Args()[1:])
 	default:
 		log.Fatalf("Unknown command: %s", cmd)
 	}
@@ -152,7 +170,7 @@ func main() {
 func getSearchDuration() time.Duration {
 	durationString, err := kvs.Get(SearchDuration)
 	var duration time.Duration
-	if err != nil {
+	if err == nil {
 		duration, err = time.ParseDuration(durationString)
 	}
 
@@ -163,8 +181,24 @@ func getSearchDuration() time.Duration {
 	return duration
 }
 
-func MakeSchedule(async bool) error {
-	opt := event.SearchOptions{Async: async}
+func getTrashRetention() time.Duration {
+	durationString, err := kvs.Get(TrashRetention)
+	var duration time.Duration
+	if err == nil {
+		duration, err = time.ParseDuration(durationString)
+	}
+
+	if err != nil {
+		// Default retention 30 days
+		return time.Hour * 24 * 30
+	}
+	return duration
+}
+
+// MakeSchedule runs the scheduler for ev on behalf of actor (which
+// may be empty if it's being run from the command line).
+func MakeSchedule(ev *Event, actor event.UserID, async bool) error {
+	opt := event.SearchOptions{Async: async, Actor: actor}
 
 	algostring, err := kvs.Get(SearchAlgo)
 	switch {
@@ -190,5 +224,5 @@ func MakeSchedule(async bool) error {
 
 	opt.SearchDuration = getSearchDuration()
 
-	return event.MakeSchedule(opt)
+	return ev.MakeSchedule(opt)
 }
@@ -4,6 +4,7 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
 
 	"github.com/julienschmidt/httprouter"
@@ -15,19 +16,55 @@ import (
 // This has to be global because ServeHTTP cannot have a pointer receiver.
 var lock sync.RWMutex
 
+const sessionsFilename = "./data/sessions.sqlite"
+
 func initMiddleware() {
-	if err := sessions.OpenSessionStore("./data/sessions.sqlite"); err != nil {
+	if err := sessions.OpenSessionStore(sessionsFilename); err != nil {
 		log.Fatalf("Opening sessions store: %v", err)
 	}
 }
 
+// requestBearerToken returns the token from an "Authorization:
+// Bearer" header, or "" if there is none.
+func requestBearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(auth[len(prefix):])
+}
+
+// RequestAPIToken returns the user and API token given in the
+// request's Authorization header, or nil if there is no valid one.
+func RequestAPIToken(r *http.Request) (*event.User, *event.APIToken) {
+	token := requestBearerToken(r)
+	if token == "" {
+		return nil, nil
+	}
+
+	user, at, err := RequestEvent(r).UserFindByAPIToken(token)
+	if err != nil {
+		log.Printf("Looking up API token: %v", err)
+		return nil, nil
+	}
+	return user, at
+}
+
+// RequestUser returns the user making the request, as identified
+// either by an API token or by the session cookie.
 func RequestUser(r *http.Request) *event.User {
+	if requestBearerToken(r) != "" {
+		user, _ := RequestAPIToken(r)
+		return user
+	}
+
 	session := sessions.RequestSession(r)
 	if session == nil || session.UserID == "" {
 		return nil
 	}
 
-	user, err := event.UserFind(event.UserID(session.UserID))
+	user, err := RequestEvent(r).UserFind(event.UserID(session.UserID))
 	if err != nil {
 		panic(err)
 	}
@@ -54,11 +91,20 @@ type Middleware struct {
 
 	// Admin only: Will be 404 if the logged-in user isnt' an admin
 	Admin *httprouter.Router
+
+	// JSON API: Available when the website is active, or for
+	// logged-in users.  Handlers do their own permission checks,
+	// and always respond with a status code rather than redirecting.
+	API *httprouter.Router
 }
 
 func (m Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	mw := NewMiddlewareResponseWriter(w)
 
+	// Everything from here on works on the event the request is for
+	ev := eventForHost(r.Host)
+	r = withEvent(r, ev)
+
 	// Allow multiple concurrent requests, but allow some operations
 	// (like shutting down) to stop incoming requests
 	lock.RLock()
@@ -68,6 +114,38 @@ func (m Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		m.Logger(w, r)
 	}
 
+	// Reject invalid API tokens, and read-only tokens used for
+	// anything which might change something, outright.
+	if requestBearerToken(r) != "" {
+		_, at := RequestAPIToken(r)
+		status, msg := 0, ""
+		switch {
+		case at == nil:
+			status, msg = http.StatusUnauthorized, "Invalid API token"
+		case at.ReadOnly && !isSafeMethod(r.Method):
+			status, msg = http.StatusForbidden, "API token is read-only"
+		}
+		if status != 0 {
+			if isAPIPath(r.URL.Path) {
+				apiError(mw, status, msg)
+			} else {
+				http.Error(mw, msg, status)
+			}
+			return
+		}
+	}
+
+	// Archived events can be looked at (and logged in to), but not
+	// changed
+	if ev.Archived && !isSafeMethod(r.Method) && r.URL.Path != "/login" {
+		if isAPIPath(r.URL.Path) {
+			apiError(mw, http.StatusForbidden, "Event is archived")
+		} else {
+			http.Error(mw, "This event has been archived, and can't be changed", http.StatusForbidden)
+		}
+		return
+	}
+
 	// First, look for public paths
 	if handler, params, _ := m.Always.Lookup(r.Method, r.URL.Path); handler != nil {
 		handler(mw, r, params)
@@ -78,9 +156,23 @@ func (m Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	u := RequestUser(r)
 
+	// API paths never fall through to the HTML handlers
+	if isAPIPath(r.URL.Path) {
+		handler, params, _ := m.API.Lookup(r.Method, r.URL.Path)
+		switch {
+		case handler == nil:
+			HandleAPINotFound(mw, r)
+		case !kvs.GetBoolDef(ev.Key(FlagActive)) && u == nil:
+			apiError(mw, http.StatusServiceUnavailable, "Website not active")
+		default:
+			handler(mw, r, params)
+		}
+		return
+	}
+
 	// Then, look for paths which are available only when active, or for admins
 	if handler, params, _ := m.Active.Lookup(r.Method, r.URL.Path); handler != nil {
-		if kvs.GetBoolDef(FlagActive) || u != nil {
+		if kvs.GetBoolDef(ev.Key(FlagActive)) || u != nil {
 			handler(mw, r, params)
 		} else {
 			http.Redirect(w, r, "/", http.StatusFound)
@@ -117,6 +209,10 @@ func (m Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	http.NotFound(w, r)
 }
 
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
 type MiddlewareResponseWriter struct {
 	http.ResponseWriter
 	written bool
@@ -8,11 +8,11 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/gofrs/flock"
 	"github.com/julienschmidt/httprouter"
 
-	"github.com/gwd/session-scheduler/event"
 	"github.com/gwd/session-scheduler/keyvalue"
 	"github.com/gwd/session-scheduler/sessions"
 )
@@ -25,6 +25,7 @@ import (
 // /new/user
 // /list/discussions
 // /list/users
+// /moderation
 // /admin/{console,test}
 //
 
@@ -43,7 +44,7 @@ func handleSigs() {
 
 	// FIXME: It would be good to have a way of registering a shutdown
 	// hook, rather than hard-coding these here.  Something for later.
-	event.Close()
+	CloseEvents()
 	kvs.Close()
 	sessions.CloseSessionStore()
 
@@ -114,18 +115,44 @@ func handleServeLock() {
 	}
 }
 
+// purgeTrash permanently deletes users and discussions which have
+// been in the trash for longer than the retention period, checking
+// every event once an hour.
+func purgeTrash() {
+	for {
+		for _, ev := range Events() {
+			_, _, err := ev.TrashPurge(time.Now().Add(-getTrashRetention()))
+			if err != nil {
+				log.Printf("Error purging trash for %s: %v", ev.Filename(), err)
+			}
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
 func serve() {
 	handleServeLock()
 
 	initMiddleware()
 
+	mailInit()
+
 	go handleSigs()
 
+	go purgeTrash()
+
+	go backupLoop()
+
 	always := NewRouter()
 
 	always.GET("/", HandleHome)
 	always.GET("/login", HandleSessionNew)
 	always.POST("/login", HandleSessionCreate)
+	always.GET("/password/forgot", HandlePasswordForgot)
+	always.POST("/password/forgot", HandlePasswordForgotPost)
+	always.GET("/password/reset/:token", HandlePasswordReset)
+	always.POST("/password/reset/:token", HandlePasswordResetPost)
+	always.GET("/email/verify/:token", HandleEmailVerify)
 
 	always.GET("/robots.txt", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		http.ServeFile(w, r, "assets/robots.txt")
@@ -145,6 +172,8 @@ func serve() {
 	public.GET("/discussion/notfound", HandleDiscussionNotFound)
 
 	public.GET("/schedule", HandleScheduleView)
+	public.GET("/schedule.ics", HandleScheduleICal)
+	public.GET("/calendar/:token/agenda.ics", HandleAgendaICal)
 
 	public.GET("/list/:itype", HandleList)
 	public.GET("/uid/:itype/:uid/:action", HandleUid)
@@ -154,6 +183,9 @@ func serve() {
 	userAuth.GET("/sign-out", HandleSessionDestroy)
 	userAuth.GET("/discussion/new", HandleDiscussionNew)
 	userAuth.POST("/discussion/new", HandleDiscussionCreate)
+	userAuth.GET("/schedule/mine", HandleScheduleMine)
+	userAuth.GET("/moderation", HandleModeration)
+	userAuth.POST("/moderation/:action", HandleModerationAction)
 
 	admin := NewRouter()
 	admin.GET("/admin/:template", HandleAdminConsole)
@@ -161,12 +193,27 @@ func serve() {
 
 	admin.POST("/testaction/:action", HandleTestAction)
 
+	api := NewRouter()
+	api.GET(apiPrefix+"/discussions", HandleAPIDiscussionList)
+	api.POST(apiPrefix+"/discussions", HandleAPIDiscussionCreate)
+	api.GET(apiPrefix+"/discussions/:did", HandleAPIDiscussionGet)
+	api.PUT(apiPrefix+"/discussions/:did", HandleAPIDiscussionUpdate)
+	api.DELETE(apiPrefix+"/discussions/:did", HandleAPIDiscussionDelete)
+	api.PUT(apiPrefix+"/discussions/:did/public", HandleAPIDiscussionSetPublic)
+	api.GET(apiPrefix+"/discussions/:did/interest", HandleAPIInterestGet)
+	api.PUT(apiPrefix+"/discussions/:did/interest", HandleAPIInterestSet)
+	api.GET(apiPrefix+"/users", HandleAPIUserList)
+	api.GET(apiPrefix+"/users/:uid", HandleAPIUserGet)
+	api.GET(apiPrefix+"/timetable", HandleAPITimetable)
+	api.GET(apiPrefix+"/locations", HandleAPILocations)
+
 	middleware := Middleware{
 		Logger:   LogRequest,
 		Always:   always,
 		Active:   public,
 		UserAuth: userAuth,
 		Admin:    admin,
+		API:      api,
 	}
 
 	serveAddress, err := kvs.Get(KeyServeAddress)
@@ -3,6 +3,8 @@ package sessions
 import (
 	"database/sql"
 	"fmt"
+	"log"
+	"os"
 	"strconv"
 	"time"
 
@@ -26,7 +28,7 @@ type SQLiteSessionStore struct {
 type schemaVersion int
 
 const (
-	dbSchemaVersion = schemaVersion(1)
+	dbSchemaVersion = schemaVersion(2)
 )
 
 const (
@@ -35,11 +37,11 @@ const (
 	attributeDefaultExpiry     = "DefaultExpiry"
 )
 
-func getSchemaVersion(tx *sqlx.Tx) (schemaVersion, error) {
+func getSchemaVersion(q sqlx.Queryer) (schemaVersion, error) {
 	var tableCount int
 
 	// Does the 'attributes' table exist?
-	err := tx.Get(&tableCount,
+	err := sqlx.Get(q, &tableCount,
 		"SELECT count(*) FROM sqlite_master WHERE type='table' AND name='attributes';")
 	if err != nil {
 		return -1, err
@@ -53,7 +55,7 @@ func getSchemaVersion(tx *sqlx.Tx) (schemaVersion, error) {
 	var fileSchemaVersionString string
 
 	// The current schema version
-	err = tx.Get(&fileSchemaVersionString,
+	err = sqlx.Get(q, &fileSchemaVersionString,
 		"select value from attributes where key=?", attributeSchemaVersion)
 	if err != nil {
 		return -1, err
@@ -64,61 +66,239 @@ func getSchemaVersion(tx *sqlx.Tx) (schemaVersion, error) {
 	return schemaVersion(fileSchemaVersion), err
 }
 
-func newSQLiteSessionStore(name string) (SessionStore, error) {
-	store := &SQLiteSessionStore{}
+func initDb(ext sqlx.Ext) error {
+	_, err := ext.Exec(`
+        create table attributes(
+            key   text primary key,
+            value text not null)`)
+	if err != nil {
+		return err
+	}
 
-	var err error
+	_, err = ext.Exec(`
+        create table sessions(
+            id       text primary key,
+            userid   string not null,
+            expiryts integer not null /* in Unix time */)`)
+	if err != nil {
+		return err
+	}
 
-	store.DB, err = sqlx.Open("sqlite3", fmt.Sprintf("file:%s?cache=shared", name))
+	_, err = ext.Exec(`
+        insert into attributes(key, value) values(?, ?)`, attributeSchemaVersion, dbSchemaVersion)
+	return err
+}
+
+// Version 1 misspelled "primary key", leaving attributes without one.
+// SQLite can't add a primary key to an existing table, so copy it.
+func fixAttributesKey(ext sqlx.Ext) error {
+	_, err := ext.Exec(`
+        create table attributes_new(
+            key   text primary key,
+            value text not null)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = ext.Exec(`
+        insert or replace into attributes_new(key, value)
+            select key, value from attributes`)
+	if err != nil {
+		return err
+	}
+
+	_, err = ext.Exec(`drop table attributes`)
+	if err != nil {
+		return err
+	}
+
+	_, err = ext.Exec(`alter table attributes_new rename to attributes`)
+	return err
+}
+
+// MigrationStep describes one step in upgrading the session store's
+// schema.
+type MigrationStep struct {
+	Version     int // Schema version after the step
+	Description string
+}
+
+// MigrationResult describes the upgrade of the session store from one
+// schema version to another.
+type MigrationResult struct {
+	FromVersion int
+	ToVersion   int
+	Steps       []MigrationStep
+	Backup      string // Copy of the database made beforehand; "" if none
+}
+
+type dbMigration struct {
+	MigrationStep
+	up func(sqlx.Ext) error
+}
+
+// dbMigrations lists the steps to upgrade an older database to
+// dbSchemaVersion, in order.  New steps must be added at the end,
+// with the next version number, and initDb updated to match.
+var dbMigrations = []dbMigration{
+	{MigrationStep{2, "Add primary key to attributes"}, fixAttributesKey},
+}
+
+// pendingMigrations returns the steps needed to upgrade a store at
+// schema version from to dbSchemaVersion.
+func pendingMigrations(from schemaVersion) ([]dbMigration, error) {
+	if from == dbSchemaVersion {
+		return nil, nil
+	}
+	if from > dbSchemaVersion {
+		return nil, fmt.Errorf("Database version mismatch: %d > %d", from, dbSchemaVersion)
+	}
+	for i := range dbMigrations {
+		if dbMigrations[i].Version == int(from)+1 {
+			return dbMigrations[i:], nil
+		}
+	}
+	return nil, fmt.Errorf("Can't upgrade session store from schema version %d", from)
+}
+
+// migrateDb upgrades db (stored in name) from schema version from to
+// dbSchemaVersion.  A backup is made first, then all steps are run in
+// a single transaction.  If dryRun is set, the steps are run but the
+// transaction is rolled back, and no backup is made.
+func migrateDb(db *sqlx.DB, name string, from schemaVersion, dryRun bool) (*MigrationResult, error) {
+	res := &MigrationResult{FromVersion: int(from), ToVersion: int(dbSchemaVersion)}
+
+	migrations, err := pendingMigrations(from)
 	if err != nil {
 		return nil, err
 	}
+	if len(migrations) == 0 {
+		return res, nil
+	}
+	for i := range migrations {
+		res.Steps = append(res.Steps, migrations[i].MigrationStep)
+	}
+
+	if !dryRun {
+		res.Backup = fmt.Sprintf("%s.v%d-%s.bak", name, from,
+			time.Now().Format("20060102-150405"))
+		_, err := db.Exec(`vacuum into ?`, res.Backup)
+		if err != nil {
+			return nil, fmt.Errorf("Backing up session store to %s: %v", res.Backup, err)
+		}
+	}
 
-	// Check for existence of tables
-	tx, err := store.Beginx()
+	tx, err := db.Beginx()
 	if err != nil {
 		return nil, err
 	}
 	defer tx.Rollback()
 
-	fileSchemaVersion, err := getSchemaVersion(tx)
+	for i := range migrations {
+		m := &migrations[i]
+		if !dryRun {
+			log.Printf("Upgrading session store to version %d: %s", m.Version, m.Description)
+		}
+		if err := m.up(tx); err != nil {
+			return nil, fmt.Errorf("Upgrading session store to version %d (%s): %v",
+				m.Version, m.Description, err)
+		}
+	}
+
+	_, err = tx.Exec(`update attributes set value = ? where key = ?`,
+		dbSchemaVersion, attributeSchemaVersion)
+	if err != nil {
+		return nil, fmt.Errorf("Setting schema version: %v", err)
+	}
+
+	if dryRun {
+		return res, nil
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("Committing session store upgrade: %v", err)
+	}
+	return res, nil
+}
+
+func newSQLiteSessionStore(name string) (SessionStore, error) {
+	store := &SQLiteSessionStore{}
+
+	var err error
+
+	store.DB, err = sqlx.Open("sqlite3", fmt.Sprintf("file:%s?cache=shared", name))
 	if err != nil {
 		return nil, err
 	}
 
+	fileSchemaVersion, err := getSchemaVersion(store)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
 	// No 'attributes' table?  Assume an empty database
 	if fileSchemaVersion == 0 {
-		_, err = tx.Exec(`
-        create table attributes(
-            key   text primay key,
-            value text not null)`)
+		tx, err := store.Beginx()
 		if err != nil {
+			store.Close()
 			return nil, err
 		}
+		defer tx.Rollback()
 
-		_, err = tx.Exec(`
-        create table sessions(
-            id       text primary key,
-            userid   string not null,
-            expiryts integer not null /* in Unix time */)`)
-		if err != nil {
+		if err = initDb(tx); err != nil {
+			store.Close()
 			return nil, err
 		}
 
-		_, err = tx.Exec(`
-        insert into attributes(key, value) values(?, ?)`, attributeSchemaVersion, dbSchemaVersion)
-		if err != nil {
+		if err = tx.Commit(); err != nil {
+			store.Close()
 			return nil, err
 		}
-	} else if fileSchemaVersion != dbSchemaVersion {
-		return nil, fmt.Errorf("Database version mismatch: %d != %d", fileSchemaVersion, dbSchemaVersion)
+
+		return store, nil
 	}
 
-	tx.Commit()
+	// Older databases are upgraded automatically
+	res, err := migrateDb(store.DB, name, fileSchemaVersion, false)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	if res.Backup != "" {
+		log.Printf("Upgraded session store from version %d to %d; backup in %s",
+			res.FromVersion, res.ToVersion, res.Backup)
+	}
 
 	return store, nil
 }
 
+// Migrate upgrades the session store in name to the current schema
+// version, as happens automatically when it's opened.  If dryRun is
+// set, nothing is changed, but the upgrade is tried out to make sure
+// it would succeed.  Stores which don't exist yet are left alone.
+func Migrate(name string, dryRun bool) (*MigrationResult, error) {
+	if _, err := os.Stat(name); os.IsNotExist(err) {
+		return &MigrationResult{ToVersion: int(dbSchemaVersion)}, nil
+	}
+
+	db, err := sqlx.Open("sqlite3", fmt.Sprintf("file:%s?cache=shared", name))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	version, err := getSchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+	if version == 0 {
+		return &MigrationResult{ToVersion: int(dbSchemaVersion)}, nil
+	}
+
+	return migrateDb(db, name, version, dryRun)
+}
+
 func (store *SQLiteSessionStore) Save(session *Session) error {
 	_, err := store.Exec(
 		`insert into sessions(id, userid, expiryts) values(?, ?, ?)`,
@@ -167,3 +347,52 @@ func CloseSessionStore() {
 	store.Close()
 	store = nil
 }
+
+// Backup writes a consistent snapshot of the open session store to
+// filename, which must not exist yet.
+func Backup(filename string) error {
+	s, ok := store.(*SQLiteSessionStore)
+	if !ok {
+		return fmt.Errorf("Session store can't be backed up")
+	}
+	_, err := s.Exec(`vacuum into ?`, filename)
+	if err != nil {
+		return fmt.Errorf("Backing up session store to %s: %v", filename, err)
+	}
+	return nil
+}
+
+// CheckBackup makes sure the file name is an intact session store
+// which this program can use, either directly or after upgrading it,
+// and returns its schema version.
+func CheckBackup(name string) (int, error) {
+	if _, err := os.Stat(name); err != nil {
+		return 0, err
+	}
+
+	db, err := sqlx.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro", name))
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.Get(&result, "pragma integrity_check"); err != nil {
+		return 0, fmt.Errorf("Checking integrity: %v", err)
+	}
+	if result != "ok" {
+		return 0, fmt.Errorf("Integrity check failed: %s", result)
+	}
+
+	version, err := getSchemaVersion(db)
+	if err != nil {
+		return 0, fmt.Errorf("Getting schema version: %v", err)
+	}
+	if version == 0 {
+		return 0, fmt.Errorf("%s isn't a session store", name)
+	}
+	if _, err := pendingMigrations(version); err != nil {
+		return int(version), err
+	}
+	return int(version), nil
+}
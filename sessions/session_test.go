@@ -1,12 +1,15 @@
 package sessions
 
 import (
+	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/icrowley/fake"
+	"github.com/jmoiron/sqlx"
 )
 
 type userState int
@@ -192,3 +195,137 @@ func TestSession(t *testing.T) {
 	// Only remove the file if we were successful
 	os.Remove(sfname)
 }
+
+func TestMigrate(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "sessions")
+	if err != nil {
+		t.Errorf("Creating temporary directory: %v", err)
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+
+	sfname := tmpdir + "/sessions.store"
+
+	// Create a version 1 store, with a session in it
+	{
+		db, err := sqlx.Open("sqlite3", sfname)
+		if err != nil {
+			t.Errorf("Creating version 1 store: %v", err)
+			return
+		}
+		for _, q := range []string{
+			`create table attributes(key text primay key, value text not null)`,
+			`create table sessions(id text primary key, userid string not null, expiryts integer not null)`,
+			`insert into attributes(key, value) values('SchemaVersion', '1')`,
+			`insert into sessions(id, userid, expiryts) values('sessid', 'userid', 0)`,
+		} {
+			if _, err := db.Exec(q); err != nil {
+				t.Errorf("%s: %v", q, err)
+				db.Close()
+				return
+			}
+		}
+		db.Close()
+	}
+
+	res, err := Migrate(sfname, true)
+	if err != nil || res.FromVersion != 1 || len(res.Steps) != 1 || res.Backup != "" {
+		t.Errorf("Dry run: got %v, err %v", res, err)
+		return
+	}
+
+	res, err = Migrate(sfname, false)
+	if err != nil || len(res.Steps) != 1 || res.Backup == "" {
+		t.Errorf("Migrate: got %v, err %v", res, err)
+		return
+	}
+	if _, err := os.Stat(res.Backup); err != nil {
+		t.Errorf("Backup: %v", err)
+		return
+	}
+
+	if err := OpenSessionStore(sfname); err != nil {
+		t.Errorf("Opening migrated store: %v", err)
+		return
+	}
+	defer CloseSessionStore()
+
+	session, err := store.Find("sessid")
+	if err != nil || session == nil || session.UserID != "userid" {
+		t.Errorf("Finding session in migrated store: got %v, err %v", session, err)
+		return
+	}
+
+	var pk int
+	err = sqlx.Get(store.(*SQLiteSessionStore), &pk,
+		`select pk from pragma_table_info('attributes') where name = 'key'`)
+	if err != nil || pk != 1 {
+		t.Errorf("attributes.key not a primary key after migration (pk %d, err %v)", pk, err)
+		return
+	}
+
+	if _, err := Migrate(sfname, false); err != nil {
+		t.Errorf("Migrating current store: %v", err)
+		return
+	}
+}
+
+func TestBackup(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "sessions")
+	if err != nil {
+		t.Errorf("Creating temporary directory: %v", err)
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+
+	sfname := tmpdir + "/sessions.store"
+	bfname := tmpdir + "/sessions.bak"
+
+	if err := OpenSessionStore(sfname); err != nil {
+		t.Errorf("Creating session store: %v", err)
+		return
+	}
+	if err := store.Save(&Session{ID: "sessid", UserID: "userid", Expiry: time.Now()}); err != nil {
+		t.Errorf("Saving session: %v", err)
+		CloseSessionStore()
+		return
+	}
+	if err := Backup(bfname); err != nil {
+		t.Errorf("Backup: %v", err)
+		CloseSessionStore()
+		return
+	}
+	CloseSessionStore()
+
+	if version, err := CheckBackup(bfname); err != nil || version != int(dbSchemaVersion) {
+		t.Errorf("CheckBackup: got version %d, err %v", version, err)
+		return
+	}
+	if _, err := CheckBackup(tmpdir + "/nonexistent"); err == nil {
+		t.Errorf("CheckBackup of non-existent file succeeded")
+		return
+	}
+
+	if err := OpenSessionStore(bfname); err != nil {
+		t.Errorf("Opening backup: %v", err)
+		return
+	}
+	defer CloseSessionStore()
+	session, err := store.Find("sessid")
+	if err != nil || session == nil || session.UserID != "userid" {
+		t.Errorf("Finding session in backup: got %v, err %v", session, err)
+		return
+	}
+
+	// Stores from the future can't be restored
+	_, err = store.(*SQLiteSessionStore).Exec(`update attributes set value = ? where key = ?`,
+		dbSchemaVersion+1, attributeSchemaVersion)
+	if err != nil {
+		t.Errorf("Setting schema version: %v", err)
+		return
+	}
+	if _, err := CheckBackup(bfname); err == nil {
+		t.Errorf("CheckBackup of newer store succeeded")
+		return
+	}
+}
@@ -1,7 +1,7 @@
 create table attributes(
-    key   text primay key,
+    key   text primary key,
     value text not null);
-    /* "SchemaVersion" "1" */
+    /* "SchemaVersion" "2" */
     /* "SessionCookieName" "XenSummitWebSession" */
     /* "DefaultExpiry" itoa(24 * 3 * time.Hour) */
 
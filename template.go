@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"html"
 	"html/template"
 	"net/http"
 	"strings"
@@ -10,6 +11,8 @@ import (
 	"github.com/microcosm-cc/bluemonday"
 
 	"github.com/russross/blackfriday/v2"
+
+	"github.com/gwd/session-scheduler/event"
 )
 
 var layoutFuncs = template.FuncMap{
@@ -33,6 +36,13 @@ var utilFuncs = template.FuncMap{
 		}
 		return dict, nil
 	},
+	// The lengths, in slots, a discussion may ask for
+	"discussionLengths": func() (lengths []int) {
+		for l := 1; l <= event.MaxDiscussionLength; l++ {
+			lengths = append(lengths, l)
+		}
+		return
+	},
 }
 var templates, layout *template.Template
 
@@ -60,6 +70,7 @@ var errorTemplate = `
 `
 
 func RenderTemplate(w http.ResponseWriter, r *http.Request, name string, data map[string]interface{}) {
+	ev := RequestEvent(r)
 	if data == nil {
 		data = map[string]interface{}{}
 	}
@@ -67,13 +78,14 @@ func RenderTemplate(w http.ResponseWriter, r *http.Request, name string, data ma
 	cur := RequestUser(r)
 
 	data["CurrentUser"] = cur
+	data["Event"] = ev
 	data["Flash"] = r.URL.Query().Get("flash")
-	data["IsTestMode"] = kvs.GetBoolDef(FlagTestMode)
-	data["IsWebsiteActive"] = kvs.GetBoolDef(FlagActive)
-	data["IsScheduleActive"] = kvs.GetBoolDef(FlagScheduleActive)
-	data["IsVcodeSent"] = kvs.GetBoolDef(FlagVerificationCodeSent)
-	data["RequireVerification"] = kvs.GetBoolDef(FlagRequireVerification)
-	data["ShowToolbar"] = kvs.GetBoolDef(FlagActive) || (cur != nil && cur.IsAdmin)
+	data["IsTestMode"] = kvs.GetBoolDef(ev.Key(FlagTestMode))
+	data["IsWebsiteActive"] = kvs.GetBoolDef(ev.Key(FlagActive))
+	data["IsScheduleActive"] = kvs.GetBoolDef(ev.Key(FlagScheduleActive))
+	data["IsVcodeSent"] = kvs.GetBoolDef(ev.Key(FlagVerificationCodeSent))
+	data["RequireVerification"] = kvs.GetBoolDef(ev.Key(FlagRequireVerification))
+	data["ShowToolbar"] = kvs.GetBoolDef(ev.Key(FlagActive)) || (cur != nil && cur.IsAdmin)
 
 	funcs := template.FuncMap{
 		"yield": func() (template.HTML, error) {
@@ -111,3 +123,17 @@ func ProcessText(input string) template.HTML {
 
 	return html
 }
+
+var plainSanitizer = bluemonday.StrictPolicy()
+
+// ProcessTextPlain renders Markdown input as plain text, for places
+// (such as calendar feeds) where HTML can't be used.
+func ProcessTextPlain(input string) string {
+	unix := strings.ReplaceAll(input, "\r\n", "\n")
+
+	rendered := blackfriday.Run([]byte(unix))
+
+	text := html.UnescapeString(string(plainSanitizer.SanitizeBytes(rendered)))
+
+	return strings.TrimSpace(text)
+}